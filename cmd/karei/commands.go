@@ -0,0 +1,779 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei"
+	"github.com/yutanagano/karei/internal/book"
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/console"
+	"github.com/yutanagano/karei/internal/datagen"
+	"github.com/yutanagano/karei/internal/engine"
+	"github.com/yutanagano/karei/internal/logging"
+	"github.com/yutanagano/karei/internal/match"
+	"github.com/yutanagano/karei/internal/server"
+	"github.com/yutanagano/karei/internal/tune"
+	"github.com/yutanagano/karei/internal/uci"
+)
+
+func runPerft(args []string) error {
+	flags := flag.NewFlagSet("perft", flag.ContinueOnError)
+	fenString := flags.String("fen", chess.GetStartingFEN().String(), "position to count from")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{errors.New("perft expects exactly one depth")}
+	}
+	depth, err := parseDepth(positional[0])
+	if err != nil {
+		return err
+	}
+
+	position, err := loadPosition(*fenString)
+	if err != nil {
+		return err
+	}
+
+	startTime := time.Now()
+	divided := position.Divide(depth)
+	elapsed := time.Since(startTime)
+
+	moves := make([]string, 0, len(divided))
+	var nodes uint64
+	for moveString, count := range divided {
+		moves = append(moves, moveString)
+		nodes += count
+	}
+	slices.Sort(moves)
+
+	for _, moveString := range moves {
+		fmt.Printf("%s: %d\n", moveString, divided[moveString])
+	}
+	fmt.Println()
+	fmt.Printf("Nodes searched  : %d\n", nodes)
+	fmt.Printf("Total time (ms) : %d\n", elapsed.Milliseconds())
+	return nil
+}
+
+func runBench(args []string) error {
+	depth := engine.DefaultBenchDepth
+	switch len(args) {
+	case 0:
+	case 1:
+		var err error
+		if depth, err = parseDepth(args[0]); err != nil {
+			return err
+		}
+	default:
+		return usageError{errors.New("bench expects at most one depth")}
+	}
+
+	engine.Bench(depth, func(line string) {
+		fmt.Println(line)
+	})
+	return nil
+}
+
+func runAnalyse(args []string, options globalOptions) error {
+	flags := flag.NewFlagSet("analyse", flag.ContinueOnError)
+	fenString := flags.String("fen", chess.GetStartingFEN().String(), "position to analyse")
+	depth := flags.Int("depth", 0, "search to this depth")
+	moveTime := flags.Int("movetime", 0, "search for this many milliseconds")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError{fmt.Errorf("unexpected argument %s", positional[0])}
+	}
+	if *depth <= 0 && *moveTime <= 0 {
+		return usageError{errors.New("analyse needs a positive --depth or --movetime")}
+	}
+
+	position, err := loadPosition(*fenString)
+	if err != nil {
+		return err
+	}
+
+	e, err := newEngine(options)
+	if err != nil {
+		return err
+	}
+
+	limits := engine.Limits{Depth: *depth, MoveTime: time.Duration(*moveTime) * time.Millisecond}
+	bestMove, ponderMove := search(e, position, limits, func(searchInfo chess.SearchInfo) {
+		fmt.Println(uci.FormatInfo(searchInfo))
+	})
+
+	if bestMove == "" {
+		bestMove = "(none)"
+	}
+	if ponderMove != "" {
+		fmt.Println("bestmove " + bestMove + " ponder " + ponderMove)
+	} else {
+		fmt.Println("bestmove " + bestMove)
+	}
+	return nil
+}
+
+func runEPD(args []string, options globalOptions) error {
+	flags := flag.NewFlagSet("epd", flag.ContinueOnError)
+	depth := flags.Int("depth", 0, "search each position to this depth")
+	moveTime := flags.Int("movetime", 1000, "search each position for this many milliseconds")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{errors.New("epd expects exactly one file")}
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	e, err := newEngine(options)
+	if err != nil {
+		return err
+	}
+
+	limits := engine.Limits{Depth: *depth, MoveTime: time.Duration(*moveTime) * time.Millisecond}
+	tested, solved := 0, 0
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		record, err := chess.ParseEPD(line)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+
+		position := chess.Position{}
+		if err := position.LoadFEN(record.FEN); err != nil {
+			return fmt.Errorf("line %d: %s", lineNumber, err.Error())
+		}
+
+		name := fmt.Sprintf("line %d", lineNumber)
+		if id := record.Operations["id"]; len(id) > 0 {
+			name = id[0]
+		}
+
+		bestMoves, err := parseSANList(position, record.Operations["bm"])
+		if err != nil {
+			return fmt.Errorf("%s: bad bm: %s", name, err.Error())
+		}
+		avoidMoves, err := parseSANList(position, record.Operations["am"])
+		if err != nil {
+			return fmt.Errorf("%s: bad am: %s", name, err.Error())
+		}
+
+		bestMove, _ := search(e, position, limits, func(chess.SearchInfo) {})
+		san, _ := position.SAN(bestMove)
+
+		if len(bestMoves) == 0 && len(avoidMoves) == 0 {
+			fmt.Printf("%s: %s\n", name, san)
+			continue
+		}
+
+		tested++
+		result := "failed"
+		if (len(bestMoves) == 0 || slices.Contains(bestMoves, bestMove)) && !slices.Contains(avoidMoves, bestMove) {
+			solved++
+			result = "solved"
+		}
+		fmt.Printf("%s: %s %s\n", name, san, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Solved %d of %d\n", solved, tested)
+	return nil
+}
+
+// shutdownTimeOut is how long serve waits for requests in progress when asked
+// to stop.
+const shutdownTimeOut = 5 * time.Second
+
+func runServe(args []string, options globalOptions) error {
+	config := server.DefaultConfig()
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := flags.String("addr", "localhost:8080", "listen on this address")
+	flags.IntVar(&config.Engines, "engines", config.Engines, "number of searches that may run at once")
+	flags.IntVar(&config.QueueSize, "queue", config.QueueSize, "number of analysis requests that may wait for an engine")
+	flags.DurationVar(&config.MaxSearchTime, "max-search-time", config.MaxSearchTime, "longest time any search may take")
+	flags.IntVar(&config.MaxPerftDepth, "max-perft-depth", config.MaxPerftDepth, "deepest perft a client may ask for")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError{fmt.Errorf("unexpected argument %s", positional[0])}
+	}
+
+	config.EngineOptions = karei.Options{Hash: options.hash, Threads: options.threads, Logger: logging.For("engine")}
+	handler, err := server.New(config)
+	if err != nil {
+		return usageError{err}
+	}
+	defer handler.Close()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	httpServer := &http.Server{Handler: handler, BaseContext: func(net.Listener) context.Context { return ctx }}
+	served := make(chan error, 1)
+	go func() {
+		served <- httpServer.Serve(listener)
+	}()
+
+	slog.Info("serving", "addr", listener.Addr().String())
+	fmt.Fprintln(os.Stderr, "karei: serving on", listener.Addr().String())
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeOut)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	return context.Cause(ctx)
+}
+
+func runMatch(args []string) error {
+	flags := flag.NewFlagSet("match", flag.ContinueOnError)
+	timeControl := flags.String("tc", "10+0.1", "time control as [moves/]seconds[+increment]")
+	timeMargin := flags.Duration("time-margin", 0, "how far an engine may overrun its clock before losing on time")
+	rounds := flags.Int("rounds", 50, "number of pairs of games, each pair playing an opening with colours swapped")
+	concurrency := flags.Int("concurrency", 1, "number of games played at once")
+	openingsPath := flags.String("openings", "", "opening book, in PGN if the name ends in .pgn and EPD otherwise")
+	pgnPath := flags.String("pgn", "", "append the games to this PGN file")
+	sprtSpec := flags.String("sprt", "", "stop once a test of elo0 against elo1 is decided, given as elo0,elo1,alpha,beta")
+	var adjudication match.Adjudication
+	flags.IntVar(&adjudication.ResignScore, "resign-score", 1000, "score in centipawns at or below which an engine resigns")
+	flags.IntVar(&adjudication.ResignMoves, "resign-moves", 0, "moves in a row an engine must be losing to resign, or 0 never to resign")
+	flags.IntVar(&adjudication.DrawScore, "draw-score", 10, "score in centipawns within which a game counts as drawn")
+	flags.IntVar(&adjudication.DrawMoves, "draw-moves", 0, "moves in a row both engines must report a drawn score, or 0 never to adjudicate draws")
+	flags.IntVar(&adjudication.DrawMoveNumber, "draw-move-number", 40, "move from which draws may be adjudicated")
+	flags.IntVar(&adjudication.MaxMoves, "max-moves", 0, "moves after which a game is drawn, or 0 for no limit")
+	syzygyPath := flags.String("syzygy-path", "", "adjudicate positions in the Syzygy tables in these directories, separated as in PATH")
+	options := map[string]string{}
+	flags.Func("option", "set a UCI option of both engines, as name=value (repeatable)", func(s string) error {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return fmt.Errorf("expected name=value, got %s", s)
+		}
+		options[name] = value
+		return nil
+	})
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 2 {
+		return usageError{errors.New("match expects exactly two engines")}
+	}
+
+	config := match.Config{
+		Event:        "karei match",
+		Adjudication: adjudication,
+		Rounds:       *rounds,
+		Concurrency:  *concurrency,
+	}
+	if config.TimeControl, err = match.ParseTimeControl(*timeControl); err != nil {
+		return usageError{err}
+	}
+	config.TimeControl.Margin = *timeMargin
+
+	for idx, path := range positional {
+		if path == "self" {
+			if path, err = os.Executable(); err != nil {
+				return err
+			}
+		}
+		config.Engines[idx] = match.EngineConfig{Path: path, Options: options}
+	}
+
+	if *sprtSpec != "" {
+		sprt, err := parseSPRT(*sprtSpec)
+		if err != nil {
+			return usageError{err}
+		}
+		config.SPRT = &sprt
+	}
+
+	if *syzygyPath != "" {
+		tablebase, err := chess.OpenTablebase(filepath.SplitList(*syzygyPath))
+		if err != nil {
+			return err
+		}
+		defer tablebase.Close()
+		config.Adjudication.Tablebase = tablebase
+	}
+
+	if *openingsPath != "" {
+		if config.Openings, err = match.LoadOpenings(*openingsPath); err != nil {
+			return err
+		}
+	}
+
+	if *pgnPath != "" {
+		f, err := os.OpenFile(*pgnPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		config.PGN = f
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	_, err = match.Run(ctx, config, func(line string) {
+		fmt.Println(line)
+	})
+	return err
+}
+
+// defaultCalibrationElos are the ratings of the Skill Level values.
+var defaultCalibrationElos = []string{"800", "1000", "1200", "1400", "1600", "1800", "2000", "2200"}
+
+func runCalibrate(args []string) error {
+	flags := flag.NewFlagSet("calibrate", flag.ContinueOnError)
+	timeControl := flags.String("tc", "5+0.05", "time control as [moves/]seconds[+increment]")
+	rounds := flags.Int("rounds", 20, "number of pairs of games between neighbouring levels")
+	concurrency := flags.Int("concurrency", 1, "number of games played at once")
+	openingsPath := flags.String("openings", "", "opening book, in PGN if the name ends in .pgn and EPD otherwise")
+	pgnPath := flags.String("pgn", "", "append the games to this PGN file")
+	maxMoves := flags.Int("max-moves", 150, "moves after which a game is drawn, or 0 for no limit")
+
+	elos, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(elos) == 0 {
+		elos = defaultCalibrationElos
+	}
+
+	config := match.CalibrationConfig{
+		Match: match.Config{
+			Event:        "karei calibration",
+			Adjudication: match.Adjudication{MaxMoves: *maxMoves},
+			Rounds:       *rounds,
+			Concurrency:  *concurrency,
+		},
+	}
+	if config.Match.TimeControl, err = match.ParseTimeControl(*timeControl); err != nil {
+		return usageError{err}
+	}
+	if config.Engine.Path, err = os.Executable(); err != nil {
+		return err
+	}
+
+	for idx, elo := range elos {
+		rating, err := strconv.Atoi(elo)
+		if err != nil {
+			return usageError{fmt.Errorf("bad value for elo: %s", elo)}
+		}
+		if idx == 0 {
+			config.Anchor = float64(rating)
+		}
+		config.Levels = append(config.Levels, match.Level{
+			Name:    "UCI_Elo " + elo,
+			Options: map[string]string{"UCI_LimitStrength": "true", "UCI_Elo": elo},
+		})
+	}
+
+	if *openingsPath != "" {
+		if config.Match.Openings, err = match.LoadOpenings(*openingsPath); err != nil {
+			return err
+		}
+	}
+
+	if *pgnPath != "" {
+		f, err := os.OpenFile(*pgnPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		config.Match.PGN = f
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	_, err = match.Calibrate(ctx, config, func(line string) {
+		fmt.Println(line)
+	})
+	return err
+}
+
+func runBook(args []string) error {
+	flags := flag.NewFlagSet("book", flag.ContinueOnError)
+	outPath := flags.String("out", "book.bin", "write the Polyglot book to this file")
+	var config book.BuildConfig
+	flags.IntVar(&config.MaxPly, "max-ply", 30, "number of half moves read from the start of each game")
+	flags.IntVar(&config.MinGames, "min-games", 3, "number of games a move must have been played in")
+	flags.Float64Var(&config.MinWinRate, "min-win-rate", 0, "fraction of points, counting draws as half, a move must have scored")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) == 0 {
+		return usageError{errors.New("book expects at least one PGN file")}
+	}
+	if config.MinWinRate < 0 || config.MinWinRate > 1 {
+		return usageError{fmt.Errorf("bad value for min-win-rate: %g not in range 0 to 1", config.MinWinRate)}
+	}
+
+	builder := book.NewBuilder(config)
+	read, skipped := 0, 0
+	for _, path := range positional {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		games, err := chess.ReadPGN(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		for idx, game := range games {
+			if err := builder.Add(game); err != nil {
+				slog.Warn("skipping game", "file", path, "game", idx+1, "reason", err.Error())
+				skipped++
+			}
+		}
+		read += len(games)
+	}
+
+	result := builder.Book()
+	f, err := os.Create(*outPath)
+	if err != nil {
+		return err
+	}
+	if err := result.Write(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	fmt.Printf("Read %d games (%d skipped), wrote %d entries to %s\n", read, skipped, result.Len(), *outPath)
+	return nil
+}
+
+func runDatagen(args []string) error {
+	flags := flag.NewFlagSet("datagen", flag.ContinueOnError)
+	var config datagen.Config
+	flags.IntVar(&config.Games, "games", 1000, "total number of games to play, counting those from earlier runs")
+	flags.IntVar(&config.Concurrency, "concurrency", 1, "number of games played at once")
+	flags.IntVar(&config.Depth, "depth", 0, "search depth for each move")
+	flags.Uint64Var(&config.Nodes, "nodes", 0, "search node limit for each move")
+	flags.IntVar(&config.RandomPlies, "random-plies", 8, "number of random moves each game starts with")
+	flags.IntVar(&config.MaxPlies, "max-plies", 400, "moves after which a game is drawn, or 0 for no limit")
+	flags.Int64Var(&config.Seed, "seed", 0, "seed for the random openings")
+	formatName := flags.String("format", "binary", "output format, binary or text")
+	evalFile := flags.String("eval-file", "", "evaluate with the network in this file instead of the handcrafted evaluation")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{errors.New("datagen expects exactly one output file")}
+	}
+	config.Path = positional[0]
+	if config.Depth == 0 && config.Nodes == 0 {
+		config.Depth = 6
+	}
+	if config.Format, err = datagen.ParseFormat(*formatName); err != nil {
+		return usageError{err}
+	}
+
+	if *evalFile != "" {
+		network, err := chess.LoadNetwork(*evalFile)
+		if err != nil {
+			return err
+		}
+		config.Evaluator = network
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	progress, err := datagen.Run(ctx, config, func(line string) {
+		fmt.Println(line)
+	})
+	fmt.Printf("Wrote %d positions from %d games to %s\n", progress.Positions, progress.Games, config.Path)
+	return err
+}
+
+func runTune(args []string) error {
+	flags := flag.NewFlagSet("tune", flag.ContinueOnError)
+	var config tune.Config
+	methodName := flags.String("method", "local", "optimiser, local or gradient")
+	flags.Float64Var(&config.K, "k", 0, "scale of evaluations in the sigmoid, or 0 to fit it to the dataset")
+	flags.IntVar(&config.Iterations, "iterations", 0, "most passes for local search or steps for gradient descent (default 100 or 10000)")
+	flags.Float64Var(&config.LearningRate, "learning-rate", 1, "step size for gradient descent in centipawns")
+	flags.IntVar(&config.Concurrency, "concurrency", runtime.NumCPU(), "number of goroutines the dataset is split between")
+	include := flags.String("include", "", "only tune parameters with names matching this regular expression")
+	formatName := flags.String("format", "binary", "dataset format, binary or text")
+	outPath := flags.String("out", "", "write the tuned parameters to this file as JSON, or to stdout if empty")
+	goPath := flags.String("go", "", "also write the tuned parameters to this file as Go source for eval.go")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{errors.New("tune expects exactly one dataset file")}
+	}
+	if config.Method, err = tune.ParseMethod(*methodName); err != nil {
+		return usageError{err}
+	}
+	if config.Iterations == 0 {
+		config.Iterations = 100
+		if config.Method == tune.Gradient {
+			config.Iterations = 10000
+		}
+	}
+	if *include != "" {
+		if config.Include, err = regexp.Compile(*include); err != nil {
+			return usageError{fmt.Errorf("bad value for include: %s", *include)}
+		}
+	}
+	format, err := datagen.ParseFormat(*formatName)
+	if err != nil {
+		return usageError{err}
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	records, err := datagen.ReadRecords(f, format)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", positional[0], err)
+	}
+	dataset, err := tune.NewDataset(records, config.Concurrency)
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", positional[0], err)
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	// stopping early still writes out the best parameters found
+	tuned, tuneErr := tune.Tune(ctx, dataset, chess.EvalParameters(), config, func(line string) {
+		fmt.Fprintln(os.Stderr, line)
+	})
+	if tuned == nil {
+		return tuneErr
+	}
+
+	out := os.Stdout
+	if *outPath != "" {
+		if out, err = os.Create(*outPath); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := chess.WriteEvalParametersJSON(out, tuned); err != nil {
+		return err
+	}
+
+	if *goPath != "" {
+		if err := chess.SetEvalParameters(tuned); err != nil {
+			return err
+		}
+		source, err := os.Create(*goPath)
+		if err != nil {
+			return err
+		}
+		defer source.Close()
+		if err := chess.WriteEvalParametersGo(source); err != nil {
+			return err
+		}
+	}
+	return tuneErr
+}
+
+func runFitWDL(args []string) error {
+	flags := flag.NewFlagSet("fitwdl", flag.ContinueOnError)
+	var config tune.WDLConfig
+	flags.IntVar(&config.Iterations, "iterations", 10000, "steps of gradient descent")
+	flags.Float64Var(&config.LearningRate, "learning-rate", 1, "step size for gradient descent in centipawns")
+	formatName := flags.String("format", "binary", "dataset format, binary or text")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) != 1 {
+		return usageError{errors.New("fitwdl expects exactly one dataset file")}
+	}
+	format, err := datagen.ParseFormat(*formatName)
+	if err != nil {
+		return usageError{err}
+	}
+
+	f, err := os.Open(positional[0])
+	if err != nil {
+		return err
+	}
+	records, err := datagen.ReadRecords(f, format)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", positional[0], err)
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	// stopping early still prints the model fitted so far
+	model, fitErr := tune.FitWDL(ctx, records, chess.DefaultWDLModel, config, func(line string) {
+		fmt.Fprintln(os.Stderr, line)
+	})
+	if fitErr != nil && ctx.Err() == nil {
+		return fitErr
+	}
+	fmt.Printf("var DefaultWDLModel = WDLModel{\n\tCentre: %s,\n\tSpread: %s,\n}\n", formatCoefficients(model.Centre), formatCoefficients(model.Spread))
+	return fitErr
+}
+
+// formatCoefficients spells the coefficients of a chess.WDLModel as Go source.
+func formatCoefficients(coefficients [chess.WDLFeatureCount]float64) string {
+	values := make([]string, len(coefficients))
+	for idx, coefficient := range coefficients {
+		values[idx] = strconv.FormatFloat(coefficient, 'f', 2, 64)
+	}
+	return "[WDLFeatureCount]float64{" + strings.Join(values, ", ") + "}"
+}
+
+func parseSPRT(s string) (match.SPRT, error) {
+	fields := strings.Split(s, ",")
+	values := make([]float64, len(fields))
+	for idx, field := range fields {
+		value, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return match.SPRT{}, fmt.Errorf("bad value for sprt: %s", s)
+		}
+		values[idx] = value
+	}
+	if len(values) != 4 || values[0] >= values[1] ||
+		values[2] <= 0 || values[2] >= 1 || values[3] <= 0 || values[3] >= 1 {
+		return match.SPRT{}, fmt.Errorf("bad value for sprt: %s", s)
+	}
+	return match.SPRT{Elo0: values[0], Elo1: values[1], Alpha: values[2], Beta: values[3]}, nil
+}
+
+// search runs a search to completion and returns its result.
+func search(e *engine.Engine, position chess.Position, limits engine.Limits, info func(chess.SearchInfo)) (bestMove, ponderMove string) {
+	done := make(chan [2]string)
+	e.Go(position, limits, info, func(bestMove, ponderMove string) {
+		done <- [2]string{bestMove, ponderMove}
+	})
+	result := <-done
+	return result[0], result[1]
+}
+
+func parseSANList(position chess.Position, sans []string) ([]string, error) {
+	result := make([]string, len(sans))
+	for idx, san := range sans {
+		moveString, err := position.ParseSAN(san)
+		if err != nil {
+			return nil, err
+		}
+		result[idx] = moveString
+	}
+	return result, nil
+}
+
+func loadPosition(fenString string) (chess.Position, error) {
+	position := chess.Position{}
+
+	fen, err := chess.ParseFEN(fenString)
+	if err != nil {
+		return position, usageError{err}
+	}
+	if err := position.LoadFEN(fen); err != nil {
+		return position, usageError{err}
+	}
+	return position, nil
+}
+
+func parseDepth(s string) (int, error) {
+	depth, err := strconv.Atoi(s)
+	if err != nil || depth < 1 {
+		return 0, usageError{fmt.Errorf("bad depth %s", s)}
+	}
+	return depth, nil
+}
+
+// parseInterspersed parses flags that may appear before, between or after
+// positional arguments, returning the positional arguments in order. Asking
+// for help prints the command's flags and returns flag.ErrHelp; other errors
+// are left for the caller to report.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	flags.SetOutput(io.Discard)
+
+	var positional []string
+	for {
+		if err := flags.Parse(args); errors.Is(err, flag.ErrHelp) {
+			flags.SetOutput(os.Stderr)
+			fmt.Fprintf(os.Stderr, "flags for karei %s:\n", flags.Name())
+			flags.PrintDefaults()
+			return nil, err
+		} else if err != nil {
+			return nil, usageError{err}
+		}
+		if flags.NArg() == 0 {
+			return positional, nil
+		}
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/console"
+	"github.com/yutanagano/karei/internal/engine"
+	"github.com/yutanagano/karei/internal/logging"
+	"github.com/yutanagano/karei/internal/uci"
+	"github.com/yutanagano/karei/internal/xboard"
+)
+
+const usageText = `usage: karei [flags] [command] [arguments]
+
+commands:
+  uci [--record <file>]            speak UCI, or xboard if the first command is xboard (default),
+                                   optionally recording a UCI transcript
+  perft [--fen <fen>] <depth>      count the leaf nodes of the move tree, per root move
+  bench [depth]                    search a fixed set of positions and report the node count
+  analyse [--fen <fen>] [--depth <n>] [--movetime <ms>]
+                                   search one position and print the result
+  epd [--depth <n>] [--movetime <ms>] <file>
+                                   run a test suite of EPD records with bm or am operations
+  match [--tc <[moves/]seconds[+increment]>] [--rounds <n>] [--openings <file>] [--pgn <file>]
+        [--sprt <elo0,elo1,alpha,beta>] [adjudication flags] <engine> <engine>
+                                   play games between two UCI engines, where self means this program
+  calibrate [--tc <[moves/]seconds[+increment]>] [--rounds <n>] [--openings <file>] [elo...]
+                                   play the UCI_Elo levels of this program against each other,
+                                   rating them from the weakest up
+  book [--out <file>] [--max-ply <n>] [--min-games <n>] [--min-win-rate <r>] <pgn>...
+                                   build a Polyglot opening book from finished games
+  datagen [--depth <n> | --nodes <n>] [--games <n>] [--concurrency <n>] [--random-plies <n>]
+          [--format binary|text] <file>
+                                   record quiet positions from self-play games as training data,
+                                   resuming an earlier run into the same file
+  tune [--method local|gradient] [--iterations <n>] [--include <regexp>] [--format binary|text]
+       [--out <file.json>] [--go <file.go>] <file>
+                                   fit the evaluation parameters to the results in a training set,
+                                   for loading with -eval-params
+  fitwdl [--iterations <n>] [--learning-rate <r>] [--format binary|text] <file>
+                                   fit the model behind UCI_ShowWDL to the results in a training set,
+                                   printing it as Go source for wdl.go
+  serve [--addr <host:port>] [--engines <n>] [--queue <n>] [--max-search-time <duration>]
+                                   serve analysis over HTTP with JSON bodies
+
+flags:
+`
+
+// globalOptions are set before the command name and apply to every command.
+type globalOptions struct {
+	logPath    string
+	logLevel   string
+	logMaxSize int
+	hash       int
+	threads    int
+	evalParams string
+}
+
+func main() {
+	os.Exit(run())
+}
+
+// run carries out the command line and returns the exit status. It is
+// separate from main so that deferred clean up happens before exiting.
+func run() int {
+	var options globalOptions
+	flag.StringVar(&options.logPath, "log", defaultLogPath(), "append the log to this file, write it to stderr, or discard it if empty")
+	flag.StringVar(&options.logLevel, "log-level", "info", "minimum level of logged messages (debug, info, warn or error), optionally\nfollowed by levels for single subsystems (uci, xboard, server, match, datagen,\ntune, engine, chess or main), such as warn,uci=debug")
+	flag.IntVar(&options.logMaxSize, "log-max-size", 16, "rotate the log file once it grows past this many megabytes, or never if 0")
+	flag.IntVar(&options.hash, "hash", 32, "initial hash table size in megabytes")
+	flag.IntVar(&options.threads, "threads", 1, "initial number of search threads (accepted, but the search is single-threaded)")
+	flag.StringVar(&options.evalParams, "eval-params", "", "load the handcrafted evaluation parameters from this JSON file, as written by tune")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usageText)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	logFile, err := setUpLogging(options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "karei:", err)
+		return 2
+	}
+	defer logFile.Close()
+
+	if options.evalParams != "" {
+		if err := chess.LoadEvalParameters(options.evalParams); err != nil {
+			fmt.Fprintln(os.Stderr, "karei:", err)
+			return 2
+		}
+	}
+
+	command, args := "uci", flag.Args()
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "uci":
+		err = runProtocol(args, options)
+	case "perft":
+		err = runPerft(args)
+	case "bench":
+		err = runBench(args)
+	case "analyse":
+		err = runAnalyse(args, options)
+	case "epd":
+		err = runEPD(args, options)
+	case "match":
+		err = runMatch(args)
+	case "calibrate":
+		err = runCalibrate(args)
+	case "book":
+		err = runBook(args)
+	case "datagen":
+		err = runDatagen(args)
+	case "tune":
+		err = runTune(args)
+	case "fitwdl":
+		err = runFitWDL(args)
+	case "serve":
+		err = runServe(args, options)
+	default:
+		err = usageError{fmt.Errorf("unrecognised command %s", command)}
+	}
+
+	var signalErr console.SignalError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		// The command has already printed its own flags.
+		return 2
+	case errors.As(err, &usageError{}):
+		fmt.Fprintln(os.Stderr, "karei:", err)
+		fmt.Fprintln(os.Stderr, "run 'karei -h' for usage")
+		return 2
+	case errors.As(err, &signalErr):
+		slog.Info("shutting down", "reason", err.Error())
+		return signalErr.ExitCode()
+	default:
+		slog.Error(err.Error())
+		fmt.Fprintln(os.Stderr, "karei:", err)
+		return 1
+	}
+}
+
+// usageError marks errors caused by bad command line arguments.
+type usageError struct {
+	error
+}
+
+func (e usageError) Unwrap() error {
+	return e.error
+}
+
+func defaultLogPath() string {
+	cacheDirectory, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDirectory, "karei", "karei.log")
+}
+
+func setUpLogging(options globalOptions) (io.Closer, error) {
+	levels, err := logging.ParseLevels(options.logLevel)
+	if err != nil {
+		return nil, err
+	}
+
+	var logFile io.WriteCloser
+	switch options.logPath {
+	case "":
+		logFile = nopCloser{io.Discard}
+	case "stderr":
+		logFile = nopCloser{os.Stderr}
+	default:
+		f, err := logging.OpenRotatingFile(options.logPath, int64(options.logMaxSize)<<20)
+		if err != nil {
+			return nil, err
+		}
+		logFile = f
+	}
+
+	logging.Configure(logFile, levels)
+	return logFile, nil
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error { return nil }
+
+// newEngine creates an engine with the hash size and thread count given on the
+// command line.
+func newEngine(options globalOptions) (*engine.Engine, error) {
+	e := engine.New()
+	if err := e.SetOption("Hash", strconv.Itoa(options.hash)); err != nil {
+		return nil, usageError{err}
+	}
+	if err := e.SetOption("Threads", strconv.Itoa(options.threads)); err != nil {
+		return nil, usageError{err}
+	}
+	return e, nil
+}
+
+func runProtocol(args []string, options globalOptions) error {
+	flags := flag.NewFlagSet("uci", flag.ContinueOnError)
+	recordPath := flags.String("record", "", "write a transcript of the UCI session to this file")
+
+	positional, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(positional) > 0 {
+		return usageError{fmt.Errorf("unexpected argument %s", positional[0])}
+	}
+
+	e, err := newEngine(options)
+	if err != nil {
+		return err
+	}
+
+	var recorder *uci.Recorder
+	if *recordPath != "" {
+		f, err := os.Create(*recordPath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		recorder = uci.NewRecorder(f)
+	}
+
+	ctx, stop := console.NotifyContext(context.Background())
+	defer stop()
+
+	input := console.NewReader(os.Stdin)
+	output := console.NewWriter(os.Stdout)
+
+	sessionErr := startSession(ctx, input.Lines, output.Lines, e, recorder)
+	outputErr := output.Close()
+
+	switch {
+	case sessionErr != nil:
+		return sessionErr
+	case recorder != nil && recorder.Err() != nil:
+		return fmt.Errorf("recording transcript: %w", recorder.Err())
+	case input.Err() != nil:
+		return fmt.Errorf("reading input: %w", input.Err())
+	case outputErr != nil:
+		return fmt.Errorf("writing output: %w", outputErr)
+	}
+	return nil
+}
+
+// startSession runs a session in the protocol chosen by the first command,
+// which the session still needs to see.
+func startSession(ctx context.Context, fromClient <-chan string, toClient chan<- string, e *engine.Engine, recorder *uci.Recorder) error {
+	var firstCommand string
+	select {
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case command, ok := <-fromClient:
+		if !ok {
+			return nil
+		}
+		firstCommand = command
+	}
+
+	commands := prependCommand(firstCommand, fromClient)
+
+	if firstCommand == "xboard" {
+		if recorder != nil {
+			slog.Warn("transcripts are only recorded for UCI sessions")
+		}
+		session := xboard.New(commands, toClient, e)
+		return session.Start(ctx)
+	}
+
+	session := uci.New(commands, toClient, e)
+	session.Record(recorder)
+	return session.Start(ctx)
+}
+
+func prependCommand(command string, rest <-chan string) <-chan string {
+	c := make(chan string)
+	go func() {
+		defer close(c)
+		c <- command
+		for line := range rest {
+			c <- line
+		}
+	}()
+	return c
+}
@@ -0,0 +1,193 @@
+// Package book reads and writes opening books in the Polyglot format, a list
+// of 16 byte entries sorted by position key, each suggesting a move with a
+// weight.
+package book
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+const entrySize = 16
+
+// Entry is a single record of a Polyglot book.
+type Entry struct {
+	Key    uint64
+	Move   uint16
+	Weight uint16
+	Learn  uint32
+}
+
+// Book is a Polyglot book held in memory.
+type Book struct {
+	entries []Entry
+}
+
+// Move is a book move in long algebraic notation with its weight.
+type Move struct {
+	Move   string
+	Weight uint16
+}
+
+func compareEntries(a, b Entry) int {
+	switch {
+	case a.Key < b.Key:
+		return -1
+	case a.Key > b.Key:
+		return 1
+	}
+	return int(b.Weight) - int(a.Weight)
+}
+
+// Read reads a whole book. The entries need not be sorted.
+func Read(r io.Reader) (*Book, error) {
+	var entries []Entry
+
+	reader := bufio.NewReader(r)
+	var record [entrySize]byte
+	for {
+		_, err := io.ReadFull(reader, record[:])
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, fmt.Errorf("bad book: size is not a multiple of %d bytes", entrySize)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, Entry{
+			Key:    binary.BigEndian.Uint64(record[0:8]),
+			Move:   binary.BigEndian.Uint16(record[8:10]),
+			Weight: binary.BigEndian.Uint16(record[10:12]),
+			Learn:  binary.BigEndian.Uint32(record[12:16]),
+		})
+	}
+
+	slices.SortStableFunc(entries, compareEntries)
+	return &Book{entries: entries}, nil
+}
+
+// Open reads the book in the file at path.
+func Open(path string) (*Book, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	result, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return result, nil
+}
+
+// Write writes the book in the Polyglot format.
+func (b *Book) Write(w io.Writer) error {
+	writer := bufio.NewWriter(w)
+	var record [entrySize]byte
+	for _, entry := range b.entries {
+		binary.BigEndian.PutUint64(record[0:8], entry.Key)
+		binary.BigEndian.PutUint16(record[8:10], entry.Move)
+		binary.BigEndian.PutUint16(record[10:12], entry.Weight)
+		binary.BigEndian.PutUint32(record[12:16], entry.Learn)
+		if _, err := writer.Write(record[:]); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// Len is the number of entries in the book.
+func (b *Book) Len() int {
+	return len(b.entries)
+}
+
+// Moves lists the legal book moves in the position, most heavily weighted
+// first. Entries with no weight are left out, as are moves that are illegal in
+// the position, which can only come from a key collision or a broken book.
+func (b *Book) Moves(position chess.Position) []Move {
+	fen := position.GetFEN()
+	squares, err := parseBoard(fen.BoardState)
+	if err != nil {
+		return nil
+	}
+	key := squares.key(fen)
+
+	legalMoves := position.LegalMoves()
+	start, _ := slices.BinarySearchFunc(b.entries, key, func(entry Entry, key uint64) int {
+		switch {
+		case entry.Key < key:
+			return -1
+		case entry.Key > key:
+			return 1
+		}
+		return 0
+	})
+
+	var result []Move
+	for _, entry := range b.entries[start:] {
+		if entry.Key != key {
+			break
+		}
+		if entry.Weight == 0 {
+			continue
+		}
+
+		moveString := decodeMove(entry.Move)
+		if squares.isKing(moveString) {
+			for castling, encoded := range castlingMoves {
+				if encoded == moveString {
+					moveString = castling
+				}
+			}
+		}
+		if slices.Contains(legalMoves, moveString) {
+			result = append(result, Move{moveString, entry.Weight})
+		}
+	}
+	return result
+}
+
+// BestMove is the most heavily weighted book move in the position. ok is false
+// if the position is not in the book.
+func (b *Book) BestMove(position chess.Position) (moveString string, ok bool) {
+	moves := b.Moves(position)
+	if len(moves) == 0 {
+		return "", false
+	}
+	return moves[0].Move, true
+}
+
+// RandomMove picks a book move in the position at random, with probability
+// proportional to its weight. ok is false if the position is not in the book.
+func (b *Book) RandomMove(position chess.Position, r *rand.Rand) (moveString string, ok bool) {
+	moves := b.Moves(position)
+
+	total := 0
+	for _, theMove := range moves {
+		total += int(theMove.Weight)
+	}
+	if total == 0 {
+		return "", false
+	}
+
+	choice := r.Intn(total)
+	for _, theMove := range moves {
+		choice -= int(theMove.Weight)
+		if choice < 0 {
+			return theMove.Move, true
+		}
+	}
+	return moves[len(moves)-1].Move, true
+}
@@ -0,0 +1,189 @@
+package book
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func positionAfter(t *testing.T, fen string, moves ...string) chess.Position {
+	t.Helper()
+	start := chess.GetStartingFEN()
+	if fen != "" {
+		var err error
+		if start, err = chess.ParseFEN(fen); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	position := chess.Position{}
+	if err := position.LoadFEN(start); err != nil {
+		t.Fatal(err)
+	}
+	for _, moveString := range moves {
+		if err := position.MakeMove(moveString); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return position
+}
+
+func TestKey(t *testing.T) {
+	type testCase struct {
+		name     string
+		moves    string
+		expected uint64
+	}
+
+	// the keys published with the Polyglot book format
+	testCases := []testCase{
+		{"starting position", "", 0x463b96181691fc9c},
+		{"e4", "e2e4", 0x823c9b50fd114196},
+		{"e4 d5", "e2e4 d7d5", 0x0756b94461c50fb0},
+		{"e4 d5 e5", "e2e4 d7d5 e4e5", 0x662fafb965db29d4},
+		{"e4 d5 e5 f5", "e2e4 d7d5 e4e5 f7f5", 0x22a48b5a8e47ff78},
+		{"e4 d5 e5 f5 Ke2", "e2e4 d7d5 e4e5 f7f5 e1e2", 0x652a607ca3f242c1},
+		{"e4 d5 e5 f5 Ke2 Kf7", "e2e4 d7d5 e4e5 f7f5 e1e2 e8f7", 0x00fdd303c946bdd9},
+		{"a4 b5 h4 b4 c4", "a2a4 b7b5 h2h4 b5b4 c2c4", 0x3c8123ea7b067637},
+		{"a4 b5 h4 b4 c4 bxc3 Ra3", "a2a4 b7b5 h2h4 b5b4 c2c4 b4c3 a1a3", 0x5c3f9b829b279560},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		position := positionAfter(t, "", strings.Fields(c.moves)...)
+		key, err := Key(position.GetFEN())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key != c.expected {
+			t.Errorf("expected %016x, got %016x", c.expected, key)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestMoves(t *testing.T) {
+	castlingFEN := "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1"
+	castlingKey, _ := Key(positionAfter(t, castlingFEN).GetFEN())
+	startKey, _ := Key(chess.GetStartingFEN())
+
+	move := func(s string) uint16 {
+		encoded, err := encodeMove(s, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return encoded
+	}
+
+	original := &Book{entries: []Entry{
+		{Key: startKey, Move: move("e2e4"), Weight: 30},
+		{Key: startKey, Move: move("d2d4"), Weight: 10},
+		{Key: startKey, Move: move("e2e5"), Weight: 50},
+		{Key: startKey, Move: move("h2h3"), Weight: 0},
+		{Key: castlingKey, Move: move("e1h1"), Weight: 2},
+		{Key: castlingKey, Move: move("e1a1"), Weight: 1},
+	}}
+
+	var b bytes.Buffer
+	if err := original.Write(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 16*len(original.entries) {
+		t.Fatalf("expected %d bytes, got %d", 16*len(original.entries), b.Len())
+	}
+	read, err := Read(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	moves := read.Moves(positionAfter(t, ""))
+	if expected := []Move{{"e2e4", 30}, {"d2d4", 10}}; !reflect.DeepEqual(moves, expected) {
+		t.Errorf("expected %v, got %v", expected, moves)
+	}
+
+	moves = read.Moves(positionAfter(t, castlingFEN))
+	if expected := []Move{{"e1g1", 2}, {"e1c1", 1}}; !reflect.DeepEqual(moves, expected) {
+		t.Errorf("expected castling moves %v, got %v", expected, moves)
+	}
+
+	if moveString, ok := read.BestMove(positionAfter(t, "")); !ok || moveString != "e2e4" {
+		t.Errorf("expected best move e2e4, got %s", moveString)
+	}
+	if _, ok := read.BestMove(positionAfter(t, "", "e2e4")); ok {
+		t.Error("expected no book move out of book")
+	}
+
+	counts := map[string]int{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 4000; i++ {
+		moveString, _ := read.RandomMove(positionAfter(t, ""), r)
+		counts[moveString]++
+	}
+	if len(counts) != 2 || counts["e2e4"] < 2700 || counts["e2e4"] > 3300 {
+		t.Errorf("expected e2e4 about three times as often as d2d4, got %v", counts)
+	}
+}
+
+func TestReadRejectsTruncatedBook(t *testing.T) {
+	if _, err := Read(bytes.NewReader(make([]byte, 20))); err == nil {
+		t.Error("expected an error for a truncated book")
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	games, err := chess.ReadPGN(strings.NewReader(`1. e4 e5 2. Nf3 1-0
+1. e4 e5 2. Nf3 1/2-1/2
+1. e4 c5 0-1
+1. d4 d5 1-0
+1. e4 e5 2. Bc4 *
+[FEN "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1"]
+1. O-O O-O-O 1/2-1/2
+[FEN "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1"]
+1. O-O O-O-O 0-1
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	builder := NewBuilder(BuildConfig{MaxPly: 2, MinGames: 2, MinWinRate: 0.4})
+	for _, game := range games {
+		if err := builder.Add(game); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result := builder.Book()
+
+	type testCase struct {
+		name     string
+		fen      string
+		moves    string
+		expected []Move
+	}
+
+	testCases := []testCase{
+		// 1. e4 scored 1.5 from 3 games, 1. d4 was only played once
+		{"first move", "", "", []Move{{"e2e4", 3}}},
+		// 1... e5 scored 0.5 from 2 games, below the win rate
+		{"reply", "", "e2e4", nil},
+		{"past the last ply", "", "e2e4 e7e5", nil},
+		{"castling", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "", nil},
+		{"castling reply", "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1", "e1g1", []Move{{"e8c8", 3}}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		moves := result.Moves(positionAfter(t, c.fen, strings.Fields(c.moves)...))
+		if !reflect.DeepEqual(moves, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, moves)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
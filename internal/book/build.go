@@ -0,0 +1,139 @@
+package book
+
+import (
+	"fmt"
+	"math"
+	"slices"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// BuildConfig controls which moves a Builder keeps.
+type BuildConfig struct {
+	// MaxPly is the number of half moves read from the start of each game.
+	MaxPly int
+	// MinGames is the number of games a move must have been played in.
+	MinGames int
+	// MinWinRate is the fraction of points, counting draws as half, that the
+	// side playing a move must have scored with it.
+	MinWinRate float64
+}
+
+type moveKey struct {
+	key  uint64
+	move uint16
+}
+
+// moveStats counts the games a move was played in and the points it scored,
+// with two points for a win and one for a draw, as Polyglot weights do.
+type moveStats struct {
+	games  int
+	points int
+}
+
+// Builder collects moves from finished games to make a book.
+type Builder struct {
+	config BuildConfig
+	stats  map[moveKey]*moveStats
+}
+
+func NewBuilder(config BuildConfig) *Builder {
+	return &Builder{config: config, stats: map[moveKey]*moveStats{}}
+}
+
+// Add counts the opening moves of a game. Unfinished games are ignored.
+func (b *Builder) Add(game chess.PGNGame) error {
+	var whitePoints int
+	switch game.Result {
+	case "1-0":
+		whitePoints = 2
+	case "1/2-1/2":
+		whitePoints = 1
+	case "0-1":
+		whitePoints = 0
+	default:
+		return nil
+	}
+
+	fen, err := game.StartingFEN()
+	if err != nil {
+		return err
+	}
+	position := chess.Position{}
+	if err := position.LoadFEN(fen); err != nil {
+		return err
+	}
+
+	// moves are only counted once the whole game has been read, so that a
+	// broken game adds nothing
+	var played []moveKey
+	var points []int
+	for ply, san := range game.Moves {
+		if ply >= b.config.MaxPly {
+			break
+		}
+
+		moveString, err := position.ParseSAN(san)
+		if err != nil {
+			return fmt.Errorf("ply %d: %w", ply+1, err)
+		}
+
+		fen := position.GetFEN()
+		squares, err := parseBoard(fen.BoardState)
+		if err != nil {
+			return err
+		}
+		encoded, err := encodeMove(moveString, squares.isKing(moveString))
+		if err != nil {
+			return err
+		}
+
+		played = append(played, moveKey{squares.key(fen), encoded})
+		if position.WhiteToMove() {
+			points = append(points, whitePoints)
+		} else {
+			points = append(points, 2-whitePoints)
+		}
+		position.MakeMove(moveString)
+	}
+
+	for idx, theMove := range played {
+		stats, ok := b.stats[theMove]
+		if !ok {
+			stats = &moveStats{}
+			b.stats[theMove] = stats
+		}
+		stats.games++
+		stats.points += points[idx]
+	}
+	return nil
+}
+
+// Book makes a book of the moves that pass the filters, weighted by the points
+// they scored. Weights are scaled down if any would not fit in an entry.
+func (b *Builder) Book() *Book {
+	var entries []Entry
+	maxPoints := 0
+	for theMove, stats := range b.stats {
+		if stats.games < b.config.MinGames || float64(stats.points) < 2*b.config.MinWinRate*float64(stats.games) {
+			continue
+		}
+		entries = append(entries, Entry{Key: theMove.key, Move: theMove.move})
+		maxPoints = max(maxPoints, stats.points)
+	}
+
+	scale := max(1, math.Ceil(float64(maxPoints)/math.MaxUint16))
+	for idx := range entries {
+		stats := b.stats[moveKey{entries[idx].Key, entries[idx].Move}]
+		// a move that never scored still has to be playable
+		entries[idx].Weight = uint16(max(1, math.Round(float64(stats.points)/scale)))
+	}
+
+	slices.SortFunc(entries, func(a, b Entry) int {
+		if result := compareEntries(a, b); result != 0 {
+			return result
+		}
+		return int(a.Move) - int(b.Move)
+	})
+	return &Book{entries: entries}
+}
@@ -0,0 +1,165 @@
+package book
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// board holds the piece letter on each square of a FEN, indexed by
+// 8*rank + file, with zero for empty squares.
+type board [64]byte
+
+func parseBoard(boardState string) (board, error) {
+	var result board
+	rows := strings.Split(boardState, "/")
+	if len(rows) != 8 {
+		return result, fmt.Errorf("bad board %s", boardState)
+	}
+
+	for idx, row := range rows {
+		rank, file := 7-idx, 0
+		for _, c := range row {
+			switch {
+			case c >= '1' && c <= '8':
+				file += int(c - '0')
+			case strings.ContainsRune("PNBRQKpnbrqk", c) && file < 8:
+				result[8*rank+file] = byte(c)
+				file++
+			default:
+				return result, fmt.Errorf("bad board %s", boardState)
+			}
+		}
+		if file != 8 {
+			return result, fmt.Errorf("bad board %s", boardState)
+		}
+	}
+	return result, nil
+}
+
+// pieceKind is the index of a piece in the Polyglot tables: black pawn, white
+// pawn, black knight, white knight and so on.
+func pieceKind(piece byte) int {
+	kind := 2 * strings.IndexByte("pnbrqk", piece|0x20)
+	if piece < 'a' {
+		kind++
+	}
+	return kind
+}
+
+// Key is the Polyglot hash of a position. It is computed the same way by every
+// program that reads Polyglot books, and has nothing to do with the hashes
+// used inside the engine.
+func Key(fen chess.FEN) (uint64, error) {
+	squares, err := parseBoard(fen.BoardState)
+	if err != nil {
+		return 0, err
+	}
+	return squares.key(fen), nil
+}
+
+func (squares board) key(fen chess.FEN) uint64 {
+	var key uint64
+	for square, piece := range squares {
+		if piece != 0 {
+			key ^= polyglotRandoms[64*pieceKind(piece)+square]
+		}
+	}
+
+	for idx, right := range "KQkq" {
+		if strings.ContainsRune(fen.CastlingRights, right) {
+			key ^= polyglotRandoms[768+idx]
+		}
+	}
+
+	// The en passant file only counts when a pawn could actually capture there.
+	if len(fen.EnPassantSquare) == 2 {
+		file := int(fen.EnPassantSquare[0] - 'a')
+		capturer, rank := byte('P'), 4
+		if fen.ActiveColour == "b" {
+			capturer, rank = 'p', 3
+		}
+		if (file > 0 && squares[8*rank+file-1] == capturer) || (file < 7 && squares[8*rank+file+1] == capturer) {
+			key ^= polyglotRandoms[772+file]
+		}
+	}
+
+	if fen.ActiveColour == "w" {
+		key ^= polyglotRandoms[780]
+	}
+	return key
+}
+
+// castlingMoves maps the king moves used for castling in UCI to the Polyglot
+// encoding, in which the king captures its own rook.
+var castlingMoves = map[string]string{
+	"e1g1": "e1h1",
+	"e1c1": "e1a1",
+	"e8g8": "e8h8",
+	"e8c8": "e8a8",
+}
+
+const promotionPieces = " nbrq"
+
+// decodeMove converts a Polyglot move to long algebraic notation, leaving
+// castling in the Polyglot encoding.
+func decodeMove(m uint16) string {
+	square := func(idx uint16) string {
+		return string(rune('a'+idx%8)) + string(rune('1'+idx/8))
+	}
+
+	result := square((m>>6)&63) + square(m&63)
+	if promotion := (m >> 12) & 7; promotion > 0 && int(promotion) < len(promotionPieces) {
+		result += string(promotionPieces[promotion])
+	}
+	return result
+}
+
+// encodeMove converts a move in long algebraic notation to the Polyglot
+// encoding, given whether the move is made by a king.
+func encodeMove(moveString string, byKing bool) (uint16, error) {
+	if byKing {
+		if castling, ok := castlingMoves[moveString]; ok {
+			moveString = castling
+		}
+	}
+	if len(moveString) < 4 || len(moveString) > 5 {
+		return 0, fmt.Errorf("bad move %s", moveString)
+	}
+
+	square := func(s string) (uint16, error) {
+		if s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+			return 0, fmt.Errorf("bad move %s", moveString)
+		}
+		return uint16(s[1]-'1')*8 + uint16(s[0]-'a'), nil
+	}
+
+	from, err := square(moveString[0:2])
+	if err != nil {
+		return 0, err
+	}
+	to, err := square(moveString[2:4])
+	if err != nil {
+		return 0, err
+	}
+
+	result := from<<6 | to
+	if len(moveString) == 5 {
+		promotion := strings.IndexByte(promotionPieces, moveString[4])
+		if promotion < 1 {
+			return 0, fmt.Errorf("bad move %s", moveString)
+		}
+		result |= uint16(promotion) << 12
+	}
+	return result, nil
+}
+
+// isKing reports whether the piece on the starting square of a move in long
+// algebraic notation is a king.
+func (squares board) isKing(moveString string) bool {
+	if len(moveString) < 2 || moveString[0] < 'a' || moveString[0] > 'h' || moveString[1] < '1' || moveString[1] > '8' {
+		return false
+	}
+	return squares[8*int(moveString[1]-'1')+int(moveString[0]-'a')]|0x20 == 'k'
+}
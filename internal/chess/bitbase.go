@@ -0,0 +1,157 @@
+package chess
+
+// The KPK bitbase records whether white wins for every placement of a white
+// king and pawn against a black king, with the pawn on files a to d. It is
+// worked out at start-up: positions that are won or drawn outright are
+// classified first, then the rest are classified from the results of their
+// successors until nothing changes.
+
+const kpkSize = 2 * 24 * 64 * 64
+
+var kpkBitbase [kpkSize / 32]uint32
+
+type kpkResult uint8
+
+const (
+	kpkInvalid kpkResult = 0
+	kpkUnknown kpkResult = 1
+	kpkDraw    kpkResult = 2
+	kpkWin     kpkResult = 4
+)
+
+type kpkPosition struct {
+	activeColour colour
+	whiteKing    coordinate
+	blackKing    coordinate
+	pawn         coordinate
+	result       kpkResult
+}
+
+func kpkIndex(activeColour colour, whiteKing, blackKing, pawnSquare coordinate) int {
+	return int(whiteKing) | int(blackKing)<<6 | int(activeColour)<<12 |
+		int(pawnSquare.getFileIndex())<<13 | int(6-pawnSquare.getRankIndex())<<15
+}
+
+func initKPKBitbase() {
+	db := make([]kpkPosition, kpkSize)
+	for idx := range db {
+		db[idx] = newKPKPosition(idx)
+	}
+
+	for changed := true; changed; {
+		changed = false
+		for idx := range db {
+			if db[idx].result == kpkUnknown {
+				db[idx].result = db[idx].classify(db)
+				changed = changed || db[idx].result != kpkUnknown
+			}
+		}
+	}
+
+	for idx := range db {
+		if db[idx].result == kpkWin {
+			kpkBitbase[idx/32] |= 1 << (idx % 32)
+		}
+	}
+}
+
+func newKPKPosition(idx int) kpkPosition {
+	pos := kpkPosition{
+		whiteKing:    coordinate(idx & 63),
+		blackKing:    coordinate(idx >> 6 & 63),
+		activeColour: colour(idx >> 12 & 1),
+		pawn:         coordinate(8*(6-idx>>15) + idx>>13&3),
+	}
+
+	pawnControl := pawnControlFrom[white][pos.pawn]
+	promotionSquare := pos.pawn + 8
+
+	switch {
+	case kingControlFrom[pos.whiteKing].get(pos.blackKing),
+		pos.whiteKing == pos.pawn,
+		pos.blackKing == pos.pawn,
+		pos.activeColour == white && pawnControl.get(pos.blackKing):
+		pos.result = kpkInvalid
+	case pos.activeColour == white &&
+		pos.pawn.getRankIndex() == 6 &&
+		pos.whiteKing != promotionSquare &&
+		pos.blackKing != promotionSquare &&
+		(!kingControlFrom[pos.blackKing].get(promotionSquare) ||
+			kingControlFrom[pos.whiteKing].get(promotionSquare)):
+		pos.result = kpkWin
+	case pos.activeColour == black &&
+		kingControlFrom[pos.blackKing]&^(kingControlFrom[pos.whiteKing]|pawnControl) == 0:
+		pos.result = kpkDraw
+	case pos.activeColour == black &&
+		kingControlFrom[pos.blackKing].get(pos.pawn) &&
+		!kingControlFrom[pos.whiteKing].get(pos.pawn):
+		pos.result = kpkDraw
+	default:
+		pos.result = kpkUnknown
+	}
+
+	return pos
+}
+
+// classify works out the result of the position from its successors. White
+// wins if some move wins, and draws if every move draws; black the reverse.
+func (pos kpkPosition) classify(db []kpkPosition) kpkResult {
+	var result kpkResult
+
+	if pos.activeColour == white {
+		targets := kingControlFrom[pos.whiteKing]
+		for {
+			to, ok := targets.pop()
+			if !ok {
+				break
+			}
+			result |= db[kpkIndex(black, to, pos.blackKing, pos.pawn)].result
+		}
+
+		if pos.pawn.getRankIndex() < 6 {
+			push := pos.pawn + 8
+			result |= db[kpkIndex(black, pos.whiteKing, pos.blackKing, push)].result
+			if pos.pawn.getRankIndex() == 1 && push != pos.whiteKing && push != pos.blackKing {
+				result |= db[kpkIndex(black, pos.whiteKing, pos.blackKing, push+8)].result
+			}
+		}
+
+		switch {
+		case result&kpkWin != 0:
+			return kpkWin
+		case result&kpkUnknown != 0:
+			return kpkUnknown
+		default:
+			return kpkDraw
+		}
+	}
+
+	targets := kingControlFrom[pos.blackKing]
+	for {
+		to, ok := targets.pop()
+		if !ok {
+			break
+		}
+		result |= db[kpkIndex(white, pos.whiteKing, to, pos.pawn)].result
+	}
+
+	switch {
+	case result&kpkDraw != 0:
+		return kpkDraw
+	case result&kpkUnknown != 0:
+		return kpkUnknown
+	default:
+		return kpkWin
+	}
+}
+
+// probeKPK reports whether white wins with the pawn on a file from a to d and
+// on a rank from 2 to 7. A pawn anywhere else is not in the bitbase, and never
+// wins.
+func probeKPK(activeColour colour, whiteKing, pawnSquare, blackKing coordinate) bool {
+	if pawnSquare.getFileIndex() > 3 || pawnSquare.getRankIndex() < 1 || pawnSquare.getRankIndex() > 6 {
+		return false
+	}
+	idx := kpkIndex(activeColour, whiteKing, blackKing, pawnSquare)
+	return kpkBitbase[idx/32]&(1<<(idx%32)) != 0
+}
@@ -26,7 +26,7 @@ func (b *bitBoard) turnOn(coord coordinate) {
 }
 
 func (b *bitBoard) turnOff(coord coordinate) {
-	*b &= bitBoard(^uint64(1) << coord)
+	*b &= ^(1 << coord)
 }
 
 func (b *bitBoard) pop() (place coordinate, ok bool) {
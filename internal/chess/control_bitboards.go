@@ -1,5 +1,7 @@
 package chess
 
+import "math/bits"
+
 var kingControlFrom [64]bitBoard
 var knightControlFrom [64]bitBoard
 
@@ -48,3 +50,80 @@ func initKnightControlBitBoards() {
 		knightControlFrom[currentSquare] = controlBitBoard
 	}
 }
+
+// pawnControlFrom is indexed by the colour of the pawn and its square.
+var pawnControlFrom [2][64]bitBoard
+
+func initPawnControlBitBoards() {
+	for currentSquare := a1; currentSquare <= h8; currentSquare++ {
+		for player, rankDelta := range [2]int8{white: 1, black: -1} {
+			for _, fileDelta := range []int8{-1, 1} {
+				if controlledSquare, err := currentSquare.move(gridDelta{fileDelta, rankDelta}); err == nil {
+					pawnControlFrom[player][currentSquare].turnOn(controlledSquare)
+				}
+			}
+		}
+	}
+}
+
+// rayDeltas are the directions sliding pieces move in. Rook directions come
+// first, then bishop directions.
+var rayDeltas = [8]gridDelta{
+	{1, 0},
+	{0, 1},
+	{-1, 0},
+	{0, -1},
+	{1, 1},
+	{-1, 1},
+	{-1, -1},
+	{1, -1},
+}
+
+// rays holds the squares from each square to the edge of the board in each
+// direction of rayDeltas, not counting the square itself.
+var rays [8][64]bitBoard
+
+func initRayBitBoards() {
+	for direction, delta := range rayDeltas {
+		for currentSquare := a1; currentSquare <= h8; currentSquare++ {
+			for theCoord, err := currentSquare.move(delta); err == nil; theCoord, err = theCoord.move(delta) {
+				rays[direction][currentSquare].turnOn(theCoord)
+			}
+		}
+	}
+}
+
+// slidingControlFrom returns the squares controlled from a square along the
+// rays in directions, stopping at the first occupied square of each.
+func slidingControlFrom(theCoord coordinate, occupancy bitBoard, directions []int) bitBoard {
+	var control bitBoard
+	for _, direction := range directions {
+		ray := rays[direction][theCoord]
+		if blockers := ray & occupancy; blockers != 0 {
+			// rays towards higher squares meet their nearest blocker at its
+			// lowest bit, the others at its highest
+			var nearest coordinate
+			if delta := rayDeltas[direction]; delta.rankDelta > 0 || (delta.rankDelta == 0 && delta.fileDelta > 0) {
+				nearest = coordinate(bits.TrailingZeros64(uint64(blockers)))
+			} else {
+				nearest = coordinate(63 - bits.LeadingZeros64(uint64(blockers)))
+			}
+			ray &^= rays[direction][nearest]
+		}
+		control |= ray
+	}
+	return control
+}
+
+var (
+	rookDirections   = []int{0, 1, 2, 3}
+	bishopDirections = []int{4, 5, 6, 7}
+)
+
+func rookControlFrom(theCoord coordinate, occupancy bitBoard) bitBoard {
+	return slidingControlFrom(theCoord, occupancy, rookDirections)
+}
+
+func bishopControlFrom(theCoord coordinate, occupancy bitBoard) bitBoard {
+	return slidingControlFrom(theCoord, occupancy, bishopDirections)
+}
@@ -0,0 +1,39 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String draws the position as an ASCII diagram from white's point of view,
+// followed by its FEN, Zobrist key and the squares of any checking pieces.
+func (p Position) String() string {
+	var b strings.Builder
+	separator := " +---+---+---+---+---+---+---+---+\n"
+
+	b.WriteString(separator)
+	for rankIndex := int8(7); rankIndex >= 0; rankIndex-- {
+		for fileIndex := int8(0); fileIndex < 8; fileIndex++ {
+			theCoord, _ := coordinateFromRankFileIndices(fileIndex, rankIndex)
+			fmt.Fprintf(&b, " | %c", p.getSquare(theCoord).toRune())
+		}
+		fmt.Fprintf(&b, " | %d\n", rankIndex+1)
+		b.WriteString(separator)
+	}
+	b.WriteString("   a   b   c   d   e   f   g   h\n\n")
+
+	fmt.Fprintf(&b, "Fen: %s\n", p.GetFEN())
+	fmt.Fprintf(&b, "Key: %016X\n", p.hash)
+
+	b.WriteString("Checkers:")
+	checkers := p.getCheckers()
+	for {
+		theCoord, ok := checkers.pop()
+		if !ok {
+			break
+		}
+		b.WriteString(" " + theCoord.toString())
+	}
+
+	return b.String()
+}
@@ -0,0 +1,233 @@
+package chess
+
+// knownWinScore is added to the evaluation of endgames that are won with
+// correct play, so that the search heads for them and, once there, keeps
+// them. It stays well below the mate scores.
+const knownWinScore = 10000
+
+// normalScale is the scale factor that leaves the evaluation unchanged.
+const normalScale = 64
+
+// endgameEvaluator gives an exact score for a material signature, from the
+// point of view of the strong side.
+type endgameEvaluator struct {
+	strong   colour
+	evaluate func(p *Position, strong colour) int
+}
+
+var endgameEvaluators = map[materialKey]endgameEvaluator{}
+
+func initEndgames() {
+	for name, evaluate := range map[string]func(*Position, colour) int{
+		"KvK":   evaluateDraw,
+		"KNvK":  evaluateDraw,
+		"KBvK":  evaluateDraw,
+		"KNNvK": evaluateDraw,
+		"KPvK":  evaluateKPK,
+		"KRvK":  evaluateKXK,
+		"KQvK":  evaluateKXK,
+		"KBNvK": evaluateKBNK,
+		"KQvKR": evaluateKQKR,
+	} {
+		counts, err := parseMaterial(name)
+		if err != nil {
+			panic(err)
+		}
+		endgameEvaluators[materialKeyFromCounts(flipMaterial(counts))] = endgameEvaluator{black, evaluate}
+		endgameEvaluators[materialKeyFromCounts(counts)] = endgameEvaluator{white, evaluate}
+	}
+}
+
+// evaluateEndgame returns the score of a recognised endgame from white's point
+// of view.
+func (p *Position) evaluateEndgame() (int, bool) {
+	evaluator, ok := endgameEvaluators[p.materialKey()]
+	if !ok {
+		return 0, false
+	}
+
+	score := evaluator.evaluate(p, evaluator.strong)
+	if evaluator.strong == black {
+		score = -score
+	}
+	return score, true
+}
+
+// endgameScale returns the factor, out of normalScale, by which to scale an
+// evaluation that favours the strong side in endgames that are harder to win
+// than the material suggests.
+func (p *Position) endgameScale(strong colour) int {
+	if p.hasWrongBishop(strong) {
+		return 0
+	}
+	if p.hasOppositeBishops() {
+		pawnDifference := p.pieceColourTypeCounter[whitePawn] - p.pieceColourTypeCounter[blackPawn]
+		if pawnDifference <= 1 && pawnDifference >= -1 {
+			return 8
+		}
+		return 32
+	}
+	return normalScale
+}
+
+func evaluateDraw(p *Position, strong colour) int {
+	return 0
+}
+
+// evaluateKPK looks the position up in the KPK bitbase, after mirroring it so
+// that the strong side is white and the pawn is on files a to d.
+func evaluateKPK(p *Position, strong colour) int {
+	pawns := p.occupationByPieceType[pawn]
+	pawnSquare, ok := pawns.pop()
+	if !ok || pawnSquare.getRankIndex() == 0 || pawnSquare.getRankIndex() == 7 {
+		return 0
+	}
+	strongKing := p.kingSquares[strong]
+	weakKing := p.kingSquares[strong.getOpponent()]
+	activeColour := p.activeColour
+
+	if strong == black {
+		pawnSquare, strongKing, weakKing = pawnSquare^56, strongKing^56, weakKing^56
+		activeColour = activeColour.getOpponent()
+	}
+	if pawnSquare.getFileIndex() >= 4 {
+		pawnSquare, strongKing, weakKing = pawnSquare^7, strongKing^7, weakKing^7
+	}
+
+	if !probeKPK(activeColour, strongKing, pawnSquare, weakKing) {
+		return 0
+	}
+	return knownWinScore + pieceValues[pawn].endgame + 10*int(pawnSquare.getRankIndex())
+}
+
+// evaluateKXK drives the lone king to the edge of the board with the
+// strong king close by.
+func evaluateKXK(p *Position, strong colour) int {
+	strongKing := p.kingSquares[strong]
+	weakKing := p.kingSquares[strong.getOpponent()]
+
+	score := nonPawnMaterial(p, strong) + pushToEdge(weakKing) + pushClose(strongKing, weakKing)
+	return knownWinScore + score
+}
+
+// evaluateKBNK drives the lone king to a corner the bishop can cover, since
+// mate is only possible there.
+func evaluateKBNK(p *Position, strong colour) int {
+	strongKing := p.kingSquares[strong]
+	weakKing := p.kingSquares[strong.getOpponent()]
+	bishops := p.occupationByPieceType[bishop]
+	bishopSquare, _ := bishops.pop()
+
+	corners := [2]coordinate{a1, h8}
+	if isLightSquare(bishopSquare) {
+		corners = [2]coordinate{a8, h1}
+	}
+	cornerDistance := min(manhattanDistance(weakKing, corners[0]), manhattanDistance(weakKing, corners[1]))
+
+	score := nonPawnMaterial(p, strong) + pushClose(strongKing, weakKing) + 20*(14-cornerDistance)
+	return knownWinScore + score
+}
+
+// evaluateKQKR is won for the queen, but slowly, so only the material and
+// king placement count.
+func evaluateKQKR(p *Position, strong colour) int {
+	strongKing := p.kingSquares[strong]
+	weakKing := p.kingSquares[strong.getOpponent()]
+
+	score := pieceValues[queen].endgame - pieceValues[rook].endgame
+	return score + pushToEdge(weakKing) + pushClose(strongKing, weakKing)
+}
+
+// hasWrongBishop reports whether the strong side has only a bishop and rook
+// pawns on one file, the bishop cannot cover the queening square and the
+// weak king already stands by it, which is a draw.
+func (p *Position) hasWrongBishop(strong colour) bool {
+	weak := strong.getOpponent()
+	strongPieces := p.occupationByColour[strong]
+	strongPawns := strongPieces & p.occupationByPieceType[pawn]
+	weakPieces := p.occupationByColour[weak]
+
+	if p.pieceColourTypeCounter[whiteBishop+squareState(strong)] != 1 ||
+		strongPawns == 0 ||
+		(strongPieces&^strongPawns).count() != 2 ||
+		weakPieces&^p.occupationByPieceType[pawn] != 1<<p.kingSquares[weak] {
+		return false
+	}
+
+	var queeningSquare coordinate
+	switch {
+	case strongPawns&^fileA == 0:
+		queeningSquare = a8
+	case strongPawns&^fileH == 0:
+		queeningSquare = h8
+	default:
+		return false
+	}
+	if strong == black {
+		queeningSquare ^= 56
+	}
+
+	bishops := strongPieces & p.occupationByPieceType[bishop]
+	bishopSquare, _ := bishops.pop()
+	if isLightSquare(bishopSquare) == isLightSquare(queeningSquare) {
+		return false
+	}
+	return chebyshevDistance(p.kingSquares[weak], queeningSquare) <= 1
+}
+
+// hasOppositeBishops reports whether each side has a single bishop, on
+// squares of different colours, and nothing else but pawns.
+func (p *Position) hasOppositeBishops() bool {
+	if p.pieceColourTypeCounter[whiteBishop] != 1 || p.pieceColourTypeCounter[blackBishop] != 1 {
+		return false
+	}
+
+	others := p.occupationByPieceType[queen] | p.occupationByPieceType[rook] | p.occupationByPieceType[knight]
+	if others != 0 {
+		return false
+	}
+
+	bishops := p.occupationByPieceType[bishop]
+	first, _ := bishops.pop()
+	second, _ := bishops.pop()
+	return isLightSquare(first) != isLightSquare(second)
+}
+
+func nonPawnMaterial(p *Position, player colour) int {
+	total := 0
+	for theState := whiteQueen + squareState(player); theState < whitePawn; theState += 2 {
+		total += pieceValues[theState.getPieceType()].endgame * p.pieceColourTypeCounter[theState]
+	}
+	return total
+}
+
+// pushToEdge is larger the closer the square is to the edge of the board.
+func pushToEdge(c coordinate) int {
+	fileDistance := max(3-c.getFileIndex(), c.getFileIndex()-4)
+	rankDistance := max(3-c.getRankIndex(), c.getRankIndex()-4)
+	return 20 * int(fileDistance+rankDistance)
+}
+
+// pushClose is larger the closer the two squares are to each other.
+func pushClose(a, b coordinate) int {
+	return 140 - 20*chebyshevDistance(a, b)
+}
+
+func chebyshevDistance(a, b coordinate) int {
+	return max(abs(int(a.getFileIndex()-b.getFileIndex())), abs(int(a.getRankIndex()-b.getRankIndex())))
+}
+
+func manhattanDistance(a, b coordinate) int {
+	return abs(int(a.getFileIndex()-b.getFileIndex())) + abs(int(a.getRankIndex()-b.getRankIndex()))
+}
+
+func isLightSquare(c coordinate) bool {
+	return (c.getFileIndex()+c.getRankIndex())%2 == 1
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
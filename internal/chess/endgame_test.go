@@ -0,0 +1,172 @@
+package chess
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKPKBitbase(t *testing.T) {
+	type testCase struct {
+		name        string
+		fen         string
+		expectedWin bool
+	}
+
+	testCases := []testCase{
+		{"pawn on the seventh", "4k3/4P3/4K3/8/8/8/8/8 w - - 0 1", true},
+		{"stalemate", "4k3/4P3/4K3/8/8/8/8/8 b - - 0 1", false},
+		{"king in front", "4k3/8/4K3/4P3/8/8/8/8 w - - 0 1", true},
+		{"key square", "4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", true},
+		{"spare tempo", "4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", true},
+		{"rook pawn", "k7/8/K7/P7/8/8/8/8 w - - 0 1", false},
+		{"pawn outruns the king", "8/8/8/8/8/8/P7/K6k w - - 0 1", true},
+		{"pawn caught", "8/8/8/8/8/8/P7/K6k b - - 0 1", true},
+		{"pawn captured", "8/8/8/8/8/1k6/P7/7K b - - 0 1", false},
+		{"black pawn stalemate", "8/8/8/8/8/4k3/4p3/4K3 w - - 0 1", false},
+		{"black pawn", "8/8/8/8/8/4k3/4p3/4K3 b - - 0 1", true},
+		{"black pawn key square", "8/8/8/8/4p3/4k3/8/4K3 w - - 0 1", true},
+		{"h pawn", "8/8/8/8/8/8/7P/k6K w - - 0 1", true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		score := thePosition.Evaluate()
+		if won := score != 0; won != c.expectedWin {
+			t.Errorf("expected win %v, got score %v", c.expectedWin, score)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestKPKBackRankPawn(t *testing.T) {
+	type testCase struct {
+		name   string
+		fen    string
+		pawn   coordinate
+		colour colour
+	}
+
+	// LoadFEN refuses pawns on the back rank, so they are put there after
+	testCases := []testCase{
+		{"white pawn", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", a8, white},
+		{"white pawn with black to move", "4k3/8/8/8/8/8/8/4K3 b - - 0 1", h8, white},
+		{"black pawn", "4k3/8/8/8/8/8/8/4K3 w - - 0 1", a1, black},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		theState := whitePawn
+		if c.colour == black {
+			theState = blackPawn
+		}
+		thePosition.setSquare(c.pawn, theState)
+
+		if score := evaluateKPK(&thePosition, c.colour); score != 0 {
+			t.Errorf("expected no score for a pawn the bitbase does not hold, got %v", score)
+		}
+		if probeKPK(white, e1, c.pawn, e8) {
+			t.Errorf("expected no win with the pawn on %v", c.pawn.toString())
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestEndgameEvaluators(t *testing.T) {
+	type testCase struct {
+		name   string
+		better string
+		worse  string
+	}
+
+	testCases := []testCase{
+		{"KRK edge", "8/8/8/8/8/2K5/7R/k7 w - - 0 1", "8/8/8/3k4/8/2K5/7R/8 w - - 0 1"},
+		{"KQK kings close", "8/8/8/8/8/2K5/7Q/k7 w - - 0 1", "K7/8/8/8/8/8/7Q/k7 w - - 0 1"},
+		{"KBNK right corner", "8/8/8/8/8/5K2/8/3BN2k w - - 0 1", "8/8/8/8/8/2K5/8/k2BN3 w - - 0 1"},
+		{"KQKR edge", "8/8/8/8/8/2K5/1r6/k2Q4 b - - 0 1", "8/8/8/3k4/8/2K5/1r6/3Q4 b - - 0 1"},
+		{"known win", "8/8/8/3k4/8/2K5/7R/8 w - - 0 1", "rnbqkbnr/8/8/8/8/8/PPPPPPPP/RNBQKBNR w - - 0 1"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		better := loadPosition(t, c.better)
+		worse := loadPosition(t, c.worse)
+		if better.Evaluate() <= worse.Evaluate() {
+			t.Errorf("expected %v to be more than %v", better.Evaluate(), worse.Evaluate())
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestEndgameScaling(t *testing.T) {
+	type testCase struct {
+		name          string
+		fen           string
+		expectedDrawn bool
+	}
+
+	testCases := []testCase{
+		{"drawn minor pieces", "k7/8/8/8/8/8/8/KNN5 w - - 0 1", true},
+		{"wrong bishop", "k7/8/8/P7/8/8/8/K1B5 w - - 0 1", true},
+		{"wrong bishop for black", "k1b5/8/8/8/p7/8/8/K7 b - - 0 1", true},
+		{"right bishop", "k7/8/8/P7/8/8/8/K2B4 w - - 0 1", false},
+		{"king away from the corner", "8/8/8/P3k3/8/8/8/K1B5 w - - 0 1", false},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		score := thePosition.Evaluate()
+		if drawn := score == 0; drawn != c.expectedDrawn {
+			t.Errorf("expected drawn %v, got score %v", c.expectedDrawn, score)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+
+	t.Run("opposite coloured bishops", func(t *testing.T) {
+		opposite := loadPosition(t, "4k3/5b2/8/p7/P1P5/8/3B4/4K3 w - - 0 1")
+		same := loadPosition(t, "4k3/4b3/8/p7/P1P5/8/3B4/4K3 w - - 0 1")
+		if opposite.Evaluate() >= same.Evaluate()/2 {
+			t.Errorf("expected %v to be small compared with %v", opposite.Evaluate(), same.Evaluate())
+		}
+	})
+}
+
+func TestEndgameEvaluationIsColourSymmetric(t *testing.T) {
+	for _, fen := range []string{
+		"8/8/8/8/3k4/8/3P4/4K3 w - - 0 1",
+		"8/8/8/8/8/5K2/8/3BN2k b - - 0 1",
+		"8/8/8/8/8/2K5/1r6/k2Q4 b - - 0 1",
+		"k7/8/8/P7/8/8/8/K1B5 w - - 0 1",
+		"4k3/5b2/8/p7/P1P5/8/3B4/4K3 w - - 0 1",
+	} {
+		thePosition := loadPosition(t, fen)
+		original := thePosition.evaluate()
+
+		thePosition.Flip()
+		if result := thePosition.evaluate(); result != original {
+			t.Errorf("%s: expected flipped evaluation %v, got %v", fen, original, result)
+		}
+	}
+}
+
+func TestSearchKeepsKPKWin(t *testing.T) {
+	thePosition := loadPosition(t, "4k3/8/4K3/4P3/8/8/8/8 w - - 0 1")
+
+	var lastInfo SearchInfo
+	searcher := Searcher{}
+	searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 6}, func(info SearchInfo) { lastInfo = info })
+
+	if lastInfo.Score < knownWinScore {
+		t.Errorf("expected a winning score, got %v", lastInfo.Score)
+	}
+}
@@ -0,0 +1,83 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+// EPD is an extended position description: the first four fields of a FEN,
+// followed by operations such as bm (best move) and id, each with a list of
+// operands.
+type EPD struct {
+	FEN        FEN
+	Operations map[string][]string
+}
+
+// ParseEPD reads a single EPD record. Quoted operands are returned without
+// their quotes.
+func ParseEPD(s string) (EPD, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 4 {
+		return EPD{}, fmt.Errorf("bad EPD: expected at least 4 fields, got %d", len(fields))
+	}
+
+	fen, err := ParseFEN(strings.Join(fields[:4], " "))
+	if err != nil {
+		return EPD{}, err
+	}
+
+	result := EPD{FEN: fen, Operations: map[string][]string{}}
+
+	operations := strings.Join(fields[4:], " ")
+	for operations != "" {
+		var operation string
+		operation, operations, err = cutOperation(operations)
+		if err != nil {
+			return EPD{}, err
+		}
+
+		opcode, operands, _ := strings.Cut(strings.TrimSpace(operation), " ")
+		if opcode == "" {
+			continue
+		}
+		result.Operations[opcode] = splitOperands(operands)
+	}
+
+	return result, nil
+}
+
+// cutOperation splits off the first semicolon terminated operation, ignoring
+// semicolons inside quoted operands.
+func cutOperation(s string) (operation, rest string, err error) {
+	quoted := false
+	for idx, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ';' && !quoted:
+			return s[:idx], s[idx+1:], nil
+		}
+	}
+
+	if quoted {
+		return "", "", fmt.Errorf("bad EPD: unterminated string in %s", s)
+	}
+	return s, "", nil
+}
+
+func splitOperands(s string) []string {
+	var result []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		if s[0] == '"' {
+			operand, rest, _ := strings.Cut(s[1:], `"`)
+			result = append(result, operand)
+			s = rest
+			continue
+		}
+
+		operand, rest, _ := strings.Cut(s, " ")
+		result = append(result, operand)
+		s = rest
+	}
+	return result
+}
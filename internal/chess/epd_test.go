@@ -0,0 +1,67 @@
+package chess
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseEPD(t *testing.T) {
+	type testCase struct {
+		name          string
+		input         string
+		expected      EPD
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{
+			"best move and id",
+			`2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1 w - - bm Qg6; id "WAC.001";`,
+			EPD{
+				FEN:        FEN{"2rr3k/pp3pp1/1nnqbN1p/3pN3/2pP4/2P3Q1/PPB4P/R4RK1", "w", "-", "-", "0", "1"},
+				Operations: map[string][]string{"bm": {"Qg6"}, "id": {"WAC.001"}},
+			},
+			false,
+		},
+		{
+			"several operands",
+			`r1b1k2r/ppppnppp/2n2q2/2b5/3NP3/2P1B3/PP3PPP/RN1QKB1R w KQkq - am Nb5 Be2; c0 "semicolons; in comments";`,
+			EPD{
+				FEN:        FEN{"r1b1k2r/ppppnppp/2n2q2/2b5/3NP3/2P1B3/PP3PPP/RN1QKB1R", "w", "KQkq", "-", "0", "1"},
+				Operations: map[string][]string{"am": {"Nb5", "Be2"}, "c0": {"semicolons; in comments"}},
+			},
+			false,
+		},
+		{
+			"no operations",
+			"8/8/8/8/8/8/8/K6k b - -",
+			EPD{
+				FEN:        FEN{"8/8/8/8/8/8/8/K6k", "b", "-", "-", "0", "1"},
+				Operations: map[string][]string{},
+			},
+			false,
+		},
+		{"too short", "8/8/8/8/8/8/8/K6k b -", EPD{}, true},
+		{"unterminated string", `8/8/8/8/8/8/8/K6k b - - id "oops;`, EPD{}, true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		result, err := ParseEPD(c.input)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("expected error, got %v", result)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
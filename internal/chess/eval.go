@@ -0,0 +1,318 @@
+package chess
+
+type taperedScore struct {
+	midgame, endgame int
+}
+
+func (s *taperedScore) add(other taperedScore) {
+	s.midgame += other.midgame
+	s.endgame += other.endgame
+}
+
+func (s *taperedScore) subtract(other taperedScore) {
+	s.midgame -= other.midgame
+	s.endgame -= other.endgame
+}
+
+func (s taperedScore) scale(factor int) taperedScore {
+	return taperedScore{s.midgame * factor, s.endgame * factor}
+}
+
+type evaluationTerm uint8
+
+const (
+	materialTerm evaluationTerm = iota
+	pieceSquareTerm
+	mobilityTerm
+	pawnStructureTerm
+	numEvaluationTerms
+)
+
+var evaluationTermNames = [numEvaluationTerms]string{
+	materialTerm:      "Material",
+	pieceSquareTerm:   "Piece squares",
+	mobilityTerm:      "Mobility",
+	pawnStructureTerm: "Pawns",
+}
+
+const maxPhase = 24
+
+var phaseWeights = [6]int{
+	king:   0,
+	queen:  4,
+	rook:   2,
+	bishop: 1,
+	knight: 1,
+	pawn:   0,
+}
+
+var pieceValues = [6]taperedScore{
+	king:   {0, 0},
+	queen:  {1025, 936},
+	rook:   {477, 512},
+	bishop: {365, 297},
+	knight: {337, 281},
+	pawn:   {82, 94},
+}
+
+var (
+	mobilityBonus     = taperedScore{2, 3}
+	doubledPawnBonus  = taperedScore{-10, -20}
+	isolatedPawnBonus = taperedScore{-10, -15}
+	passedPawnBonus   = [8]taperedScore{
+		{0, 0}, {5, 10}, {10, 15}, {15, 25}, {25, 45}, {40, 70}, {60, 110}, {0, 0},
+	}
+)
+
+// Piece square tables are laid out as seen from white's side of the board, so
+// that the first row lists a8 to h8.
+var pieceSquareValues = [6][64]int{
+	king: {
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-30, -40, -40, -50, -50, -40, -40, -30,
+		-20, -30, -30, -40, -40, -30, -30, -20,
+		-10, -20, -20, -20, -20, -20, -20, -10,
+		20, 20, 0, 0, 0, 0, 20, 20,
+		20, 30, 10, 0, 0, 10, 30, 20,
+	},
+	queen: {
+		-20, -10, -10, -5, -5, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 5, 5, 5, 0, -10,
+		-5, 0, 5, 5, 5, 5, 0, -5,
+		0, 0, 5, 5, 5, 5, 0, -5,
+		-10, 5, 5, 5, 5, 5, 0, -10,
+		-10, 0, 5, 0, 0, 0, 0, -10,
+		-20, -10, -10, -5, -5, -10, -10, -20,
+	},
+	rook: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		5, 10, 10, 10, 10, 10, 10, 5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		-5, 0, 0, 0, 0, 0, 0, -5,
+		0, 0, 0, 5, 5, 0, 0, 0,
+	},
+	bishop: {
+		-20, -10, -10, -10, -10, -10, -10, -20,
+		-10, 0, 0, 0, 0, 0, 0, -10,
+		-10, 0, 5, 10, 10, 5, 0, -10,
+		-10, 5, 5, 10, 10, 5, 5, -10,
+		-10, 0, 10, 10, 10, 10, 0, -10,
+		-10, 10, 10, 10, 10, 10, 10, -10,
+		-10, 5, 0, 0, 0, 0, 5, -10,
+		-20, -10, -10, -10, -10, -10, -10, -20,
+	},
+	knight: {
+		-50, -40, -30, -30, -30, -30, -40, -50,
+		-40, -20, 0, 0, 0, 0, -20, -40,
+		-30, 0, 10, 15, 15, 10, 0, -30,
+		-30, 5, 15, 20, 20, 15, 5, -30,
+		-30, 0, 15, 20, 20, 15, 0, -30,
+		-30, 5, 10, 15, 15, 10, 5, -30,
+		-40, -20, 0, 5, 5, 0, -20, -40,
+		-50, -40, -30, -30, -30, -30, -40, -50,
+	},
+	pawn: {
+		0, 0, 0, 0, 0, 0, 0, 0,
+		50, 50, 50, 50, 50, 50, 50, 50,
+		10, 10, 20, 30, 30, 20, 10, 10,
+		5, 5, 10, 25, 25, 10, 5, 5,
+		0, 0, 0, 20, 20, 0, 0, 0,
+		5, -5, -10, 0, 0, -10, -5, 5,
+		5, 10, 10, -20, -20, 10, 10, 5,
+		0, 0, 0, 0, 0, 0, 0, 0,
+	},
+}
+
+var kingEndgameSquareValues = [64]int{
+	-50, -40, -30, -20, -20, -30, -40, -50,
+	-30, -20, -10, 0, 0, -10, -20, -30,
+	-30, -10, 20, 30, 30, 20, -10, -30,
+	-30, -10, 30, 40, 40, 30, -10, -30,
+	-30, -10, 30, 40, 40, 30, -10, -30,
+	-30, -10, 20, 30, 30, 20, -10, -30,
+	-30, -30, 0, 0, 0, 0, -30, -30,
+	-50, -30, -30, -30, -30, -30, -30, -50,
+}
+
+// pieceSquareTableIndex returns the index into the piece square tables for a
+// piece on a square.
+func pieceSquareTableIndex(theState squareState, theCoord coordinate) coordinate {
+	if theState.getColour() == black {
+		return theCoord
+	}
+	return theCoord ^ 56
+}
+
+// pieceSquareTables is indexed by squareState and coordinate.
+var pieceSquareTables [12][64]taperedScore
+
+func initPieceSquareTables() {
+	for theState := whiteKing; theState < empty; theState++ {
+		thePieceType := theState.getPieceType()
+		for theCoord := a1; theCoord <= h8; theCoord++ {
+			tableIndex := pieceSquareTableIndex(theState, theCoord)
+			value := pieceSquareValues[thePieceType][tableIndex]
+			pieceSquareTables[theState][theCoord] = taperedScore{value, value}
+			if thePieceType == king {
+				pieceSquareTables[theState][theCoord].endgame = kingEndgameSquareValues[tableIndex]
+			}
+		}
+	}
+}
+
+// EvaluationTerm is one component of the static evaluation, in centipawns,
+// with the contribution of each side given separately.
+type EvaluationTerm struct {
+	Name  string
+	White int
+	Black int
+}
+
+// Evaluate returns the static evaluation of the position in centipawns from
+// white's point of view. Recognised endgames are scored exactly, and drawish
+// ones are scaled towards zero.
+func (p *Position) Evaluate() int {
+	if score, ok := p.evaluateEndgame(); ok {
+		return score
+	}
+
+	terms := p.evaluateTerms()
+
+	var total taperedScore
+	for _, term := range terms {
+		total.add(term[white])
+		total.subtract(term[black])
+	}
+
+	score := p.taper(total)
+	strong := white
+	if score < 0 {
+		strong = black
+	}
+	return score * p.endgameScale(strong) / normalScale
+}
+
+// EvaluationBreakdown returns the terms that make up Evaluate. The last term
+// holds whatever recognised endgames and endgame scaling add on top of the
+// others, credited to the side it favours, so that the terms sum to Evaluate.
+func (p *Position) EvaluationBreakdown() []EvaluationTerm {
+	terms := p.evaluateTerms()
+
+	result := make([]EvaluationTerm, numEvaluationTerms, numEvaluationTerms+1)
+	sum := 0
+	for idx, term := range terms {
+		result[idx] = EvaluationTerm{
+			Name:  evaluationTermNames[idx],
+			White: p.taper(term[white]),
+			Black: p.taper(term[black]),
+		}
+		sum += result[idx].White - result[idx].Black
+	}
+
+	adjustment := EvaluationTerm{Name: "Endgame"}
+	if difference := p.Evaluate() - sum; difference > 0 {
+		adjustment.White = difference
+	} else {
+		adjustment.Black = -difference
+	}
+	return append(result, adjustment)
+}
+
+// evaluate returns the static evaluation of the position in centipawns, from
+// the point of view of the side to move.
+func (p *Position) evaluate() int {
+	if p.activeColour == black {
+		return -p.Evaluate()
+	}
+	return p.Evaluate()
+}
+
+func (p *Position) evaluateTerms() [numEvaluationTerms][2]taperedScore {
+	var terms [numEvaluationTerms][2]taperedScore
+
+	for theState := whiteKing; theState < empty; theState++ {
+		value := pieceValues[theState.getPieceType()].scale(p.pieceColourTypeCounter[theState])
+		terms[materialTerm][theState.getColour()].add(value)
+	}
+
+	for player := white; player <= black; player++ {
+		pieces := p.occupationByColour[player]
+		for {
+			theCoord, ok := pieces.pop()
+			if !ok {
+				break
+			}
+			terms[pieceSquareTerm][player].add(pieceSquareTables[p.board[theCoord]][theCoord])
+		}
+
+		mobility := (p.controlByColour[player] &^ p.occupationByColour[player]).count()
+		terms[mobilityTerm][player] = mobilityBonus.scale(mobility)
+
+		terms[pawnStructureTerm][player] = p.evaluatePawnStructure(player)
+	}
+
+	return terms
+}
+
+func (p *Position) evaluatePawnStructure(player colour) taperedScore {
+	doubled, isolated, passed := p.countPawnFeatures(player)
+
+	result := doubledPawnBonus.scale(doubled)
+	result.add(isolatedPawnBonus.scale(isolated))
+	for relativeRank, count := range passed {
+		result.add(passedPawnBonus[relativeRank].scale(count))
+	}
+	return result
+}
+
+// countPawnFeatures counts the player's doubled and isolated pawns, along
+// with the passed pawns on each rank as seen from the player's side.
+func (p *Position) countPawnFeatures(player colour) (doubled, isolated int, passed [8]int) {
+	friendlyPawns := p.occupationByColour[player] & p.occupationByPieceType[pawn]
+	enemyPawns := p.occupationByColour[player.getOpponent()] & p.occupationByPieceType[pawn]
+
+	for fileIndex := 0; fileIndex < 8; fileIndex++ {
+		pawnsOnFile := (friendlyPawns & fileMasks[fileIndex]).count()
+		if pawnsOnFile > 1 {
+			doubled += pawnsOnFile - 1
+		}
+		if pawnsOnFile > 0 && friendlyPawns&adjacentFileMasks[fileIndex] == 0 {
+			isolated += pawnsOnFile
+		}
+	}
+
+	for {
+		theCoord, ok := friendlyPawns.pop()
+		if !ok {
+			break
+		}
+		if enemyPawns&passedPawnMasks[player][theCoord] == 0 {
+			relativeRank := theCoord.getRankIndex()
+			if player == black {
+				relativeRank = 7 - relativeRank
+			}
+			passed[relativeRank]++
+		}
+	}
+
+	return doubled, isolated, passed
+}
+
+func (p *Position) getPhase() int {
+	phase := 0
+	for theState := whiteKing; theState < empty; theState++ {
+		phase += phaseWeights[theState.getPieceType()] * p.pieceColourTypeCounter[theState]
+	}
+	return min(phase, maxPhase)
+}
+
+func (p *Position) taper(s taperedScore) int {
+	phase := p.getPhase()
+	return (s.midgame*phase + s.endgame*(maxPhase-phase)) / maxPhase
+}
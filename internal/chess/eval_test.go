@@ -0,0 +1,85 @@
+package chess
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		expectedSign int
+	}
+
+	testCases := []testCase{
+		{"starting position", GetStartingFEN(), 0},
+		{"extra queen", FEN{"rnb1kbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR", "w", "KQkq", "-", "0", "1"}, 1},
+		{"advanced passed pawn", FEN{"4k3/1P6/8/8/8/8/6p1/4K3", "w", "-", "-", "0", "1"}, 0},
+		{"doubled isolated pawns", FEN{"4k3/pp6/8/8/8/2P5/2P5/4K3", "w", "-", "-", "0", "1"}, -1},
+	}
+
+	sign := func(x int) int {
+		if x > 0 {
+			return 1
+		}
+		if x < 0 {
+			return -1
+		}
+		return 0
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		if result := thePosition.Evaluate(); sign(result) != c.expectedSign {
+			t.Errorf("expected evaluation with sign %v, got %v", c.expectedSign, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestEvaluateIsColourSymmetric(t *testing.T) {
+	for _, f := range []FEN{
+		operaGame,
+		{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R", "w", "KQkq", "-", "0", "1"},
+		{"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8", "w", "-", "-", "0", "1"},
+	} {
+		thePosition := Position{}
+		thePosition.LoadFEN(f)
+		original := thePosition.evaluate()
+		breakdown := thePosition.EvaluationBreakdown()
+
+		thePosition.Flip()
+		if result := thePosition.evaluate(); result != original {
+			t.Errorf("%s: expected flipped evaluation %v, got %v", f, original, result)
+		}
+
+		for idx, term := range thePosition.EvaluationBreakdown() {
+			if term.White != breakdown[idx].Black || term.Black != breakdown[idx].White {
+				t.Errorf("%s: term %s is not symmetric", f, term.Name)
+			}
+		}
+	}
+}
+
+func TestEvaluationBreakdownSumsToEvaluate(t *testing.T) {
+	for _, f := range []FEN{
+		operaGame,
+		{"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8", "w", "-", "-", "0", "1"},
+		{"8/8/8/8/8/4k3/4P3/4K3", "w", "-", "-", "0", "1"},
+		{"8/8/8/4k3/8/8/8/4K2Q", "w", "-", "-", "0", "1"},
+		{"8/5k2/8/2b3p1/8/3B4/5P1P/6K1", "b", "-", "-", "0", "1"},
+	} {
+		thePosition := Position{}
+		thePosition.LoadFEN(f)
+
+		sum := 0
+		for _, term := range thePosition.EvaluationBreakdown() {
+			sum += term.White - term.Black
+		}
+		if expected := thePosition.Evaluate(); sum != expected {
+			t.Errorf("%s: expected terms to sum to %v, got %v", f, expected, sum)
+		}
+	}
+}
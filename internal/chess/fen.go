@@ -1,5 +1,11 @@
 package chess
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 type FEN struct {
 	BoardState      string
 	ActiveColour    string
@@ -9,7 +15,7 @@ type FEN struct {
 	FullMoveNumber  string
 }
 
-func (f FEN) toString() string {
+func (f FEN) String() string {
 	return f.BoardState + " " + f.ActiveColour + " " + f.CastlingRights + " " + f.EnPassantSquare + " " + f.HalfMoveClock + " " + f.FullMoveNumber
 }
 
@@ -23,3 +29,79 @@ func GetStartingFEN() FEN {
 		FullMoveNumber:  "1",
 	}
 }
+
+func (p Position) GetFEN() FEN {
+	var boardState strings.Builder
+	for rankIndex := int8(7); rankIndex >= 0; rankIndex-- {
+		emptyCount := 0
+		for fileIndex := int8(0); fileIndex < 8; fileIndex++ {
+			theCoord, _ := coordinateFromRankFileIndices(fileIndex, rankIndex)
+			theState := p.getSquare(theCoord)
+			if theState == empty {
+				emptyCount++
+				continue
+			}
+			if emptyCount > 0 {
+				boardState.WriteString(strconv.Itoa(emptyCount))
+				emptyCount = 0
+			}
+			boardState.WriteRune(theState.toRune())
+		}
+		if emptyCount > 0 {
+			boardState.WriteString(strconv.Itoa(emptyCount))
+		}
+		if rankIndex > 0 {
+			boardState.WriteRune('/')
+		}
+	}
+
+	activeColour := "w"
+	if p.activeColour == black {
+		activeColour = "b"
+	}
+
+	castling := ""
+	for idx, flag := range []castlingRights{whiteCastleKingSide, whiteCastleQueenSide, blackCastleKingSide, blackCastleQueenSide} {
+		if p.castlingRights.isSet(flag) {
+			castling += string("KQkq"[idx])
+		}
+	}
+	if castling == "" {
+		castling = "-"
+	}
+
+	enPassantSquare := "-"
+	if p.enPassantSquare != nullCoordinate {
+		enPassantSquare = p.enPassantSquare.toString()
+	}
+
+	return FEN{
+		BoardState:      boardState.String(),
+		ActiveColour:    activeColour,
+		CastlingRights:  castling,
+		EnPassantSquare: enPassantSquare,
+		HalfMoveClock:   strconv.Itoa(int(p.halfMoveClock)),
+		FullMoveNumber:  strconv.Itoa(p.fullMoveNumber),
+	}
+}
+
+// ParseFEN splits a FEN string into its fields. The half move clock and full
+// move number may be omitted, as they are in EPD records.
+func ParseFEN(s string) (FEN, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 4 {
+		fields = append(fields, "0", "1")
+	}
+	if len(fields) != 6 {
+		return FEN{}, fmt.Errorf("bad FEN: expected 4 or 6 fields, got %d", len(fields))
+	}
+
+	return FEN{
+		BoardState:      fields[0],
+		ActiveColour:    fields[1],
+		CastlingRights:  fields[2],
+		EnPassantSquare: fields[3],
+		HalfMoveClock:   fields[4],
+		FullMoveNumber:  fields[5],
+	}, nil
+}
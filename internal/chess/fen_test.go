@@ -0,0 +1,101 @@
+package chess
+
+import "testing"
+
+func TestGetFEN(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moveStrings []string
+		expected    string
+	}
+
+	testCases := []testCase{
+		{
+			"starting position",
+			GetStartingFEN(),
+			nil,
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		},
+		{
+			"opera",
+			operaGame,
+			nil,
+			"3rkb1r/p2nqppp/5n2/1B2p1B1/4P3/1Q6/PPP2PPP/2KR3R w k - 3 13",
+		},
+		{
+			"after moves",
+			GetStartingFEN(),
+			[]string{"e2e4", "c7c5", "g1f3"},
+			"rnbqkbnr/pp1ppppp/8/2p5/4P3/5N2/PPPP1PPP/RNBQKB1R b KQkq - 1 2",
+		},
+		{
+			"en passant square",
+			GetStartingFEN(),
+			[]string{"e2e4"},
+			"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		for _, moveString := range c.moveStrings {
+			if err := thePosition.MakeMove(moveString); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if result := thePosition.GetFEN().String(); result != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestParseFEN(t *testing.T) {
+	type testCase struct {
+		name        string
+		input       string
+		expected    FEN
+		expectError bool
+	}
+
+	testCases := []testCase{
+		{
+			"full",
+			"3rkb1r/p2nqppp/5n2/1B2p1B1/4P3/1Q6/PPP2PPP/2KR3R w k - 3 13",
+			operaGame,
+			false,
+		},
+		{
+			"without clocks",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq -",
+			GetStartingFEN(),
+			false,
+		},
+		{
+			"too short",
+			"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w",
+			FEN{},
+			true,
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		result, err := ParseFEN(c.input)
+		if (err != nil) != c.expectError {
+			t.Fatalf("expected error %v, got %v", c.expectError, err)
+		}
+		if result != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -3,4 +3,13 @@ package chess
 func init() {
 	initKingControlBitBoards()
 	initKnightControlBitBoards()
+	initPawnControlBitBoards()
+	initRayBitBoards()
+	initPawnBitBoards()
+	initZobristKeys()
+	initPieceSquareTables()
+	initSyzygyTables()
+	initKPKBitbase()
+	initEndgames()
+	initReductions()
 }
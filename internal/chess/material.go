@@ -0,0 +1,58 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+// materialKey identifies the material of a position, with three bits for the
+// number of each kind of piece other than kings.
+type materialKey uint32
+
+func materialKeyFromCounts(counts [12]int) materialKey {
+	var key materialKey
+	for theState := whiteQueen; theState < empty; theState++ {
+		key |= materialKey(counts[theState]) << (3 * (theState - whiteQueen))
+	}
+	return key
+}
+
+func (p Position) materialKey() materialKey {
+	return materialKeyFromCounts(p.pieceColourTypeCounter)
+}
+
+// parseMaterial reads piece counts from a name such as KRPvKR, which lists
+// the white pieces, then the black pieces. Each side must have exactly one
+// king, written first.
+func parseMaterial(name string) ([12]int, error) {
+	var counts [12]int
+
+	white, black, ok := strings.Cut(name, "v")
+	if !ok {
+		return counts, fmt.Errorf("unrecognised material %s", name)
+	}
+
+	for colourIdx, side := range []string{white, black} {
+		if !strings.HasPrefix(side, "K") {
+			return counts, fmt.Errorf("unrecognised material %s", name)
+		}
+		for _, letter := range side {
+			theState, err := squareStateFromRune(letter)
+			if err != nil || (theState.getPieceType() == king && letter != 'K') {
+				return counts, fmt.Errorf("unrecognised material %s", name)
+			}
+			counts[theState+squareState(colourIdx)]++
+		}
+	}
+
+	return counts, nil
+}
+
+// flipMaterial swaps the piece counts of the two colours.
+func flipMaterial(counts [12]int) [12]int {
+	var flipped [12]int
+	for theState, count := range counts {
+		flipped[theState^1] = count
+	}
+	return flipped
+}
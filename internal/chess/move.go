@@ -2,6 +2,7 @@ package chess
 
 import (
 	"fmt"
+	"strings"
 )
 
 type algebraicMove struct {
@@ -115,6 +116,14 @@ func (m move) getCurrentEPSquare() coordinate {
 	return coordinate((m & moveMaskEPSquare) >> moveOffsetEPSquare)
 }
 
+func (m move) toString() string {
+	s := m.getFromCoordinate().toString() + m.getToCoordinate().toString()
+	if promotionTo := m.getPromotionTo(); promotionTo != empty {
+		s += strings.ToLower(string(promotionTo.toRune()))
+	}
+	return s
+}
+
 type moveList []move
 
 func (l *moveList) add(theMove move) {
@@ -142,3 +151,16 @@ func (l moveList) contains(query move) bool {
 	}
 	return false
 }
+
+func (l moveList) restrictedTo(moveStrings []string) moveList {
+	result := moveList{}
+	for _, element := range l {
+		for _, moveString := range moveStrings {
+			if element.toString() == strings.ToLower(moveString) {
+				result.add(element)
+				break
+			}
+		}
+	}
+	return result
+}
@@ -0,0 +1,346 @@
+package chess
+
+// noMove stands for the absence of a move. No real move encodes to zero, as
+// a move that captures nothing holds empty as its captured piece.
+const noMove move = 0
+
+// maxHistory bounds the history scores, which are pulled back towards zero
+// the closer they get to it.
+const maxHistory = 16384
+
+// pieceTo is a table of scores indexed by the moving piece and its
+// destination.
+type pieceTo [12][64]int16
+
+// moveHistory holds what the search has learnt about which quiet moves tend
+// to cause cut-offs.
+type moveHistory struct {
+	// killers are the last two quiet moves to cause a cut-off at each ply.
+	killers [maxPly][2]move
+	// butterfly is indexed by the colour moving and the move's squares.
+	butterfly [2][64][64]int16
+	// counterMoves are the quiet moves that last refuted each move, indexed
+	// by the piece that made it and its destination.
+	counterMoves [12][64]move
+	// continuation scores moves by the piece and destination of the move
+	// before, and of the move before that.
+	continuation [12][64]pieceTo
+}
+
+func (h *moveHistory) clear() {
+	*h = moveHistory{}
+}
+
+// updateHistory adds bonus to a history score, scaled down as the score nears
+// maxHistory so that it stays in bounds and recent results count for more.
+func updateHistory(entry *int16, bonus int) {
+	*entry += int16(bonus - int(*entry)*abs(bonus)/maxHistory)
+}
+
+// historyBonus is how much a cut-off at depth is worth to the history of the
+// move that caused it.
+func historyBonus(depth int) int {
+	return min(32*depth*depth, 1200)
+}
+
+// storeKiller records a quiet move that caused a cut-off at ply.
+func (h *moveHistory) storeKiller(ply int, theMove move) {
+	if sameMove(h.killers[ply][0], theMove) {
+		return
+	}
+	h.killers[ply][1] = h.killers[ply][0]
+	h.killers[ply][0] = theMove
+}
+
+// sameMove reports whether two moves go between the same squares with the
+// same promotion, whatever position they were generated in.
+func sameMove(a, b move) bool {
+	const mask = moveMaskFrom | moveMaskTo | moveMaskPromotionTo
+	return a&mask == b&mask
+}
+
+// searchStackEntry records a move played on the way to the current node.
+type searchStackEntry struct {
+	theMove move
+	// piece is the piece that stands on the destination once the move is
+	// made, or empty if no move was made.
+	piece squareState
+}
+
+type pickStage uint8
+
+const (
+	stageHashMove pickStage = iota
+	stageScoreNoisy
+	stageGoodNoisy
+	stageRefutations
+	stageScoreQuiets
+	stageQuiets
+	stageBadNoisy
+	stageDone
+)
+
+type scoredMove struct {
+	theMove move
+	score   int
+}
+
+// movePicker hands out the legal moves of a position one at a time, best
+// first: the hash move, captures and promotions that look good, killers, the
+// counter move, the other quiet moves by history and finally the captures and
+// promotions that look bad. Each stage is only scored when it is reached, so a
+// cut-off early on saves the work of ordering the rest.
+type movePicker struct {
+	p          *Position
+	history    *moveHistory
+	stage      pickStage
+	noisyOnly  bool
+	hashMove   move
+	refutation [3]move // killers then the counter move
+	// continuations are the continuation history tables selected by the last
+	// two moves, if there were any.
+	continuations [2]*pieceTo
+
+	candidates []scoredMove
+	badNoisy   []scoredMove
+	index      int
+}
+
+// newMovePicker orders all the legal moves of a node in the main search. The
+// stack holds the moves leading to the node, the last of them the move just
+// made.
+func newMovePicker(p *Position, history *moveHistory, hashMove move, ply int, stack []searchStackEntry) *movePicker {
+	mp := &movePicker{p: p, history: history, hashMove: hashMove}
+	mp.refutation[0] = history.killers[ply][0]
+	mp.refutation[1] = history.killers[ply][1]
+
+	for idx := range mp.continuations {
+		if len(stack) <= idx {
+			break
+		}
+		previous := stack[len(stack)-1-idx]
+		if previous.piece == empty {
+			continue
+		}
+		mp.continuations[idx] = &history.continuation[previous.piece][previous.theMove.getToCoordinate()]
+		if idx == 0 {
+			mp.refutation[2] = history.counterMoves[previous.piece][previous.theMove.getToCoordinate()]
+		}
+	}
+	return mp
+}
+
+// newNoisyMovePicker orders only the captures and promotions that do not lose
+// material, for the quiescence search.
+func newNoisyMovePicker(p *Position) *movePicker {
+	return &movePicker{p: p, stage: stageScoreNoisy, noisyOnly: true}
+}
+
+// isNoisy reports whether a move captures or promotes.
+func (p *Position) isNoisy(theMove move) bool {
+	return theMove.getPromotionTo() != empty || p.isCapture(theMove)
+}
+
+// next returns the next move to search, or false once there are none left.
+func (mp *movePicker) next() (move, bool) {
+	for {
+		switch mp.stage {
+		case stageHashMove:
+			mp.stage++
+			if mp.hashMove != noMove && mp.p.legalMoves.contains(mp.hashMove) {
+				return mp.hashMove, true
+			}
+
+		case stageScoreNoisy:
+			mp.candidates = mp.candidates[:0]
+			for _, theMove := range mp.p.legalMoves {
+				if !mp.p.isNoisy(theMove) || theMove == mp.hashMove {
+					continue
+				}
+				candidate := scoredMove{theMove, mp.p.mvvLvaScore(theMove)}
+				if mp.p.isGoodNoisy(theMove) {
+					mp.candidates = append(mp.candidates, candidate)
+				} else {
+					mp.badNoisy = append(mp.badNoisy, candidate)
+				}
+			}
+			mp.index = 0
+			mp.stage++
+
+		case stageGoodNoisy:
+			if theMove, ok := mp.pickBest(); ok {
+				return theMove, true
+			}
+			mp.index = 0
+			mp.stage++
+			if mp.noisyOnly {
+				mp.stage = stageDone
+			}
+
+		case stageRefutations:
+			for mp.index < len(mp.refutation) {
+				idx := mp.index
+				mp.index++
+				if theMove, ok := mp.findRefutation(idx); ok {
+					return theMove, true
+				}
+			}
+			mp.stage++
+
+		case stageScoreQuiets:
+			mp.candidates = mp.candidates[:0]
+			for _, theMove := range mp.p.legalMoves {
+				if mp.p.isNoisy(theMove) || theMove == mp.hashMove || mp.isRefutation(theMove) {
+					continue
+				}
+				mp.candidates = append(mp.candidates, scoredMove{theMove, mp.quietScore(theMove)})
+			}
+			mp.index = 0
+			mp.stage++
+
+		case stageQuiets:
+			if theMove, ok := mp.pickBest(); ok {
+				return theMove, true
+			}
+			mp.candidates = mp.badNoisy
+			mp.index = 0
+			mp.stage++
+
+		case stageBadNoisy:
+			if theMove, ok := mp.pickBest(); ok {
+				return theMove, true
+			}
+			mp.stage++
+
+		default:
+			return noMove, false
+		}
+	}
+}
+
+// pickBest returns the best of the remaining candidates, moving it in front
+// of them. Moves with equal scores come out in the order they were generated.
+func (mp *movePicker) pickBest() (move, bool) {
+	if mp.index >= len(mp.candidates) {
+		return noMove, false
+	}
+	best := mp.index
+	for idx := mp.index + 1; idx < len(mp.candidates); idx++ {
+		if mp.candidates[idx].score > mp.candidates[best].score {
+			best = idx
+		}
+	}
+	chosen := mp.candidates[best]
+	copy(mp.candidates[mp.index+1:best+1], mp.candidates[mp.index:best])
+	mp.candidates[mp.index] = chosen
+	mp.index++
+	return chosen.theMove, true
+}
+
+// findRefutation returns the legal quiet move matching the killer or counter
+// move at idx, unless it has already been tried.
+func (mp *movePicker) findRefutation(idx int) (move, bool) {
+	candidate := mp.refutation[idx]
+	if candidate == noMove || sameMove(candidate, mp.hashMove) {
+		return noMove, false
+	}
+	for earlier := 0; earlier < idx; earlier++ {
+		if sameMove(candidate, mp.refutation[earlier]) {
+			return noMove, false
+		}
+	}
+	for _, theMove := range mp.p.legalMoves {
+		if sameMove(theMove, candidate) && !mp.p.isNoisy(theMove) {
+			return theMove, true
+		}
+	}
+	return noMove, false
+}
+
+func (mp *movePicker) isRefutation(theMove move) bool {
+	for _, candidate := range mp.refutation {
+		if candidate != noMove && sameMove(candidate, theMove) {
+			return true
+		}
+	}
+	return false
+}
+
+// quietScore ranks a quiet move by its butterfly and continuation histories.
+func (mp *movePicker) quietScore(theMove move) int {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	piece := mp.p.board[from]
+	score := int(mp.history.butterfly[mp.p.activeColour][from][to])
+	for _, continuation := range mp.continuations {
+		if continuation != nil {
+			score += int(continuation[piece][to])
+		}
+	}
+	return score
+}
+
+// mvvLvaScore ranks captures by the value of the captured piece and then by
+// the value of the capturing piece, with promotions adding the value gained.
+func (p *Position) mvvLvaScore(theMove move) int {
+	score := 0
+	if promotion := theMove.getPromotionTo(); promotion != empty {
+		score += pieceValues[promotion.getPieceType()].midgame - pieceValues[pawn].midgame
+	}
+	if !p.isCapture(theMove) {
+		return score
+	}
+	victim := pawn
+	if captured := theMove.getCapturedPiece(); captured != empty {
+		victim = captured.getPieceType()
+	}
+	attacker := p.board[theMove.getFromCoordinate()]
+	return score + 10*pieceValues[victim].midgame - pieceValues[attacker.getPieceType()].midgame + 1
+}
+
+// isGoodNoisy reports whether a capture or promotion does not lose material
+// once the exchange it starts is resolved. Promotions other than to a queen
+// are left for last.
+func (p *Position) isGoodNoisy(theMove move) bool {
+	if promotion := theMove.getPromotionTo(); promotion != empty && promotion.getPieceType() != queen {
+		return false
+	}
+	return p.seeGreaterOrEqual(theMove, 0)
+}
+
+// updateQuietHistory rewards the quiet move that caused a cut-off at a node
+// and penalises the quiet moves tried before it.
+func (h *moveHistory) updateQuietHistory(p *Position, best move, tried []move, depth int, ply int, stack []searchStackEntry) {
+	h.storeKiller(ply, best)
+
+	var continuations [2]*pieceTo
+	for idx := range continuations {
+		if len(stack) <= idx {
+			break
+		}
+		previous := stack[len(stack)-1-idx]
+		if previous.piece == empty {
+			continue
+		}
+		continuations[idx] = &h.continuation[previous.piece][previous.theMove.getToCoordinate()]
+		if idx == 0 {
+			h.counterMoves[previous.piece][previous.theMove.getToCoordinate()] = best
+		}
+	}
+
+	bonus := historyBonus(depth)
+	update := func(theMove move, bonus int) {
+		from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+		piece := p.board[from]
+		updateHistory(&h.butterfly[p.activeColour][from][to], bonus)
+		for _, continuation := range continuations {
+			if continuation != nil {
+				updateHistory(&continuation[piece][to], bonus)
+			}
+		}
+	}
+
+	update(best, bonus)
+	for _, theMove := range tried {
+		update(theMove, -bonus)
+	}
+}
@@ -0,0 +1,336 @@
+package chess
+
+import "testing"
+
+func findMove(t *testing.T, p *Position, moveString string) move {
+	t.Helper()
+	theMove, err := p.findLegalMove(moveString)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return theMove
+}
+
+func pickAll(mp *movePicker) []move {
+	var result []move
+	for {
+		theMove, ok := mp.next()
+		if !ok {
+			return result
+		}
+		result = append(result, theMove)
+	}
+}
+
+func TestMovePickerStages(t *testing.T) {
+	type testCase struct {
+		name        string
+		fen         string
+		hashMove    string
+		killers     []string
+		counterMove string
+		history     map[string]int
+	}
+
+	const kiwipete = "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"
+	testCases := []testCase{
+		{"no heuristics", kiwipete, "", nil, "", nil},
+		{"quiet hash move", kiwipete, "e1g1", nil, "", nil},
+		{"capture hash move", kiwipete, "f3f6", nil, "", nil},
+		{"killers", kiwipete, "", []string{"a2a3", "d2g5"}, "", nil},
+		{"counter move", kiwipete, "", []string{"a2a3"}, "g2g3", nil},
+		{"killer is hash move", kiwipete, "a2a3", []string{"a2a3", "b2b3"}, "b2b3", nil},
+		{"history", kiwipete, "", nil, "", map[string]int{"a1b1": 900, "e1d1": -300, "g2g4": 400}},
+		{"promotions", "n1n5/PPPk4/8/8/8/8/4Kppp/5N1N w - - 0 1", "", []string{"e2d3"}, "", nil},
+		{"in check", "8/8/8/2k5/3Pp3/8/8/4K3 b - d3 0 1", "", nil, "", nil},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		var history moveHistory
+
+		var hashMove move
+		if c.hashMove != "" {
+			hashMove = findMove(t, &thePosition, c.hashMove)
+		}
+		for _, killer := range c.killers {
+			history.storeKiller(3, findMove(t, &thePosition, killer))
+		}
+
+		// the counter move answers a made up last move by the black queen
+		stack := []searchStackEntry{{moveFromParts(e8, e7, empty, empty, 0, 0), blackQueen}}
+		if c.counterMove != "" {
+			history.counterMoves[blackQueen][e7] = findMove(t, &thePosition, c.counterMove)
+		}
+		for moveString, score := range c.history {
+			theMove := findMove(t, &thePosition, moveString)
+			history.butterfly[thePosition.activeColour][theMove.getFromCoordinate()][theMove.getToCoordinate()] = int16(score)
+		}
+
+		mp := newMovePicker(&thePosition, &history, hashMove, 3, stack)
+		picked := pickAll(mp)
+
+		if len(picked) != len(thePosition.legalMoves) {
+			t.Fatalf("expected %d moves, got %d", len(thePosition.legalMoves), len(picked))
+		}
+		seen := map[move]bool{}
+		for _, theMove := range picked {
+			if seen[theMove] || !thePosition.legalMoves.contains(theMove) {
+				t.Fatalf("unexpected move %s", theMove.toString())
+			}
+			seen[theMove] = true
+		}
+
+		refutations := append(history.killers[3][:], history.counterMoves[blackQueen][e7])
+
+		stageOf := func(theMove move) pickStage {
+			switch {
+			case theMove == hashMove:
+				return stageHashMove
+			case thePosition.isNoisy(theMove) && thePosition.isGoodNoisy(theMove):
+				return stageGoodNoisy
+			case thePosition.isNoisy(theMove):
+				return stageBadNoisy
+			case mp.isRefutation(theMove):
+				return stageRefutations
+			}
+			return stageQuiets
+		}
+
+		for idx := 1; idx < len(picked); idx++ {
+			previous, current := picked[idx-1], picked[idx]
+			previousStage, currentStage := stageOf(previous), stageOf(current)
+			if currentStage < previousStage {
+				t.Errorf("expected %s before %s", current.toString(), previous.toString())
+			}
+			if currentStage != previousStage {
+				continue
+			}
+
+			switch currentStage {
+			case stageGoodNoisy, stageBadNoisy:
+				if thePosition.mvvLvaScore(current) > thePosition.mvvLvaScore(previous) {
+					t.Errorf("expected %s before %s by MVV-LVA", current.toString(), previous.toString())
+				}
+			case stageRefutations:
+				previousIndex, currentIndex := 0, 0
+				for idx, refutation := range refutations {
+					if sameMove(refutation, previous) && previousIndex == 0 {
+						previousIndex = idx + 1
+					}
+					if sameMove(refutation, current) && currentIndex == 0 {
+						currentIndex = idx + 1
+					}
+				}
+				if currentIndex < previousIndex {
+					t.Errorf("expected %s before %s", current.toString(), previous.toString())
+				}
+			case stageQuiets:
+				if mp.quietScore(current) > mp.quietScore(previous) {
+					t.Errorf("expected %s before %s by history", current.toString(), previous.toString())
+				}
+			}
+		}
+
+		if hashMove != noMove && picked[0] != hashMove {
+			t.Errorf("expected hash move %s first, got %s", c.hashMove, picked[0].toString())
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestNoisyMovePicker(t *testing.T) {
+	type testCase struct {
+		name     string
+		fen      string
+		expected []string
+	}
+
+	testCases := []testCase{
+		{"quiet position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", nil},
+		{"captures", "4k3/8/2r1q3/1p1P4/p7/8/8/3Q3K w - - 0 1", []string{"d5e6", "d5c6"}},
+		{"defended pawn", "4k3/8/2p5/3p4/8/8/8/3QK3 w - - 0 1", nil},
+		{"en passant", "4k3/8/8/2pP4/8/8/8/4K3 w - c6 0 1", []string{"d5c6"}},
+		{"promotions", "4k3/1P6/8/8/8/8/8/4K3 w - - 0 1", []string{"b7b8q"}},
+		{"defended promotion square", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", []string{"a7b8q"}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		picked := pickAll(newNoisyMovePicker(&thePosition))
+
+		if len(picked) != len(c.expected) {
+			t.Fatalf("expected %v, got %d moves", c.expected, len(picked))
+		}
+		for idx, theMove := range picked {
+			if theMove.toString() != c.expected[idx] {
+				t.Errorf("expected %s at %d, got %s", c.expected[idx], idx, theMove.toString())
+			}
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestMVVLVAScore(t *testing.T) {
+	type testCase struct {
+		name   string
+		better string
+		worse  string
+	}
+
+	testCases := []testCase{
+		{"bigger victim", "e4d5", "e4f5"},
+		{"smaller attacker", "c3d5", "d1d5"},
+		{"victim before attacker", "d1d5", "e4f5"},
+		{"queen promotion over pawn capture", "b7b8q", "e4f5"},
+		{"promotion with capture", "b7a8q", "b7b8q"},
+		{"queen over rook promotion", "b7b8q", "b7b8r"},
+	}
+
+	thePosition := loadPosition(t, "r2r1k2/1Pq5/8/3n1p2/4P1P1/2N5/8/3QK2R w K - 0 1")
+
+	checkCase := func(t *testing.T, c testCase) {
+		better := findMove(t, &thePosition, c.better)
+		worse := findMove(t, &thePosition, c.worse)
+		if thePosition.mvvLvaScore(better) <= thePosition.mvvLvaScore(worse) {
+			t.Errorf("expected %s to score above %s", c.better, c.worse)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestStoreKiller(t *testing.T) {
+	thePosition := loadPosition(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	a2a3 := findMove(t, &thePosition, "a2a3")
+	b2b3 := findMove(t, &thePosition, "b2b3")
+	g1f3 := findMove(t, &thePosition, "g1f3")
+
+	type testCase struct {
+		name     string
+		stored   []move
+		expected [2]move
+	}
+
+	testCases := []testCase{
+		{"one killer", []move{a2a3}, [2]move{a2a3, noMove}},
+		{"two killers", []move{a2a3, b2b3}, [2]move{b2b3, a2a3}},
+		{"oldest dropped", []move{a2a3, b2b3, g1f3}, [2]move{g1f3, b2b3}},
+		{"repeat kept once", []move{a2a3, b2b3, b2b3}, [2]move{b2b3, a2a3}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		var history moveHistory
+		for _, theMove := range c.stored {
+			history.storeKiller(5, theMove)
+		}
+		if history.killers[5] != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, history.killers[5])
+		}
+		if history.killers[4] != [2]move{} {
+			t.Error("expected other plies to be left alone")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestUpdateHistory(t *testing.T) {
+	type testCase struct {
+		name    string
+		bonuses []int
+		check   func(int16) bool
+	}
+
+	testCases := []testCase{
+		{"bonus", []int{100}, func(v int16) bool { return v == 100 }},
+		{"malus", []int{-100}, func(v int16) bool { return v == -100 }},
+		{"saturates", repeat(historyBonus(20), 1000), func(v int16) bool { return v > maxHistory*9/10 && v <= maxHistory }},
+		{"saturates negative", repeat(-historyBonus(20), 1000), func(v int16) bool { return v < -maxHistory*9/10 && v >= -maxHistory }},
+		{"recovers", append(repeat(historyBonus(20), 1000), repeat(-historyBonus(20), 20)...), func(v int16) bool { return v < 0 }},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		var entry int16
+		for _, bonus := range c.bonuses {
+			updateHistory(&entry, bonus)
+		}
+		if !c.check(entry) {
+			t.Errorf("unexpected history score %v", entry)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func repeat(value, count int) []int {
+	result := make([]int, count)
+	for idx := range result {
+		result[idx] = value
+	}
+	return result
+}
+
+func TestUpdateQuietHistory(t *testing.T) {
+	thePosition := loadPosition(t, "rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq - 0 2")
+	best := findMove(t, &thePosition, "g1f3")
+	tried := []move{findMove(t, &thePosition, "a2a3"), findMove(t, &thePosition, "h2h4")}
+
+	// the last two moves were e2e4 by a white pawn and e7e5 by a black pawn
+	stack := []searchStackEntry{
+		{moveFromParts(e2, e4, empty, empty, 0, 0), whitePawn},
+		{moveFromParts(e7, e5, empty, empty, 0, 0), blackPawn},
+	}
+
+	var history moveHistory
+	history.updateQuietHistory(&thePosition, best, tried, 6, 2, stack)
+
+	if history.killers[2][0] != best {
+		t.Error("expected the best move to become a killer")
+	}
+	if history.counterMoves[blackPawn][e5] != best {
+		t.Error("expected the best move to become the counter move to e7e5")
+	}
+	if history.butterfly[white][g1][f3] <= 0 {
+		t.Error("expected a butterfly bonus for the best move")
+	}
+	for _, theMove := range tried {
+		if history.butterfly[white][theMove.getFromCoordinate()][theMove.getToCoordinate()] >= 0 {
+			t.Errorf("expected a butterfly malus for %s", theMove.toString())
+		}
+	}
+	if history.continuation[blackPawn][e5][whiteKnight][f3] <= 0 {
+		t.Error("expected a continuation bonus after the last move")
+	}
+	if history.continuation[whitePawn][e4][whiteKnight][f3] <= 0 {
+		t.Error("expected a continuation bonus after the move before")
+	}
+	if history.continuation[blackPawn][e5][whitePawn][a3] >= 0 {
+		t.Error("expected a continuation malus for a2a3")
+	}
+
+	// a different previous move only sees the butterfly history
+	history.killers[2] = [2]move{}
+	history.counterMoves[blackPawn][e5] = noMove
+	mp := newMovePicker(&thePosition, &history, noMove, 2, stack)
+	if first, _ := mp.next(); first != best {
+		t.Errorf("expected %s first, got %s", best.toString(), first.toString())
+	}
+	otherStack := []searchStackEntry{{moveFromParts(d7, d6, empty, empty, 0, 0), blackPawn}}
+	mp = newMovePicker(&thePosition, &history, noMove, 2, otherStack)
+	if mp.quietScore(best) >= newMovePicker(&thePosition, &history, noMove, 2, stack).quietScore(best) {
+		t.Error("expected the continuation history to count only after the same moves")
+	}
+}
@@ -0,0 +1,329 @@
+package chess
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Evaluator scores positions in centipawns from white's point of view. An
+// Evaluator must be safe for concurrent use.
+type Evaluator interface {
+	Evaluate(p *Position) int
+}
+
+type handcraftedEvaluator struct{}
+
+func (handcraftedEvaluator) Evaluate(p *Position) int {
+	return p.Evaluate()
+}
+
+// Handcrafted is the evaluation built from material, piece-square tables,
+// mobility, pawn structure and endgame knowledge, as returned by
+// Position.Evaluate.
+var Handcrafted Evaluator = handcraftedEvaluator{}
+
+// A Network is an efficiently updatable neural network. Its inputs are HalfKP
+// features: for each side, the square of that side's king together with the
+// type, colour and square of every other piece, seen from that side so that
+// black's board is mirrored vertically. The inputs feed a hidden layer kept
+// up to date as pieces move, then a small dense layer and a single output.
+//
+// Values are quantised: the hidden layer has int16 weights and its outputs
+// are clipped to [0, nnueActivationLimit], which stands for one. The dense
+// and output layers have int8 weights scaled by 1<<nnueWeightShift.
+type Network struct {
+	hiddenSize int
+	layerSize  int
+	// outputScale is the score in centipawns of an output of one.
+	outputScale int32
+
+	featureBiases  []int16
+	featureWeights []int16
+	layerBiases    []int32
+	layerWeights   []int8
+	outputBias     int32
+	outputWeights  []int8
+}
+
+const (
+	nnueMagic           = "KNUE"
+	nnueVersion         = 1
+	nnueInputs          = 64 * 10 * 64
+	nnueActivationLimit = 127
+	nnueWeightShift     = 6
+
+	maxNNUEHiddenSize = 4096
+	maxNNUELayerSize  = 256
+)
+
+// LoadNetwork reads a network from a file written by Network.Write, which
+// may be gzipped.
+func LoadNetwork(path string) (*Network, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadNetwork(f)
+}
+
+// ReadNetwork reads a network in the format written by Network.Write,
+// decompressing it first if it is gzipped.
+func ReadNetwork(r io.Reader) (*Network, error) {
+	buffered := bufio.NewReader(r)
+	if header, err := buffered.Peek(2); err == nil && header[0] == 0x1f && header[1] == 0x8b {
+		decompressed, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, fmt.Errorf("bad network file: %s", err.Error())
+		}
+		defer decompressed.Close()
+		buffered = bufio.NewReader(decompressed)
+	}
+
+	magic := make([]byte, len(nnueMagic))
+	if _, err := io.ReadFull(buffered, magic); err != nil || string(magic) != nnueMagic {
+		return nil, errors.New("bad network file: not a network")
+	}
+
+	var header struct {
+		Version     uint32
+		HiddenSize  uint32
+		LayerSize   uint32
+		OutputScale int32
+	}
+	if err := binary.Read(buffered, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("bad network file: %s", err.Error())
+	}
+	if header.Version != nnueVersion {
+		return nil, fmt.Errorf("bad network file: unrecognised version %d", header.Version)
+	}
+	if header.HiddenSize == 0 || header.HiddenSize > maxNNUEHiddenSize || header.LayerSize == 0 || header.LayerSize > maxNNUELayerSize {
+		return nil, fmt.Errorf("bad network file: unsupported layer sizes %d and %d", header.HiddenSize, header.LayerSize)
+	}
+
+	n := newNetwork(int(header.HiddenSize), int(header.LayerSize))
+	n.outputScale = header.OutputScale
+	for _, data := range []any{n.featureBiases, n.featureWeights, n.layerBiases, n.layerWeights, &n.outputBias, n.outputWeights} {
+		if err := binary.Read(buffered, binary.LittleEndian, data); err != nil {
+			return nil, fmt.Errorf("bad network file: %s", err.Error())
+		}
+	}
+	if _, err := buffered.ReadByte(); err != io.EOF {
+		return nil, errors.New("bad network file: trailing data")
+	}
+
+	return n, nil
+}
+
+// Write writes the network uncompressed.
+func (n *Network) Write(w io.Writer) error {
+	buffered := bufio.NewWriter(w)
+	buffered.WriteString(nnueMagic)
+
+	header := []any{uint32(nnueVersion), uint32(n.hiddenSize), uint32(n.layerSize), n.outputScale}
+	parameters := []any{n.featureBiases, n.featureWeights, n.layerBiases, n.layerWeights, n.outputBias, n.outputWeights}
+	for _, data := range append(header, parameters...) {
+		if err := binary.Write(buffered, binary.LittleEndian, data); err != nil {
+			return err
+		}
+	}
+
+	return buffered.Flush()
+}
+
+func newNetwork(hiddenSize, layerSize int) *Network {
+	return &Network{
+		hiddenSize:     hiddenSize,
+		layerSize:      layerSize,
+		featureBiases:  make([]int16, hiddenSize),
+		featureWeights: make([]int16, nnueInputs*hiddenSize),
+		layerBiases:    make([]int32, layerSize),
+		layerWeights:   make([]int8, layerSize*2*hiddenSize),
+		outputWeights:  make([]int8, layerSize),
+	}
+}
+
+// DefaultNetwork returns a small built-in network that counts material,
+// much like the handcrafted evaluation without its positional terms. It
+// lets the network code run without a network file.
+func DefaultNetwork() *Network {
+	const centipawnsPerUnit = 32
+
+	n := newNetwork(2, 1)
+	n.outputScale = centipawnsPerUnit * nnueActivationLimit
+
+	// the two hidden neurons count the material of the side the accumulator
+	// belongs to and of its opponent, in units of centipawnsPerUnit
+	for kingSquare := a1; kingSquare <= h8; kingSquare++ {
+		for theState := whiteQueen; theState < empty; theState++ {
+			value := pieceValues[theState.getPieceType()]
+			units := int16((value.midgame + value.endgame + centipawnsPerUnit) / (2 * centipawnsPerUnit))
+			for theCoord := a1; theCoord <= h8; theCoord++ {
+				feature := halfKPFeature(white, kingSquare, theCoord, theState)
+				n.featureWeights[feature*n.hiddenSize+int(theState.getColour())] = units
+			}
+		}
+	}
+
+	// the dense neuron is the material difference, offset to stay positive,
+	// and the output removes the offset again
+	const one = 1 << nnueWeightShift
+	const offset = nnueActivationLimit / 2
+	n.layerWeights[0], n.layerWeights[1] = one, -one
+	n.layerBiases[0] = offset * one
+	n.outputWeights[0] = one
+	n.outputBias = -offset * one
+
+	return n
+}
+
+// Evaluate returns the output of the network for the position in centipawns
+// from white's point of view. It uses the position's accumulator if it has
+// one for this network.
+func (n *Network) Evaluate(p *Position) int {
+	var values [2][]int16
+	if acc := p.accumulator; acc != nil && acc.network == n {
+		acc.refreshStale(p)
+		values = acc.values
+	} else {
+		fresh := n.newAccumulator(p)
+		values = fresh.values
+	}
+
+	score := n.propagate(values[p.activeColour], values[p.activeColour.getOpponent()])
+	if p.activeColour == black {
+		return -score
+	}
+	return score
+}
+
+// propagate runs the layers after the hidden layer, giving the score in
+// centipawns for the side to move.
+func (n *Network) propagate(us, them []int16) int {
+	var hidden [maxNNUELayerSize]int32
+	for idx := 0; idx < n.layerSize; idx++ {
+		weights := n.layerWeights[idx*2*n.hiddenSize : (idx+1)*2*n.hiddenSize]
+		sum := n.layerBiases[idx] + dotClipped(us, weights[:n.hiddenSize]) + dotClipped(them, weights[n.hiddenSize:])
+		hidden[idx] = clip(sum >> nnueWeightShift)
+	}
+
+	output := n.outputBias
+	for idx, weight := range n.outputWeights {
+		output += hidden[idx] * int32(weight)
+	}
+
+	return int(int64(output) * int64(n.outputScale) / (nnueActivationLimit << nnueWeightShift))
+}
+
+// dotClipped returns the dot product of the clipped activations with the
+// weights. The loop runs over contiguous memory with its bounds checks
+// hoisted, which is as close as Go gets to SIMD.
+func dotClipped(activations []int16, weights []int8) int32 {
+	weights = weights[:len(activations)]
+	var sum int32
+	for idx, activation := range activations {
+		sum += clip(int32(activation)) * int32(weights[idx])
+	}
+	return sum
+}
+
+func clip(x int32) int32 {
+	return min(max(x, 0), nnueActivationLimit)
+}
+
+// halfKPFeature returns the index of the input for a piece other than a king
+// from the point of view of one side.
+func halfKPFeature(perspective colour, kingSquare, theCoord coordinate, theState squareState) int {
+	if perspective == black {
+		kingSquare ^= 56
+		theCoord ^= 56
+	}
+	pieceIndex := 2 * (int(theState.getPieceType()) - 1)
+	if theState.getColour() != perspective {
+		pieceIndex++
+	}
+	return (int(kingSquare)*10+pieceIndex)*64 + int(theCoord)
+}
+
+// accumulator holds the hidden layer of a network for a position, from the
+// point of view of each side. Position.setSquare keeps it up to date; when a
+// king moves, every input for that side changes, so its half is marked
+// stale and recomputed when next needed.
+type accumulator struct {
+	network *Network
+	values  [2][]int16
+	stale   [2]bool
+}
+
+func (n *Network) newAccumulator(p *Position) *accumulator {
+	acc := &accumulator{
+		network: n,
+		values:  [2][]int16{make([]int16, n.hiddenSize), make([]int16, n.hiddenSize)},
+		stale:   [2]bool{true, true},
+	}
+	acc.refreshStale(p)
+	return acc
+}
+
+func (acc *accumulator) clone() *accumulator {
+	return &accumulator{
+		network: acc.network,
+		values:  [2][]int16{append([]int16{}, acc.values[white]...), append([]int16{}, acc.values[black]...)},
+		stale:   acc.stale,
+	}
+}
+
+func (acc *accumulator) refreshStale(p *Position) {
+	for perspective := white; perspective <= black; perspective++ {
+		if !acc.stale[perspective] {
+			continue
+		}
+
+		values := acc.values[perspective]
+		copy(values, acc.network.featureBiases)
+		pieces := p.getOccupationBitBoard() &^ p.occupationByPieceType[king]
+		for {
+			theCoord, ok := pieces.pop()
+			if !ok {
+				break
+			}
+			acc.addFeature(perspective, halfKPFeature(perspective, p.kingSquares[perspective], theCoord, p.board[theCoord]), 1)
+		}
+		acc.stale[perspective] = false
+	}
+}
+
+// update follows a change to one square of the board.
+func (acc *accumulator) update(p *Position, theCoord coordinate, previousState, theState squareState) {
+	for _, changed := range [2]struct {
+		theState squareState
+		sign     int16
+	}{{previousState, -1}, {theState, 1}} {
+		switch {
+		case changed.theState == empty:
+		case changed.theState.getPieceType() == king:
+			acc.stale[changed.theState.getColour()] = true
+		default:
+			for perspective := white; perspective <= black; perspective++ {
+				if !acc.stale[perspective] {
+					acc.addFeature(perspective, halfKPFeature(perspective, p.kingSquares[perspective], theCoord, changed.theState), changed.sign)
+				}
+			}
+		}
+	}
+}
+
+func (acc *accumulator) addFeature(perspective colour, feature int, sign int16) {
+	hiddenSize := acc.network.hiddenSize
+	weights := acc.network.featureWeights[feature*hiddenSize : (feature+1)*hiddenSize]
+	values := acc.values[perspective][:hiddenSize]
+	for idx, weight := range weights {
+		values[idx] += sign * weight
+	}
+}
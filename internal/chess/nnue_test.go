@@ -0,0 +1,186 @@
+package chess
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"math/rand"
+	"slices"
+	"testing"
+)
+
+func randomNetwork(seed int64) *Network {
+	random := rand.New(rand.NewSource(seed))
+	n := newNetwork(8, 4)
+	n.outputScale = 600
+	for idx := range n.featureBiases {
+		n.featureBiases[idx] = int16(random.Intn(64))
+	}
+	for idx := range n.featureWeights {
+		n.featureWeights[idx] = int16(random.Intn(33) - 16)
+	}
+	for idx := range n.layerBiases {
+		n.layerBiases[idx] = int32(random.Intn(1024) - 512)
+	}
+	for idx := range n.layerWeights {
+		n.layerWeights[idx] = int8(random.Intn(128) - 64)
+	}
+	for idx := range n.outputWeights {
+		n.outputWeights[idx] = int8(random.Intn(128) - 64)
+	}
+	n.outputBias = int32(random.Intn(1024) - 512)
+	return n
+}
+
+func TestReadNetwork(t *testing.T) {
+	n := randomNetwork(1)
+	var written bytes.Buffer
+	if err := n.Write(&written); err != nil {
+		t.Fatal(err)
+	}
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	gzipWriter.Write(written.Bytes())
+	gzipWriter.Close()
+
+	withVersion := func(version byte) []byte {
+		data := slices.Clone(written.Bytes())
+		data[len(nnueMagic)] = version
+		return data
+	}
+
+	type testCase struct {
+		name        string
+		data        []byte
+		expectError bool
+	}
+
+	testCases := []testCase{
+		{"plain", written.Bytes(), false},
+		{"gzipped", compressed.Bytes(), false},
+		{"empty", nil, true},
+		{"bad magic", append([]byte("KNUF"), written.Bytes()[len(nnueMagic):]...), true},
+		{"bad version", withVersion(2), true},
+		{"truncated", written.Bytes()[:written.Len()-1], true},
+		{"trailing data", append(slices.Clone(written.Bytes()), 0), true},
+	}
+
+	thePosition := loadPosition(t, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	expectedScore := n.Evaluate(&thePosition)
+
+	checkCase := func(t *testing.T, c testCase) {
+		read, err := ReadNetwork(bytes.NewReader(c.data))
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if score := read.Evaluate(&thePosition); score != expectedScore {
+			t.Errorf("expected score %v, got %v", expectedScore, score)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestAccumulatorUpdates(t *testing.T) {
+	n := randomNetwork(2)
+	random := rand.New(rand.NewSource(3))
+
+	for _, fen := range []string{
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"n1n5/PPPk4/8/8/8/8/4Kppp/5N1N b - - 0 1",
+		"8/8/8/2k5/3Pp3/8/8/4K3 b - d3 0 1",
+	} {
+		thePosition := loadPosition(t, fen)
+		thePosition.accumulator = n.newAccumulator(&thePosition)
+
+		check := func(when string) {
+			thePosition.accumulator.refreshStale(&thePosition)
+			fresh := n.newAccumulator(&thePosition)
+			for perspective := white; perspective <= black; perspective++ {
+				if !slices.Equal(thePosition.accumulator.values[perspective], fresh.values[perspective]) {
+					t.Fatalf("%s: accumulator out of date %s %s", fen, when, thePosition.GetFEN().String())
+				}
+			}
+		}
+
+		var played []move
+		for ply := 0; ply < 40; ply++ {
+			if len(thePosition.legalMoves) == 0 || (len(played) > 0 && random.Intn(4) == 0) {
+				if len(played) == 0 {
+					break
+				}
+				thePosition.unmakeMove(played[len(played)-1])
+				played = played[:len(played)-1]
+				check("after unmaking a move")
+				continue
+			}
+
+			theMove := thePosition.legalMoves[random.Intn(len(thePosition.legalMoves))]
+			thePosition.makeMove(theMove)
+			played = append(played, theMove)
+			check("after " + theMove.toString())
+		}
+	}
+}
+
+func TestDefaultNetwork(t *testing.T) {
+	type testCase struct {
+		name string
+		fen  string
+	}
+
+	testCases := []testCase{
+		{"starting position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"extra queen", "rnb1kbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"extra knight for black", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/R1BQKBNR b KQkq - 0 1"},
+		{"minor for pawns", "4k3/pppp4/8/8/8/8/8/2B1K3 w - - 0 1"},
+	}
+
+	sign := func(x int) int {
+		return min(max(x, -1), 1)
+	}
+
+	n := DefaultNetwork()
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		score := n.Evaluate(&thePosition)
+		if expected := Handcrafted.Evaluate(&thePosition); sign(score) != sign(expected) {
+			t.Errorf("expected the network to agree with the handcrafted evaluation %v, got %v", expected, score)
+		}
+
+		thePosition.Flip()
+		if flipped := n.Evaluate(&thePosition); flipped != -score {
+			t.Errorf("expected flipped score %v, got %v", -score, flipped)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSearchWithNetwork(t *testing.T) {
+	thePosition := loadPosition(t, "4k3/8/8/3q4/8/8/8/3RK3 w - - 0 1")
+
+	var lastInfo SearchInfo
+	searcher := Searcher{}
+	bestMove, _ := searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 3, Evaluator: DefaultNetwork()}, func(info SearchInfo) { lastInfo = info })
+
+	if bestMove != "d1d5" {
+		t.Errorf("expected d1d5, got %s", bestMove)
+	}
+	if lastInfo.Score <= 0 {
+		t.Errorf("expected a positive score, got %v", lastInfo.Score)
+	}
+	if thePosition.accumulator != nil {
+		t.Error("expected the search to leave the position alone")
+	}
+}
@@ -0,0 +1,281 @@
+package chess
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"io"
+	"os"
+	"strings"
+)
+
+// EvalParameter is one weight of the handcrafted evaluation, in centipawns.
+type EvalParameter struct {
+	Name  string
+	Value int
+}
+
+// EvalCoefficient is how much the evaluation of a position changes per
+// centipawn of the parameter at Index in EvalParameters.
+type EvalCoefficient struct {
+	Index int
+	Value float64
+}
+
+// The parameters are laid out as follows, with tapered weights taking two
+// places, midgame then endgame. Passed pawns can only stand on the six ranks
+// in between the first and last. The piece square tables follow the layout of
+// pieceSquareValues.
+const (
+	pieceValueParameters      = 0
+	mobilityParameters        = pieceValueParameters + 2*5
+	doubledPawnParameters     = mobilityParameters + 2
+	isolatedPawnParameters    = doubledPawnParameters + 2
+	passedPawnParameters      = isolatedPawnParameters + 2
+	pieceSquareParameters     = passedPawnParameters + 2*6
+	kingEndgameParameters     = pieceSquareParameters + 6*64
+	numEvalParameters         = kingEndgameParameters + 64
+	pieceValueParameterOffset = 2 * int(queen)
+)
+
+var pieceTypeNames = [6]string{"King", "Queen", "Rook", "Bishop", "Knight", "Pawn"}
+
+// evalParameters returns the names of the parameters along with where they
+// are kept.
+func evalParameters() [numEvalParameters]struct {
+	name  string
+	value *int
+} {
+	var result [numEvalParameters]struct {
+		name  string
+		value *int
+	}
+	set := func(idx int, name string, value *int) {
+		result[idx].name = name
+		result[idx].value = value
+	}
+	setTapered := func(idx int, name string, value *taperedScore) {
+		set(idx, name+".Midgame", &value.midgame)
+		set(idx+1, name+".Endgame", &value.endgame)
+	}
+
+	for thePieceType := queen; thePieceType <= pawn; thePieceType++ {
+		setTapered(pieceValueParameters+2*int(thePieceType)-pieceValueParameterOffset, "PieceValues."+pieceTypeNames[thePieceType], &pieceValues[thePieceType])
+	}
+	setTapered(mobilityParameters, "MobilityBonus", &mobilityBonus)
+	setTapered(doubledPawnParameters, "DoubledPawnBonus", &doubledPawnBonus)
+	setTapered(isolatedPawnParameters, "IsolatedPawnBonus", &isolatedPawnBonus)
+	for relativeRank := 1; relativeRank <= 6; relativeRank++ {
+		setTapered(passedPawnParameters+2*(relativeRank-1), fmt.Sprintf("PassedPawnBonus.Rank%d", relativeRank+1), &passedPawnBonus[relativeRank])
+	}
+	for thePieceType := king; thePieceType <= pawn; thePieceType++ {
+		for tableIndex := a1; tableIndex <= h8; tableIndex++ {
+			name := fmt.Sprintf("PieceSquareValues.%s.%s", pieceTypeNames[thePieceType], (tableIndex ^ 56).toString())
+			set(pieceSquareParameters+64*int(thePieceType)+int(tableIndex), name, &pieceSquareValues[thePieceType][tableIndex])
+		}
+	}
+	for tableIndex := a1; tableIndex <= h8; tableIndex++ {
+		set(kingEndgameParameters+int(tableIndex), "KingEndgameSquareValues."+(tableIndex^56).toString(), &kingEndgameSquareValues[tableIndex])
+	}
+
+	return result
+}
+
+// EvalParameters returns the current weights of the handcrafted evaluation in
+// a fixed order. Squares are named as seen from white's side.
+func EvalParameters() []EvalParameter {
+	var result []EvalParameter
+	for _, parameter := range evalParameters() {
+		result = append(result, EvalParameter{parameter.name, *parameter.value})
+	}
+	return result
+}
+
+// SetEvalParameters changes weights of the handcrafted evaluation by name,
+// leaving the rest alone. It must not be called while anything is being
+// evaluated.
+func SetEvalParameters(parameters []EvalParameter) error {
+	byName := map[string]*int{}
+	for _, parameter := range evalParameters() {
+		byName[parameter.name] = parameter.value
+	}
+
+	for _, parameter := range parameters {
+		if _, ok := byName[parameter.Name]; !ok {
+			return fmt.Errorf("unrecognised evaluation parameter %s", parameter.Name)
+		}
+	}
+	for _, parameter := range parameters {
+		*byName[parameter.Name] = parameter.Value
+	}
+
+	initPieceSquareTables()
+	return nil
+}
+
+// LoadEvalParameters sets weights of the handcrafted evaluation from a JSON
+// file mapping names to values, as written by WriteEvalParametersJSON.
+func LoadEvalParameters(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]int
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("bad evaluation parameters in %s: %s", path, err.Error())
+	}
+
+	parameters := make([]EvalParameter, 0, len(values))
+	for name, value := range values {
+		parameters = append(parameters, EvalParameter{name, value})
+	}
+	return SetEvalParameters(parameters)
+}
+
+// WriteEvalParametersJSON writes the parameters as a JSON object mapping names
+// to values.
+func WriteEvalParametersJSON(w io.Writer, parameters []EvalParameter) error {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	for idx, parameter := range parameters {
+		name, _ := json.Marshal(parameter.Name)
+		fmt.Fprintf(&buf, "  %s: %d", name, parameter.Value)
+		if idx < len(parameters)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString("}\n")
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// WriteEvalParametersGo writes the current weights as the Go declarations in
+// eval.go that hold them, ready to paste over the originals.
+func WriteEvalParametersGo(w io.Writer) error {
+	var buf bytes.Buffer
+	tapered := func(s taperedScore) string {
+		return fmt.Sprintf("{%d, %d}", s.midgame, s.endgame)
+	}
+	table := func(values [64]int) {
+		for row := 0; row < 8; row++ {
+			for column := 0; column < 8; column++ {
+				fmt.Fprintf(&buf, "%d, ", values[8*row+column])
+			}
+			buf.WriteByte('\n')
+		}
+	}
+
+	buf.WriteString("package chess\n\nvar pieceValues = [6]taperedScore{\n")
+	for thePieceType := king; thePieceType <= pawn; thePieceType++ {
+		fmt.Fprintf(&buf, "%s: %s,\n", strings.ToLower(pieceTypeNames[thePieceType]), tapered(pieceValues[thePieceType]))
+	}
+	buf.WriteString("}\n\nvar (\n")
+	fmt.Fprintf(&buf, "mobilityBonus = taperedScore%s\n", tapered(mobilityBonus))
+	fmt.Fprintf(&buf, "doubledPawnBonus = taperedScore%s\n", tapered(doubledPawnBonus))
+	fmt.Fprintf(&buf, "isolatedPawnBonus = taperedScore%s\n", tapered(isolatedPawnBonus))
+	buf.WriteString("passedPawnBonus = [8]taperedScore{\n")
+	for _, bonus := range passedPawnBonus {
+		fmt.Fprintf(&buf, "%s, ", tapered(bonus))
+	}
+	buf.WriteString("\n}\n)\n\n")
+
+	buf.WriteString("// Piece square tables are laid out as seen from white's side of the board, so\n")
+	buf.WriteString("// that the first row lists a8 to h8.\n")
+	buf.WriteString("var pieceSquareValues = [6][64]int{\n")
+	for thePieceType := king; thePieceType <= pawn; thePieceType++ {
+		fmt.Fprintf(&buf, "%s: {\n", strings.ToLower(pieceTypeNames[thePieceType]))
+		table(pieceSquareValues[thePieceType])
+		buf.WriteString("},\n")
+	}
+	buf.WriteString("}\n\nvar kingEndgameSquareValues = [64]int{\n")
+	table(kingEndgameSquareValues)
+	buf.WriteString("}\n")
+
+	source, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	// the package clause is only there for the formatter
+	_, err = w.Write(bytes.TrimPrefix(source, []byte("package chess\n\n")))
+	return err
+}
+
+// EvalCoefficients breaks the evaluation of the position from white's point
+// of view into a constant and a linear combination of the parameters, so that
+// it is offset plus the sum of each coefficient times its parameter, up to
+// rounding. Recognised endgames are constant, and the scaling of drawish
+// endgames is folded into the coefficients.
+func (p *Position) EvalCoefficients() (offset float64, coefficients []EvalCoefficient) {
+	if score, ok := p.evaluateEndgame(); ok {
+		return float64(score), nil
+	}
+
+	midgameWeight := float64(p.getPhase()) / maxPhase
+	endgameWeight := 1 - midgameWeight
+
+	var dense [numEvalParameters]float64
+	addTapered := func(idx int, count float64) {
+		dense[idx] += count * midgameWeight
+		dense[idx+1] += count * endgameWeight
+	}
+
+	for player := white; player <= black; player++ {
+		sign := 1.0
+		if player == black {
+			sign = -1
+		}
+
+		for thePieceType := queen; thePieceType <= pawn; thePieceType++ {
+			count := p.pieceColourTypeCounter[2*squareState(thePieceType)+squareState(player)]
+			addTapered(pieceValueParameters+2*int(thePieceType)-pieceValueParameterOffset, sign*float64(count))
+		}
+
+		pieces := p.occupationByColour[player]
+		for {
+			theCoord, ok := pieces.pop()
+			if !ok {
+				break
+			}
+			theState := p.board[theCoord]
+			tableIndex := int(pieceSquareTableIndex(theState, theCoord))
+			if theState.getPieceType() == king {
+				dense[pieceSquareParameters+tableIndex] += sign * midgameWeight
+				dense[kingEndgameParameters+tableIndex] += sign * endgameWeight
+				continue
+			}
+			dense[pieceSquareParameters+64*int(theState.getPieceType())+tableIndex] += sign
+		}
+
+		mobility := (p.controlByColour[player] &^ p.occupationByColour[player]).count()
+		addTapered(mobilityParameters, sign*float64(mobility))
+
+		doubled, isolated, passed := p.countPawnFeatures(player)
+		addTapered(doubledPawnParameters, sign*float64(doubled))
+		addTapered(isolatedPawnParameters, sign*float64(isolated))
+		for relativeRank := 1; relativeRank <= 6; relativeRank++ {
+			addTapered(passedPawnParameters+2*(relativeRank-1), sign*float64(passed[relativeRank]))
+		}
+	}
+
+	parameters := evalParameters()
+	score := 0.0
+	for idx, coefficient := range dense {
+		score += coefficient * float64(*parameters[idx].value)
+	}
+	strong := white
+	if score < 0 {
+		strong = black
+	}
+	scale := float64(p.endgameScale(strong)) / normalScale
+
+	for idx, coefficient := range dense {
+		if coefficient != 0 {
+			coefficients = append(coefficients, EvalCoefficient{idx, coefficient * scale})
+		}
+	}
+	return 0, coefficients
+}
@@ -0,0 +1,126 @@
+package chess
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEvalCoefficients(t *testing.T) {
+	type testCase struct {
+		name string
+		fen  string
+	}
+
+	testCases := []testCase{
+		{"starting position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"middlegame", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1"},
+		{"black to move", "r4rk1/1pp1qppp/p1np1n2/2b1p1B1/2B1P1b1/P1NP1N2/1PP1QPPP/R4RK1 b - - 0 10"},
+		{"pawn endgame", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1"},
+		{"passed pawns", "8/P5k1/8/8/8/1p6/6K1/8 b - - 0 1"},
+		{"opposite bishops", "4k3/2b1p3/8/8/8/8/3BP3/4K3 w - - 0 1"},
+		{"recognised endgame", "8/8/8/4k3/8/8/4P3/4K3 w - - 0 1"},
+	}
+
+	parameters := EvalParameters()
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		offset, coefficients := thePosition.EvalCoefficients()
+
+		score := offset
+		for _, coefficient := range coefficients {
+			score += coefficient.Value * float64(parameters[coefficient.Index].Value)
+		}
+		if expected := thePosition.Evaluate(); score < float64(expected)-2 || score > float64(expected)+2 {
+			t.Errorf("expected %v, got %v", expected, score)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSetEvalParameters(t *testing.T) {
+	original := EvalParameters()
+	defer SetEvalParameters(original)
+
+	if len(original) != numEvalParameters {
+		t.Fatalf("expected %d parameters, got %d", numEvalParameters, len(original))
+	}
+	names := map[string]bool{}
+	for _, parameter := range original {
+		if names[parameter.Name] {
+			t.Errorf("parameter %s appears twice", parameter.Name)
+		}
+		names[parameter.Name] = true
+	}
+
+	thePosition := loadPosition(t, "rnbqkb1r/pppppppp/8/8/4N3/8/PPPPPPPP/R1BQKBNR w KQkq - 0 1")
+	before := thePosition.Evaluate()
+	if err := SetEvalParameters([]EvalParameter{{"PieceSquareValues.Knight.e4", 1000}}); err != nil {
+		t.Fatal(err)
+	}
+	if after := thePosition.Evaluate(); after <= before {
+		t.Errorf("expected a better square for the knight on e4 to raise the score from %v, got %v", before, after)
+	}
+
+	if err := SetEvalParameters([]EvalParameter{{"PieceValues.Queen.Midgame", 0}, {"PieceValues.Amazon.Midgame", 1}}); err == nil {
+		t.Error("expected an error for an unrecognised parameter")
+	}
+	if value := pieceValues[queen].midgame; value != original[0].Value {
+		t.Errorf("expected an error to leave the parameters alone, got queen value %v", value)
+	}
+}
+
+func TestLoadEvalParameters(t *testing.T) {
+	original := EvalParameters()
+	defer SetEvalParameters(original)
+
+	changed := EvalParameters()
+	for idx := range changed {
+		changed[idx].Value += idx % 7
+	}
+
+	path := filepath.Join(t.TempDir(), "params.json")
+	var buf bytes.Buffer
+	if err := WriteEvalParametersJSON(&buf, changed); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := LoadEvalParameters(path); err != nil {
+		t.Fatal(err)
+	}
+	for idx, parameter := range EvalParameters() {
+		if parameter != changed[idx] {
+			t.Fatalf("expected %v, got %v", changed[idx], parameter)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(`{"MobilityBonus.Midgame": "two"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadEvalParameters(path); err == nil {
+		t.Error("expected an error for a bad value")
+	}
+}
+
+func TestWriteEvalParametersGo(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteEvalParametersGo(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := os.ReadFile("eval.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(source), buf.String()) {
+		t.Errorf("expected the declarations in eval.go, got\n%s", buf.String())
+	}
+}
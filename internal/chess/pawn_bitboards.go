@@ -0,0 +1,43 @@
+package chess
+
+var fileMasks [8]bitBoard
+var adjacentFileMasks [8]bitBoard
+var passedPawnMasks [2][64]bitBoard
+
+func initPawnBitBoards() {
+	for fileIndex := 0; fileIndex < 8; fileIndex++ {
+		fileMasks[fileIndex] = fileA << fileIndex
+	}
+
+	for fileIndex := 0; fileIndex < 8; fileIndex++ {
+		adjacentFileMasks[fileIndex] = 0
+		if fileIndex > 0 {
+			adjacentFileMasks[fileIndex] |= fileMasks[fileIndex-1]
+		}
+		if fileIndex < 7 {
+			adjacentFileMasks[fileIndex] |= fileMasks[fileIndex+1]
+		}
+	}
+
+	for currentSquare := a1; currentSquare <= h8; currentSquare++ {
+		fileIndex := currentSquare.getFileIndex()
+		rankIndex := currentSquare.getRankIndex()
+		frontSpan := fileMasks[fileIndex] | adjacentFileMasks[fileIndex]
+
+		var whiteMask, blackMask bitBoard
+		for otherSquare := a1; otherSquare <= h8; otherSquare++ {
+			if !frontSpan.get(otherSquare) {
+				continue
+			}
+			if otherSquare.getRankIndex() > rankIndex {
+				whiteMask.turnOn(otherSquare)
+			}
+			if otherSquare.getRankIndex() < rankIndex {
+				blackMask.turnOn(otherSquare)
+			}
+		}
+
+		passedPawnMasks[white][currentSquare] = whiteMask
+		passedPawnMasks[black][currentSquare] = blackMask
+	}
+}
@@ -0,0 +1,45 @@
+package chess
+
+import "context"
+
+// Perft counts the leaf nodes of the legal move tree to the given depth, which
+// is the standard way of verifying move generation.
+func (p *Position) Perft(depth int) uint64 {
+	if depth <= 0 {
+		return 1
+	}
+	if depth == 1 {
+		return uint64(len(p.legalMoves))
+	}
+
+	var nodes uint64
+	for _, theMove := range p.legalMoves {
+		p.makeMove(theMove)
+		nodes += p.Perft(depth - 1)
+		p.unmakeMove(theMove)
+	}
+	return nodes
+}
+
+// Divide returns the perft count below each legal move, keyed by the move in
+// long algebraic notation. It is used to narrow down move generation bugs by
+// comparison with another engine.
+func (p *Position) Divide(depth int) map[string]uint64 {
+	result, _ := p.DivideContext(context.Background(), depth)
+	return result
+}
+
+// DivideContext is Divide, giving up between moves at the root once ctx is
+// done, with the cause of the cancellation.
+func (p *Position) DivideContext(ctx context.Context, depth int) (map[string]uint64, error) {
+	result := make(map[string]uint64, len(p.legalMoves))
+	for _, theMove := range p.legalMoves {
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		p.makeMove(theMove)
+		result[theMove.toString()] = p.Perft(depth - 1)
+		p.unmakeMove(theMove)
+	}
+	return result, nil
+}
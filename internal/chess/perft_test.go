@@ -0,0 +1,69 @@
+package chess
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestPerft(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		depth    int
+		expected uint64
+	}
+
+	testCases := []testCase{
+		{"starting position", GetStartingFEN(), 3, 8902},
+		{"kiwipete", FEN{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R", "w", "KQkq", "-", "0", "1"}, 3, 97862},
+		{"en passant pins", FEN{"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8", "w", "-", "-", "0", "1"}, 4, 43238},
+		{"promotions", FEN{"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R", "w", "KQ", "-", "1", "8"}, 3, 62379},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		if result := thePosition.Perft(c.depth); result != c.expected {
+			t.Errorf("expected %v nodes, got %v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestDivide(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(GetStartingFEN())
+
+	result := thePosition.Divide(2)
+	if len(result) != 20 {
+		t.Errorf("expected 20 moves, got %v", len(result))
+	}
+
+	var total uint64
+	for moveString, nodes := range result {
+		if nodes != 20 {
+			t.Errorf("expected 20 nodes after %s, got %v", moveString, nodes)
+		}
+		total += nodes
+	}
+	if expected := thePosition.Perft(2); total != expected {
+		t.Errorf("expected divide to sum to %v, got %v", expected, total)
+	}
+}
+
+func TestDivideContextCancelled(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(GetStartingFEN())
+
+	gone := errors.New("client gone")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(gone)
+
+	if result, err := thePosition.DivideContext(ctx, 10); !errors.Is(err, gone) || result != nil {
+		t.Errorf("expected no result and error %v, got %v and %v", gone, result, err)
+	}
+}
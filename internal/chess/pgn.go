@@ -0,0 +1,149 @@
+package chess
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var moveNumberPattern = regexp.MustCompile(`^[0-9]+\.*`)
+
+// PGNGame is a game read from PGN: its tags, the moves of its main line in SAN
+// and the result that ends its movetext. Comments, variations and annotations
+// are dropped.
+type PGNGame struct {
+	Tags   map[string]string
+	Moves  []string
+	Result string
+}
+
+// StartingFEN is the position the game starts from, given by its FEN tag if it
+// has one.
+func (g PGNGame) StartingFEN() (FEN, error) {
+	if fenString, ok := g.Tags["FEN"]; ok {
+		return ParseFEN(fenString)
+	}
+	return GetStartingFEN(), nil
+}
+
+func isResult(token string) bool {
+	switch token {
+	case "1-0", "0-1", "1/2-1/2", "*":
+		return true
+	}
+	return false
+}
+
+// ReadPGN reads every game in r.
+func ReadPGN(r io.Reader) ([]PGNGame, error) {
+	var games []PGNGame
+	current := PGNGame{Tags: map[string]string{}}
+	hasMoves := false
+
+	finishGame := func(result string) {
+		if hasMoves || len(current.Tags) > 0 {
+			if result == "" {
+				result = current.Tags["Result"]
+			}
+			current.Result = result
+			games = append(games, current)
+		}
+		current = PGNGame{Tags: map[string]string{}}
+		hasMoves = false
+	}
+
+	inComment := false
+	variationDepth := 0
+
+	handleToken := func(token string) {
+		if isResult(token) {
+			finishGame(token)
+			return
+		}
+		token = moveNumberPattern.ReplaceAllString(token, "")
+		token = strings.TrimRight(token, "!?")
+		if token == "" || strings.HasPrefix(token, "$") {
+			return
+		}
+		current.Moves = append(current.Moves, token)
+		hasMoves = true
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Text()
+
+		if trimmed := strings.TrimSpace(line); !inComment && variationDepth == 0 && strings.HasPrefix(trimmed, "[") {
+			if hasMoves {
+				finishGame("")
+			}
+			name, value, err := parseTag(trimmed)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			current.Tags[name] = value
+			continue
+		}
+
+		var token strings.Builder
+		flush := func() {
+			if token.Len() > 0 {
+				handleToken(token.String())
+				token.Reset()
+			}
+		}
+
+	characters:
+		for _, c := range line {
+			if inComment {
+				inComment = c != '}'
+				continue
+			}
+
+			switch c {
+			case '{':
+				flush()
+				inComment = true
+			case ';':
+				break characters
+			case '(':
+				flush()
+				variationDepth++
+			case ')':
+				if variationDepth > 0 {
+					variationDepth--
+				}
+			case ' ', '\t', '\r':
+				flush()
+			default:
+				if variationDepth == 0 {
+					token.WriteRune(c)
+				}
+			}
+		}
+		flush()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	finishGame("")
+	return games, nil
+}
+
+func parseTag(line string) (name, value string, err error) {
+	inner, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+	if !ok {
+		return "", "", fmt.Errorf("bad tag %s", line)
+	}
+
+	name, quoted, _ := strings.Cut(strings.TrimSpace(inner), " ")
+	value, err = strconv.Unquote(strings.TrimSpace(quoted))
+	if err != nil {
+		return "", "", fmt.Errorf("bad tag %s", line)
+	}
+	return name, value, nil
+}
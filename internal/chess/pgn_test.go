@@ -0,0 +1,42 @@
+package chess
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadPGN(t *testing.T) {
+	pgn := `[Event "Test"]
+[White "A \"quoted\" name"]
+
+1. e4 {best by test} e5 2. Nf3 (2. f4 exf4) Nc6!? 3. Bb5 $1 a6 ; the Morphy defence
+4. Ba4 1-0
+
+[Event "Second"]
+[FEN "4k3/8/8/8/8/8/8/4K2R b K - 0 30"]
+
+30... Kd7 31. O-O *
+`
+
+	games, err := ReadPGN(strings.NewReader(pgn))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []PGNGame{
+		{
+			map[string]string{"Event": "Test", "White": `A "quoted" name`},
+			[]string{"e4", "e5", "Nf3", "Nc6", "Bb5", "a6", "Ba4"},
+			"1-0",
+		},
+		{
+			map[string]string{"Event": "Second", "FEN": "4k3/8/8/8/8/8/8/4K2R b K - 0 30"},
+			[]string{"Kd7", "O-O"},
+			"*",
+		},
+	}
+	if !reflect.DeepEqual(games, expected) {
+		t.Errorf("expected %v, got %v", expected, games)
+	}
+}
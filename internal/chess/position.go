@@ -3,6 +3,7 @@ package chess
 import (
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 type Position struct {
@@ -16,41 +17,65 @@ type Position struct {
 	activeColour           colour
 	pieceColourTypeCounter [12]int
 	halfMoveClock          uint8
+	fullMoveNumber         int
 	legalMoves             moveList
+	hash                   uint64
+	history                []historyEntry
+	// accumulator, if set, is kept up to date with the board for a network
+	accumulator *accumulator
+}
+
+type historyEntry struct {
+	hash            uint64
+	halfMoveClock   uint8
+	controlByColour [2]bitBoard
+	legalMoves      moveList
 }
 
 func (p *Position) LoadFEN(f FEN) error {
 	p.clear()
-	var currentFileIndex, currentRankIndex int8 = 0, 7
-	for _, currentRune := range f.BoardState {
-		if currentFileIndex > 8 {
-			return fmt.Errorf("bad FEN: overfilled row during board specification, row %v col %v", currentRankIndex, currentFileIndex)
-		}
-
-		if currentRune == '/' {
-			if currentFileIndex != 8 {
-				return fmt.Errorf("bad FEN: underfilled row during board specification, row %v col %v", currentRankIndex, currentFileIndex)
+	ranks := strings.Split(f.BoardState, "/")
+	if len(ranks) != 8 {
+		return fmt.Errorf("bad FEN: expected 8 ranks, got %d", len(ranks))
+	}
+	for idx, rank := range ranks {
+		currentRankIndex := int8(7 - idx)
+		var currentFileIndex int8
+		for _, currentRune := range rank {
+			if currentRune >= '1' && currentRune <= '8' {
+				currentFileIndex += int8(currentRune - '0')
+				if currentFileIndex > 8 {
+					return fmt.Errorf("bad FEN: overfilled rank %d", currentRankIndex+1)
+				}
+				continue
+			}
+			if currentFileIndex >= 8 {
+				return fmt.Errorf("bad FEN: overfilled rank %d", currentRankIndex+1)
 			}
 
-			currentRankIndex--
-			currentFileIndex = 0
-			continue
-		}
+			currentCoordinate, _ := coordinateFromRankFileIndices(currentFileIndex, currentRankIndex)
+			currentSquareState, err := squareStateFromRune(currentRune)
 
-		if numEmptySquares, err := strconv.Atoi(string(currentRune)); err == nil {
-			currentFileIndex += int8(numEmptySquares)
-			continue
-		}
-
-		currentCoordinate, _ := coordinateFromRankFileIndices(currentFileIndex, currentRankIndex)
-		currentSquareState, err := squareStateFromRune(currentRune)
+			if err != nil {
+				return fmt.Errorf("bad FEN: %s", err.Error())
+			}
+			if currentSquareState.getPieceType() == pawn && (currentRankIndex == 0 || currentRankIndex == 7) {
+				return fmt.Errorf("bad FEN: pawn on the back rank at %v", currentCoordinate.toString())
+			}
 
-		if err != nil {
-			return fmt.Errorf("bad FEN: %s", err.Error())
+			p.setSquare(currentCoordinate, currentSquareState)
+			currentFileIndex++
+		}
+		if currentFileIndex != 8 {
+			return fmt.Errorf("bad FEN: underfilled rank %d", currentRankIndex+1)
 		}
+	}
 
-		p.setSquare(currentCoordinate, currentSquareState)
-		currentFileIndex++
+	if count := p.pieceColourTypeCounter[whiteKing]; count != 1 {
+		return fmt.Errorf("bad FEN: expected exactly one white king, got %d", count)
+	}
+	if count := p.pieceColourTypeCounter[blackKing]; count != 1 {
+		return fmt.Errorf("bad FEN: expected exactly one black king, got %d", count)
 	}
 
 	switch f.ActiveColour {
@@ -88,6 +113,26 @@ func (p *Position) LoadFEN(f FEN) error {
 			return fmt.Errorf("bad FEN: unrecognised character in castling rights specification: %c", theRune)
 		}
 	}
+	// castling needs the king and the rook on their starting squares
+	for _, castling := range []struct {
+		flag       castlingRights
+		king, rook coordinate
+		theColour  colour
+	}{
+		{whiteCastleKingSide, e1, h1, white},
+		{whiteCastleQueenSide, e1, a1, white},
+		{blackCastleKingSide, e8, h8, black},
+		{blackCastleQueenSide, e8, a8, black},
+	} {
+		kingState, rookState := whiteKing, whiteRook
+		if castling.theColour == black {
+			kingState, rookState = blackKing, blackRook
+		}
+		if p.castlingRights.isSet(castling.flag) && (p.board[castling.king] != kingState || p.board[castling.rook] != rookState) {
+			return fmt.Errorf("bad FEN: castling rights %s without the king and rook on %s and %s",
+				f.CastlingRights, castling.king.toString(), castling.rook.toString())
+		}
+	}
 
 	switch f.EnPassantSquare {
 	case "-":
@@ -97,6 +142,17 @@ func (p *Position) LoadFEN(f FEN) error {
 		if err != nil {
 			return fmt.Errorf("bad FEN: %s", err.Error())
 		}
+		// the square is the one the opponent's pawn has just passed over, which
+		// it left empty
+		passed, from, to, pushedPawn := int8(5), int8(6), int8(4), blackPawn
+		if p.activeColour == black {
+			passed, from, to, pushedPawn = 2, 1, 3, whitePawn
+		}
+		fromSquare, _ := coordinateFromRankFileIndices(eps.getFileIndex(), from)
+		toSquare, _ := coordinateFromRankFileIndices(eps.getFileIndex(), to)
+		if eps.getRankIndex() != passed || p.board[eps] != empty || p.board[fromSquare] != empty || p.board[toSquare] != pushedPawn {
+			return fmt.Errorf("bad FEN: no pawn can just have passed over en passant square %s", f.EnPassantSquare)
+		}
 		p.enPassantSquare = eps
 	}
 
@@ -109,11 +165,30 @@ func (p *Position) LoadFEN(f FEN) error {
 	}
 	p.halfMoveClock = uint8(hmcInt)
 
+	fmnInt, err := strconv.Atoi(f.FullMoveNumber)
+	if err != nil {
+		return fmt.Errorf("bad FEN: %s", err.Error())
+	}
+	if fmnInt < 1 {
+		return fmt.Errorf("bad FEN: full move number must be positive")
+	}
+	p.fullMoveNumber = fmnInt
+
+	p.hash ^= p.getStateHash()
 	p.doStaticAnalysis()
 
 	return nil
 }
 
+func (p Position) clone() Position {
+	p.legalMoves = append(moveList{}, p.legalMoves...)
+	p.history = append([]historyEntry{}, p.history...)
+	if p.accumulator != nil {
+		p.accumulator = p.accumulator.clone()
+	}
+	return p
+}
+
 func (p *Position) clear() {
 	for idx := range p.board {
 		p.board[idx] = empty
@@ -137,19 +212,30 @@ func (p *Position) clear() {
 		p.pieceColourTypeCounter[idx] = 0
 	}
 	p.halfMoveClock = 0
+	p.fullMoveNumber = 1
 	p.legalMoves = moveList{}
+	p.hash = 0
+	p.history = nil
+	if p.accumulator != nil {
+		p.accumulator.stale = [2]bool{true, true}
+	}
 }
 
 func (p *Position) setSquare(theCoord coordinate, theState squareState) {
+	previousState := p.board[theCoord]
+	if previousState != empty {
+		p.occupationByColour[previousState.getColour()].turnOff(theCoord)
+		p.occupationByPieceType[previousState.getPieceType()].turnOff(theCoord)
+		p.pieceColourTypeCounter[previousState]--
+		p.hash ^= zobristPieceSquare[previousState][theCoord]
+	}
+
 	p.board[theCoord] = theState
+	if p.accumulator != nil {
+		p.accumulator.update(p, theCoord, previousState, theState)
+	}
 
 	if theState == empty {
-		p.occupationByColour[white].turnOff(theCoord)
-		p.occupationByColour[black].turnOff(theCoord)
-
-		for thePieceType := king; thePieceType <= pawn; thePieceType++ {
-			p.occupationByPieceType[thePieceType].turnOff(theCoord)
-		}
 		return
 	}
 
@@ -162,12 +248,24 @@ func (p *Position) setSquare(theCoord coordinate, theState squareState) {
 
 	p.occupationByColour[theColour].turnOn(theCoord)
 	p.occupationByPieceType[thePieceType].turnOn(theCoord)
+	p.pieceColourTypeCounter[theState]++
+	p.hash ^= zobristPieceSquare[theState][theCoord]
 }
 
 func (p Position) getSquare(theCoord coordinate) squareState {
 	return p.board[theCoord]
 }
 
+// getStateHash returns the part of the Zobrist hash that does not depend on
+// piece placement.
+func (p Position) getStateHash() uint64 {
+	hash := zobristCastling[p.castlingRights] ^ zobristEnPassant(p.enPassantSquare)
+	if p.activeColour == black {
+		hash ^= zobristBlackToMove
+	}
+	return hash
+}
+
 func (p *Position) doStaticAnalysis() {
 	p.controlByColour[white] = 0
 	p.controlByColour[black] = 0
@@ -221,17 +319,17 @@ func (p *Position) surveyKingActivity(player colour, getPsuedoLegalMoves bool, p
 			whiteKingSideCastle := p.moveFromAlgebraicParts(e1, g1, empty)
 			pseudoLegalMoves.add(whiteKingSideCastle)
 		}
-		if p.castlingRights.isSet(whiteCastleQueenSide) && p.allowsSafePassage(white, d1) && p.allowsSafePassage(white, c1) {
-			whiteQueenSideCastle := p.moveFromAlgebraicParts(e1, g1, empty)
+		if p.castlingRights.isSet(whiteCastleQueenSide) && p.allowsSafePassage(white, d1) && p.allowsSafePassage(white, c1) && !p.getOccupationBitBoard().get(b1) {
+			whiteQueenSideCastle := p.moveFromAlgebraicParts(e1, c1, empty)
 			pseudoLegalMoves.add(whiteQueenSideCastle)
 		}
 	case black:
 		if p.castlingRights.isSet(blackCastleKingSide) && p.allowsSafePassage(black, f8) && p.allowsSafePassage(black, g8) {
-			blackKingSideCastle := p.moveFromAlgebraicParts(e1, g1, empty)
+			blackKingSideCastle := p.moveFromAlgebraicParts(e8, g8, empty)
 			pseudoLegalMoves.add(blackKingSideCastle)
 		}
-		if p.castlingRights.isSet(blackCastleQueenSide) && p.allowsSafePassage(black, d8) && p.allowsSafePassage(black, c8) {
-			blackQueenSideCastle := p.moveFromAlgebraicParts(e1, g1, empty)
+		if p.castlingRights.isSet(blackCastleQueenSide) && p.allowsSafePassage(black, d8) && p.allowsSafePassage(black, c8) && !p.getOccupationBitBoard().get(b8) {
+			blackQueenSideCastle := p.moveFromAlgebraicParts(e8, c8, empty)
 			pseudoLegalMoves.add(blackQueenSideCastle)
 		}
 	}
@@ -324,7 +422,7 @@ func (p *Position) surveyKnightActivity(player colour, getPsuedoLegalMoves bool,
 		p.controlByColour[player] |= controlledSquares
 
 		if !getPsuedoLegalMoves {
-			return
+			continue
 		}
 
 		for {
@@ -508,10 +606,31 @@ func (p Position) moveFromAlgebraicParts(from, to coordinate, promotionTo square
 }
 
 func (p *Position) isLegalMove(theMove move) bool {
-	return true
+	player := p.activeColour
+	opponent := player.getOpponent()
+
+	p.makePseudoLegalMove(theMove)
+	p.controlByColour[opponent] = 0
+	p.surveyPieceActivity(opponent, false)
+	isLegal := !p.inCheck(player)
+	p.unmakeMove(theMove)
+
+	return isLegal
+}
+
+func (p *Position) makeMove(theMove move) {
+	p.makePseudoLegalMove(theMove)
+	p.doStaticAnalysis()
 }
 
 func (p *Position) makePseudoLegalMove(theMove move) {
+	p.history = append(p.history, historyEntry{
+		hash:            p.hash,
+		halfMoveClock:   p.halfMoveClock,
+		controlByColour: p.controlByColour,
+		legalMoves:      p.legalMoves,
+	})
+	p.hash ^= p.getStateHash()
 	p.enPassantSquare = nullCoordinate
 
 	fromCoord := theMove.getFromCoordinate()
@@ -519,6 +638,12 @@ func (p *Position) makePseudoLegalMove(theMove move) {
 	EPSquare := theMove.getCurrentEPSquare()
 	pieceBeingMoved := p.getSquare(fromCoord)
 
+	if pieceBeingMoved.getPieceType() == pawn || theMove.getCapturedPiece() != empty {
+		p.halfMoveClock = 0
+	} else {
+		p.halfMoveClock++
+	}
+
 	switch pieceBeingMoved {
 	case whiteKing:
 		p.castlingRights.turnOff(whiteCastleKingSide | whiteCastleQueenSide)
@@ -566,6 +691,18 @@ func (p *Position) makePseudoLegalMove(theMove move) {
 		}
 	}
 
+	// capturing a rook on its home square also forfeits that side's castling right
+	switch toCoord {
+	case a1:
+		p.castlingRights.turnOff(whiteCastleQueenSide)
+	case h1:
+		p.castlingRights.turnOff(whiteCastleKingSide)
+	case a8:
+		p.castlingRights.turnOff(blackCastleQueenSide)
+	case h8:
+		p.castlingRights.turnOff(blackCastleKingSide)
+	}
+
 	p.setSquare(fromCoord, empty)
 	if promotionTo := theMove.getPromotionTo(); promotionTo != empty {
 		p.setSquare(toCoord, promotionTo)
@@ -573,18 +710,33 @@ func (p *Position) makePseudoLegalMove(theMove move) {
 		p.setSquare(toCoord, pieceBeingMoved)
 	}
 
+	if p.activeColour == black {
+		p.fullMoveNumber++
+	}
 	p.activeColour = p.activeColour.getOpponent()
-
-	p.doStaticAnalysis()
+	p.hash ^= p.getStateHash()
 }
 
 func (p *Position) unmakeMove(theMove move) {
+	p.activeColour = p.activeColour.getOpponent()
+	if p.activeColour == black {
+		p.fullMoveNumber--
+	}
 	p.enPassantSquare = theMove.getCurrentEPSquare()
 	p.castlingRights = theMove.getCurrentCastlingRights()
 
 	fromCoord := theMove.getFromCoordinate()
 	toCoord := theMove.getToCoordinate()
 	pieceBeingMoved := p.getSquare(toCoord)
+	if theMove.getPromotionTo() != empty {
+		pieceBeingMoved = whitePawn
+		if p.activeColour == black {
+			pieceBeingMoved = blackPawn
+		}
+	}
+
+	p.setSquare(fromCoord, pieceBeingMoved)
+	p.setSquare(toCoord, theMove.getCapturedPiece())
 
 	switch pieceBeingMoved {
 	case whiteKing:
@@ -603,13 +755,164 @@ func (p *Position) unmakeMove(theMove move) {
 			p.setSquare(h8, blackRook)
 			p.setSquare(f8, empty)
 		}
+	case whitePawn:
+		if toCoord == p.enPassantSquare {
+			p.setSquare(toCoord-8, blackPawn)
+		}
+	case blackPawn:
+		if toCoord == p.enPassantSquare {
+			p.setSquare(toCoord+8, whitePawn)
+		}
+	}
+
+	lastEntry := p.history[len(p.history)-1]
+	p.history = p.history[:len(p.history)-1]
+	p.hash = lastEntry.hash
+	p.halfMoveClock = lastEntry.halfMoveClock
+	p.controlByColour = lastEntry.controlByColour
+	p.legalMoves = lastEntry.legalMoves
+}
+
+// makeNullMove passes the turn to the opponent without moving, returning the
+// en passant square it clears for unmakeNullMove. The side to move must not be
+// in check.
+func (p *Position) makeNullMove() coordinate {
+	p.history = append(p.history, historyEntry{
+		hash:            p.hash,
+		halfMoveClock:   p.halfMoveClock,
+		controlByColour: p.controlByColour,
+		legalMoves:      p.legalMoves,
+	})
+	previousEPSquare := p.enPassantSquare
+	p.hash ^= p.getStateHash()
+	p.enPassantSquare = nullCoordinate
+	// no repetition can reach back past a null move
+	p.halfMoveClock = 0
+	p.activeColour = p.activeColour.getOpponent()
+	p.hash ^= p.getStateHash()
+	p.doStaticAnalysis()
+	return previousEPSquare
+}
+
+func (p *Position) unmakeNullMove(previousEPSquare coordinate) {
+	p.activeColour = p.activeColour.getOpponent()
+	p.enPassantSquare = previousEPSquare
+
+	lastEntry := p.history[len(p.history)-1]
+	p.history = p.history[:len(p.history)-1]
+	p.hash = lastEntry.hash
+	p.halfMoveClock = lastEntry.halfMoveClock
+	p.controlByColour = lastEntry.controlByColour
+	p.legalMoves = lastEntry.legalMoves
+}
+
+func (p Position) repetitionCount() int {
+	count := 0
+	for idx := len(p.history) - 2; idx >= 0 && idx >= len(p.history)-int(p.halfMoveClock); idx -= 2 {
+		if p.history[idx].hash == p.hash {
+			count++
+		}
 	}
+	return count
 }
 
 func (p Position) inCheck(player colour) bool {
 	return p.isAttackedByEnemy(player, p.kingSquares[player])
 }
 
+// givesCheck reports whether a legal move puts the opponent in check, directly
+// or by uncovering a slider, without making it. Castling is taken never to
+// give check.
+func (p *Position) givesCheck(theMove move) bool {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	mover := p.board[from]
+	if mover.getPieceType() == king && (to == from+2 || from == to+2) {
+		return false
+	}
+
+	occupancy := p.getOccupationBitBoard()&^(1<<from) | 1<<to
+	if mover.getPieceType() == pawn && to == theMove.getCurrentEPSquare() {
+		occupancy &^= 1 << p.enPassantVictim(to)
+	}
+	placed := mover
+	if promotion := theMove.getPromotionTo(); promotion != empty {
+		placed = promotion
+	}
+
+	var control bitBoard
+	switch placed.getPieceType() {
+	case queen:
+		control = rookControlFrom(to, occupancy) | bishopControlFrom(to, occupancy)
+	case rook:
+		control = rookControlFrom(to, occupancy)
+	case bishop:
+		control = bishopControlFrom(to, occupancy)
+	case knight:
+		control = knightControlFrom[to]
+	case pawn:
+		control = pawnControlFrom[p.activeColour][to]
+	}
+	theirKing := p.kingSquares[p.activeColour.getOpponent()]
+	if control.get(theirKing) {
+		return true
+	}
+
+	ours := p.occupationByColour[p.activeColour] &^ (1 << from)
+	rooksAndQueens := (p.occupationByPieceType[rook] | p.occupationByPieceType[queen]) & ours
+	bishopsAndQueens := (p.occupationByPieceType[bishop] | p.occupationByPieceType[queen]) & ours
+	return rookControlFrom(theirKing, occupancy)&rooksAndQueens != 0 ||
+		bishopControlFrom(theirKing, occupancy)&bishopsAndQueens != 0
+}
+
+// getCheckers returns the enemy pieces giving check to the side to move.
+func (p Position) getCheckers() bitBoard {
+	kingCoord := p.kingSquares[p.activeColour]
+	enemies := p.occupationByColour[p.activeColour.getOpponent()]
+	if kingCoord == nullCoordinate {
+		return 0
+	}
+
+	checkers := knightControlFrom[kingCoord] & enemies & p.occupationByPieceType[knight]
+
+	kingBitBoard := bitBoard(1) << kingCoord
+	var pawnCheckSquares bitBoard
+	if p.activeColour == white {
+		pawnCheckSquares = (kingBitBoard&^fileH)<<9 | (kingBitBoard&^fileA)<<7
+	} else {
+		pawnCheckSquares = (kingBitBoard&^fileH)>>7 | (kingBitBoard&^fileA)>>9
+	}
+	checkers |= pawnCheckSquares & enemies & p.occupationByPieceType[pawn]
+
+	for _, delta := range []gridDelta{
+		{1, 0},
+		{1, 1},
+		{0, 1},
+		{-1, 1},
+		{-1, 0},
+		{-1, -1},
+		{0, -1},
+		{1, -1},
+	} {
+		slider := rook
+		if delta.fileDelta != 0 && delta.rankDelta != 0 {
+			slider = bishop
+		}
+
+		for theCoord, err := kingCoord.move(delta); err == nil; theCoord, err = theCoord.move(delta) {
+			theState := p.getSquare(theCoord)
+			if theState == empty {
+				continue
+			}
+			if enemies.get(theCoord) && (theState.getPieceType() == slider || theState.getPieceType() == queen) {
+				checkers.turnOn(theCoord)
+			}
+			break
+		}
+	}
+
+	return checkers
+}
+
 func (p Position) isAttackedByEnemy(player colour, theCoord coordinate) bool {
 	return p.controlByColour[player.getOpponent()].get(theCoord)
 }
@@ -626,27 +929,136 @@ func (p Position) getOccupationBitBoard() bitBoard {
 	return p.occupationByColour[white] | p.occupationByColour[black]
 }
 
-func (p *Position) MakeMove(theMove algebraicMove) error {
-	// check for pseudolegality
-	// TODO make this check robust
+// WhiteToMove reports whether it is white's turn to move.
+func (p Position) WhiteToMove() bool {
+	return p.activeColour == white
+}
+
+// FullMoveNumber returns the number of the move being played, starting at 1
+// and going up after each black move.
+func (p Position) FullMoveNumber() int {
+	return p.fullMoveNumber
+}
+
+// InCheck reports whether the side to move is in check.
+func (p Position) InCheck() bool {
+	return p.inCheck(p.activeColour)
+}
+
+// IsCapture reports whether a legal move, given in long algebraic notation,
+// captures a piece, counting en passant. It is false for illegal moves.
+func (p Position) IsCapture(moveString string) bool {
+	theMove, err := p.findLegalMove(moveString)
+	return err == nil && p.isCapture(theMove)
+}
+
+// LegalMoves lists the legal moves in the position in long algebraic notation.
+func (p Position) LegalMoves() []string {
+	result := make([]string, len(p.legalMoves))
+	for idx, theMove := range p.legalMoves {
+		result[idx] = theMove.toString()
+	}
+	return result
+}
+
+// Flip mirrors the position vertically and swaps the colours of all pieces,
+// so that the other side is to move. The move history is discarded.
+func (p *Position) Flip() {
+	original := *p
+	p.clear()
+
+	for theCoord := a1; theCoord <= h8; theCoord++ {
+		if theState := original.board[theCoord]; theState != empty {
+			// colours alternate in squareState, so toggling the lowest bit swaps them
+			p.setSquare(theCoord^56, theState^1)
+		}
+	}
+
+	p.castlingRights = original.castlingRights>>2 | (original.castlingRights&0b11)<<2
+	if original.enPassantSquare != nullCoordinate {
+		p.enPassantSquare = original.enPassantSquare ^ 56
+	}
+	p.activeColour = original.activeColour.getOpponent()
+	p.halfMoveClock = original.halfMoveClock
+	p.fullMoveNumber = original.fullMoveNumber
+
+	p.hash ^= p.getStateHash()
+	p.doStaticAnalysis()
+}
+
+// MakeMove plays a move given in long algebraic notation (e.g. e2e4, e1g1,
+// e7e8q), provided it is legal in the current position.
+func (p *Position) MakeMove(moveString string) error {
+	theMove, err := p.findLegalMove(moveString)
+	if err != nil {
+		return err
+	}
+
+	p.makeMove(theMove)
+	return nil
+}
+
+// findLegalMove looks up a move given in long algebraic notation among the
+// legal moves of the position.
+func (p Position) findLegalMove(moveString string) (move, error) {
+	theMove, err := algebraicMoveFromString(moveString)
+	if err != nil {
+		return 0, err
+	}
+
 	fromSquareState := p.board[theMove.From]
 	if fromSquareState == empty {
-		return fmt.Errorf("no piece to move: %s", theMove.toString())
+		return 0, fmt.Errorf("no piece to move: %s", moveString)
 	}
 	if fromSquareState.getColour() != p.activeColour {
-		return fmt.Errorf("attempting to move piece of wrong colour: %s", theMove.toString())
+		return 0, fmt.Errorf("attempting to move piece of wrong colour: %s", moveString)
 	}
 
-	toSquareState := p.board[theMove.To]
-	if toSquareState.getColour() == p.activeColour {
-		return fmt.Errorf("cannot move piece to square occupied by friendly piece: %s", theMove.toString())
-	}
+	for _, legalMove := range p.legalMoves {
+		if legalMove.getFromCoordinate() != theMove.From || legalMove.getToCoordinate() != theMove.To {
+			continue
+		}
+
+		// UCI always spells promotions in lower case, so only the piece type is compared
+		promotionTo := legalMove.getPromotionTo()
+		if promotionTo != theMove.Promotion && (promotionTo == empty || theMove.Promotion == empty || promotionTo.getPieceType() != theMove.Promotion.getPieceType()) {
+			continue
+		}
 
-	if theMove.Promotion != empty && theMove.Promotion.getColour() != p.activeColour {
-		return fmt.Errorf("cannot promote to enemy piece: %s", theMove.toString())
+		return legalMove, nil
 	}
 
-	// make pseudomove
+	return 0, fmt.Errorf("illegal move in current position: %s", moveString)
+}
 
-	return nil
+// Outcome reports whether the game is over in the position. The result is
+// given in PGN notation ("1-0", "0-1" or "1/2-1/2") along with a short reason,
+// and is empty if the game continues.
+func (p Position) Outcome() (result, reason string) {
+	switch {
+	case len(p.legalMoves) == 0 && p.inCheck(p.activeColour):
+		if p.activeColour == white {
+			return "0-1", "Black mates"
+		}
+		return "1-0", "White mates"
+	case len(p.legalMoves) == 0:
+		return "1/2-1/2", "Stalemate"
+	case p.halfMoveClock >= 100:
+		return "1/2-1/2", "Fifty move rule"
+	case p.repetitionCount() >= 2:
+		return "1/2-1/2", "Threefold repetition"
+	case p.hasInsufficientMaterial():
+		return "1/2-1/2", "Insufficient material"
+	}
+	return "", ""
+}
+
+// hasInsufficientMaterial reports whether neither side can possibly deliver
+// mate, which is only the case for lone kings and a single minor piece.
+func (p *Position) hasInsufficientMaterial() bool {
+	heavyPieces := p.occupationByPieceType[queen] | p.occupationByPieceType[rook] | p.occupationByPieceType[pawn]
+	if heavyPieces != 0 {
+		return false
+	}
+	return (p.occupationByPieceType[bishop] | p.occupationByPieceType[knight]).count() <= 1
 }
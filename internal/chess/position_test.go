@@ -1,6 +1,7 @@
 package chess
 
 import (
+	"reflect"
 	"testing"
 )
 
@@ -91,6 +92,55 @@ func TestLoadFEN(t *testing.T) {
 	}
 }
 
+func TestLoadFENRejectsImpossiblePositions(t *testing.T) {
+	type testCase struct {
+		name string
+		fen  string
+	}
+
+	testCases := []testCase{
+		{"white pawn on the back rank", "P3k3/8/8/8/8/8/8/4K3 w - - 0 1"},
+		{"black pawn on the back rank", "4k3/8/8/8/8/8/8/p3K3 w - - 0 1"},
+		{"castling without rooks", "4k3/8/8/8/8/8/8/4K3 w KQ - 0 1"},
+		{"castling with the king moved", "r3k2r/8/8/8/8/8/8/R2K3R w KQkq - 0 1"},
+		{"castling with a black rook moved", "r3k1r1/8/8/8/8/8/8/R3K2R w KQkq - 0 1"},
+		{"en passant on the wrong rank", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e3 0 1"},
+		{"en passant without a pushed pawn", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR b KQkq e3 0 1"},
+		{"en passant behind the pawn", "rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq e4 0 2"},
+		{"en passant with the start square filled", "rnbqkbnr/pppppppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq e6 0 2"},
+		{"nine ranks", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR/8 w KQkq - 0 1"},
+		{"seven ranks", "rnbqkbnr/pppppppp/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"nine files", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR1 w KQkq - 0 1"},
+		{"seven files", "rnbqkbnr/pppppppp/8/8/7/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+		{"zero empty squares", "rnbqkbnr/pppppppp/8/8/8/08/PPPPPPPP/RNBQKBNR w KQkq - 0 1"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		fen, err := ParseFEN(c.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		thePosition := Position{}
+		if err := thePosition.LoadFEN(fen); err == nil {
+			t.Errorf("expected %s to be rejected", c.fen)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+
+	// an en passant square is kept where a pawn has just passed over it
+	for _, fen := range []string{
+		"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR b KQkq e3 0 1",
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2",
+	} {
+		if thePosition := loadPosition(t, fen); thePosition.GetFEN().String() != fen {
+			t.Errorf("expected %s, got %s", fen, thePosition.GetFEN())
+		}
+	}
+}
+
 func TestGetPseudoLegalMoves(t *testing.T) {
 	// TODO test from another position to look at knights, pawns, castling and en-passant
 	thePosition := Position{}
@@ -121,3 +171,403 @@ func TestGetPseudoLegalMoves(t *testing.T) {
 		}
 	}
 }
+
+func TestMakeMove(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moveStrings  []string
+		squareChecks []struct {
+			coordinate
+			squareState
+		}
+		enPassantSquare coordinate
+		castlingRights
+		halfMoveClock uint8
+	}
+
+	testCases := []testCase{
+		{
+			"king side castle",
+			FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "w", "KQkq", "-", "0", "1"},
+			[]string{"e1g1"},
+			[]struct {
+				coordinate
+				squareState
+			}{
+				{g1, whiteKing},
+				{f1, whiteRook},
+				{e1, empty},
+				{h1, empty},
+			},
+			nullCoordinate,
+			blackCastleKingSide | blackCastleQueenSide,
+			1,
+		},
+		{
+			"queen side castle",
+			FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "b", "KQkq", "-", "0", "1"},
+			[]string{"e8c8"},
+			[]struct {
+				coordinate
+				squareState
+			}{
+				{c8, blackKing},
+				{d8, blackRook},
+				{a8, empty},
+			},
+			nullCoordinate,
+			whiteCastleKingSide | whiteCastleQueenSide,
+			1,
+		},
+		{
+			"en passant",
+			GetStartingFEN(),
+			[]string{"e2e4", "a7a6", "e4e5", "d7d5", "e5d6"},
+			[]struct {
+				coordinate
+				squareState
+			}{
+				{d6, whitePawn},
+				{d5, empty},
+				{e5, empty},
+			},
+			nullCoordinate,
+			0b1111,
+			0,
+		},
+		{
+			"promotion with capture",
+			FEN{"1n2k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1"},
+			[]string{"a7b8q"},
+			[]struct {
+				coordinate
+				squareState
+			}{
+				{b8, whiteQueen},
+				{a7, empty},
+			},
+			nullCoordinate,
+			0,
+			0,
+		},
+		{
+			"rook capture removes castling right",
+			FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "w", "KQkq", "-", "0", "1"},
+			[]string{"a1a8"},
+			[]struct {
+				coordinate
+				squareState
+			}{
+				{a8, whiteRook},
+			},
+			nullCoordinate,
+			whiteCastleKingSide | blackCastleKingSide,
+			0,
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+
+		for _, moveString := range c.moveStrings {
+			if err := thePosition.MakeMove(moveString); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, sc := range c.squareChecks {
+			if result := thePosition.board[sc.coordinate]; result != sc.squareState {
+				t.Errorf("expected %v at %v, got %v", sc.squareState, sc.coordinate, result)
+			}
+		}
+
+		if thePosition.enPassantSquare != c.enPassantSquare {
+			t.Errorf("expected en passant square %v, got %v", c.enPassantSquare, thePosition.enPassantSquare)
+		}
+
+		if thePosition.castlingRights != c.castlingRights {
+			t.Errorf("expected castling rights %v, got %v", c.castlingRights, thePosition.castlingRights)
+		}
+
+		if thePosition.halfMoveClock != c.halfMoveClock {
+			t.Errorf("expected half move clock to be %v, got %v", c.halfMoveClock, thePosition.halfMoveClock)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestMakeMoveRejectsIllegalMoves(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moveString string
+	}
+
+	testCases := []testCase{
+		{"malformed", GetStartingFEN(), "e2"},
+		{"empty square", GetStartingFEN(), "e3e4"},
+		{"wrong colour", GetStartingFEN(), "e7e5"},
+		{"blocked", GetStartingFEN(), "a1a3"},
+		{"castling through check", FEN{"4k3/8/8/8/8/8/5r2/4K2R", "w", "K", "-", "0", "1"}, "e1g1"},
+		{"pinned piece", FEN{"4k3/4r3/8/8/8/8/4B3/4K3", "w", "-", "-", "0", "1"}, "e2d3"},
+		{"missing promotion", FEN{"4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1"}, "a7a8"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		if err := thePosition.MakeMove(c.moveString); err == nil {
+			t.Errorf("expected %s to be rejected", c.moveString)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestUnmakeMove(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(FEN{"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R", "w", "KQkq", "-", "0", "1"})
+	original := thePosition
+
+	for _, theMove := range original.legalMoves {
+		thePosition.makeMove(theMove)
+		thePosition.unmakeMove(theMove)
+
+		if !reflect.DeepEqual(thePosition, original) {
+			t.Errorf("position not restored after making and unmaking %v", theMove)
+		}
+	}
+}
+
+func TestNullMove(t *testing.T) {
+	thePosition := loadPosition(t, "rnbqkbnr/pppp1ppp/8/8/3Pp3/8/PPP1PPPP/RNBQKBNR b KQkq d3 0 2")
+	original := thePosition
+	passed := loadPosition(t, "rnbqkbnr/pppp1ppp/8/8/3Pp3/8/PPP1PPPP/RNBQKBNR w KQkq - 0 2")
+
+	previousEPSquare := thePosition.makeNullMove()
+	if thePosition.hash != passed.hash {
+		t.Errorf("expected hash %v after passing, got %v", passed.hash, thePosition.hash)
+	}
+	if len(thePosition.legalMoves) != len(passed.legalMoves) {
+		t.Errorf("expected %v legal moves after passing, got %v", len(passed.legalMoves), len(thePosition.legalMoves))
+	}
+
+	thePosition.unmakeNullMove(previousEPSquare)
+	if !reflect.DeepEqual(thePosition, original) {
+		t.Error("position not restored after unmaking a null move")
+	}
+}
+
+func TestGivesCheck(t *testing.T) {
+	fens := []string{
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+	}
+
+	// making each move and looking is the reference, a ply deep for variety
+	check := func(t *testing.T, thePosition *Position) {
+		for _, theMove := range thePosition.legalMoves {
+			from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+			if thePosition.board[from].getPieceType() == king && (to == from+2 || from == to+2) {
+				continue
+			}
+			result := thePosition.givesCheck(theMove)
+			thePosition.makeMove(theMove)
+			expected := thePosition.inCheck(thePosition.activeColour)
+			thePosition.unmakeMove(theMove)
+			if result != expected {
+				t.Errorf("%s in %s: expected %v, got %v", theMove.toString(), thePosition.GetFEN().String(), expected, result)
+			}
+		}
+	}
+
+	for _, fen := range fens {
+		thePosition := loadPosition(t, fen)
+		check(t, &thePosition)
+		for _, theMove := range thePosition.legalMoves {
+			thePosition.makeMove(theMove)
+			check(t, &thePosition)
+			thePosition.unmakeMove(theMove)
+		}
+	}
+}
+
+func TestRepetitionCount(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(GetStartingFEN())
+	startingHash := thePosition.hash
+
+	for _, moveString := range []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"} {
+		if err := thePosition.MakeMove(moveString); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if thePosition.hash != startingHash {
+		t.Errorf("expected hash %v after returning to the starting position, got %v", startingHash, thePosition.hash)
+	}
+
+	if result := thePosition.repetitionCount(); result != 2 {
+		t.Errorf("expected starting position to have repeated twice, got %v", result)
+	}
+}
+
+func TestFlip(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		expected string
+	}
+
+	testCases := []testCase{
+		{
+			"opera",
+			operaGame,
+			"2kr3r/ppp2ppp/1q6/4p3/1b2P1b1/5N2/P2NQPPP/3RKB1R b K - 3 13",
+		},
+		{
+			"en passant",
+			FEN{"rnbqkbnr/pppppppp/8/8/4P3/8/PPPP1PPP/RNBQKBNR", "b", "KQkq", "e3", "0", "1"},
+			"rnbqkbnr/pppp1ppp/8/4p3/8/8/PPPPPPPP/RNBQKBNR w KQkq e6 0 1",
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		original := thePosition.GetFEN()
+
+		thePosition.Flip()
+		if result := thePosition.GetFEN().String(); result != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, result)
+		}
+
+		expected := Position{}
+		expected.LoadFEN(thePosition.GetFEN())
+		if thePosition.hash != expected.hash {
+			t.Errorf("expected hash %v after flipping, got %v", expected.hash, thePosition.hash)
+		}
+
+		thePosition.Flip()
+		if result := thePosition.GetFEN(); result != original {
+			t.Errorf("expected flipping twice to give %s, got %s", original, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestGetCheckers(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		expected bitBoard
+	}
+
+	testCases := []testCase{
+		{"none", GetStartingFEN(), 0},
+		{"knight", FEN{"4k3/8/3N4/8/8/8/8/4K3", "b", "-", "-", "0", "1"}, bitBoard(1) << d6},
+		{"pawn", FEN{"4k3/8/8/8/8/8/3p4/4K3", "w", "-", "-", "0", "1"}, bitBoard(1) << d2},
+		{"double check", FEN{"4k3/8/8/8/1b6/8/8/r3K3", "w", "-", "-", "0", "1"}, bitBoard(1)<<a1 | bitBoard(1)<<b4},
+		{"blocked slider", FEN{"4k3/4r3/8/8/8/8/4P3/4K3", "w", "-", "-", "0", "1"}, 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+		if result := thePosition.getCheckers(); result != c.expected {
+			t.Errorf("expected checkers %b, got %b", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestOutcome(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moves          []string
+		expectedResult string
+		expectedReason string
+	}
+
+	testCases := []testCase{
+		{"game continues", GetStartingFEN(), nil, "", ""},
+		{"fool's mate", GetStartingFEN(), []string{"f2f3", "e7e5", "g2g4", "d8h4"}, "0-1", "Black mates"},
+		{"back rank mate", FEN{"6k1/5ppp/8/8/8/8/8/R5K1", "w", "-", "-", "0", "1"}, []string{"a1a8"}, "1-0", "White mates"},
+		{"stalemate", FEN{"k7/8/1Q6/8/8/8/8/7K", "b", "-", "-", "0", "1"}, nil, "1/2-1/2", "Stalemate"},
+		{"fifty move rule", FEN{"k7/8/8/8/8/8/8/R6K", "w", "-", "-", "99", "80"}, []string{"a1b1"}, "1/2-1/2", "Fifty move rule"},
+		{"repetition", GetStartingFEN(), []string{"g1f3", "g8f6", "f3g1", "f6g8", "g1f3", "g8f6", "f3g1", "f6g8"}, "1/2-1/2", "Threefold repetition"},
+		{"insufficient material", FEN{"k7/8/8/8/8/8/8/5N1K", "w", "-", "-", "0", "1"}, nil, "1/2-1/2", "Insufficient material"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		if err := thePosition.LoadFEN(c.FEN); err != nil {
+			t.Fatal(err)
+		}
+		for _, moveString := range c.moves {
+			if err := thePosition.MakeMove(moveString); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		result, reason := thePosition.Outcome()
+		if result != c.expectedResult || reason != c.expectedReason {
+			t.Errorf("expected %q {%s}, got %q {%s}", c.expectedResult, c.expectedReason, result, reason)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestIsCapture(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moveString      string
+		expectedCapture bool
+		expectedInCheck bool
+	}
+
+	testCases := []testCase{
+		{"quiet move", GetStartingFEN(), "e2e4", false, false},
+		{"capture", FEN{"4k3/8/8/3p4/4P3/8/8/4K3", "w", "-", "-", "0", "1"}, "e4d5", true, false},
+		{"en passant", FEN{"4k3/8/8/3pP3/8/8/8/4K3", "w", "-", "d6", "0", "1"}, "e5d6", true, false},
+		{"capture out of check", FEN{"4k3/8/8/8/8/8/3q4/4K3", "w", "-", "-", "0", "1"}, "e1d2", true, true},
+		{"illegal move", GetStartingFEN(), "e2e5", false, false},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		if err := thePosition.LoadFEN(c.FEN); err != nil {
+			t.Fatal(err)
+		}
+		if result := thePosition.IsCapture(c.moveString); result != c.expectedCapture {
+			t.Errorf("expected capture %v, got %v", c.expectedCapture, result)
+		}
+		if result := thePosition.InCheck(); result != c.expectedInCheck {
+			t.Errorf("expected in check %v, got %v", c.expectedInCheck, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -0,0 +1,188 @@
+package chess
+
+import (
+	"fmt"
+	"strings"
+)
+
+var pieceTypeLetters = [6]string{
+	king:   "K",
+	queen:  "Q",
+	rook:   "R",
+	bishop: "B",
+	knight: "N",
+	pawn:   "",
+}
+
+// SAN converts a legal move from long algebraic notation to standard
+// algebraic notation (e.g. e1g1 to O-O, or g1f3 to Nf3).
+func (p Position) SAN(moveString string) (string, error) {
+	theMove, err := p.findLegalMove(moveString)
+	if err != nil {
+		return "", err
+	}
+
+	result := p.moveToSAN(theMove)
+
+	after := p.clone()
+	after.makeMove(theMove)
+	if after.inCheck(after.activeColour) {
+		if len(after.legalMoves) == 0 {
+			return result + "#", nil
+		}
+		return result + "+", nil
+	}
+	return result, nil
+}
+
+// ParseSAN converts a legal move from standard algebraic notation to long
+// algebraic notation. Check and annotation suffixes are ignored.
+func (p Position) ParseSAN(san string) (string, error) {
+	trimmed := strings.TrimRight(san, "+#!?")
+
+	var candidates []move
+	for _, theMove := range p.legalMoves {
+		if p.matchesSAN(theMove, trimmed) {
+			candidates = append(candidates, theMove)
+		}
+	}
+
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("illegal move in current position: %s", san)
+	case 1:
+		return candidates[0].toString(), nil
+	default:
+		return "", fmt.Errorf("ambiguous move: %s", san)
+	}
+}
+
+// moveToSAN spells a move in standard algebraic notation, without any check
+// suffix.
+func (p Position) moveToSAN(theMove move) string {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	thePieceType := p.board[from].getPieceType()
+
+	if thePieceType == king {
+		switch int(to) - int(from) {
+		case 2:
+			return "O-O"
+		case -2:
+			return "O-O-O"
+		}
+	}
+
+	var b strings.Builder
+	isCapture := theMove.getCapturedPiece() != empty || (thePieceType == pawn && to == p.enPassantSquare)
+
+	if thePieceType == pawn {
+		if isCapture {
+			b.WriteString(from.toString()[:1])
+		}
+	} else {
+		b.WriteString(pieceTypeLetters[thePieceType])
+		b.WriteString(p.getDisambiguation(theMove))
+	}
+
+	if isCapture {
+		b.WriteString("x")
+	}
+	b.WriteString(to.toString())
+
+	if promotionTo := theMove.getPromotionTo(); promotionTo != empty {
+		b.WriteString("=" + pieceTypeLetters[promotionTo.getPieceType()])
+	}
+
+	return b.String()
+}
+
+// getDisambiguation returns the file, rank or square of origin needed to tell
+// a piece move apart from moves by other pieces of the same type to the same
+// square.
+func (p Position) getDisambiguation(theMove move) string {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	thePieceType := p.board[from].getPieceType()
+
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range p.legalMoves {
+		otherFrom := other.getFromCoordinate()
+		if otherFrom == from || other.getToCoordinate() != to || p.board[otherFrom].getPieceType() != thePieceType {
+			continue
+		}
+
+		ambiguous = true
+		if otherFrom.getFileIndex() == from.getFileIndex() {
+			sameFile = true
+		}
+		if otherFrom.getRankIndex() == from.getRankIndex() {
+			sameRank = true
+		}
+	}
+
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return from.toString()[:1]
+	case !sameRank:
+		return from.toString()[1:]
+	default:
+		return from.toString()
+	}
+}
+
+// matchesSAN reports whether a move fits a move in standard algebraic notation.
+// Unlike a plain comparison with moveToSAN, this accepts redundant
+// disambiguation and a missing capture sign.
+func (p Position) matchesSAN(theMove move, san string) bool {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	thePieceType := p.board[from].getPieceType()
+
+	switch strings.ReplaceAll(san, "0", "O") {
+	case "O-O":
+		return thePieceType == king && int(to)-int(from) == 2
+	case "O-O-O":
+		return thePieceType == king && int(to)-int(from) == -2
+	}
+
+	sanPieceType := pawn
+	if len(san) > 0 {
+		for candidate, letter := range pieceTypeLetters {
+			if letter != "" && san[:1] == letter {
+				sanPieceType = pieceType(candidate)
+				san = san[1:]
+				break
+			}
+		}
+	}
+	if sanPieceType != thePieceType {
+		return false
+	}
+
+	sanPromotion := ""
+	if idx := strings.IndexByte(san, '='); idx >= 0 {
+		sanPromotion, san = san[idx+1:], san[:idx]
+	} else if len(san) > 0 && strings.Contains("QRBN", san[len(san)-1:]) {
+		sanPromotion, san = san[len(san)-1:], san[:len(san)-1]
+	}
+	if promotionTo := theMove.getPromotionTo(); promotionTo == empty {
+		if sanPromotion != "" {
+			return false
+		}
+	} else if sanPromotion != pieceTypeLetters[promotionTo.getPieceType()] {
+		return false
+	}
+
+	if len(san) < 2 || san[len(san)-2:] != to.toString() {
+		return false
+	}
+
+	// whatever remains before the destination narrows down the origin
+	fromString := from.toString()
+	for _, hint := range strings.ReplaceAll(san[:len(san)-2], "x", "") {
+		if !strings.ContainsRune(fromString, hint) {
+			return false
+		}
+	}
+	return true
+}
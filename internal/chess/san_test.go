@@ -0,0 +1,100 @@
+package chess
+
+import "testing"
+
+func TestSAN(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		moveString string
+		expected   string
+	}
+
+	testCases := []testCase{
+		{"pawn push", GetStartingFEN(), "e2e4", "e4"},
+		{"knight", GetStartingFEN(), "g1f3", "Nf3"},
+		{"castling king side", FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "w", "KQkq", "-", "0", "1"}, "e1g1", "O-O"},
+		{"castling queen side", FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "b", "KQkq", "-", "0", "1"}, "e8c8", "O-O-O"},
+		{"pawn capture", FEN{"4k3/8/8/3p4/4P3/8/8/4K3", "w", "-", "-", "0", "1"}, "e4d5", "exd5"},
+		{"en passant", FEN{"4k3/8/8/3pP3/8/8/8/4K3", "w", "-", "d6", "0", "1"}, "e5d6", "exd6"},
+		{"promotion with check", FEN{"4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1"}, "a7a8q", "a8=Q+"},
+		{"under promotion", FEN{"4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1"}, "a7a8n", "a8=N"},
+		{"file disambiguation", FEN{"4k3/8/8/8/8/8/8/R4RK1", "w", "-", "-", "0", "1"}, "a1d1", "Rad1"},
+		{"rank disambiguation", FEN{"R7/8/8/8/8/8/7k/R3K3", "w", "-", "-", "0", "1"}, "a1a4", "R1a4"},
+		{"square disambiguation", FEN{"4k3/8/8/8/8/Q1Q5/8/Q3K3", "w", "-", "-", "0", "1"}, "a3b2", "Qa3b2"},
+		{"mate", FEN{"6k1/5ppp/8/8/8/8/8/R5K1", "w", "-", "-", "0", "1"}, "a1a8", "Ra8#"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		if err := thePosition.LoadFEN(c.FEN); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := thePosition.SAN(c.moveString)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, result)
+		}
+
+		parsed, err := thePosition.ParseSAN(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != c.moveString {
+			t.Errorf("expected %s to parse back to %s, got %s", result, c.moveString, parsed)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestParseSAN(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		san           string
+		expected      string
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{"redundant disambiguation", GetStartingFEN(), "Ngf3", "g1f3", false},
+		{"missing capture sign", FEN{"4k3/8/8/3p4/4P3/8/8/4K3", "w", "-", "-", "0", "1"}, "ed5", "e4d5", false},
+		{"zeros for castling", FEN{"r3k2r/8/8/8/8/8/8/R3K2R", "w", "KQkq", "-", "0", "1"}, "0-0-0", "e1c1", false},
+		{"annotations", GetStartingFEN(), "e4!?", "e2e4", false},
+		{"promotion without equals", FEN{"4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1"}, "a8Q+", "a7a8q", false},
+		{"ambiguous", FEN{"4k3/8/8/8/8/8/8/R4RK1", "w", "-", "-", "0", "1"}, "Rd1", "", true},
+		{"illegal", GetStartingFEN(), "e5", "", true},
+		{"bishop is not a pawn", FEN{"4k3/8/8/8/8/8/8/1B2K3", "w", "-", "-", "0", "1"}, "bc2", "", true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		if err := thePosition.LoadFEN(c.FEN); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := thePosition.ParseSAN(c.san)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("expected error, got %s", result)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result != c.expected {
+			t.Errorf("expected %s, got %s", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
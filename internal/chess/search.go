@@ -0,0 +1,824 @@
+package chess
+
+import (
+	"context"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+var logger = logging.For("chess")
+
+const (
+	maxPly    = 128
+	infinity  = 32001
+	mateScore = 32000
+)
+
+// SearchParameters limit a search. Zero values mean no limit.
+type SearchParameters struct {
+	Depth       int
+	Nodes       uint64
+	SearchMoves []string
+	// MultiPV is the number of best lines to find, each starting with a
+	// different move. Zero finds one.
+	MultiPV int
+
+	// Tablebase, if set, is probed at the root and during the search once few
+	// enough pieces are left. Positions with as many pieces as the largest
+	// tables are only probed TBProbeDepth plies or more from the horizon.
+	Tablebase    *Tablebase
+	TBProbeDepth int
+	// TBIgnore50MoveRule scores cursed wins and blessed losses as wins and
+	// losses.
+	TBIgnore50MoveRule bool
+
+	// Evaluator scores the leaves of the search. If nil, the handcrafted
+	// evaluation is used.
+	Evaluator Evaluator
+
+	// TranspositionTable, if set, carries what the search learns over to
+	// later searches. If nil, the Searcher uses a table of its own, cleared
+	// before each search.
+	TranspositionTable *TranspositionTable
+	// DisabledFeatures turns parts of the selective search off.
+	DisabledFeatures SearchFeature
+
+	// Contempt is how much worse than an even position a draw is for the
+	// side to move at the root, in centipawns, and how much better for the
+	// other side. A negative contempt makes the search seek draws.
+	Contempt int
+}
+
+// SearchFeature is a way the search prunes, reduces or extends lines, which
+// can be turned off to measure what it is worth.
+type SearchFeature uint16
+
+const (
+	NullMovePruning SearchFeature = 1 << iota
+	LateMoveReductions
+	FutilityPruning
+	ReverseFutilityPruning
+	Razoring
+	LateMovePruning
+	CheckExtensions
+	SingularExtensions
+)
+
+// SearchFeatures lists every SearchFeature.
+var SearchFeatures = []SearchFeature{
+	NullMovePruning,
+	LateMoveReductions,
+	FutilityPruning,
+	ReverseFutilityPruning,
+	Razoring,
+	LateMovePruning,
+	CheckExtensions,
+	SingularExtensions,
+}
+
+var searchFeatureNames = map[SearchFeature]string{
+	NullMovePruning:        "NullMovePruning",
+	LateMoveReductions:     "LateMoveReductions",
+	FutilityPruning:        "FutilityPruning",
+	ReverseFutilityPruning: "ReverseFutilityPruning",
+	Razoring:               "Razoring",
+	LateMovePruning:        "LateMovePruning",
+	CheckExtensions:        "CheckExtensions",
+	SingularExtensions:     "SingularExtensions",
+}
+
+func (f SearchFeature) String() string {
+	return searchFeatureNames[f]
+}
+
+// defaultHashSize is the size in megabytes of the table a Searcher uses when
+// not given one.
+const defaultHashSize = 16
+
+// reductions holds the late move reductions by depth and move number, which
+// grow with the logarithm of each.
+var reductions [maxPly][64]int
+
+func initReductions() {
+	for depth := 1; depth < maxPly; depth++ {
+		for moveNumber := 1; moveNumber < 64; moveNumber++ {
+			reductions[depth][moveNumber] = int(0.75 + math.Log(float64(depth))*math.Log(float64(moveNumber))/2.25)
+		}
+	}
+}
+
+// ScoreBound says whether a reported score is exact or only a bound on the
+// true score.
+type ScoreBound uint8
+
+const (
+	ExactScore ScoreBound = iota
+	// LowerBound scores come from a move that did better than expected, and
+	// are about to be searched again with a wider window.
+	LowerBound
+	// UpperBound scores come from an iteration where every move did worse
+	// than expected.
+	UpperBound
+)
+
+// SearchInfo describes the outcome of one completed iteration of the search,
+// or of an attempt at one that fell outside its aspiration window, which Bound
+// tells apart. Score is in centipawns from the point of view of the side to
+// move. MateIn is non-zero when a forced mate has been found: positive if the
+// side to move mates, negative if it is mated.
+type SearchInfo struct {
+	Depth    int
+	SelDepth int
+	Score    int
+	MateIn   int
+	Bound    ScoreBound
+	// WDL is the expected outcome of the score, if it was asked for.
+	WDL *WinDrawLoss
+	// MultiPV numbers the line from 1, best first, when several are
+	// searched, and is zero otherwise.
+	MultiPV int
+	Nodes   uint64
+	Time    time.Duration
+	TBHits  uint64
+	PV      []string
+}
+
+// Searcher runs iterative deepening alpha-beta searches. A Searcher may be
+// reused across searches but must not run more than one at a time.
+type Searcher struct {
+	ctx       context.Context
+	params    SearchParameters
+	startTime time.Time
+	nodes     uint64
+	selDepth  int
+	stopped   bool
+
+	// rootColour is the side to move at the root, which contempt favours.
+	rootColour colour
+
+	tbCardinality int
+	tbHits        uint64
+	rootInTB      bool
+	tbRootScore   int
+
+	pvTable  [maxPly][maxPly]move
+	pvLength [maxPly]int
+	// previousPV is the principal variation of the last completed iteration,
+	// whose moves are tried first while the search follows it.
+	previousPV []move
+	// multiPV is the number of lines searched, and pvIndex the one being
+	// searched now.
+	multiPV int
+	pvIndex int
+
+	tt *TranspositionTable
+	// ownTT is the table used when the parameters do not give one.
+	ownTT *TranspositionTable
+
+	history *moveHistory
+	stack   [maxPly]searchStackEntry
+	// excluded holds, at each ply, the move a singular extension search
+	// leaves out.
+	excluded [maxPly]move
+}
+
+// Search searches the position until ctx is done or a limit in params is hit,
+// calling report after each completed iteration, and whenever an iteration
+// has to be searched again with a wider window. It returns the best move and
+// the expected reply in long algebraic notation; bestMove is empty if the
+// position has no legal moves.
+func (s *Searcher) Search(ctx context.Context, thePosition Position, params SearchParameters, report func(SearchInfo)) (bestMove, ponderMove string) {
+	s.ctx = ctx
+	s.params = params
+	s.startTime = time.Now()
+	s.nodes = 0
+	s.stopped = false
+	s.previousPV = s.previousPV[:0]
+	if s.history == nil {
+		s.history = new(moveHistory)
+	}
+	s.history.clear()
+	s.tt = params.TranspositionTable
+	if s.tt == nil {
+		if s.ownTT == nil {
+			s.ownTT = NewTranspositionTable(defaultHashSize)
+		}
+		s.ownTT.Clear()
+		s.tt = s.ownTT
+	}
+
+	p := thePosition.clone()
+	s.rootColour = p.activeColour
+	if network, ok := params.Evaluator.(*Network); ok {
+		p.accumulator = network.newAccumulator(&p)
+	}
+	rootMoves := p.legalMoves
+	if len(params.SearchMoves) > 0 {
+		rootMoves = rootMoves.restrictedTo(params.SearchMoves)
+	}
+	if len(rootMoves) == 0 {
+		logger.Debug("no moves to search", "fen", thePosition.GetFEN().String())
+		return "", ""
+	}
+	rootMoves = s.probeRoot(&p, rootMoves)
+	p.legalMoves = rootMoves
+
+	maxDepth := maxPly - 1
+	if params.Depth > 0 && params.Depth < maxDepth {
+		maxDepth = params.Depth
+	}
+
+	s.multiPV = min(max(params.MultiPV, 1), len(rootMoves))
+	lines := make([]rootLine, s.multiPV)
+	completedDepth := 0
+	for depth := 1; depth <= maxDepth && !s.stopped; depth++ {
+		for s.pvIndex = 0; s.pvIndex < s.multiPV; s.pvIndex++ {
+			line := &lines[s.pvIndex]
+			s.selDepth = 0
+			s.previousPV = append(s.previousPV[:0], line.pv...)
+			// each line leaves out the moves that start the lines before it
+			p.legalMoves = rootMoves.without(lines[:s.pvIndex])
+
+			score := s.aspirationSearch(&p, depth, line.score, line.pv, report)
+			if s.stopped && depth > 1 {
+				break
+			}
+
+			line.pv = append(line.pv[:0], s.pvTable[0][:s.pvLength[0]]...)
+			line.score = score
+			if report != nil {
+				report(s.getInfo(depth, score, line.pv))
+			}
+
+			if s.stopped {
+				break
+			}
+		}
+		if !s.stopped || depth == 1 {
+			completedDepth = depth
+		}
+	}
+
+	logger.Debug("search ended", "depth", completedDepth, "nodes", s.nodes, "stopped", s.stopped, "time", time.Since(s.startTime))
+
+	pv := lines[0].pv
+	if len(pv) == 0 {
+		return rootMoves[0].toString(), ""
+	}
+	bestMove = pv[0].toString()
+	if len(pv) > 1 {
+		ponderMove = pv[1].toString()
+	}
+	return bestMove, ponderMove
+}
+
+// rootLine is one of the best lines found by the search.
+type rootLine struct {
+	pv    []move
+	score int
+}
+
+// without returns the root moves other than those starting the lines.
+func (l moveList) without(lines []rootLine) moveList {
+	result := make(moveList, 0, len(l))
+	for _, theMove := range l {
+		if !slices.ContainsFunc(lines, func(line rootLine) bool { return len(line.pv) > 0 && line.pv[0] == theMove }) {
+			result = append(result, theMove)
+		}
+	}
+	return result
+}
+
+const (
+	// aspirationDepth is the first depth searched with a window around the
+	// score of the iteration before, rather than with a full window.
+	aspirationDepth = 4
+	// aspirationWindow is how far the first window reaches either side of
+	// the expected score, in centipawns.
+	aspirationWindow = 25
+)
+
+// aspirationSearch searches the root to depth with a narrow window around the
+// score of the previous iteration, widening the window on the side that
+// fails until the score falls within it. The PV of the previous iteration is
+// reported if a search fails low, as a failed low search has none.
+func (s *Searcher) aspirationSearch(p *Position, depth int, previousScore int, previousPV []move, report func(SearchInfo)) int {
+	alpha, beta := -infinity, infinity
+	window := aspirationWindow
+	if depth >= aspirationDepth {
+		alpha = max(previousScore-window, -infinity)
+		beta = min(previousScore+window, infinity)
+	}
+
+	for {
+		score := s.alphaBeta(p, depth, alpha, beta, 0)
+		if s.stopped {
+			return score
+		}
+
+		var info SearchInfo
+		switch {
+		case score <= alpha && alpha > -infinity:
+			info = s.getInfo(depth, score, previousPV)
+			info.Bound = UpperBound
+			// pull beta down too, as the score is likely to keep falling
+			beta = (alpha + beta) / 2
+			alpha = max(score-window, -infinity)
+		case score >= beta && beta < infinity:
+			info = s.getInfo(depth, score, s.pvTable[0][:s.pvLength[0]])
+			info.Bound = LowerBound
+			beta = min(score+window, infinity)
+		default:
+			return score
+		}
+
+		if report != nil {
+			report(info)
+		}
+		window += window / 2
+	}
+}
+
+// drawScore is the score of a drawn position for the side to move, which is
+// below zero for the side to move at the root when contempt is positive.
+func (s *Searcher) drawScore(p *Position) int {
+	if p.activeColour == s.rootColour {
+		return -s.params.Contempt
+	}
+	return s.params.Contempt
+}
+
+// evaluate returns the static evaluation of the position from the point of
+// view of the side to move.
+func (s *Searcher) evaluate(p *Position) int {
+	if s.params.Evaluator == nil {
+		return p.evaluate()
+	}
+
+	score := s.params.Evaluator.Evaluate(p)
+	if p.activeColour == black {
+		return -score
+	}
+	return score
+}
+
+func (s *Searcher) getInfo(depth int, score int, pv []move) SearchInfo {
+	info := SearchInfo{
+		Depth:    depth,
+		SelDepth: s.selDepth,
+		Score:    score,
+		Nodes:    s.nodes,
+		Time:     time.Since(s.startTime),
+		TBHits:   s.tbHits,
+		PV:       make([]string, len(pv)),
+	}
+	if s.multiPV > 1 {
+		info.MultiPV = s.pvIndex + 1
+	}
+
+	// the tables know better than the search, short of a mate
+	if s.rootInTB && score < mateScore-maxPly && score > -mateScore+maxPly {
+		score = s.tbRootScore
+		info.Score = score
+	}
+
+	if score > mateScore-maxPly {
+		info.MateIn = (mateScore - score + 1) / 2
+	} else if score < -mateScore+maxPly {
+		info.MateIn = -(mateScore + score) / 2
+	}
+
+	for idx, theMove := range pv {
+		info.PV[idx] = theMove.toString()
+	}
+
+	return info
+}
+
+func (s *Searcher) checkLimits() {
+	if s.params.Nodes > 0 && s.nodes >= s.params.Nodes {
+		s.stopped = true
+	}
+	if s.nodes%2048 == 0 && s.ctx.Err() != nil {
+		s.stopped = true
+	}
+}
+
+// Margins and depth limits of the selective search, in centipawns and plies.
+const (
+	reverseFutilityDepth   = 6
+	reverseFutilityMargin  = 80
+	razoringDepth          = 2
+	razoringMargin         = 250
+	futilityDepth          = 3
+	futilityMargin         = 120
+	lateMovePruningDepth   = 4
+	nullMoveDepth          = 3
+	nullMoveReduction      = 3
+	lateMoveReductionDepth = 3
+	singularDepth          = 8
+)
+
+// enabled reports whether a feature of the selective search is turned on.
+func (s *Searcher) enabled(feature SearchFeature) bool {
+	return s.params.DisabledFeatures&feature == 0
+}
+
+// lateMovePruningCount is how many quiet moves are searched at depth before
+// the rest are pruned.
+func lateMovePruningCount(depth int) int {
+	return 3 + depth*depth
+}
+
+func (s *Searcher) alphaBeta(p *Position, depth int, alpha, beta int, ply int) int {
+	s.pvLength[ply] = ply
+
+	inCheck := p.inCheck(p.activeColour)
+	if inCheck && ply > 0 && s.enabled(CheckExtensions) {
+		// this also keeps checks from dropping straight into quiescence
+		depth++
+	}
+
+	if depth <= 0 {
+		return s.quiescence(p, alpha, beta, ply)
+	}
+
+	s.nodes++
+	s.checkLimits()
+	if s.stopped {
+		return 0
+	}
+
+	if ply > 0 && (p.halfMoveClock >= 100 || p.repetitionCount() > 0 || p.hasInsufficientMaterial()) {
+		return s.drawScore(p)
+	}
+
+	if len(p.legalMoves) == 0 {
+		if inCheck {
+			return -mateScore + ply
+		}
+		return s.drawScore(p)
+	}
+
+	isPV := beta-alpha > 1
+	// excluded is the move left out by a singular extension search, which
+	// must neither use nor overwrite what the table knows of the position
+	excluded := s.excluded[ply]
+
+	ttEntry, ttHit := s.tt.probe(p.hash, ply)
+	if excluded != noMove {
+		ttHit = false
+	}
+	if ttHit && !isPV && ply > 0 && int(ttEntry.depth) >= depth {
+		score := int(ttEntry.score)
+		switch {
+		case ttEntry.bound == boundExact:
+			return min(max(score, alpha), beta)
+		case ttEntry.bound == boundLower && score >= beta:
+			return beta
+		case ttEntry.bound == boundUpper && score <= alpha:
+			return alpha
+		}
+	}
+
+	maxValue := infinity
+	probedTB := false
+	if ply > 0 && s.shouldProbe(p, depth) {
+		state := tbProbeOK
+		wdl := s.params.Tablebase.probeWDL(p, &state)
+		if state != tbProbeFailed {
+			s.tbHits++
+			probedTB = true
+
+			// cursed wins and blessed losses count as draws, just off zero
+			cursedScore := 1
+			if s.params.TBIgnore50MoveRule {
+				cursedScore = 0
+			}
+
+			switch {
+			case int(wdl) > cursedScore:
+				score := tbWinScore - ply
+				if score >= beta {
+					return beta
+				}
+				alpha = max(alpha, score)
+			case int(wdl) < -cursedScore:
+				score := -tbWinScore + ply
+				if score <= alpha {
+					return alpha
+				}
+				maxValue = score
+			case wdl == WDLDraw:
+				return min(max(s.drawScore(p), alpha), beta)
+			default:
+				return min(max(2*int(wdl)*cursedScore, alpha), beta)
+			}
+		}
+	}
+
+	if ply >= maxPly-1 {
+		return min(s.evaluate(p), maxValue)
+	}
+
+	staticEval := -infinity
+	if !inCheck {
+		staticEval = s.evaluate(p)
+	}
+	// nodes off the principal variation can be cut short on a guess, unless
+	// a mate is at stake
+	canPrune := !isPV && !inCheck && excluded == noMove && abs(beta) < minKnownWin
+
+	if canPrune && s.enabled(ReverseFutilityPruning) && depth <= reverseFutilityDepth &&
+		staticEval-reverseFutilityMargin*depth >= beta {
+		return beta
+	}
+
+	if canPrune && s.enabled(Razoring) && depth <= razoringDepth && staticEval+razoringMargin*depth <= alpha {
+		if score := s.quiescence(p, alpha, alpha+1, ply); score <= alpha {
+			return alpha
+		}
+	}
+
+	if canPrune && s.enabled(NullMovePruning) && depth >= nullMoveDepth && staticEval >= beta && s.canNullMove(p, ply) {
+		reduction := nullMoveReduction + depth/4
+		previousEPSquare := p.makeNullMove()
+		s.stack[ply] = searchStackEntry{noMove, empty}
+		score := -s.alphaBeta(p, depth-1-reduction, -beta, -beta+1, ply+1)
+		p.unmakeNullMove(previousEPSquare)
+
+		if s.stopped {
+			return 0
+		}
+		if score >= beta {
+			return beta
+		}
+	}
+
+	hashMove := s.hashMove(ply)
+	if hashMove == noMove && ttHit {
+		hashMove = ttEntry.move
+	}
+
+	// the table's move is extended if every other move falls well short of it
+	singular := false
+	if ply > 0 && excluded == noMove && s.enabled(SingularExtensions) && depth >= singularDepth &&
+		ttHit && ttEntry.move != noMove && ttEntry.bound != boundUpper && int(ttEntry.depth) >= depth-3 &&
+		abs(int(ttEntry.score)) < minKnownWin && p.legalMoves.contains(ttEntry.move) {
+		singularBeta := int(ttEntry.score) - 2*depth
+		s.excluded[ply] = ttEntry.move
+		score := s.alphaBeta(p, (depth-1)/2, singularBeta-1, singularBeta, ply)
+		s.excluded[ply] = noMove
+		s.pvLength[ply] = ply
+
+		if s.stopped {
+			return 0
+		}
+		singular = score < singularBeta
+	}
+
+	picker := newMovePicker(p, s.history, hashMove, ply, s.stack[:ply])
+	var quietsTried []move
+	bestMove := noMove
+	movesSearched := 0
+	for {
+		theMove, ok := picker.next()
+		if !ok {
+			break
+		}
+		if theMove == excluded {
+			continue
+		}
+
+		isQuiet := !p.isNoisy(theMove)
+		if isQuiet && !isPV && !inCheck && movesSearched > 0 && alpha > -minKnownWin && !p.givesCheck(theMove) {
+			if s.enabled(LateMovePruning) && depth <= lateMovePruningDepth && len(quietsTried) >= lateMovePruningCount(depth) {
+				continue
+			}
+			if s.enabled(FutilityPruning) && depth <= futilityDepth && staticEval+futilityMargin*depth <= alpha {
+				continue
+			}
+		}
+
+		newDepth := depth - 1
+		if singular && theMove == ttEntry.move {
+			newDepth++
+		}
+
+		s.makeMove(p, theMove, ply)
+		movesSearched++
+
+		reduction := 0
+		if s.enabled(LateMoveReductions) && depth >= lateMoveReductionDepth && movesSearched > 1 && isQuiet &&
+			!inCheck && !p.inCheck(p.activeColour) {
+			reduction = reductions[min(depth, maxPly-1)][min(movesSearched, 63)]
+			if isPV {
+				reduction--
+			}
+			reduction = min(max(reduction, 0), newDepth-1)
+		}
+
+		// the first move is expected to be best, so the others only need to
+		// be shown to be worse, with a zero window; one that turns out better
+		// is searched again, first to full depth if it was reduced and then
+		// with the full window
+		var score int
+		if movesSearched == 1 {
+			score = -s.alphaBeta(p, newDepth, -beta, -alpha, ply+1)
+		} else {
+			score = -s.alphaBeta(p, newDepth-reduction, -alpha-1, -alpha, ply+1)
+			if score > alpha && reduction > 0 {
+				score = -s.alphaBeta(p, newDepth, -alpha-1, -alpha, ply+1)
+			}
+			if score > alpha && score < beta {
+				score = -s.alphaBeta(p, newDepth, -beta, -alpha, ply+1)
+			}
+		}
+		p.unmakeMove(theMove)
+
+		if s.stopped {
+			return 0
+		}
+
+		if score > alpha {
+			alpha = score
+			bestMove = theMove
+			s.updatePV(ply, theMove)
+		}
+		if score >= beta {
+			if isQuiet {
+				s.history.updateQuietHistory(p, theMove, quietsTried, depth, ply, s.stack[:ply])
+			}
+			if excluded == noMove && !probedTB {
+				s.tt.store(p.hash, theMove, beta, depth, boundLower, ply)
+			}
+			return beta
+		}
+		if isQuiet {
+			quietsTried = append(quietsTried, theMove)
+		}
+	}
+
+	score := min(alpha, maxValue)
+	if excluded == noMove && !probedTB {
+		theBound := boundUpper
+		if bestMove != noMove {
+			theBound = boundExact
+		}
+		s.tt.store(p.hash, bestMove, score, depth, theBound, ply)
+	}
+	return score
+}
+
+// canNullMove reports whether the side to move may pass at ply. Two passes in
+// a row prove nothing, and without pieces other than pawns the side to move
+// is too likely to be in zugzwang for a pass to be a fair guess at its best.
+func (s *Searcher) canNullMove(p *Position, ply int) bool {
+	if ply > 0 && s.stack[ply-1].theMove == noMove {
+		return false
+	}
+	return nonPawnMaterial(p, p.activeColour) > 0
+}
+
+// probeRoot ranks the root moves with the tablebase, if there is one and the
+// position is in it, and keeps only the moves that preserve the result. The
+// search then probes the WDL tables only if the DTZ tables are missing and the
+// root is won, so as not to throw the win away.
+func (s *Searcher) probeRoot(p *Position, rootMoves moveList) moveList {
+	s.tbHits = 0
+	s.tbCardinality = 0
+	s.rootInTB = false
+
+	t := s.params.Tablebase
+	if t == nil {
+		return rootMoves
+	}
+	s.tbCardinality = t.MaxPieces()
+	if p.getOccupationBitBoard().count() > s.tbCardinality || p.castlingRights != 0 {
+		return rootMoves
+	}
+
+	ranked, dtzAvailable, ok := t.rankRootMoves(p, rootMoves, !s.params.TBIgnore50MoveRule)
+	if !ok {
+		return rootMoves
+	}
+
+	s.rootInTB = true
+	s.tbHits = uint64(len(ranked))
+	s.tbRootScore = ranked[0].score
+	if dtzAvailable || s.tbRootScore <= 0 {
+		s.tbCardinality = 0
+	}
+
+	var kept moveList
+	for _, rootMove := range ranked {
+		if rootMove.rank == ranked[0].rank {
+			kept = append(kept, rootMove.move)
+		}
+	}
+	logger.Debug("root position in tablebase", "moves", len(kept), "score", s.tbRootScore)
+	return kept
+}
+
+// shouldProbe reports whether to probe the WDL tables during the search. The
+// tables assume no castling rights and a fresh fifty move counter.
+func (s *Searcher) shouldProbe(p *Position, depth int) bool {
+	if s.tbCardinality == 0 || p.halfMoveClock != 0 || p.castlingRights != 0 {
+		return false
+	}
+	pieces := p.getOccupationBitBoard().count()
+	return pieces < s.tbCardinality || (pieces == s.tbCardinality && depth >= s.params.TBProbeDepth)
+}
+
+func (s *Searcher) quiescence(p *Position, alpha, beta int, ply int) int {
+	s.pvLength[ply] = ply
+	s.nodes++
+	s.checkLimits()
+	if s.stopped {
+		return 0
+	}
+
+	if ply > s.selDepth {
+		s.selDepth = ply
+	}
+
+	inCheck := p.inCheck(p.activeColour)
+	if len(p.legalMoves) == 0 {
+		if inCheck {
+			return -mateScore + ply
+		}
+		return s.drawScore(p)
+	}
+
+	if ply >= maxPly-1 {
+		return s.evaluate(p)
+	}
+
+	if !inCheck {
+		standPat := s.evaluate(p)
+		if standPat >= beta {
+			return beta
+		}
+		if standPat > alpha {
+			alpha = standPat
+		}
+	}
+
+	picker := newNoisyMovePicker(p)
+	if inCheck {
+		picker = newMovePicker(p, s.history, noMove, ply, s.stack[:ply])
+	}
+
+	for {
+		theMove, ok := picker.next()
+		if !ok {
+			break
+		}
+
+		s.makeMove(p, theMove, ply)
+		score := -s.quiescence(p, -beta, -alpha, ply+1)
+		p.unmakeMove(theMove)
+
+		if s.stopped {
+			return 0
+		}
+
+		if score >= beta {
+			return beta
+		}
+		if score > alpha {
+			alpha = score
+		}
+	}
+
+	return alpha
+}
+
+func (s *Searcher) updatePV(ply int, theMove move) {
+	s.pvTable[ply][ply] = theMove
+	copy(s.pvTable[ply][ply+1:], s.pvTable[ply+1][ply+1:s.pvLength[ply+1]])
+	s.pvLength[ply] = s.pvLength[ply+1]
+}
+
+// makeMove makes a move at ply, recording it on the search stack.
+func (s *Searcher) makeMove(p *Position, theMove move, ply int) {
+	p.makeMove(theMove)
+	s.stack[ply] = searchStackEntry{theMove, p.board[theMove.getToCoordinate()]}
+}
+
+// hashMove returns the move to try first at ply: the move the last iteration
+// played there, if the search has followed its principal variation so far.
+func (s *Searcher) hashMove(ply int) move {
+	if ply >= len(s.previousPV) {
+		return noMove
+	}
+	for idx := 0; idx < ply; idx++ {
+		if s.stack[idx].theMove != s.previousPV[idx] {
+			return noMove
+		}
+	}
+	return s.previousPV[ply]
+}
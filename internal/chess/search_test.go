@@ -0,0 +1,291 @@
+package chess
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	type testCase struct {
+		name string
+		FEN
+		params           SearchParameters
+		expectedBestMove string
+		expectedMateIn   int
+	}
+
+	testCases := []testCase{
+		{
+			"back rank mate",
+			FEN{"6k1/5ppp/8/8/8/8/8/R5K1", "w", "-", "-", "0", "1"},
+			SearchParameters{Depth: 2},
+			"a1a8",
+			1,
+		},
+		{
+			"mate in two",
+			FEN{"k7/8/2K5/8/8/8/8/1R6", "w", "-", "-", "0", "1"},
+			SearchParameters{Depth: 4},
+			"", // both Kb6 and Kc7 mate in two
+			2,
+		},
+		{
+			"wins hanging queen",
+			FEN{"4k3/8/8/3q4/8/8/8/3RK3", "w", "-", "-", "0", "1"},
+			SearchParameters{Depth: 2},
+			"d1d5",
+			0,
+		},
+		{
+			"restricted to search moves",
+			FEN{"4k3/8/8/3q4/8/8/8/3RK3", "w", "-", "-", "0", "1"},
+			SearchParameters{Depth: 2, SearchMoves: []string{"e1f2"}},
+			"e1f2",
+			0,
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := Position{}
+		thePosition.LoadFEN(c.FEN)
+
+		var lastInfo SearchInfo
+		searcher := Searcher{}
+		bestMove, _ := searcher.Search(context.Background(), thePosition, c.params, func(info SearchInfo) { lastInfo = info })
+
+		if c.expectedBestMove != "" && bestMove != c.expectedBestMove {
+			t.Errorf("expected best move %s, got %s", c.expectedBestMove, bestMove)
+		}
+		if lastInfo.MateIn != c.expectedMateIn {
+			t.Errorf("expected mate in %v, got %v", c.expectedMateIn, lastInfo.MateIn)
+		}
+		if lastInfo.Depth != c.params.Depth {
+			t.Errorf("expected search to reach depth %v, got %v", c.params.Depth, lastInfo.Depth)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSearchWithoutLegalMoves(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(FEN{"k7/2Q5/1K6/8/8/8/8/8", "b", "-", "-", "0", "1"})
+
+	searcher := Searcher{}
+	bestMove, ponderMove := searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 3}, nil)
+
+	if bestMove != "" || ponderMove != "" {
+		t.Errorf("expected no move in stalemate, got %s %s", bestMove, ponderMove)
+	}
+}
+
+func TestSearchStopsWhenCancelled(t *testing.T) {
+	thePosition := Position{}
+	thePosition.LoadFEN(GetStartingFEN())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	searcher := Searcher{}
+	bestMove, _ := searcher.Search(ctx, thePosition, SearchParameters{}, nil)
+
+	if bestMove == "" {
+		t.Error("expected a move even when the search is cancelled immediately")
+	}
+}
+
+func TestSearchFeatures(t *testing.T) {
+	type testCase struct {
+		name             string
+		fen              string
+		depth            int
+		expectedBestMove string
+	}
+
+	testCases := []testCase{
+		{"back rank mate", "6k1/5ppp/8/8/8/8/5PPP/R5K1 w - - 0 1", 5, "a1a8"},
+		{"wins hanging queen", "r3k3/ppp2ppp/8/3q4/8/2N5/PPP2PPP/4K2R w - - 0 1", 5, "c3d5"},
+		{"knight fork", "r3k3/8/8/3N4/8/8/8/4K3 w - - 0 1", 5, "d5c7"},
+		{"promotes with capture", "5r2/4P3/8/8/8/8/k7/4K3 w - - 0 1", 5, "e7f8q"},
+	}
+
+	checkCase := func(t *testing.T, c testCase, disabled SearchFeature) {
+		thePosition := loadPosition(t, c.fen)
+		searcher := Searcher{}
+		bestMove, _ := searcher.Search(context.Background(), thePosition, SearchParameters{Depth: c.depth, DisabledFeatures: disabled}, nil)
+		if bestMove != c.expectedBestMove {
+			t.Errorf("expected best move %s, got %s", c.expectedBestMove, bestMove)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			checkCase(t, c, 0)
+			for _, feature := range SearchFeatures {
+				t.Run("without "+feature.String(), func(t *testing.T) { checkCase(t, c, feature) })
+			}
+		})
+	}
+}
+
+func TestSelectiveSearchSavesNodes(t *testing.T) {
+	thePosition := loadPosition(t, "r1bq1rk1/ppp1nppp/4n3/3p3Q/3P4/1BP1B3/PP1N2PP/R4RK1 w - - 1 16")
+
+	nodes := func(disabled SearchFeature) uint64 {
+		var lastInfo SearchInfo
+		searcher := Searcher{}
+		searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 4, DisabledFeatures: disabled}, func(info SearchInfo) { lastInfo = info })
+		return lastInfo.Nodes
+	}
+
+	var allFeatures SearchFeature
+	for _, feature := range SearchFeatures {
+		allFeatures |= feature
+	}
+	if selective, full := nodes(0), nodes(allFeatures); selective >= full {
+		t.Errorf("expected fewer nodes than %v with the selective search, got %v", full, selective)
+	}
+}
+
+func TestCanNullMove(t *testing.T) {
+	type testCase struct {
+		name          string
+		fen           string
+		afterNullMove bool
+		expected      bool
+	}
+
+	testCases := []testCase{
+		{"pieces", "4k3/8/8/8/8/8/4P3/3NK3 w - - 0 1", false, true},
+		{"only pawns", "4k3/8/8/8/8/8/4P3/3nK3 w - - 0 1", false, false},
+		{"after a null move", "4k3/8/8/8/8/8/4P3/3NK3 w - - 0 1", true, false},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		searcher := Searcher{}
+		searcher.stack[0] = searchStackEntry{moveFromParts(e2, e4, empty, empty, 0, nullCoordinate), whitePawn}
+		if c.afterNullMove {
+			searcher.stack[0] = searchStackEntry{noMove, empty}
+		}
+		if result := searcher.canNullMove(&thePosition, 1); result != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestReductions(t *testing.T) {
+	for depth := 1; depth < maxPly; depth++ {
+		for moveNumber := 1; moveNumber < 64; moveNumber++ {
+			reduction := reductions[depth][moveNumber]
+			if reduction < 0 || reduction >= max(depth, 1)+1 {
+				t.Fatalf("depth %v, move %v: reduction %v out of range", depth, moveNumber, reduction)
+			}
+			if depth > 1 && reduction < reductions[depth-1][moveNumber] || moveNumber > 1 && reduction < reductions[depth][moveNumber-1] {
+				t.Fatalf("depth %v, move %v: expected reductions to grow with depth and move number", depth, moveNumber)
+			}
+		}
+	}
+}
+
+func TestAspirationWindows(t *testing.T) {
+	// the score swings enough between iterations to fall outside the window
+	// both ways
+	thePosition := loadPosition(t, "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1")
+
+	var infos []SearchInfo
+	searcher := Searcher{}
+	searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 6}, func(info SearchInfo) { infos = append(infos, info) })
+
+	seen := map[ScoreBound]bool{}
+	for idx, info := range infos {
+		seen[info.Bound] = true
+		if info.Bound == ExactScore {
+			continue
+		}
+		if idx == len(infos)-1 {
+			t.Fatal("expected the search to end with an exact score")
+		}
+		// the bound holds for the score the iteration settles on
+		next := infos[idx+1]
+		if next.Depth != info.Depth {
+			t.Errorf("expected depth %v to be searched again, got %v", info.Depth, next.Depth)
+		}
+		if info.Bound == LowerBound && next.Score < info.Score || info.Bound == UpperBound && next.Score > info.Score {
+			t.Errorf("depth %v: expected %v to bound %v", info.Depth, info.Score, next.Score)
+		}
+	}
+	if !seen[LowerBound] || !seen[UpperBound] {
+		t.Errorf("expected both lower and upper bounds, got %v", seen)
+	}
+}
+
+func TestMultiPV(t *testing.T) {
+	thePosition := loadPosition(t, "4k3/8/8/2RqR3/8/2N5/8/4K3 w - - 0 1")
+
+	lines := map[int]SearchInfo{}
+	searcher := Searcher{}
+	bestMove, _ := searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 4, MultiPV: 4}, func(info SearchInfo) {
+		if info.Bound == ExactScore && info.Depth == 4 {
+			lines[info.MultiPV] = info
+		}
+	})
+
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 lines, got %v", len(lines))
+	}
+	if bestMove != lines[1].PV[0] {
+		t.Errorf("expected the best move to start the first line, got %s and %s", bestMove, lines[1].PV[0])
+	}
+
+	firstMoves := map[string]bool{}
+	for multiPV := 1; multiPV <= 4; multiPV++ {
+		firstMoves[lines[multiPV].PV[0]] = true
+	}
+	if len(firstMoves) != 4 {
+		t.Errorf("expected every line to start with a different move, got %v", firstMoves)
+	}
+}
+
+func TestContempt(t *testing.T) {
+	type testCase struct {
+		name     string
+		fen      string
+		contempt int
+		expected int
+	}
+
+	// lone kings are drawn whatever either side plays
+	testCases := []testCase{
+		{"no contempt", "8/8/8/4k3/8/8/8/4K3 w - - 0 1", 0, 0},
+		{"avoiding draws as white", "8/8/8/4k3/8/8/8/4K3 w - - 0 1", 20, -20},
+		{"avoiding draws as black", "8/8/8/4k3/8/8/8/4K3 b - - 0 1", 20, -20},
+		{"seeking draws", "8/8/8/4k3/8/8/8/4K3 w - - 0 1", -20, 20},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+
+		var score int
+		searcher := Searcher{}
+		searcher.Search(context.Background(), thePosition, SearchParameters{Depth: 3, Contempt: c.contempt}, func(info SearchInfo) {
+			if info.Bound == ExactScore {
+				score = info.Score
+			}
+		})
+
+		if score != c.expected {
+			t.Errorf("expected %d, got %d", c.expected, score)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
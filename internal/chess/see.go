@@ -0,0 +1,173 @@
+package chess
+
+// seeKingValue is what the king counts for in an exchange: more than all the
+// other pieces together, so that capturing it ends the exchange.
+const seeKingValue = 20000
+
+func seeValue(thePieceType pieceType) int {
+	if thePieceType == king {
+		return seeKingValue
+	}
+	return pieceValues[thePieceType].midgame
+}
+
+// attackersTo returns the pieces of both colours that attack a square, when
+// only the squares in occupancy are occupied. Pieces outside occupancy are
+// left out, and sliders see through them.
+func (p *Position) attackersTo(theCoord coordinate, occupancy bitBoard) bitBoard {
+	pieces := p.occupationByPieceType
+	rooksAndQueens := pieces[rook] | pieces[queen]
+	bishopsAndQueens := pieces[bishop] | pieces[queen]
+
+	attackers := kingControlFrom[theCoord] & pieces[king]
+	attackers |= knightControlFrom[theCoord] & pieces[knight]
+	// a white pawn attacks the square if a black pawn there would attack it
+	attackers |= pawnControlFrom[black][theCoord] & pieces[pawn] & p.occupationByColour[white]
+	attackers |= pawnControlFrom[white][theCoord] & pieces[pawn] & p.occupationByColour[black]
+	attackers |= rookControlFrom(theCoord, occupancy) & rooksAndQueens
+	attackers |= bishopControlFrom(theCoord, occupancy) & bishopsAndQueens
+	return attackers & occupancy
+}
+
+// SEE returns the material the side to move can expect to win, in
+// centipawns, by playing a move given in long algebraic notation and then
+// resolving the exchange of captures on its destination, where either side
+// may stop capturing whenever it would lose by going on. Pins and checks are
+// ignored. It returns 0 for a move that is not legal.
+func (p Position) SEE(moveString string) int {
+	theMove, err := p.findLegalMove(moveString)
+	if err != nil {
+		return 0
+	}
+	return p.see(theMove)
+}
+
+// SEEGreaterOrEqual reports whether SEE for a move is at least threshold. It
+// is false for a move that is not legal.
+func (p Position) SEEGreaterOrEqual(moveString string, threshold int) bool {
+	theMove, err := p.findLegalMove(moveString)
+	if err != nil {
+		return false
+	}
+	return p.seeGreaterOrEqual(theMove, threshold)
+}
+
+// maxExchangeLength bounds the number of captures in an exchange, as there are
+// only so many pieces that can attack one square.
+const maxExchangeLength = 32
+
+// firstCapture returns the material won by a move, the value of the piece it
+// leaves on its destination and the squares still occupied once it is made.
+func (p *Position) firstCapture(theMove move) (gain, onSquare int, occupancy bitBoard) {
+	from, to := theMove.getFromCoordinate(), theMove.getToCoordinate()
+	mover := p.board[from].getPieceType()
+	occupancy = p.getOccupationBitBoard() &^ (1 << from)
+
+	if victim := theMove.getCapturedPiece(); victim != empty {
+		gain = seeValue(victim.getPieceType())
+	} else if mover == pawn && to == theMove.getCurrentEPSquare() {
+		gain = seeValue(pawn)
+		occupancy &^= 1 << p.enPassantVictim(to)
+	}
+
+	onSquare = seeValue(mover)
+	if promotion := theMove.getPromotionTo(); promotion != empty {
+		onSquare = seeValue(promotion.getPieceType())
+		gain += onSquare - seeValue(pawn)
+	}
+	return gain, onSquare, occupancy
+}
+
+// exchange plays out the captures on the destination of a move, each side
+// taking with its least valuable attacker, and returns the balance after each
+// capture from the point of view of the side making it, had the captures
+// stopped there.
+func (p *Position) exchange(theMove move) ([maxExchangeLength]int, int) {
+	var gains [maxExchangeLength]int
+	to := theMove.getToCoordinate()
+	gain, onSquare, occupancy := p.firstCapture(theMove)
+	gains[0] = gain
+
+	side := p.activeColour.getOpponent()
+	length := 1
+	for ; length < maxExchangeLength; length++ {
+		attackers := p.attackersTo(to, occupancy)
+		ownAttackers := attackers & p.occupationByColour[side]
+		if ownAttackers == 0 {
+			break
+		}
+
+		attacker, attackerType := p.leastValuableAttacker(ownAttackers)
+		if attackerType == king && attackers&p.occupationByColour[side.getOpponent()] != 0 {
+			// the king cannot capture into check
+			break
+		}
+
+		gains[length] = onSquare - gains[length-1]
+		onSquare = seeValue(attackerType)
+		if attackerType == pawn && isPromotionRank(to) {
+			gains[length] += seeValue(queen) - seeValue(pawn)
+			onSquare = seeValue(queen)
+		}
+
+		occupancy &^= 1 << attacker
+		side = side.getOpponent()
+	}
+
+	return gains, length
+}
+
+func isPromotionRank(theCoord coordinate) bool {
+	return theCoord.getRankIndex() == 0 || theCoord.getRankIndex() == 7
+}
+
+// enPassantVictim returns the square of the pawn captured by an en passant
+// capture onto the given square.
+func (p *Position) enPassantVictim(to coordinate) coordinate {
+	if p.activeColour == white {
+		return to - 8
+	}
+	return to + 8
+}
+
+// leastValuableAttacker returns the square and type of the cheapest of the
+// attackers, which must not be empty.
+func (p *Position) leastValuableAttacker(attackers bitBoard) (coordinate, pieceType) {
+	thePieceType := pawn
+	for ; thePieceType > king; thePieceType-- {
+		if attackers&p.occupationByPieceType[thePieceType] != 0 {
+			break
+		}
+	}
+	candidates := attackers & p.occupationByPieceType[thePieceType]
+	theCoord, _ := candidates.pop()
+	return theCoord, thePieceType
+}
+
+func (p *Position) see(theMove move) int {
+	gains, length := p.exchange(theMove)
+	// each side chooses between stopping and letting the exchange go on
+	for idx := length - 1; idx > 0; idx-- {
+		gains[idx-1] = -max(-gains[idx-1], gains[idx])
+	}
+	return gains[0]
+}
+
+// seeGreaterOrEqual settles most moves from the first capture and the reply
+// to it, before resorting to the whole exchange.
+func (p *Position) seeGreaterOrEqual(theMove move, threshold int) bool {
+	gain, onSquare, _ := p.firstCapture(theMove)
+	// the opponent can always stop at once
+	if gain < threshold {
+		return false
+	}
+	// and the side to move can always stop after the reply
+	worstReply := onSquare
+	if isPromotionRank(theMove.getToCoordinate()) {
+		worstReply += seeValue(queen) - seeValue(pawn)
+	}
+	if gain-worstReply >= threshold {
+		return true
+	}
+	return p.see(theMove) >= threshold
+}
@@ -0,0 +1,127 @@
+package chess
+
+import "testing"
+
+func TestSEE(t *testing.T) {
+	type testCase struct {
+		name       string
+		fen        string
+		moveString string
+		expected   int
+	}
+
+	p, n, b := pieceValues[pawn].midgame, pieceValues[knight].midgame, pieceValues[bishop].midgame
+	r, q := pieceValues[rook].midgame, pieceValues[queen].midgame
+
+	testCases := []testCase{
+		{"undefended pawn", "4k3/8/8/3p4/8/8/8/3RK3 w - - 0 1", "d1d5", p},
+		{"defended pawn", "4k3/8/2p5/3p4/8/8/8/3RK3 w - - 0 1", "d1d5", p - r},
+		{"pawn takes defended knight", "4k3/2p5/3n4/4P3/8/8/8/4K3 w - - 0 1", "e5d6", n - p},
+		{"rook behind rook", "4k3/3r4/8/3p4/8/8/3R4/3RK3 w - - 0 1", "d2d5", p},
+		{"bishop behind pawn", "4k3/8/2n5/1p6/B7/8/8/4K3 w - - 0 1", "a4b5", p},
+		{"queen behind bishop", "4k3/8/8/3p4/4p3/5B2/6Q1/4K3 w - - 0 1", "f3e4", 2*p - b},
+		{"king cannot recapture", "4k3/3p4/8/8/8/8/3R4/3RK3 w - - 0 1", "d2d7", p},
+		{"king recaptures", "4k3/3p4/8/8/8/8/8/3RK3 w - - 0 1", "d1d7", p - r},
+		{"en passant", "4k3/8/8/2pP4/8/8/8/4K3 w - c6 0 1", "d5c6", p},
+		{"defended en passant", "4k3/1p6/8/2pP4/8/8/8/4K3 w - c6 0 1", "d5c6", 0},
+		{"promotion", "4k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7a8q", q - p},
+		{"defended promotion", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7a8q", -p},
+		{"promotion with capture", "1r2k3/P7/8/8/8/8/8/4K3 w - - 0 1", "a7b8q", r + q - p},
+		{"recapture with promotion", "4k3/8/8/8/8/8/1p6/R3K3 b - - 0 1", "b2a1q", r + q - p},
+		{"quiet move to attacked square", "4k3/8/8/4p3/8/8/8/3RK3 w - - 0 1", "d1d4", -r},
+		{"quiet move", "4k3/8/8/8/8/8/8/3RK3 w - - 0 1", "d1d4", 0},
+		{"black to move", "4k3/8/8/3r4/4P3/8/8/4K3 b - - 0 1", "d5d4", 0},
+		{"illegal move", "4k3/8/8/3p4/8/8/8/3RK3 w - - 0 1", "d1d6", 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		if score := thePosition.SEE(c.moveString); score != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, score)
+		}
+
+		for _, threshold := range []int{c.expected - 1, c.expected, c.expected + 1} {
+			expected := c.expected >= threshold && c.name != "illegal move"
+			if result := thePosition.SEEGreaterOrEqual(c.moveString, threshold); result != expected {
+				t.Errorf("threshold %v: expected %v, got %v", threshold, expected, result)
+			}
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestAttackersTo(t *testing.T) {
+	type testCase struct {
+		name     string
+		fen      string
+		square   coordinate
+		removed  []coordinate
+		expected []coordinate
+	}
+
+	testCases := []testCase{
+		{"pawns, knights and kings", "4k3/8/4p3/1N1n4/2P1K3/8/8/8 w - - 0 1", d5, nil, []coordinate{c4, e4, e6}},
+		{"knight", "4k3/8/4p3/1N1n4/2P1K3/8/8/8 w - - 0 1", c7, nil, []coordinate{b5, d5}},
+		{"sliders", "4k3/3r4/8/3p4/8/8/3R4/3RK3 w - - 0 1", d5, nil, []coordinate{d2, d7}},
+		{"x-ray", "4k3/3r4/8/3p4/8/8/3R4/3RK3 w - - 0 1", d5, []coordinate{d2}, []coordinate{d1, d7}},
+		{"removed attacker", "4k3/3r4/8/3p4/8/8/3R4/3RK3 w - - 0 1", d5, []coordinate{d7}, []coordinate{d2}},
+		{"diagonals", "4k3/8/8/3p4/4p3/5B2/6Q1/4K3 w - - 0 1", e4, []coordinate{f3}, []coordinate{d5, g2}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		occupancy := thePosition.getOccupationBitBoard()
+		for _, theCoord := range c.removed {
+			occupancy.turnOff(theCoord)
+		}
+
+		var expected bitBoard
+		for _, theCoord := range c.expected {
+			expected.turnOn(theCoord)
+		}
+		if attackers := thePosition.attackersTo(c.square, occupancy); attackers != expected {
+			t.Errorf("expected %x, got %x", expected, attackers)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSlidingControlFrom(t *testing.T) {
+	thePosition := loadPosition(t, "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	occupancy := thePosition.getOccupationBitBoard()
+
+	// the control worked out while generating moves is the reference
+	for player := white; player <= black; player++ {
+		var control bitBoard
+		pieces := thePosition.occupationByColour[player]
+		for {
+			theCoord, ok := pieces.pop()
+			if !ok {
+				break
+			}
+			switch thePosition.board[theCoord].getPieceType() {
+			case king:
+				control |= kingControlFrom[theCoord]
+			case queen:
+				control |= rookControlFrom(theCoord, occupancy) | bishopControlFrom(theCoord, occupancy)
+			case rook:
+				control |= rookControlFrom(theCoord, occupancy)
+			case bishop:
+				control |= bishopControlFrom(theCoord, occupancy)
+			case knight:
+				control |= knightControlFrom[theCoord]
+			case pawn:
+				control |= pawnControlFrom[player][theCoord]
+			}
+		}
+		if control != thePosition.controlByColour[player] {
+			t.Errorf("colour %v: expected %x, got %x", player, thePosition.controlByColour[player], control)
+		}
+	}
+}
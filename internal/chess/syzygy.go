@@ -0,0 +1,821 @@
+package chess
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// The Syzygy format is documented only by its reference prober. The layout of
+// the files and the position indexing below follow that prober closely, so
+// that the two can be compared line by line.
+
+const (
+	tbMaxPieces = 7
+
+	tbSuffixWDL = ".rtbw"
+	tbSuffixDTZ = ".rtbz"
+)
+
+var (
+	tbMagicWDL = [4]byte{0x71, 0xe8, 0x23, 0x5d}
+	tbMagicDTZ = [4]byte{0xd7, 0x66, 0x0c, 0xa5}
+)
+
+// flags in the first byte of a table
+const (
+	tbHeaderSplit    = 1
+	tbHeaderHasPawns = 2
+)
+
+// flags of the pairs data for one side and file
+const (
+	tbFlagSideToMove  = 1
+	tbFlagMapped      = 2
+	tbFlagWinPlies    = 4
+	tbFlagLossPlies   = 8
+	tbFlagWide        = 16
+	tbFlagSingleValue = 128
+)
+
+// Tables for the position index, built once at start up.
+var (
+	tbBinomial      [6][64]int
+	tbMapB1H1H7     [64]int
+	tbMapA1D1D4     [64]int
+	tbMapKK         [10][64]int
+	tbMapPawns      [64]int
+	tbLeadPawnIdx   [6][64]int
+	tbLeadPawnsSize [6][4]int
+)
+
+func offA1H8(square int) int {
+	return square>>3 - square&7
+}
+
+func initSyzygyTables() {
+	code := 0
+	for square := 0; square < 64; square++ {
+		if offA1H8(square) < 0 {
+			tbMapB1H1H7[square] = code
+			code++
+		}
+	}
+
+	// squares of the a1-d1-d4 triangle below the diagonal come first, then
+	// those on the diagonal
+	var diagonal []int
+	code = 0
+	for square := 0; square <= 27; square++ {
+		if offA1H8(square) < 0 && square&7 <= 3 {
+			tbMapA1D1D4[square] = code
+			code++
+		} else if offA1H8(square) == 0 && square&7 <= 3 {
+			diagonal = append(diagonal, square)
+		}
+	}
+	for _, square := range diagonal {
+		tbMapA1D1D4[square] = code
+		code++
+	}
+
+	// the 462 placements of two kings with the first in the a1-d1-d4 triangle,
+	// where the second is not above the diagonal if the first is on it
+	type placement struct{ idx, square int }
+	var bothOnDiagonal []placement
+	code = 0
+	for idx := 0; idx < 10; idx++ {
+		for first := 0; first <= 27; first++ {
+			if tbMapA1D1D4[first] != idx || (idx == 0 && first != int(b1)) {
+				continue
+			}
+			for second := 0; second < 64; second++ {
+				switch {
+				case first == second || kingControlFrom[first].get(coordinate(second)):
+					continue
+				case offA1H8(first) == 0 && offA1H8(second) > 0:
+					continue
+				case offA1H8(first) == 0 && offA1H8(second) == 0:
+					bothOnDiagonal = append(bothOnDiagonal, placement{idx, second})
+				default:
+					tbMapKK[idx][second] = code
+					code++
+				}
+			}
+		}
+	}
+	for _, p := range bothOnDiagonal {
+		tbMapKK[p.idx][p.square] = code
+		code++
+	}
+
+	tbBinomial[0][0] = 1
+	for n := 1; n < 64; n++ {
+		for k := 0; k < 6 && k <= n; k++ {
+			if k > 0 {
+				tbBinomial[k][n] += tbBinomial[k-1][n-1]
+			}
+			if k < n {
+				tbBinomial[k][n] += tbBinomial[k][n-1]
+			}
+		}
+	}
+
+	// the leading pawn is the one nearest the edge, and then the one on the
+	// lowest rank, which is the pawn with the highest tbMapPawns
+	availableSquares := 47
+	for leadPawnsCount := 1; leadPawnsCount <= 5; leadPawnsCount++ {
+		for file := 0; file <= 3; file++ {
+			idx := 0
+			for rank := 1; rank <= 6; rank++ {
+				square := 8*rank + file
+				if leadPawnsCount == 1 {
+					tbMapPawns[square] = availableSquares
+					availableSquares--
+					tbMapPawns[square^7] = availableSquares
+					availableSquares--
+				}
+				tbLeadPawnIdx[leadPawnsCount][square] = idx
+				idx += tbBinomial[leadPawnsCount-1][tbMapPawns[square]]
+			}
+			tbLeadPawnsSize[leadPawnsCount][file] = idx
+		}
+	}
+}
+
+// pairsData describes the compressed values for one side to move and, in
+// tables with pawns, one file of the leading pawn.
+type pairsData struct {
+	flags  byte
+	pieces [tbMaxPieces]byte
+
+	groupLen [tbMaxPieces + 1]int
+	groupIdx [tbMaxPieces + 1]uint64
+
+	sizeofBlock     uint64
+	span            uint64
+	sparseIndexSize uint64
+	blocksNum       uint64
+	blockLengthSize uint64
+	minSymLen       int
+	maxSymLen       int
+	lowestSym       []uint16
+	base64          []uint64
+	symlen          []int
+	btree           []byte
+
+	sparseIndex []byte
+	blockLength []uint16
+	dataOffset  int64
+
+	// mapIdx locates the DTZ values for each kind of result in the map of
+	// the table
+	mapIdx [4]int
+}
+
+// singleValue is the value of every position, for tables that store only one.
+func (d *pairsData) singleValue() int {
+	return d.minSymLen
+}
+
+func (d *pairsData) left(sym int) int {
+	return int(d.btree[3*sym+1]&0xf)<<8 | int(d.btree[3*sym])
+}
+
+func (d *pairsData) right(sym int) int {
+	return int(d.btree[3*sym+2])<<4 | int(d.btree[3*sym+1]>>4)
+}
+
+// decompress finds the value stored at idx. Values are Huffman coded symbols,
+// each of which expands into a sequence of values by recursive pairing.
+func (d *pairsData) decompress(f io.ReaderAt, idx uint64) (int, error) {
+	if d.flags&tbFlagSingleValue != 0 {
+		return d.singleValue(), nil
+	}
+
+	// the sparse index holds the block and offset of every span-th value, from
+	// which the block holding idx is found by walking the block lengths
+	k := idx / d.span
+	if k >= d.sparseIndexSize {
+		return 0, errors.New("index out of range")
+	}
+	block := int(binary.LittleEndian.Uint32(d.sparseIndex[6*k:]))
+	offset := int(binary.LittleEndian.Uint16(d.sparseIndex[6*k+4:]))
+	offset += int(idx%d.span) - int(d.span/2)
+
+	for offset < 0 {
+		block--
+		if block < 0 {
+			return 0, errors.New("corrupt sparse index")
+		}
+		offset += int(d.blockLength[block]) + 1
+	}
+	for block < len(d.blockLength) && offset > int(d.blockLength[block]) {
+		offset -= int(d.blockLength[block]) + 1
+		block++
+	}
+	if block >= len(d.blockLength) || uint64(block) >= d.blocksNum {
+		return 0, errors.New("corrupt sparse index")
+	}
+
+	data := make([]byte, d.sizeofBlock)
+	if _, err := f.ReadAt(data, d.dataOffset+int64(block)*int64(d.sizeofBlock)); err != nil {
+		return 0, err
+	}
+	readUint32 := func(at int) uint64 {
+		if at+4 > len(data) {
+			return 0
+		}
+		return uint64(binary.BigEndian.Uint32(data[at:]))
+	}
+
+	buf64 := readUint32(0)<<32 | readUint32(4)
+	next := 8
+	buf64Size := 64
+
+	var sym int
+	for {
+		length := 0
+		for length < len(d.base64)-1 && buf64 < d.base64[length] {
+			length++
+		}
+
+		sym = int((buf64-d.base64[length])>>(64-length-d.minSymLen)) + int(d.lowestSym[length])
+		if sym >= len(d.symlen) {
+			return 0, errors.New("corrupt block")
+		}
+		if offset < d.symlen[sym]+1 {
+			break
+		}
+
+		offset -= d.symlen[sym] + 1
+		length += d.minSymLen
+		buf64 <<= length
+		buf64Size -= length
+		if buf64Size <= 32 {
+			buf64Size += 32
+			buf64 |= readUint32(next) << (64 - buf64Size)
+			next += 4
+		}
+	}
+
+	// the symbol expands into symlen+1 values: descend into whichever half
+	// holds the one wanted
+	for d.symlen[sym] != 0 {
+		left := d.left(sym)
+		if offset < d.symlen[left]+1 {
+			sym = left
+		} else {
+			offset -= d.symlen[left] + 1
+			sym = d.right(sym)
+		}
+	}
+
+	return d.left(sym), nil
+}
+
+// tbTable is one WDL or DTZ file, loaded when first probed.
+type tbTable struct {
+	path string
+	dtz  bool
+
+	key             materialKey
+	key2            materialKey
+	pieceCount      int
+	hasPawns        bool
+	hasUniquePieces bool
+	pawnCount       [2]int
+
+	once  sync.Once
+	err   error
+	file  *os.File
+	items [2][4]*pairsData
+	sides int
+	// dtzMap translates the stored values of DTZ tables
+	dtzMap []byte
+}
+
+func (t *tbTable) get(stm, file int) *pairsData {
+	if !t.hasPawns {
+		file = 0
+	}
+	return t.items[stm%t.sides][file]
+}
+
+func (t *tbTable) load() error {
+	t.once.Do(func() {
+		t.err = t.open()
+		if t.err != nil {
+			logger.Warn("cannot load tablebase", "path", t.path, "error", t.err)
+		}
+	})
+	return t.err
+}
+
+func (t *tbTable) open() error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if info.Size()%64 != 16 {
+		f.Close()
+		return fmt.Errorf("corrupt tablebase %s", t.path)
+	}
+
+	if err := t.parse(&tbReader{r: f}); err != nil {
+		f.Close()
+		return fmt.Errorf("corrupt tablebase %s: %w", t.path, err)
+	}
+	t.file = f
+	return nil
+}
+
+// tbReader reads the little endian header of a table, remembering the first
+// error.
+type tbReader struct {
+	r   io.ReaderAt
+	pos int64
+	err error
+}
+
+func (r *tbReader) bytes(n int) []byte {
+	b := make([]byte, n)
+	if r.err == nil && n > 0 {
+		_, r.err = r.r.ReadAt(b, r.pos)
+	}
+	r.pos += int64(n)
+	return b
+}
+
+func (r *tbReader) byte() byte {
+	return r.bytes(1)[0]
+}
+
+func (r *tbReader) uint16() uint16 {
+	return binary.LittleEndian.Uint16(r.bytes(2))
+}
+
+func (r *tbReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *tbReader) align(n int64) {
+	r.pos = (r.pos + n - 1) &^ (n - 1)
+}
+
+func (t *tbTable) parse(r *tbReader) error {
+	magic := tbMagicWDL
+	if t.dtz {
+		magic = tbMagicDTZ
+	}
+	if [4]byte(r.bytes(4)) != magic {
+		return errors.New("bad magic number")
+	}
+
+	header := r.byte()
+	if (header&tbHeaderHasPawns != 0) != t.hasPawns {
+		return errors.New("unexpected pawns")
+	}
+	t.sides = 1
+	if !t.dtz && header&tbHeaderSplit != 0 {
+		t.sides = 2
+	}
+
+	maxFile := 0
+	if t.hasPawns {
+		maxFile = 3
+	}
+	pawnsOnBothSides := t.hasPawns && t.pawnCount[1] > 0
+
+	for file := 0; file <= maxFile; file++ {
+		var order [2][2]int
+		orderByte := r.byte()
+		order[0] = [2]int{int(orderByte & 0xf), 0xf}
+		order[1] = [2]int{int(orderByte >> 4), 0xf}
+		if pawnsOnBothSides {
+			orderByte = r.byte()
+			order[0][1] = int(orderByte & 0xf)
+			order[1][1] = int(orderByte >> 4)
+		}
+
+		for side := 0; side < t.sides; side++ {
+			t.items[side][file] = &pairsData{}
+		}
+		for k := 0; k < t.pieceCount; k++ {
+			pieceByte := r.byte()
+			for side := 0; side < t.sides; side++ {
+				if side == 0 {
+					t.items[side][file].pieces[k] = pieceByte & 0xf
+				} else {
+					t.items[side][file].pieces[k] = pieceByte >> 4
+				}
+			}
+		}
+
+		for side := 0; side < t.sides; side++ {
+			t.setGroups(t.items[side][file], order[side], file)
+		}
+	}
+	r.align(2)
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < t.sides; side++ {
+			if err := t.items[side][file].setSizes(r); err != nil {
+				return err
+			}
+		}
+	}
+
+	if t.dtz {
+		t.setDTZMap(r, maxFile)
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < t.sides; side++ {
+			d := t.items[side][file]
+			d.sparseIndex = r.bytes(6 * int(d.sparseIndexSize))
+		}
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < t.sides; side++ {
+			d := t.items[side][file]
+			raw := r.bytes(2 * int(d.blockLengthSize))
+			d.blockLength = make([]uint16, d.blockLengthSize)
+			for idx := range d.blockLength {
+				d.blockLength[idx] = binary.LittleEndian.Uint16(raw[2*idx:])
+			}
+		}
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < t.sides; side++ {
+			d := t.items[side][file]
+			r.align(64)
+			d.dataOffset = r.pos
+			r.pos += int64(d.blocksNum * d.sizeofBlock)
+		}
+	}
+
+	return r.err
+}
+
+// setGroups splits the pieces into the groups that are indexed together and
+// works out the factor by which the index of each group is multiplied.
+func (t *tbTable) setGroups(d *pairsData, order [2]int, file int) {
+	firstLen := 2
+	switch {
+	case t.hasPawns:
+		firstLen = 0
+	case t.hasUniquePieces:
+		firstLen = 3
+	}
+
+	n := 0
+	d.groupLen[n] = 1
+	for i := 1; i < t.pieceCount; i++ {
+		firstLen--
+		if firstLen > 0 || d.pieces[i] == d.pieces[i-1] {
+			d.groupLen[n]++
+		} else {
+			n++
+			d.groupLen[n] = 1
+		}
+	}
+	n++
+	d.groupLen[n] = 0
+
+	pawnsOnBothSides := t.hasPawns && t.pawnCount[1] > 0
+	next := 1
+	freeSquares := 64 - d.groupLen[0]
+	if pawnsOnBothSides {
+		next = 2
+		freeSquares -= d.groupLen[1]
+	}
+
+	idx := uint64(1)
+	for k := 0; next < n || k == order[0] || k == order[1]; k++ {
+		switch k {
+		case order[0]:
+			d.groupIdx[0] = idx
+			switch {
+			case t.hasPawns:
+				idx *= uint64(tbLeadPawnsSize[d.groupLen[0]][file])
+			case t.hasUniquePieces:
+				idx *= 31332
+			default:
+				idx *= 462
+			}
+		case order[1]:
+			d.groupIdx[1] = idx
+			idx *= uint64(tbBinomial[d.groupLen[1]][48-d.groupLen[0]])
+		default:
+			d.groupIdx[next] = idx
+			idx *= uint64(tbBinomial[d.groupLen[next]][freeSquares])
+			freeSquares -= d.groupLen[next]
+			next++
+		}
+	}
+	d.groupIdx[n] = idx
+}
+
+// size is the number of positions indexed.
+func (d *pairsData) size() uint64 {
+	n := 0
+	for d.groupLen[n] != 0 {
+		n++
+	}
+	return d.groupIdx[n]
+}
+
+func (d *pairsData) setSizes(r *tbReader) error {
+	d.flags = r.byte()
+	if d.flags&tbFlagSingleValue != 0 {
+		d.minSymLen = int(r.byte())
+		return r.err
+	}
+
+	d.sizeofBlock = 1 << r.byte()
+	d.span = 1 << r.byte()
+	d.sparseIndexSize = (d.size() + d.span - 1) / d.span
+	padding := uint64(r.byte())
+	d.blocksNum = uint64(r.uint32())
+	d.blockLengthSize = d.blocksNum + padding
+	d.maxSymLen = int(r.byte())
+	d.minSymLen = int(r.byte())
+	if r.err != nil {
+		return r.err
+	}
+	if d.maxSymLen < d.minSymLen || d.maxSymLen > 64 || d.sizeofBlock > 1<<20 {
+		return errors.New("bad symbol lengths")
+	}
+
+	d.lowestSym = make([]uint16, d.maxSymLen-d.minSymLen+1)
+	for idx := range d.lowestSym {
+		d.lowestSym[idx] = r.uint16()
+	}
+
+	// The codes are canonical Huffman codes in which longer codes have lower
+	// values, so base64[i] is the lowest code of length minSymLen+i, padded
+	// to 64 bits.
+	d.base64 = make([]uint64, len(d.lowestSym))
+	for i := len(d.base64) - 2; i >= 0; i-- {
+		d.base64[i] = (d.base64[i+1] + uint64(d.lowestSym[i]) - uint64(d.lowestSym[i+1])) / 2
+	}
+	for i := range d.base64 {
+		d.base64[i] <<= 64 - i - d.minSymLen
+	}
+
+	symbols := int(r.uint16())
+	d.btree = r.bytes(3 * symbols)
+	r.pos += int64(symbols & 1)
+	if r.err != nil {
+		return r.err
+	}
+
+	d.symlen = make([]int, symbols)
+	visited := make([]bool, symbols)
+	for sym := 0; sym < symbols; sym++ {
+		if !visited[sym] {
+			if err := d.setSymlen(sym, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// setSymlen works out how many values, less one, a symbol expands into.
+func (d *pairsData) setSymlen(sym int, visited []bool) error {
+	visited[sym] = true
+	right := d.right(sym)
+	if right == 0xfff {
+		d.symlen[sym] = 0
+		return nil
+	}
+
+	left := d.left(sym)
+	for _, child := range []int{left, right} {
+		if child >= len(d.symlen) {
+			return errors.New("bad symbol tree")
+		}
+		if !visited[child] {
+			if err := d.setSymlen(child, visited); err != nil {
+				return err
+			}
+		}
+	}
+	d.symlen[sym] = d.symlen[left] + d.symlen[right] + 1
+	return nil
+}
+
+func (t *tbTable) setDTZMap(r *tbReader, maxFile int) {
+	start := r.pos
+	for file := 0; file <= maxFile; file++ {
+		d := t.items[0][file]
+		if d.flags&tbFlagMapped == 0 {
+			continue
+		}
+
+		if d.flags&tbFlagWide != 0 {
+			r.align(2)
+			for i := range d.mapIdx {
+				d.mapIdx[i] = int(r.pos-start) + 2
+				r.pos += 2 * int64(r.uint16())
+			}
+		} else {
+			for i := range d.mapIdx {
+				d.mapIdx[i] = int(r.pos-start) + 1
+				r.pos += int64(r.byte())
+			}
+		}
+	}
+	r.align(2)
+
+	end := r.pos
+	r.pos = start
+	t.dtzMap = r.bytes(int(end - start))
+}
+
+// mapScore turns a stored value into a result: a WDL score, or a DTZ in plies
+// given the WDL score of the position.
+func (t *tbTable) mapScore(file int, value int, wdl WDL) (int, error) {
+	if !t.dtz {
+		return value - 2, nil
+	}
+
+	d := t.get(0, file)
+	if d.flags&tbFlagMapped != 0 {
+		start := d.mapIdx[[5]int{1, 3, 0, 2, 0}[wdl+2]]
+		if d.flags&tbFlagWide != 0 {
+			at := start + 2*value
+			if at+2 > len(t.dtzMap) {
+				return 0, errors.New("corrupt DTZ map")
+			}
+			value = int(binary.LittleEndian.Uint16(t.dtzMap[at:]))
+		} else {
+			at := start + value
+			if at >= len(t.dtzMap) {
+				return 0, errors.New("corrupt DTZ map")
+			}
+			value = int(t.dtzMap[at])
+		}
+	}
+
+	// DTZ is stored in moves rather than plies where that loses nothing
+	if (wdl == WDLWin && d.flags&tbFlagWinPlies == 0) ||
+		(wdl == WDLLoss && d.flags&tbFlagLossPlies == 0) ||
+		wdl == WDLCursedWin || wdl == WDLBlessedLoss {
+		value *= 2
+	}
+	return value + 1, nil
+}
+
+// tbEntry holds the WDL and DTZ tables for one material balance.
+type tbEntry struct {
+	wdl *tbTable
+	dtz *tbTable
+}
+
+// Tablebase probes Syzygy endgame tablebases. Tables are found when the
+// tablebase is opened but only read when first probed. A Tablebase is safe for
+// concurrent use.
+type Tablebase struct {
+	entries   map[materialKey]*tbEntry
+	maxPieces int
+	hasDTZ    bool
+	files     []*tbTable
+}
+
+// OpenTablebase looks for Syzygy tables in the given directories. Tables whose
+// files cannot be read are reported as missing when probed.
+func OpenTablebase(directories []string) (*Tablebase, error) {
+	t := &Tablebase{entries: map[materialKey]*tbEntry{}}
+
+	for _, directory := range directories {
+		dirEntries, err := os.ReadDir(directory)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, dirEntry := range dirEntries {
+			name := dirEntry.Name()
+			suffix := strings.ToLower(filepath.Ext(name))
+			if dirEntry.IsDir() || (suffix != tbSuffixWDL && suffix != tbSuffixDTZ) {
+				continue
+			}
+
+			table, err := newTBTable(strings.TrimSuffix(name, filepath.Ext(name)))
+			if err != nil {
+				logger.Debug("ignoring file in tablebase directory", "name", name, "error", err)
+				continue
+			}
+			table.path = filepath.Join(directory, name)
+			table.dtz = suffix == tbSuffixDTZ
+			t.add(table)
+		}
+	}
+
+	logger.Info("tablebase opened", "tables", len(t.files), "pieces", t.maxPieces)
+	return t, nil
+}
+
+func (t *Tablebase) add(table *tbTable) {
+	entry, ok := t.entries[table.key]
+	if !ok {
+		entry = &tbEntry{}
+		t.entries[table.key] = entry
+		t.entries[table.key2] = entry
+	}
+
+	if table.dtz {
+		if entry.dtz != nil {
+			return
+		}
+		entry.dtz = table
+		t.hasDTZ = true
+	} else {
+		if entry.wdl != nil {
+			return
+		}
+		entry.wdl = table
+		t.maxPieces = max(t.maxPieces, table.pieceCount)
+	}
+	t.files = append(t.files, table)
+}
+
+// MaxPieces is the largest number of pieces, kings included, in any WDL table
+// found. It is zero if there are none.
+func (t *Tablebase) MaxPieces() int {
+	if t == nil {
+		return 0
+	}
+	return t.maxPieces
+}
+
+// Close releases the files of any tables that have been probed.
+func (t *Tablebase) Close() error {
+	var errs []error
+	for _, table := range t.files {
+		table.once.Do(func() { table.err = errors.New("tablebase closed") })
+		if table.file != nil {
+			errs = append(errs, table.file.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newTBTable reads the material of a table from its name, such as KRPvKR.
+func newTBTable(name string) (*tbTable, error) {
+	counts, err := parseMaterial(name)
+	if err != nil {
+		return nil, fmt.Errorf("bad tablebase name %s", name)
+	}
+
+	table := &tbTable{}
+	for theState, count := range counts {
+		table.pieceCount += count
+		if count == 1 && squareState(theState).getPieceType() != king {
+			table.hasUniquePieces = true
+		}
+	}
+	if counts[whiteKing] != 1 || counts[blackKing] != 1 || table.pieceCount > tbMaxPieces {
+		return nil, fmt.Errorf("bad tablebase name %s", name)
+	}
+
+	table.key = materialKeyFromCounts(counts)
+	table.key2 = materialKeyFromCounts(flipMaterial(counts))
+
+	// the leading colour is the side with fewer pawns, since that compresses
+	// better
+	whitePawns, blackPawns := counts[whitePawn], counts[blackPawn]
+	table.hasPawns = whitePawns+blackPawns > 0
+	if blackPawns == 0 || (whitePawns > 0 && blackPawns >= whitePawns) {
+		table.pawnCount = [2]int{whitePawns, blackPawns}
+	} else {
+		table.pawnCount = [2]int{blackPawns, whitePawns}
+	}
+	return table, nil
+}
+
+// tablebaseNames lists the names of the tables that were found, for logging
+// and tests.
+func (t *Tablebase) tablebaseNames() []string {
+	var names []string
+	for _, table := range t.files {
+		names = append(names, filepath.Base(table.path))
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,904 @@
+package chess
+
+import (
+	"bytes"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// The tables in testdata/syzygy are not the published ones, which cannot be
+// fetched while testing. They are written here instead, from results worked
+// out by retrograde analysis over the move generator, in the published format:
+// the index of tbReference, pairs compression with a canonical Huffman code,
+// sparse index and DTZ map. TestSyzygyFiles then checks the prober against
+// those results position by position, and TestSyzygyPublished checks it
+// against the published tables where they are at hand.
+
+// syzygyTestTables are the tables in testdata/syzygy, in the order they have
+// to be solved in, since pawns promote into the tables before them.
+var syzygyTestTables = []string{"KNvK", "KBvK", "KRvK", "KQvK", "KPvK"}
+
+// tbSolution holds the result of every position of a material balance in
+// which white is the stronger side. A position is keyed by the side to move
+// and the squares of its pieces, in the order of pieces. Only the canonical
+// key of each set of reflections is filled in.
+type tbSolution struct {
+	name     string
+	pieces   []squareState
+	hasPawns bool
+	legal    []bool
+	wdl      []WDL
+	// dtz is what ProbeDTZ should return, with mated positions at -1
+	dtz []int
+}
+
+// result returns whether the position with the given key is legal, and its
+// results.
+func (s *tbSolution) result(key int) (legal bool, wdl WDL, dtz int) {
+	key = s.canonical(key)
+	return s.legal[key], s.wdl[key], s.dtz[key]
+}
+
+// canonical returns the lowest key of the reflections of a position, all of
+// which have the same result.
+func (s *tbSolution) canonical(key int) int {
+	symmetries := pawnlessSymmetries
+	if s.hasPawns {
+		symmetries = pawnSymmetries
+	}
+	squares := s.squares(key)
+	lowest := key
+	for _, symmetry := range symmetries[1:] {
+		reflected := int(s.activeColour(key))
+		for _, square := range squares {
+			reflectedSquare := int(square)
+			for _, reflect := range symmetry {
+				reflectedSquare = reflect(reflectedSquare)
+			}
+			reflected = reflected<<6 | reflectedSquare
+		}
+		lowest = min(lowest, reflected)
+	}
+	return lowest
+}
+
+func (s *tbSolution) size() int {
+	return 2 << (6 * len(s.pieces))
+}
+
+func (s *tbSolution) squares(key int) []coordinate {
+	squares := make([]coordinate, len(s.pieces))
+	for i := len(squares) - 1; i >= 0; i-- {
+		squares[i] = coordinate(key & 63)
+		key >>= 6
+	}
+	return squares
+}
+
+func (s *tbSolution) activeColour(key int) colour {
+	return colour(key >> (6 * len(s.pieces)))
+}
+
+// position sets up the position with the given key, reporting false if it is
+// not a legal one.
+func (s *tbSolution) position(key int) (Position, bool) {
+	var thePosition Position
+	thePosition.clear()
+
+	squares := s.squares(key)
+	for i, square := range squares {
+		if thePosition.board[square] != empty {
+			return thePosition, false
+		}
+		if s.pieces[i].getPieceType() == pawn && (square.getRankIndex() == 0 || square.getRankIndex() == 7) {
+			return thePosition, false
+		}
+		thePosition.setSquare(square, s.pieces[i])
+	}
+	thePosition.activeColour = s.activeColour(key)
+	thePosition.hash ^= thePosition.getStateHash()
+	thePosition.doStaticAnalysis()
+
+	if thePosition.inCheck(thePosition.activeColour.getOpponent()) {
+		return thePosition, false
+	}
+	return thePosition, true
+}
+
+// key returns the key of a position with the material of the solution.
+func (s *tbSolution) key(p *Position) int {
+	var states []squareState
+	var squares []coordinate
+	occupied := p.getOccupationBitBoard()
+	for square, ok := occupied.pop(); ok; square, ok = occupied.pop() {
+		states = append(states, p.board[square])
+		squares = append(squares, square)
+	}
+	return s.keyOf(p.activeColour, states, squares)
+}
+
+// keyOf returns the key of the position with the given side to move and
+// pieces on the given squares, which must have the material of the solution.
+func (s *tbSolution) keyOf(activeColour colour, states []squareState, squares []coordinate) int {
+	key := int(activeColour)
+	used := make([]bool, len(states))
+	for _, piece := range s.pieces {
+		for i, theState := range states {
+			if theState == piece && !used[i] {
+				used[i] = true
+				key = key<<6 | int(squares[i])
+				break
+			}
+		}
+	}
+	return key
+}
+
+// newTBSolution sets up an empty solution for a material balance, whose
+// positions can be enumerated before it is solved.
+func newTBSolution(name string) (*tbSolution, error) {
+	counts, err := parseMaterial(name)
+	if err != nil {
+		return nil, err
+	}
+	s := &tbSolution{name: name}
+	for theState := whiteKing; theState < empty; theState++ {
+		for count := 0; count < counts[theState]; count++ {
+			s.pieces = append(s.pieces, theState)
+		}
+		s.hasPawns = s.hasPawns || (counts[theState] > 0 && theState.getPieceType() == pawn)
+	}
+	return s, nil
+}
+
+// tbSuccessor is a move from a position: either into another position of the
+// same table, or into another table whose result is known.
+type tbSuccessor struct {
+	key      int
+	result   WDL
+	zeroing  bool
+	sameSide bool
+}
+
+// solveSyzygy works out the result of every position of a material balance
+// from the solutions of the tables it converts into.
+func solveSyzygy(name string, solved map[materialKey]*tbSolution) (*tbSolution, error) {
+	s, err := newTBSolution(name)
+	if err != nil {
+		return nil, err
+	}
+	counts, _ := parseMaterial(name)
+
+	s.legal = make([]bool, s.size())
+	s.wdl = make([]WDL, s.size())
+	s.dtz = make([]int, s.size())
+	successors := make([][]tbSuccessor, s.size())
+	mated := make([]bool, s.size())
+
+	for key := range s.legal {
+		if s.canonical(key) != key {
+			continue
+		}
+		thePosition, ok := s.position(key)
+		if !ok {
+			continue
+		}
+		s.legal[key] = true
+		mated[key] = len(thePosition.legalMoves) == 0 && thePosition.inCheck(thePosition.activeColour)
+
+		// the successors are worked out from the moves, which is much quicker
+		// than making them
+		squares := s.squares(key)
+		for _, theMove := range thePosition.legalMoves {
+			states := slices.Clone(s.pieces)
+			childSquares := slices.Clone(squares)
+			for i, square := range childSquares {
+				if square == theMove.getToCoordinate() {
+					states, childSquares = slices.Delete(states, i, i+1), slices.Delete(childSquares, i, i+1)
+					break
+				}
+			}
+			for i, square := range childSquares {
+				if square == theMove.getFromCoordinate() {
+					childSquares[i] = theMove.getToCoordinate()
+					if promotion := theMove.getPromotionTo(); promotion != empty {
+						states[i] = promotion
+					}
+				}
+			}
+
+			var childCounts [12]int
+			for _, theState := range states {
+				childCounts[theState]++
+			}
+			successor := tbSuccessor{zeroing: thePosition.isZeroing(theMove)}
+			childColour := thePosition.activeColour.getOpponent()
+			switch material := materialKeyFromCounts(childCounts); {
+			case childCounts == counts:
+				successor.key = s.canonical(s.keyOf(childColour, states, childSquares))
+				successor.sameSide = true
+			case len(states) == 2:
+				successor.result = WDLDraw
+			case solved[material] != nil:
+				other := solved[material]
+				_, successor.result, _ = other.result(other.keyOf(childColour, states, childSquares))
+			default:
+				return nil, fmt.Errorf("%s converts into a table that has not been solved", name)
+			}
+			successors[key] = append(successors[key], successor)
+		}
+	}
+
+	const unknown = WDL(-3)
+	for key := range s.wdl {
+		switch {
+		case !s.legal[key]:
+			s.wdl[key] = WDLDraw
+		case mated[key]:
+			s.wdl[key] = WDLLoss
+		case len(successors[key]) == 0:
+			s.wdl[key] = WDLDraw
+		default:
+			s.wdl[key] = unknown
+		}
+	}
+	result := func(successor tbSuccessor) WDL {
+		if successor.sameSide {
+			return s.wdl[successor.key]
+		}
+		return successor.result
+	}
+
+	// a position is won if a move loses for the opponent, and lost if every
+	// move wins for them; whatever is left is drawn
+	for changed := true; changed; {
+		changed = false
+		for key, value := range s.wdl {
+			if value != unknown {
+				continue
+			}
+			allWin := true
+			for _, successor := range successors[key] {
+				childResult := result(successor)
+				if childResult == WDLLoss {
+					s.wdl[key] = WDLWin
+					changed = true
+					break
+				}
+				allWin = allWin && childResult == WDLWin
+			}
+			if s.wdl[key] == unknown && allWin {
+				s.wdl[key] = WDLLoss
+				changed = true
+			}
+		}
+	}
+	for key, value := range s.wdl {
+		if value == unknown {
+			s.wdl[key] = WDLDraw
+		}
+	}
+
+	// Distances to zeroing are found a ply at a time, from those found the ply
+	// before: the winner takes the shortest, the loser the longest. A mate
+	// counts as zeroing.
+	plies := make([]int, s.size())
+	resolved := make([]bool, s.size())
+	remaining := 0
+	for key := range s.wdl {
+		switch {
+		case mated[key]:
+			resolved[key] = true
+		case s.legal[key] && s.wdl[key] != WDLDraw:
+			remaining++
+		}
+	}
+	for ply := 1; remaining > 0; ply++ {
+		if ply > 100 {
+			return nil, fmt.Errorf("%s has wins the fifty move rule spoils", name)
+		}
+		var newlyResolved []int
+		for key, value := range s.wdl {
+			if resolved[key] || !s.legal[key] || value == WDLDraw {
+				continue
+			}
+			if value == WDLWin {
+				for _, successor := range successors[key] {
+					if result(successor) != WDLLoss {
+						continue
+					}
+					if successor.zeroing || (resolved[successor.key] && plies[successor.key] == ply-1) {
+						newlyResolved = append(newlyResolved, key)
+						break
+					}
+				}
+			}
+			if value == WDLLoss {
+				longest := 0
+				for _, successor := range successors[key] {
+					switch {
+					case successor.zeroing:
+					case !resolved[successor.key]:
+						longest = ply
+					default:
+						longest = max(longest, plies[successor.key])
+					}
+				}
+				if longest == ply-1 {
+					newlyResolved = append(newlyResolved, key)
+				}
+			}
+		}
+		for _, key := range newlyResolved {
+			resolved[key] = true
+			plies[key] = ply
+		}
+		remaining -= len(newlyResolved)
+	}
+
+	for key, value := range s.wdl {
+		switch {
+		case mated[key]:
+			s.dtz[key] = -1
+		case value == WDLWin:
+			s.dtz[key] = plies[key]
+		case value == WDLLoss:
+			s.dtz[key] = -plies[key]
+		}
+	}
+	return s, nil
+}
+
+var (
+	syzygySolutionsOnce sync.Once
+	syzygySolutions     map[string]*tbSolution
+	syzygySolutionsErr  error
+)
+
+// solveSyzygyTestTables solves the tables in testdata/syzygy, once.
+func solveSyzygyTestTables(t *testing.T) map[string]*tbSolution {
+	t.Helper()
+	syzygySolutionsOnce.Do(func() {
+		syzygySolutions = map[string]*tbSolution{}
+		solved := map[materialKey]*tbSolution{}
+		for _, name := range syzygyTestTables {
+			s, err := solveSyzygy(name, solved)
+			if err != nil {
+				syzygySolutionsErr = err
+				return
+			}
+			counts, _ := parseMaterial(name)
+			solved[materialKeyFromCounts(counts)] = s
+			syzygySolutions[name] = s
+		}
+	})
+	if syzygySolutionsErr != nil {
+		t.Fatal(syzygySolutionsErr)
+	}
+	return syzygySolutions
+}
+
+// tbLayout sets up the prober's WDL table with the pieces in the given order
+// for every side and file, and the groups multiplied in the given order, so
+// that positions can be located in it without a file.
+func tbLayout(name string, pieces []byte, order [2]int) (*tbTable, error) {
+	t, err := newTBTable(name)
+	if err != nil {
+		return nil, err
+	}
+	t.sides = 2
+	for file := 0; file < 4; file++ {
+		for side := 0; side < t.sides; side++ {
+			d := &pairsData{}
+			copy(d.pieces[:], pieces)
+			t.setGroups(d, order, file)
+			t.items[side][file] = d
+		}
+	}
+	return t, nil
+}
+
+// tbCompressed is the pairs data for one side and file, as written.
+type tbCompressed struct {
+	flags byte
+	// value is the value of every position, if there is only one
+	value byte
+
+	blockShift  byte
+	spanShift   byte
+	minSymLen   int
+	maxSymLen   int
+	lowestSym   []uint16
+	btree       [][2]int
+	sparseIndex []byte
+	blockLength []uint16
+	blocks      []byte
+}
+
+const (
+	tbGenBlockShift = 6
+	tbGenSpanShift  = 8
+	// pairs are only made of symbols that expand into no more than this many
+	// values, and only while they occur often enough to be worth it
+	tbGenMaxExpansion = 1024
+	tbGenMinPairCount = 8
+	tbGenMaxSymbols   = 4095
+)
+
+// compressValues pairs up common runs of values into symbols, codes the
+// symbols and splits the codes into blocks. values may hold -1 for positions
+// that cannot occur, whose value does not matter.
+func compressValues(values []int, flags byte) tbCompressed {
+	// positions that cannot occur take the value before them, which keeps
+	// runs long
+	filled := slices.Clone(values)
+	last := -1
+	for _, value := range filled {
+		if value >= 0 {
+			last = value
+			break
+		}
+	}
+	for idx, value := range filled {
+		if value < 0 {
+			filled[idx] = last
+		}
+		last = filled[idx]
+	}
+
+	distinct := slices.Clone(filled)
+	slices.Sort(distinct)
+	distinct = slices.Compact(distinct)
+	if len(distinct) <= 1 {
+		value := 0
+		if len(distinct) == 1 {
+			value = distinct[0]
+		}
+		return tbCompressed{flags: flags | tbFlagSingleValue, value: byte(value)}
+	}
+
+	c := tbCompressed{flags: flags, blockShift: tbGenBlockShift, spanShift: tbGenSpanShift}
+	expansion := []int{}
+	leaf := map[int]int{}
+	for _, value := range distinct {
+		leaf[value] = len(c.btree)
+		c.btree = append(c.btree, [2]int{value, 0xfff})
+		expansion = append(expansion, 1)
+	}
+	sequence := make([]int, len(filled))
+	for idx, value := range filled {
+		sequence[idx] = leaf[value]
+	}
+
+	for len(c.btree) < tbGenMaxSymbols {
+		counts := map[[2]int]int{}
+		for i := 0; i+1 < len(sequence); i++ {
+			counts[[2]int{sequence[i], sequence[i+1]}]++
+			// a run of one symbol holds only half as many pairs of it
+			if sequence[i] == sequence[i+1] && i+2 < len(sequence) && sequence[i+2] == sequence[i] {
+				i++
+			}
+		}
+
+		var best [2]int
+		bestCount := 0
+		for pair, count := range counts {
+			if expansion[pair[0]]+expansion[pair[1]] > tbGenMaxExpansion {
+				continue
+			}
+			if count > bestCount || (count == bestCount && (pair[0] < best[0] || (pair[0] == best[0] && pair[1] < best[1]))) {
+				best, bestCount = pair, count
+			}
+		}
+		if bestCount < tbGenMinPairCount {
+			break
+		}
+
+		symbol := len(c.btree)
+		c.btree = append(c.btree, best)
+		expansion = append(expansion, expansion[best[0]]+expansion[best[1]])
+		paired := sequence[:0]
+		for i := 0; i < len(sequence); i++ {
+			if i+1 < len(sequence) && sequence[i] == best[0] && sequence[i+1] == best[1] {
+				paired = append(paired, symbol)
+				i++
+			} else {
+				paired = append(paired, sequence[i])
+			}
+		}
+		sequence = paired
+	}
+
+	// Symbols are renumbered so that those with longer codes come first and
+	// those that are never coded last, as the canonical code requires.
+	frequencies := make([]int, len(c.btree))
+	for _, symbol := range sequence {
+		frequencies[symbol]++
+	}
+	lengths := huffmanLengths(frequencies)
+	order := make([]int, len(c.btree))
+	for symbol := range order {
+		order[symbol] = symbol
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if (lengths[a] == 0) != (lengths[b] == 0) {
+			return lengths[b] == 0
+		}
+		return lengths[a] > lengths[b]
+	})
+	renumbered := make([]int, len(order))
+	for newSymbol, symbol := range order {
+		renumbered[symbol] = newSymbol
+	}
+	btree := make([][2]int, len(c.btree))
+	newExpansion := make([]int, len(c.btree))
+	newLengths := make([]int, len(c.btree))
+	for symbol, pair := range c.btree {
+		if pair[1] != 0xfff {
+			pair = [2]int{renumbered[pair[0]], renumbered[pair[1]]}
+		}
+		btree[renumbered[symbol]] = pair
+		newExpansion[renumbered[symbol]] = expansion[symbol]
+		newLengths[renumbered[symbol]] = lengths[symbol]
+	}
+	c.btree, expansion, lengths = btree, newExpansion, newLengths
+	for idx, symbol := range sequence {
+		sequence[idx] = renumbered[symbol]
+	}
+
+	c.minSymLen, c.maxSymLen = 64, 0
+	countOfLength := map[int]int{}
+	for _, length := range lengths {
+		if length > 0 {
+			c.minSymLen = min(c.minSymLen, length)
+			c.maxSymLen = max(c.maxSymLen, length)
+			countOfLength[length]++
+		}
+	}
+	c.lowestSym = make([]uint16, c.maxSymLen-c.minSymLen+1)
+	base := make([]uint64, len(c.lowestSym))
+	next := 0
+	for i := len(c.lowestSym) - 1; i >= 0; i-- {
+		c.lowestSym[i] = uint16(next)
+		if i < len(c.lowestSym)-1 {
+			base[i] = (base[i+1] + uint64(countOfLength[c.minSymLen+i+1])) / 2
+		}
+		next += countOfLength[c.minSymLen+i]
+	}
+	code := func(symbol int) (uint64, int) {
+		i := lengths[symbol] - c.minSymLen
+		return base[i] + uint64(symbol-int(c.lowestSym[i])), lengths[symbol]
+	}
+
+	// Blocks hold whole symbols. The sparse index records the block and the
+	// offset within it of the value in the middle of every span.
+	blockSize := 1 << c.blockShift
+	var blockStarts []int
+	var block []byte
+	var bits, blockValues, valueIdx int
+	flush := func() {
+		c.blocks = append(c.blocks, block...)
+		c.blocks = append(c.blocks, make([]byte, blockSize-len(block))...)
+		c.blockLength = append(c.blockLength, uint16(blockValues-1))
+		block, bits, blockValues = nil, 0, 0
+	}
+	for _, symbol := range sequence {
+		value, length := code(symbol)
+		if bits+length > 8*blockSize || blockValues+expansion[symbol] > 1<<16 {
+			flush()
+		}
+		if blockValues == 0 {
+			blockStarts = append(blockStarts, valueIdx)
+		}
+		for bit := length - 1; bit >= 0; bit-- {
+			if bits%8 == 0 {
+				block = append(block, 0)
+			}
+			if value>>bit&1 != 0 {
+				block[bits/8] |= 0x80 >> (bits % 8)
+			}
+			bits++
+		}
+		blockValues += expansion[symbol]
+		valueIdx += expansion[symbol]
+	}
+	flush()
+
+	span := 1 << c.spanShift
+	for start := 0; start < len(filled); start += span {
+		middle := start + span/2
+		blockIdx := sort.Search(len(blockStarts), func(i int) bool { return blockStarts[i] > middle }) - 1
+		c.sparseIndex = binary.LittleEndian.AppendUint32(c.sparseIndex, uint32(blockIdx))
+		c.sparseIndex = binary.LittleEndian.AppendUint16(c.sparseIndex, uint16(middle-blockStarts[blockIdx]))
+	}
+	return c
+}
+
+type huffmanNode struct {
+	weight, order int
+	symbols       []int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	return h[i].weight < h[j].weight || (h[i].weight == h[j].weight && h[i].order < h[j].order)
+}
+func (h huffmanHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x any)   { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() any {
+	old := *h
+	node := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return node
+}
+
+// huffmanLengths returns the length of the Huffman code of each symbol, or
+// zero for symbols that do not occur.
+func huffmanLengths(frequencies []int) []int {
+	lengths := make([]int, len(frequencies))
+	h := &huffmanHeap{}
+	for symbol, frequency := range frequencies {
+		if frequency > 0 {
+			heap.Push(h, huffmanNode{frequency, symbol, []int{symbol}})
+		}
+	}
+	if h.Len() == 1 {
+		lengths[(*h)[0].symbols[0]] = 1
+		return lengths
+	}
+
+	order := len(frequencies)
+	for h.Len() > 1 {
+		a := heap.Pop(h).(huffmanNode)
+		b := heap.Pop(h).(huffmanNode)
+		for _, symbol := range append(slices.Clone(a.symbols), b.symbols...) {
+			lengths[symbol]++
+		}
+		heap.Push(h, huffmanNode{a.weight + b.weight, order, append(a.symbols, b.symbols...)})
+		order++
+	}
+	return lengths
+}
+
+// tbGenPieces is the order of the pieces in the tables written: the piece
+// other than the kings first, as the index of tables with pawns requires.
+var tbGenPieces = map[string][]byte{
+	"KNvK": {2, 6, 14},
+	"KBvK": {3, 6, 14},
+	"KRvK": {4, 6, 14},
+	"KQvK": {5, 6, 14},
+	"KPvK": {1, 6, 14},
+}
+
+// tbGenDTZSide is the side to move held by each DTZ table written. Black to
+// move in KPvK has the losses and the mates.
+var tbGenDTZSide = map[string]int{"KPvK": 1}
+
+// writeSyzygyTable writes the WDL or DTZ table of a solution.
+func writeSyzygyTable(path string, s *tbSolution, dtz bool) error {
+	// the positions are indexed apart from the prober, which reading the
+	// tables then checks
+	reference := newTBReference(tbGenPieces[s.name], [2]int{0, 0xf})
+	maxFile := 0
+	if reference.hasPawns {
+		maxFile = 3
+	}
+	sides := 2
+	if dtz {
+		sides = 1
+	}
+	storedSide := tbGenDTZSide[s.name]
+
+	// the value of every index, or -1 where no position is stored
+	var values [2][4][]int
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			values[side][file] = make([]int, reference.size[file])
+			for idx := range values[side][file] {
+				values[side][file][idx] = -1
+			}
+		}
+	}
+	var dtzValues [4]map[uint64]int
+	var dtzWins [4]map[uint64]bool
+	for file := range dtzValues {
+		dtzValues[file] = map[uint64]int{}
+		dtzWins[file] = map[uint64]bool{}
+	}
+
+	for key, legal := range s.legal {
+		if !legal {
+			continue
+		}
+		thePosition, _ := s.position(key)
+		stm, file, idx := reference.index(&thePosition)
+
+		value := int(s.wdl[key]) + 2
+		if dtz {
+			if stm != storedSide || s.wdl[key] == WDLDraw {
+				continue
+			}
+			stm = 0
+			// plies to zeroing less one, with mates at zero
+			value = max(s.dtz[key], -s.dtz[key]) - 1
+			dtzValues[file][idx] = value
+			dtzWins[file][idx] = s.wdl[key] == WDLWin
+		}
+		if previous := values[stm][file][idx]; previous >= 0 && previous != value {
+			return fmt.Errorf("%s: positions with different results share index %d", s.name, idx)
+		}
+		values[stm][file][idx] = value
+	}
+
+	// DTZ tables store an index into a map of the distances for each result
+	var dtzMaps [4][4][]int
+	if dtz {
+		for file := 0; file <= maxFile; file++ {
+			var frequencies [2]map[int]int
+			frequencies[0], frequencies[1] = map[int]int{}, map[int]int{}
+			for idx, value := range dtzValues[file] {
+				section := 1
+				if dtzWins[file][idx] {
+					section = 0
+				}
+				frequencies[section][value]++
+			}
+			var lookup [2]map[int]int
+			for section := range frequencies {
+				distances := make([]int, 0, len(frequencies[section]))
+				for distance := range frequencies[section] {
+					distances = append(distances, distance)
+				}
+				sort.Slice(distances, func(i, j int) bool {
+					a, b := distances[i], distances[j]
+					fa, fb := frequencies[section][a], frequencies[section][b]
+					return fa > fb || (fa == fb && a < b)
+				})
+				if len(distances) > 255 || (len(distances) > 0 && slices.Max(distances) > 255) {
+					return fmt.Errorf("%s: distances too long for a narrow map", s.name)
+				}
+				dtzMaps[file][section] = distances
+				lookup[section] = map[int]int{}
+				for mapped, distance := range distances {
+					lookup[section][distance] = mapped
+				}
+			}
+			for idx, value := range dtzValues[file] {
+				section := 1
+				if dtzWins[file][idx] {
+					section = 0
+				}
+				values[0][file][idx] = lookup[section][value]
+			}
+		}
+	}
+
+	var compressed [2][4]tbCompressed
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			var flags byte
+			if dtz {
+				flags = byte(storedSide) | tbFlagMapped | tbFlagWinPlies | tbFlagLossPlies
+			}
+			compressed[side][file] = compressValues(values[side][file], flags)
+			if compressed[side][file].flags&tbFlagSingleValue != 0 {
+				compressed[side][file].flags &^= tbFlagMapped
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	header := byte(0)
+	if dtz {
+		b.Write(tbMagicDTZ[:])
+	} else {
+		b.Write(tbMagicWDL[:])
+		header |= tbHeaderSplit
+	}
+	if reference.hasPawns {
+		header |= tbHeaderHasPawns
+	}
+	b.WriteByte(header)
+
+	pieces := tbGenPieces[s.name]
+	for file := 0; file <= maxFile; file++ {
+		b.WriteByte(0)
+		for _, piece := range pieces {
+			b.WriteByte(piece | piece<<4)
+		}
+	}
+	align := func(n int) {
+		for b.Len()%n != 0 {
+			b.WriteByte(0)
+		}
+	}
+	align(2)
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			c := compressed[side][file]
+			b.WriteByte(c.flags)
+			if c.flags&tbFlagSingleValue != 0 {
+				b.WriteByte(c.value)
+				continue
+			}
+			b.Write([]byte{c.blockShift, c.spanShift, 0})
+			binary.Write(&b, binary.LittleEndian, uint32(len(c.blockLength)))
+			b.Write([]byte{byte(c.maxSymLen), byte(c.minSymLen)})
+			binary.Write(&b, binary.LittleEndian, c.lowestSym)
+			binary.Write(&b, binary.LittleEndian, uint16(len(c.btree)))
+			for _, pair := range c.btree {
+				b.Write([]byte{byte(pair[0]), byte(pair[0]>>8&0xf | pair[1]&0xf<<4), byte(pair[1] >> 4)})
+			}
+			if len(c.btree)%2 != 0 {
+				b.WriteByte(0)
+			}
+		}
+	}
+
+	if dtz {
+		for file := 0; file <= maxFile; file++ {
+			if compressed[0][file].flags&tbFlagMapped == 0 {
+				continue
+			}
+			for _, distances := range dtzMaps[file] {
+				b.WriteByte(byte(len(distances)))
+				for _, distance := range distances {
+					b.WriteByte(byte(distance))
+				}
+			}
+		}
+		align(2)
+	}
+
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			b.Write(compressed[side][file].sparseIndex)
+		}
+	}
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			binary.Write(&b, binary.LittleEndian, compressed[side][file].blockLength)
+		}
+	}
+	for file := 0; file <= maxFile; file++ {
+		for side := 0; side < sides; side++ {
+			align(64)
+			b.Write(compressed[side][file].blocks)
+		}
+	}
+
+	// the published tables end with a checksum, which the prober ignores
+	align(64)
+	b.Write(make([]byte, 16))
+
+	return os.WriteFile(path, b.Bytes(), 0o644)
+}
+
+// TestGenerateSyzygy writes the tables in testdata/syzygy. It only runs when
+// SYZYGY_GENERATE names the directory to write them to.
+func TestGenerateSyzygy(t *testing.T) {
+	dir := os.Getenv("SYZYGY_GENERATE")
+	if dir == "" {
+		t.Skip("SYZYGY_GENERATE is not set")
+	}
+
+	for name, s := range solveSyzygyTestTables(t) {
+		if err := writeSyzygyTable(filepath.Join(dir, name+tbSuffixWDL), s, false); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeSyzygyTable(filepath.Join(dir, name+tbSuffixDTZ), s, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
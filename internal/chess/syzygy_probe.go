@@ -0,0 +1,592 @@
+package chess
+
+import (
+	"slices"
+	"sort"
+)
+
+// WDL is a tablebase result from the point of view of the side to move. A
+// cursed win is a win that the fifty move rule turns into a draw, and a blessed
+// loss a loss that it saves.
+type WDL int
+
+const (
+	WDLLoss WDL = iota - 2
+	WDLBlessedLoss
+	WDLDraw
+	WDLCursedWin
+	WDLWin
+)
+
+// maxDTZ ranks root moves: wins that are certain under the fifty move rule
+// rank maxDTZ, and the rest of the ranks count down from there.
+const maxDTZ = 1 << 18
+
+type tbProbeState int8
+
+const (
+	tbProbeFailed tbProbeState = iota
+	tbProbeOK
+	// the DTZ table only holds the other side to move
+	tbProbeChangeSideToMove
+	// the best move zeroes the fifty move counter, so the DTZ stored for the
+	// position may be wrong
+	tbProbeZeroingBestMove
+)
+
+// syzygyPieceCode is the code of a piece in the tables: pawn 1 up to king 6,
+// with 8 added for black.
+func syzygyPieceCode(theState squareState) byte {
+	codes := [6]byte{king: 6, queen: 5, rook: 4, bishop: 3, knight: 2, pawn: 1}
+	code := codes[theState.getPieceType()]
+	if theState.getColour() == black {
+		code |= 8
+	}
+	return code
+}
+
+// ProbeWDL looks up the position in the WDL tables, ignoring the fifty move
+// counter. ok is false if there is no table for the position or it has
+// castling rights.
+func (t *Tablebase) ProbeWDL(thePosition Position) (result WDL, ok bool) {
+	if t == nil || thePosition.getOccupationBitBoard().count() > t.maxPieces || thePosition.castlingRights != 0 {
+		return WDLDraw, false
+	}
+
+	p := thePosition.clone()
+	state := tbProbeOK
+	result = t.probeWDL(&p, &state)
+	return result, state != tbProbeFailed
+}
+
+// ProbeDTZ looks up the distance in plies to the next capture or pawn move
+// that keeps the result of the position: positive if the side to move wins,
+// negative if it loses and zero for a draw. Results of 100 or more in either
+// direction are cursed wins or blessed losses. ok is false if there is no table
+// for the position or it has castling rights.
+func (t *Tablebase) ProbeDTZ(thePosition Position) (dtz int, ok bool) {
+	if t == nil || thePosition.getOccupationBitBoard().count() > t.maxPieces || thePosition.castlingRights != 0 {
+		return 0, false
+	}
+
+	p := thePosition.clone()
+	state := tbProbeOK
+	dtz = t.probeDTZ(&p, &state)
+	return dtz, state != tbProbeFailed
+}
+
+func (t *Tablebase) probeTable(p *Position, dtz bool, wdl WDL, state *tbProbeState) int {
+	if p.getOccupationBitBoard().count() == 2 {
+		return int(WDLDraw)
+	}
+
+	entry, ok := t.entries[p.materialKey()]
+	if !ok {
+		*state = tbProbeFailed
+		return 0
+	}
+	table := entry.wdl
+	if dtz {
+		table = entry.dtz
+	}
+	if table == nil || table.load() != nil {
+		*state = tbProbeFailed
+		return 0
+	}
+
+	value, err := table.probe(p, wdl, state)
+	if err != nil {
+		logger.Warn("cannot probe tablebase", "path", table.path, "error", err)
+		*state = tbProbeFailed
+		return 0
+	}
+	return value
+}
+
+func (t *tbTable) probe(p *Position, wdl WDL, state *tbProbeState) (int, error) {
+	stm, file, idx := t.locate(p)
+	if t.dtz && !t.hasSideToMove(stm, file) {
+		*state = tbProbeChangeSideToMove
+		return 0, nil
+	}
+
+	value, err := t.get(stm, file).decompress(t.file, idx)
+	if err != nil {
+		return 0, err
+	}
+	return t.mapScore(file, value, wdl)
+}
+
+// locate finds where a position is stored: the side to move and file of the
+// leading pawn that select the pairs data, and the index within it.
+func (t *tbTable) locate(p *Position) (stm, file int, idx uint64) {
+	var squares [tbMaxPieces]int
+	var pieces [tbMaxPieces]byte
+	size, leadPawnsCount := 0, 0
+	var leadPawns bitBoard
+
+	// Tables are stored with the stronger side as white, and symmetric tables
+	// with white to move, so the position may have to be flipped.
+	symmetricBlackToMove := t.key == t.key2 && p.activeColour == black
+	blackStronger := p.materialKey() != t.key
+	flipColour, flipSquares := byte(0), 0
+	stm = int(p.activeColour)
+	if symmetricBlackToMove || blackStronger {
+		flipColour, flipSquares = 8, 56
+		stm ^= 1
+	}
+
+	if t.hasPawns {
+		leadColour := white
+		if (t.items[0][0].pieces[0]^flipColour)&8 != 0 {
+			leadColour = black
+		}
+		leadPawns = p.occupationByColour[leadColour] & p.occupationByPieceType[pawn]
+
+		remaining := leadPawns
+		for square, ok := remaining.pop(); ok; square, ok = remaining.pop() {
+			squares[size] = int(square) ^ flipSquares
+			size++
+		}
+		leadPawnsCount = size
+
+		lead := 0
+		for idx := 1; idx < leadPawnsCount; idx++ {
+			if tbMapPawns[squares[idx]] > tbMapPawns[squares[lead]] {
+				lead = idx
+			}
+		}
+		squares[0], squares[lead] = squares[lead], squares[0]
+		file = min(squares[0]&7, 7-(squares[0]&7))
+	}
+
+	remaining := p.getOccupationBitBoard() &^ leadPawns
+	for square, ok := remaining.pop(); ok; square, ok = remaining.pop() {
+		squares[size] = int(square) ^ flipSquares
+		pieces[size] = syzygyPieceCode(p.board[square]) ^ flipColour
+		size++
+	}
+
+	d := t.get(stm, file)
+
+	// put the pieces in the order the table was built with
+	for i := leadPawnsCount; i < size-1; i++ {
+		for j := i + 1; j < size; j++ {
+			if d.pieces[i] == pieces[j] {
+				pieces[i], pieces[j] = pieces[j], pieces[i]
+				squares[i], squares[j] = squares[j], squares[i]
+				break
+			}
+		}
+	}
+
+	return stm, file, t.encode(d, squares[:size], leadPawnsCount)
+}
+
+// hasSideToMove reports whether a DTZ table holds positions with the given
+// side to move. Symmetric pawnless tables hold both.
+func (t *tbTable) hasSideToMove(stm, file int) bool {
+	flags := t.get(stm, file).flags
+	return int(flags&tbFlagSideToMove) == stm || (t.key == t.key2 && !t.hasPawns)
+}
+
+// encode works out the index of a position in a table from the squares of its
+// pieces, which are in table order with the leading pawns first. The squares
+// are changed to those of the position that is stored.
+func (t *tbTable) encode(d *pairsData, squares []int, leadPawnsCount int) uint64 {
+	// the leading piece goes on files a to d
+	if squares[0]&7 > 3 {
+		for i := range squares {
+			squares[i] ^= 7
+		}
+	}
+
+	var idx uint64
+	if t.hasPawns {
+		idx = uint64(tbLeadPawnIdx[leadPawnsCount][squares[0]])
+		others := squares[1:leadPawnsCount]
+		sort.SliceStable(others, func(i, j int) bool { return tbMapPawns[others[i]] < tbMapPawns[others[j]] })
+		for i := 1; i < leadPawnsCount; i++ {
+			idx += uint64(tbBinomial[i][tbMapPawns[squares[i]]])
+		}
+	} else {
+		// then on ranks 1 to 4, and then below the a1-h8 diagonal
+		if squares[0]>>3 > 3 {
+			for i := range squares {
+				squares[i] ^= 56
+			}
+		}
+		for i := 0; i < d.groupLen[0]; i++ {
+			off := offA1H8(squares[i])
+			if off == 0 {
+				continue
+			}
+			if off > 0 {
+				for j := i; j < len(squares); j++ {
+					squares[j] = (squares[j]>>3 | squares[j]<<3) & 63
+				}
+			}
+			break
+		}
+
+		if t.hasUniquePieces {
+			idx = t.encodeUniquePieces(squares)
+		} else {
+			idx = uint64(tbMapKK[tbMapA1D1D4[squares[0]]][squares[1]])
+		}
+	}
+
+	// the other groups are sets of like pieces on the squares left over
+	idx *= d.groupIdx[0]
+	groupStart := d.groupLen[0]
+	remainingPawns := t.hasPawns && t.pawnCount[1] > 0
+	for next := 1; d.groupLen[next] != 0; next++ {
+		group := squares[groupStart : groupStart+d.groupLen[next]]
+		slices.Sort(group)
+
+		var n uint64
+		for i, square := range group {
+			adjust := 0
+			for _, previous := range squares[:groupStart] {
+				if square > previous {
+					adjust++
+				}
+			}
+			if remainingPawns {
+				adjust += 8
+			}
+			n += uint64(tbBinomial[i+1][square-adjust])
+		}
+
+		remainingPawns = false
+		idx += n * d.groupIdx[next]
+		groupStart += d.groupLen[next]
+	}
+	return idx
+}
+
+// encodeUniquePieces indexes the first three pieces of a pawnless table with
+// a piece of which there is only one, one of 31332 placements.
+func (t *tbTable) encodeUniquePieces(squares []int) uint64 {
+	rank := func(square int) int { return square >> 3 }
+	adjust1, adjust2 := 0, 0
+	if squares[1] > squares[0] {
+		adjust1 = 1
+	}
+	if squares[2] > squares[0] {
+		adjust2++
+	}
+	if squares[2] > squares[1] {
+		adjust2++
+	}
+
+	var idx int
+	switch {
+	case offA1H8(squares[0]) != 0:
+		idx = (tbMapA1D1D4[squares[0]]*63+squares[1]-adjust1)*62 + squares[2] - adjust2
+	case offA1H8(squares[1]) != 0:
+		idx = (6*63+rank(squares[0])*28+tbMapB1H1H7[squares[1]])*62 + squares[2] - adjust2
+	case offA1H8(squares[2]) != 0:
+		idx = 6*63*62 + 4*28*62 + rank(squares[0])*7*28 + (rank(squares[1])-adjust1)*28 + tbMapB1H1H7[squares[2]]
+	default:
+		idx = 6*63*62 + 4*28*62 + 4*7*28 + rank(squares[0])*7*6 + (rank(squares[1])-adjust1)*6 + rank(squares[2]) - adjust2
+	}
+	return uint64(idx)
+}
+
+// isZeroing reports whether a move resets the fifty move counter.
+func (p *Position) isZeroing(theMove move) bool {
+	return theMove.getCapturedPiece() != empty || p.getSquare(theMove.getFromCoordinate()).getPieceType() == pawn
+}
+
+// isCapture reports whether a move captures, including en passant.
+func (p *Position) isCapture(theMove move) bool {
+	if theMove.getCapturedPiece() != empty {
+		return true
+	}
+	return p.board[theMove.getFromCoordinate()].getPieceType() == pawn && theMove.getToCoordinate() == theMove.getCurrentEPSquare()
+}
+
+// tbSearch resolves captures, and pawn moves too if checkZeroingMoves is set,
+// before probing the WDL table. The tables assume that the best move is never
+// a capture, and know nothing of en passant.
+func (t *Tablebase) tbSearch(p *Position, checkZeroingMoves bool, state *tbProbeState) WDL {
+	bestValue := WDLLoss
+	moveCount := 0
+
+	legalMoves := p.legalMoves
+	for _, theMove := range legalMoves {
+		if !p.isCapture(theMove) && (!checkZeroingMoves || p.getSquare(theMove.getFromCoordinate()).getPieceType() != pawn) {
+			continue
+		}
+
+		moveCount++
+		p.makeMove(theMove)
+		value := -t.tbSearch(p, false, state)
+		p.unmakeMove(theMove)
+
+		if *state == tbProbeFailed {
+			return WDLDraw
+		}
+		if value > bestValue {
+			bestValue = value
+			if value >= WDLWin {
+				*state = tbProbeZeroingBestMove
+				return value
+			}
+		}
+	}
+
+	// once every legal move has been searched, the table is not needed
+	noMoreMoves := moveCount > 0 && moveCount == len(legalMoves)
+	var value WDL
+	if noMoreMoves {
+		value = bestValue
+	} else {
+		value = WDL(t.probeTable(p, false, WDLDraw, state))
+		if *state == tbProbeFailed {
+			return WDLDraw
+		}
+	}
+
+	if bestValue >= value {
+		if bestValue > WDLDraw || noMoreMoves {
+			*state = tbProbeZeroingBestMove
+		} else {
+			*state = tbProbeOK
+		}
+		return bestValue
+	}
+	*state = tbProbeOK
+	return value
+}
+
+func (t *Tablebase) probeWDL(p *Position, state *tbProbeState) WDL {
+	*state = tbProbeOK
+	return t.tbSearch(p, false, state)
+}
+
+// dtzBeforeZeroing is the DTZ of a position whose best move zeroes the fifty
+// move counter.
+func dtzBeforeZeroing(wdl WDL) int {
+	switch wdl {
+	case WDLWin:
+		return 1
+	case WDLCursedWin:
+		return 101
+	case WDLBlessedLoss:
+		return -101
+	case WDLLoss:
+		return -1
+	}
+	return 0
+}
+
+func sign(x int) int {
+	switch {
+	case x > 0:
+		return 1
+	case x < 0:
+		return -1
+	}
+	return 0
+}
+
+func (t *Tablebase) probeDTZ(p *Position, state *tbProbeState) int {
+	*state = tbProbeOK
+	wdl := t.tbSearch(p, true, state)
+	if *state == tbProbeFailed || wdl == WDLDraw {
+		return 0
+	}
+	if *state == tbProbeZeroingBestMove {
+		return dtzBeforeZeroing(wdl)
+	}
+
+	dtz := t.probeTable(p, true, wdl, state)
+	if *state == tbProbeFailed {
+		return 0
+	}
+	if *state != tbProbeChangeSideToMove {
+		if wdl == WDLBlessedLoss || wdl == WDLCursedWin {
+			dtz += 100
+		}
+		return dtz * sign(int(wdl))
+	}
+
+	// the table holds the other side to move, so look one ply ahead for the
+	// move that keeps the result with the lowest DTZ
+	minDTZ := 0xffff
+	for _, theMove := range p.legalMoves {
+		zeroing := p.isZeroing(theMove)
+		p.makeMove(theMove)
+
+		// a zeroing move starts the count again, so only the sign of the
+		// result after it matters
+		if zeroing {
+			dtz = -dtzBeforeZeroing(t.tbSearch(p, false, state))
+		} else {
+			dtz = -t.probeDTZ(p, state)
+		}
+
+		if dtz == 1 && len(p.legalMoves) == 0 && p.inCheck(p.activeColour) {
+			minDTZ = 1
+		}
+		if !zeroing {
+			dtz += sign(dtz)
+		}
+		if dtz < minDTZ && sign(dtz) == sign(int(wdl)) {
+			minDTZ = dtz
+		}
+
+		p.unmakeMove(theMove)
+		if *state == tbProbeFailed {
+			return 0
+		}
+	}
+
+	// with no legal moves the position is mate
+	if minDTZ == 0xffff {
+		return -1
+	}
+	return minDTZ
+}
+
+// hasRepeated reports whether any position since the last capture or pawn
+// move has occurred before.
+func (p *Position) hasRepeated() bool {
+	end := len(p.history) - int(p.halfMoveClock)
+	for idx := len(p.history) - 1; idx >= 0 && idx >= end; idx-- {
+		entryHash := p.history[idx].hash
+		for earlier := idx - 4; earlier >= 0 && earlier >= end; earlier -= 2 {
+			if p.history[earlier].hash == entryHash {
+				return true
+			}
+		}
+	}
+	return p.repetitionCount() > 0
+}
+
+// tbRootMove is a root move ranked by the tables, with the score to report
+// for it.
+type tbRootMove struct {
+	move  move
+	rank  int
+	score int
+}
+
+// rankRootMoves ranks the root moves with the DTZ tables, or with the WDL
+// tables if there are no DTZ tables for the position. Moves are returned best
+// first. ok is false if the position could not be probed.
+func (t *Tablebase) rankRootMoves(p *Position, rootMoves moveList, useRule50 bool) (ranked []tbRootMove, dtzAvailable bool, ok bool) {
+	ranked = make([]tbRootMove, len(rootMoves))
+	for idx, theMove := range rootMoves {
+		ranked[idx].move = theMove
+	}
+
+	if t.rootProbe(p, ranked, useRule50) {
+		dtzAvailable = true
+	} else if !t.rootProbeWDL(p, ranked, useRule50) {
+		return nil, false, false
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool { return ranked[i].rank > ranked[j].rank })
+	return ranked, dtzAvailable, true
+}
+
+// tbWinScore is the score of a win found in the tables, just short of the
+// scores of forced mates.
+const tbWinScore = mateScore - maxPly - 1
+
+func (t *Tablebase) rootProbe(p *Position, ranked []tbRootMove, useRule50 bool) bool {
+	cnt50 := int(p.halfMoveClock)
+	repeated := p.hasRepeated()
+	bound := 1
+	if useRule50 {
+		bound = maxDTZ - 100
+	}
+	pawnValue := pieceValues[pawn].endgame
+
+	state := tbProbeOK
+	for idx := range ranked {
+		theMove := ranked[idx].move
+		p.makeMove(theMove)
+
+		var dtz int
+		switch {
+		case p.halfMoveClock == 0:
+			dtz = dtzBeforeZeroing(-t.probeWDL(p, &state))
+		case p.halfMoveClock >= 100 || p.repetitionCount() >= 2:
+			dtz = 0
+		default:
+			dtz = -t.probeDTZ(p, &state)
+			dtz += sign(dtz)
+		}
+
+		// a mating move is one ply from zeroing the counter
+		if dtz == 2 && len(p.legalMoves) == 0 && p.inCheck(p.activeColour) {
+			dtz = 1
+		}
+
+		p.unmakeMove(theMove)
+		if state == tbProbeFailed {
+			return false
+		}
+
+		// certain wins rank equally; losses rank equally unless the fifty move
+		// rule might save them
+		var rank int
+		switch {
+		case dtz > 0 && dtz+cnt50 <= 99 && !repeated:
+			rank = maxDTZ
+		case dtz > 0:
+			rank = maxDTZ - (dtz + cnt50)
+		case dtz < 0 && -dtz*2+cnt50 < 100:
+			rank = -maxDTZ
+		case dtz < 0:
+			rank = -maxDTZ + (-dtz + cnt50)
+		}
+		ranked[idx].rank = rank
+
+		// cursed wins score a little above a draw, more so the nearer they are
+		// to being real wins
+		switch {
+		case rank >= bound:
+			ranked[idx].score = tbWinScore
+		case rank > 0:
+			ranked[idx].score = max(3, rank-(maxDTZ-200)) * pawnValue / 200
+		case rank == 0:
+			ranked[idx].score = 0
+		case rank > -bound:
+			ranked[idx].score = min(-3, rank+(maxDTZ-200)) * pawnValue / 200
+		default:
+			ranked[idx].score = -tbWinScore
+		}
+	}
+	return true
+}
+
+func (t *Tablebase) rootProbeWDL(p *Position, ranked []tbRootMove, useRule50 bool) bool {
+	wdlToRank := [5]int{-maxDTZ, -maxDTZ + 101, 0, maxDTZ - 101, maxDTZ}
+	wdlToScore := [5]int{-tbWinScore, -2, 0, 2, tbWinScore}
+
+	state := tbProbeOK
+	for idx := range ranked {
+		theMove := ranked[idx].move
+		p.makeMove(theMove)
+		wdl := WDLDraw
+		if p.halfMoveClock < 100 && p.repetitionCount() < 2 {
+			wdl = -t.probeWDL(p, &state)
+		}
+		p.unmakeMove(theMove)
+		if state == tbProbeFailed {
+			return false
+		}
+
+		ranked[idx].rank = wdlToRank[wdl+2]
+		if !useRule50 {
+			wdl = WDL(2 * sign(int(wdl)))
+		}
+		ranked[idx].score = wdlToScore[wdl+2]
+	}
+	return true
+}
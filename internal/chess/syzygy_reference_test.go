@@ -0,0 +1,461 @@
+package chess
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// tbReference indexes positions as the published tables do. It is written
+// after Fathom's prober rather than from the index in syzygy_probe.go, which
+// follows Stockfish's, and uses none of the prober's tables. The tables in
+// testdata/syzygy are written with it, so that reading them checks the
+// prober's index instead of repeating it.
+type tbReference struct {
+	// pieces are the codes of the pieces in table order: the leading pawns,
+	// the other pawns and then the rest
+	pieces    []byte
+	hasPawns  bool
+	kkEnc     bool
+	symmetric bool
+	// pawns counts the leading pawns and the other pawns
+	pawns [2]int
+	// norm is the length of the group that starts at each piece
+	norm []int
+	// factor multiplies the index of the group that starts at each piece, for
+	// each file of the leading pawn
+	factor [4][]uint64
+	size   [4]uint64
+}
+
+// tbRefTriangle maps each square to the one it is reflected onto in the
+// a1-d1-d4 triangle: b1, c1, d1, c2, d2 and d3 come first, then a1, b2, c3
+// and d4 on the diagonal.
+var tbRefTriangle = [64]int{
+	6, 0, 1, 2, 2, 1, 0, 6,
+	0, 7, 3, 4, 4, 3, 7, 0,
+	1, 3, 8, 5, 5, 8, 3, 1,
+	2, 4, 5, 9, 9, 5, 4, 2,
+	2, 4, 5, 9, 9, 5, 4, 2,
+	1, 3, 8, 5, 5, 8, 3, 1,
+	0, 7, 3, 4, 4, 3, 7, 0,
+	6, 0, 1, 2, 2, 1, 0, 6,
+}
+
+// tbRefLower numbers the 28 squares below the a1-h8 diagonal.
+var tbRefLower = [64]int{
+	-1, 0, 1, 2, 3, 4, 5, 6,
+	-1, -1, 7, 8, 9, 10, 11, 12,
+	-1, -1, -1, 13, 14, 15, 16, 17,
+	-1, -1, -1, -1, 18, 19, 20, 21,
+	-1, -1, -1, -1, -1, 22, 23, 24,
+	-1, -1, -1, -1, -1, -1, 25, 26,
+	-1, -1, -1, -1, -1, -1, -1, 27,
+	-1, -1, -1, -1, -1, -1, -1, -1,
+}
+
+// tbRefPawnTwist numbers the squares a pawn may stand on, from a2, h2, a3 and
+// h3 down to d7 and e7, so that any other pawn numbers lower than the leading
+// one.
+var tbRefPawnTwist = [64]int{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	47, 35, 23, 11, 10, 22, 34, 46,
+	45, 33, 21, 9, 8, 20, 32, 44,
+	43, 31, 19, 7, 6, 18, 30, 42,
+	41, 29, 17, 5, 4, 16, 28, 40,
+	39, 27, 15, 3, 2, 14, 26, 38,
+	37, 25, 13, 1, 0, 12, 24, 36,
+	0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// tbRefFlap orders the squares of a leading pawn: nearest the edge first, and
+// then lowest.
+var tbRefFlap = [64]int{
+	0, 0, 0, 0, 0, 0, 0, 0,
+	0, 6, 12, 18, 18, 12, 6, 0,
+	1, 7, 13, 19, 19, 13, 7, 1,
+	2, 8, 14, 20, 20, 14, 8, 2,
+	3, 9, 15, 21, 21, 15, 9, 3,
+	4, 10, 16, 22, 22, 16, 10, 4,
+	5, 11, 17, 23, 23, 17, 11, 5,
+	0, 0, 0, 0, 0, 0, 0, 0,
+}
+
+// tbRefKK numbers the 462 placements of two kings with the first on a square
+// of tbRefTriangle, and -1 where the second cannot stand.
+var tbRefKK = func() (kk [10][64]int) {
+	triangle := []int{1, 2, 3, 10, 11, 19, 0, 9, 18, 27}
+	adjacent := func(a, b int) bool {
+		return max(a>>3-b>>3, b>>3-a>>3) <= 1 && max(a&7-b&7, b&7-a&7) <= 1
+	}
+	// the rank less the file: positive above the diagonal
+	off := func(square int) int { return square>>3 - square&7 }
+
+	code := 0
+	var last [][2]int
+	for t, first := range triangle {
+		for second := range kk[t] {
+			kk[t][second] = -1
+			switch {
+			case adjacent(first, second), off(first) == 0 && off(second) > 0:
+			case off(first) == 0 && off(second) == 0:
+				last = append(last, [2]int{t, second})
+			default:
+				kk[t][second] = code
+				code++
+			}
+		}
+	}
+	for _, placement := range last {
+		kk[placement[0]][placement[1]] = code
+		code++
+	}
+	return kk
+}()
+
+func tbRefBinomial(n, k int) uint64 {
+	if k < 0 || k > n {
+		return 0
+	}
+	result := uint64(1)
+	for i := 0; i < k; i++ {
+		result = result * uint64(n-i) / uint64(i+1)
+	}
+	return result
+}
+
+// tbRefLeadPawns returns the index of the first placement of count leading
+// pawns with the first on the given square, and the number of placements on
+// its file.
+func tbRefLeadPawns(count, square int) (first, size uint64) {
+	file := min(square&7, 7-square&7)
+	for rank := 1; rank <= 6; rank++ {
+		if 8*rank+file == square {
+			first = size
+		}
+		size += tbRefBinomial(tbRefPawnTwist[8*rank+file], count-1)
+	}
+	return first, size
+}
+
+// newTBReference sets up the index of a table with the pieces in the given
+// order, given as the codes of the pieces in the table, and with the groups
+// multiplied in the given order, as in the order bytes of a table.
+func newTBReference(pieces []byte, order [2]int) *tbReference {
+	n := len(pieces)
+	r := &tbReference{pieces: pieces, hasPawns: pieces[0]&7 == 1, norm: make([]int, n)}
+
+	var counts [16]int
+	for _, piece := range pieces {
+		counts[piece]++
+	}
+	r.symmetric = slices.Equal(counts[1:7], counts[9:15])
+	unique := 0
+	for piece, count := range counts {
+		if count == 1 && piece&7 != 6 {
+			unique++
+		}
+	}
+	r.kkEnc = !r.hasPawns && unique == 0
+
+	var start int
+	switch {
+	case r.hasPawns:
+		r.pawns = [2]int{counts[pieces[0]], counts[pieces[0]^8]}
+		r.norm[0] = r.pawns[0]
+		start = r.pawns[0]
+		if r.pawns[1] > 0 {
+			r.norm[start] = r.pawns[1]
+			start += r.pawns[1]
+		}
+	case r.kkEnc:
+		r.norm[0], start = 2, 2
+	default:
+		r.norm[0], start = 3, 3
+	}
+	for i := start; i < n; i += r.norm[i] {
+		for j := i; j < n && pieces[j] == pieces[i]; j++ {
+			r.norm[i]++
+		}
+	}
+
+	files := 1
+	if r.hasPawns {
+		files = 4
+	}
+	for file := 0; file < files; file++ {
+		factor := make([]uint64, n)
+		f := uint64(1)
+		i := r.norm[0]
+		if r.hasPawns && r.pawns[1] > 0 {
+			i += r.norm[i]
+		}
+		free := 64 - i
+		for k := 0; i < n || k == order[0] || k == order[1]; k++ {
+			switch {
+			case k == order[0]:
+				factor[0] = f
+				switch {
+				case r.hasPawns:
+					_, size := tbRefLeadPawns(r.norm[0], 8+file)
+					f *= size
+				case r.kkEnc:
+					f *= 462
+				default:
+					f *= 31332
+				}
+			case k == order[1]:
+				factor[r.norm[0]] = f
+				f *= tbRefBinomial(48-r.norm[0], r.norm[r.norm[0]])
+			default:
+				factor[i] = f
+				f *= tbRefBinomial(free, r.norm[i])
+				free -= r.norm[i]
+				i += r.norm[i]
+			}
+		}
+		r.factor[file], r.size[file] = factor, f
+	}
+	return r
+}
+
+// tbRefCode is the code of the piece on a square in the tables.
+func tbRefCode(theState squareState) byte {
+	letter := byte(theState.toRune())
+	code := byte(strings.IndexByte("PNBRQK", letter&^0x20) + 1)
+	if letter >= 'a' {
+		code |= 8
+	}
+	return code
+}
+
+// index returns the side to move and file of the pairs data that hold a
+// position, and its index there.
+func (r *tbReference) index(p *Position) (side, file int, idx uint64) {
+	var counts [16]int
+	for square := range p.board {
+		if p.board[square] != empty {
+			counts[tbRefCode(p.board[square])]++
+		}
+	}
+	for _, piece := range r.pieces {
+		counts[piece]--
+	}
+	flip := slices.ContainsFunc(counts[:], func(count int) bool { return count != 0 }) ||
+		(r.symmetric && p.activeColour == black)
+
+	colourFlip, mirror := byte(0), 0
+	side = int(p.activeColour)
+	if flip {
+		colourFlip, mirror = 8, 56
+		side ^= 1
+	}
+
+	// the squares of the pieces, in table order and ascending among like
+	// pieces
+	squares := make([]int, 0, len(r.pieces))
+	fill := func(i int) int {
+		for square := range p.board {
+			if p.board[square] != empty && tbRefCode(p.board[square]) == r.pieces[i]^colourFlip {
+				squares = append(squares, square^mirror)
+			}
+		}
+		return len(squares)
+	}
+
+	i := fill(0)
+	if r.hasPawns {
+		for j := 1; j < r.pawns[0]; j++ {
+			if tbRefFlap[squares[0]] > tbRefFlap[squares[j]] {
+				squares[0], squares[j] = squares[j], squares[0]
+			}
+		}
+		file = min(squares[0]&7, 7-squares[0]&7)
+	}
+	for i < len(r.pieces) {
+		i = fill(i)
+	}
+	return side, file, r.encode(squares, file)
+}
+
+// encode works out the index from the squares of the pieces in table order.
+func (r *tbReference) encode(p []int, file int) uint64 {
+	n := len(p)
+	if p[0]&4 != 0 {
+		for i := range p {
+			p[i] ^= 7
+		}
+	}
+
+	var idx uint64
+	var k int
+	if r.hasPawns {
+		k = r.pawns[0]
+		// the other leading pawns, highest tbRefPawnTwist first
+		others := p[1:k]
+		slices.SortFunc(others, func(a, b int) int { return tbRefPawnTwist[b] - tbRefPawnTwist[a] })
+		idx, _ = tbRefLeadPawns(k, p[0])
+		for i := 1; i < k; i++ {
+			idx += tbRefBinomial(tbRefPawnTwist[p[i]], k-i)
+		}
+		idx *= r.factor[file][0]
+
+		if r.pawns[1] > 0 {
+			t := k + r.pawns[1]
+			idx += r.group(p, k, t, 8) * r.factor[file][k]
+			k = t
+		}
+	} else {
+		if p[0]&32 != 0 {
+			for i := range p {
+				p[i] ^= 56
+			}
+		}
+		off := func(square int) int { return square>>3 - square&7 }
+		first := 3
+		if r.kkEnc {
+			first = 2
+		}
+		for i := range p {
+			if off(p[i]) == 0 {
+				continue
+			}
+			if off(p[i]) > 0 && i < first {
+				for j := range p {
+					p[j] = (p[j]>>3 | p[j]<<3) & 63
+				}
+			}
+			break
+		}
+
+		if r.kkEnc {
+			idx = uint64(tbRefKK[tbRefTriangle[p[0]]][p[1]])
+			k = 2
+		} else {
+			s1, s2 := 0, 0
+			if p[1] > p[0] {
+				s1 = 1
+			}
+			if p[2] > p[0] {
+				s2++
+			}
+			if p[2] > p[1] {
+				s2++
+			}
+			// squares on the diagonal are numbered up it
+			diagonal := func(square int) int { return square / 9 }
+			switch {
+			case off(p[0]) != 0:
+				idx = uint64(tbRefTriangle[p[0]]*63*62 + (p[1]-s1)*62 + p[2] - s2)
+			case off(p[1]) != 0:
+				idx = uint64(6*63*62 + diagonal(p[0])*28*62 + tbRefLower[p[1]]*62 + p[2] - s2)
+			case off(p[2]) != 0:
+				idx = uint64(6*63*62 + 4*28*62 + diagonal(p[0])*7*28 + (diagonal(p[1])-s1)*28 + tbRefLower[p[2]])
+			default:
+				idx = uint64(6*63*62 + 4*28*62 + 4*7*28 + diagonal(p[0])*7*6 + (diagonal(p[1])-s1)*6 + diagonal(p[2]) - s2)
+			}
+			k = 3
+		}
+		idx *= r.factor[0][0]
+	}
+
+	for k < n {
+		t := k + r.norm[k]
+		idx += r.group(p, k, t, 0) * r.factor[file][k]
+		k = t
+	}
+	return idx
+}
+
+// group indexes the like pieces in p[k:t] among the squares the pieces before
+// them leave free, less the given number of squares at the start.
+func (r *tbReference) group(p []int, k, t, skip int) uint64 {
+	slices.Sort(p[k:t])
+	var s uint64
+	for i := k; i < t; i++ {
+		skips := 0
+		for _, previous := range p[:k] {
+			if p[i] > previous {
+				skips++
+			}
+		}
+		s += tbRefBinomial(p[i]-skips-skip, i-k+1)
+	}
+	return s
+}
+
+// TestSyzygyReferenceIndex checks the prober's index against tbReference for
+// layouts the 3 piece tables in testdata/syzygy do not have: like pieces,
+// several leading pawns, pawns on both sides and groups multiplied out of
+// order. It samples positions with either colour as the stronger side.
+func TestSyzygyReferenceIndex(t *testing.T) {
+	type testCase struct {
+		name     string
+		material string
+		pieces   []byte
+		order    [2]int
+	}
+
+	testCases := []testCase{
+		{"unique pieces", "KRvKN", []byte{4, 6, 14, 10}, [2]int{0, 0xf}},
+		{"like pieces", "KRRvK", []byte{6, 14, 4, 4}, [2]int{0, 0xf}},
+		{"like pieces out of order", "KRRvK", []byte{6, 14, 4, 4}, [2]int{1, 0xf}},
+		{"like and unique pieces", "KNNvKB", []byte{11, 6, 14, 2, 2}, [2]int{1, 0xf}},
+		{"two leading pawns", "KPPvK", []byte{1, 1, 6, 14}, [2]int{0, 0xf}},
+		{"black pawn leading", "KRvKP", []byte{9, 4, 6, 14}, [2]int{0, 0xf}},
+		{"pawns on both sides", "KPvKP", []byte{1, 9, 6, 14}, [2]int{0, 1}},
+		{"pawns on both sides out of order", "KPvKP", []byte{1, 9, 6, 14}, [2]int{1, 0}},
+		{"pawns and pieces", "KRPvKP", []byte{1, 9, 6, 14, 4}, [2]int{2, 0}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		table, err := tbLayout(c.material, c.pieces, c.order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reference := newTBReference(c.pieces, c.order)
+		files := 1
+		if reference.hasPawns {
+			files = 4
+		}
+		for file := 0; file < files; file++ {
+			if size := table.get(0, file).size(); size != reference.size[file] {
+				t.Fatalf("expected %d positions on file %d, got %d", reference.size[file], file, size)
+			}
+		}
+
+		solution, err := newTBSolution(c.material)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an odd step samples every square of every piece
+		sampled := 0
+		for key := 0; key < solution.size(); key += solution.size()/50000 | 1 {
+			thePosition, ok := solution.position(key)
+			if !ok {
+				continue
+			}
+			if sampled%2 == 1 {
+				thePosition.Flip()
+			}
+			sampled++
+
+			side, file, idx := table.locate(&thePosition)
+			refSide, refFile, refIdx := reference.index(&thePosition)
+			if side != refSide || file != refFile || idx != refIdx {
+				t.Fatalf("%s: expected side %d, file %d and index %d, got %d, %d and %d",
+					thePosition.GetFEN().String(), refSide, refFile, refIdx, side, file, idx)
+			}
+			if idx >= reference.size[file] {
+				t.Fatalf("%s: index %d is not below %d", thePosition.GetFEN().String(), idx, reference.size[file])
+			}
+		}
+		if sampled == 0 {
+			t.Fatal("no positions sampled")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
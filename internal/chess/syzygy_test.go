@@ -0,0 +1,553 @@
+package chess
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func loadPosition(t *testing.T, fen string) Position {
+	t.Helper()
+	parsed, err := ParseFEN(fen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thePosition := Position{}
+	if err := thePosition.LoadFEN(parsed); err != nil {
+		t.Fatal(err)
+	}
+	return thePosition
+}
+
+func TestSyzygyIndexTables(t *testing.T) {
+	kkCodes := map[int]bool{}
+	for idx := range tbMapKK {
+		for _, code := range tbMapKK[idx] {
+			kkCodes[code] = true
+		}
+	}
+	if len(kkCodes) != 462 {
+		t.Errorf("expected 462 placements of two kings, got %d", len(kkCodes))
+	}
+
+	pawnCodes := map[int]bool{}
+	for square := 8; square < 56; square++ {
+		pawnCodes[tbMapPawns[square]] = true
+	}
+	if len(pawnCodes) != 48 {
+		t.Errorf("expected 48 pawn squares, got %d", len(pawnCodes))
+	}
+
+	if tbBinomial[2][5] != 10 || tbBinomial[5][63] != 7028847 {
+		t.Errorf("unexpected binomials %d and %d", tbBinomial[2][5], tbBinomial[5][63])
+	}
+	if tbLeadPawnsSize[1][0] != 6 {
+		t.Errorf("expected 6 placements of a single leading pawn, got %d", tbLeadPawnsSize[1][0])
+	}
+}
+
+// symmetries of the board that the tables use: all eight for pawnless tables,
+// and only left to right for tables with pawns.
+var (
+	flipFile     = func(square int) int { return square ^ 7 }
+	flipRank     = func(square int) int { return square ^ 56 }
+	flipDiagonal = func(square int) int { return (square>>3 | square<<3) & 63 }
+
+	pawnlessSymmetries = [][]func(int) int{
+		{}, {flipFile}, {flipRank}, {flipFile, flipRank},
+		{flipDiagonal}, {flipDiagonal, flipFile}, {flipDiagonal, flipRank}, {flipDiagonal, flipFile, flipRank},
+	}
+	pawnSymmetries = [][]func(int) int{{}, {flipFile}}
+)
+
+// TestSyzygyEncode checks that equivalent placements of pieces share an index
+// within the size of the table, and that others do not.
+func TestSyzygyEncode(t *testing.T) {
+	type testCase struct {
+		name string
+		// pieces in table order, leading pawns first
+		pieces []byte
+		order  [2]int
+		// squares each piece may stand on, to keep the test quick
+		squares [][]int
+	}
+
+	all, pawnSquares, someSquares := []int{}, []int{}, []int{}
+	for square := 0; square < 64; square++ {
+		all = append(all, square)
+		if square >= 8 && square < 56 {
+			pawnSquares = append(pawnSquares, square)
+		}
+		if square%5 == 0 {
+			someSquares = append(someSquares, square)
+		}
+	}
+
+	testCases := []testCase{
+		{"kings", []byte{6, 14}, [2]int{0, 0xf}, [][]int{all, all}},
+		{"unique pieces", []byte{5, 6, 14}, [2]int{0, 0xf}, [][]int{all, all, all}},
+		{"like pieces", []byte{6, 14, 4, 4}, [2]int{0, 0xf}, [][]int{someSquares, all, someSquares, all}},
+		{"pawns", []byte{1, 6, 14}, [2]int{0, 0xf}, [][]int{pawnSquares, all, all}},
+		{"two pawns", []byte{1, 1, 6, 14}, [2]int{0, 0xf}, [][]int{pawnSquares, pawnSquares, someSquares, someSquares}},
+		{"pawns on both sides", []byte{1, 9, 6, 14}, [2]int{0, 1}, [][]int{pawnSquares, pawnSquares, someSquares, someSquares}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		table := &tbTable{pieceCount: len(c.pieces)}
+		counts := map[byte]int{}
+		for _, piece := range c.pieces {
+			counts[piece]++
+		}
+		for piece, count := range counts {
+			table.hasUniquePieces = table.hasUniquePieces || (count == 1 && piece&7 != 6)
+		}
+		if c.pieces[0]&7 == 1 {
+			table.hasPawns = true
+			table.pawnCount = [2]int{counts[c.pieces[0]], counts[c.pieces[0]^8]}
+		}
+		leadPawnsCount := 0
+		if table.hasPawns {
+			leadPawnsCount = table.pawnCount[0]
+		}
+
+		var d [4]*pairsData
+		for file := range d {
+			d[file] = &pairsData{}
+			copy(d[file].pieces[:], c.pieces)
+			table.setGroups(d[file], c.order, file)
+		}
+
+		symmetries := pawnSymmetries
+		if !table.hasPawns {
+			symmetries = pawnlessSymmetries
+		}
+
+		type key struct {
+			file int
+			idx  uint64
+		}
+		owners := map[key][]int{}
+
+		var place func(squares []int)
+		place = func(squares []int) {
+			if len(squares) < len(c.pieces) {
+			next:
+				for _, square := range c.squares[len(squares)] {
+					for _, taken := range squares {
+						if taken == square {
+							continue next
+						}
+					}
+					place(append(squares, square))
+				}
+				return
+			}
+
+			// placements of kings next to each other are left out of the
+			// tables
+			kings := []int{}
+			for i, piece := range c.pieces {
+				if piece&7 == 6 {
+					kings = append(kings, squares[i])
+				}
+			}
+			if kingControlFrom[kings[0]].get(coordinate(kings[1])) {
+				return
+			}
+
+			encoded := append([]int{}, squares...)
+			file := 0
+			if table.hasPawns {
+				lead := 0
+				for i := 1; i < leadPawnsCount; i++ {
+					if tbMapPawns[encoded[i]] > tbMapPawns[encoded[lead]] {
+						lead = i
+					}
+				}
+				encoded[0], encoded[lead] = encoded[lead], encoded[0]
+				file = min(encoded[0]&7, 7-(encoded[0]&7))
+			}
+			idx := table.encode(d[file], encoded, leadPawnsCount)
+			if idx >= d[file].size() {
+				t.Fatalf("index %d of %v is not below %d", idx, squares, d[file].size())
+			}
+
+			k := key{file, idx}
+			owner, seen := owners[k]
+			if !seen {
+				owners[k] = append([]int{}, squares...)
+				return
+			}
+			for _, symmetry := range symmetries {
+				transformed := append([]int{}, squares...)
+				for i := range transformed {
+					for _, f := range symmetry {
+						transformed[i] = f(transformed[i])
+					}
+				}
+				if samePlacement(c.pieces, transformed, owner) {
+					return
+				}
+			}
+			t.Fatalf("%v and %v share index %d", owner, squares, idx)
+		}
+		place(nil)
+
+		if len(owners) == 0 {
+			t.Fatal("no placements")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+// samePlacement reports whether two placements put the same pieces on the
+// same squares, in any order among like pieces.
+func samePlacement(pieces []byte, a, b []int) bool {
+	for i, piece := range pieces {
+		found := false
+		for j := range pieces {
+			if pieces[j] == piece && b[j] == a[i] {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSyzygyDecompress(t *testing.T) {
+	// Three symbols with codes of one or two bits: 1 for symbol 2, 00 for
+	// symbol 0 and 01 for symbol 1. Symbols 0 and 1 stand for the values 0 and
+	// 2, and symbol 2 for the pair of them.
+	var header bytes.Buffer
+	header.Write([]byte{0, 5, 3, 0})
+	binary.Write(&header, binary.LittleEndian, uint32(1))
+	header.Write([]byte{2, 1})
+	binary.Write(&header, binary.LittleEndian, []uint16{2, 0, 3})
+	header.Write([]byte{0x00, 0xf0, 0xff, 0x02, 0xf0, 0xff, 0x00, 0x10, 0x00, 0})
+
+	d := &pairsData{}
+	d.groupLen[0], d.groupIdx[0], d.groupIdx[1] = 1, 1, 6
+	r := &tbReader{r: bytes.NewReader(header.Bytes())}
+	if err := d.setSizes(r); err != nil {
+		t.Fatal(err)
+	}
+	if r.pos != int64(header.Len()) {
+		t.Fatalf("expected to read %d bytes, read %d", header.Len(), r.pos)
+	}
+
+	// the sparse index points at the value in the middle of the first span
+	d.sparseIndex = []byte{0, 0, 0, 0, 4, 0}
+	d.blockLength = []uint16{5}
+	block := make([]byte, 32)
+	block[0] = 0b10100100
+
+	var values []int
+	for idx := uint64(0); idx < 6; idx++ {
+		value, err := d.decompress(bytes.NewReader(block), idx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, value)
+	}
+	if expected := []int{0, 2, 2, 0, 0, 2}; !reflect.DeepEqual(values, expected) {
+		t.Errorf("expected %v, got %v", expected, values)
+	}
+}
+
+// writeSingleValueTable writes a pawnless table in which every position has
+// the same value for each side to move, as the generator does for tables such
+// as KQvK where one value compresses everything. It is enough to exercise
+// everything but the decompression.
+func writeSingleValueTable(t *testing.T, path string, pieces []byte, values []byte, dtzFlags byte) {
+	t.Helper()
+	var b bytes.Buffer
+	if filepath.Ext(path) == tbSuffixDTZ {
+		b.Write(tbMagicDTZ[:])
+		b.WriteByte(0)
+	} else {
+		b.Write(tbMagicWDL[:])
+		b.WriteByte(tbHeaderSplit)
+	}
+
+	b.WriteByte(0)
+	for _, piece := range pieces {
+		b.WriteByte(piece | piece<<4)
+	}
+	if b.Len()%2 != 0 {
+		b.WriteByte(0)
+	}
+
+	for _, value := range values {
+		b.Write([]byte{tbFlagSingleValue | dtzFlags, value})
+	}
+	for b.Len()%64 != 16 {
+		b.WriteByte(0)
+	}
+
+	if err := os.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// openTestTablebase writes KQvK tables in which white to move always wins,
+// which is true, and black to move always loses, which is true unless black
+// can take the queen or is stalemated. The DTZ table holds white to move,
+// with every win 9 plies from zeroing.
+func openTestTablebase(t *testing.T, withDTZ bool) *Tablebase {
+	t.Helper()
+	dir := t.TempDir()
+	writeSingleValueTable(t, filepath.Join(dir, "KQvK.rtbw"), []byte{5, 6, 14}, []byte{4, 0}, 0)
+	if withDTZ {
+		writeSingleValueTable(t, filepath.Join(dir, "KQvK.rtbz"), []byte{5, 6, 14}, []byte{4}, 0)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a table"), 0o644)
+
+	tablebase, err := OpenTablebase([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tablebase.Close() })
+	return tablebase
+}
+
+func TestTablebaseProbe(t *testing.T) {
+	tablebase := openTestTablebase(t, true)
+	if tablebase.MaxPieces() != 3 {
+		t.Errorf("expected tables of up to 3 pieces, got %d", tablebase.MaxPieces())
+	}
+	if names := tablebase.tablebaseNames(); !reflect.DeepEqual(names, []string{"KQvK.rtbw", "KQvK.rtbz"}) {
+		t.Errorf("unexpected tables %v", names)
+	}
+
+	type testCase struct {
+		name        string
+		fen         string
+		expectedOK  bool
+		expectedWDL WDL
+		expectedDTZ int
+	}
+
+	testCases := []testCase{
+		{"side with the queen to move", "8/8/8/8/8/8/8/KQ5k w - - 0 1", true, WDLWin, 9},
+		{"side without the queen to move", "8/8/8/8/8/8/8/KQ5k b - - 0 1", true, WDLLoss, -10},
+		{"queen can be taken", "8/8/8/8/8/8/6Qk/K7 b - - 0 1", true, WDLDraw, 0},
+		{"black has the queen", "8/8/8/8/8/8/8/kq5K b - - 0 1", true, WDLWin, 9},
+		{"bare kings", "8/8/8/8/8/8/8/K6k w - - 0 1", true, WDLDraw, 0},
+		{"no table", "8/8/8/8/8/8/8/KR5k w - - 0 1", false, WDLDraw, 0},
+		{"too many pieces", "8/8/8/8/8/8/8/KQR4k w - - 0 1", false, WDLDraw, 0},
+		{"castling rights", "4k3/8/8/8/8/8/8/4K2R w K - 0 1", false, WDLDraw, 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		wdl, ok := tablebase.ProbeWDL(thePosition)
+		if ok != c.expectedOK || wdl != c.expectedWDL {
+			t.Errorf("expected WDL %v (%v), got %v (%v)", c.expectedWDL, c.expectedOK, wdl, ok)
+		}
+		dtz, ok := tablebase.ProbeDTZ(thePosition)
+		if ok != c.expectedOK || dtz != c.expectedDTZ {
+			t.Errorf("expected DTZ %v (%v), got %v (%v)", c.expectedDTZ, c.expectedOK, dtz, ok)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSearchWithTablebase(t *testing.T) {
+	type testCase struct {
+		name string
+		fen  string
+		// the DTZ table decides at the root; without it the search has to
+		// find the win by probing the WDL table after the capture
+		withDTZ bool
+		params  SearchParameters
+		// every move but these keeps the win
+		blunders      []string
+		expectedMove  string
+		expectedScore int
+	}
+
+	testCases := []testCase{
+		{
+			"root in tablebase",
+			"8/8/8/8/8/2k5/8/KQ6 w - - 0 1",
+			true,
+			SearchParameters{Depth: 3},
+			[]string{"b1c2", "b1b3", "b1d3"},
+			"",
+			tbWinScore,
+		},
+		{
+			"capture into tablebase",
+			"7k/8/8/8/3n4/8/8/K2Q4 w - - 0 1",
+			false,
+			SearchParameters{Depth: 2, TBProbeDepth: 1},
+			nil,
+			"d1d4",
+			tbWinScore - 1,
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		tablebase := openTestTablebase(t, c.withDTZ)
+		c.params.Tablebase = tablebase
+
+		var lastInfo SearchInfo
+		searcher := Searcher{}
+		bestMove, _ := searcher.Search(context.Background(), loadPosition(t, c.fen), c.params, func(info SearchInfo) { lastInfo = info })
+
+		if c.expectedMove != "" && bestMove != c.expectedMove {
+			t.Errorf("expected best move %s, got %s", c.expectedMove, bestMove)
+		}
+		for _, blunder := range c.blunders {
+			if bestMove == blunder {
+				t.Errorf("expected anything but %s", blunder)
+			}
+		}
+		if lastInfo.Score != c.expectedScore || lastInfo.MateIn != 0 {
+			t.Errorf("expected score %d, got %d (mate in %d)", c.expectedScore, lastInfo.Score, lastInfo.MateIn)
+		}
+		if lastInfo.TBHits == 0 {
+			t.Error("expected tablebase hits")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+// TestSyzygyFiles probes the 3 piece tables in testdata/syzygy, which were
+// written by TestGenerateSyzygy rather than taken from the published set, with
+// an index of its own. It checks some well known results, then a sample of the
+// positions of every table, with either colour as the stronger side, against
+// the results the tables were written from.
+func TestSyzygyFiles(t *testing.T) {
+	tablebase, err := OpenTablebase([]string{filepath.Join("testdata", "syzygy")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tablebase.Close()
+
+	type testCase struct {
+		name        string
+		fen         string
+		expectedWDL WDL
+		expectedDTZ int
+	}
+
+	testCases := []testCase{
+		{"queen wins", "8/8/8/8/8/8/8/KQ5k w - - 0 1", WDLWin, 9},
+		{"queen is lost", "8/8/8/8/8/8/6Qk/K7 b - - 0 1", WDLDraw, 0},
+		{"stalemate", "7k/5Q2/6K1/8/8/8/8/8 b - - 0 1", WDLDraw, 0},
+		{"mated", "7k/6Q1/6K1/8/8/8/8/8 b - - 0 1", WDLLoss, -1},
+		{"mate in one", "7k/8/6K1/8/8/8/8/3Q4 w - - 0 1", WDLWin, 1},
+		{"rook wins", "8/8/8/3k4/8/8/8/KR6 b - - 0 1", WDLLoss, -30},
+		{"knight cannot win", "8/8/8/8/8/8/8/KN5k w - - 0 1", WDLDraw, 0},
+		{"pawn runs", "8/8/8/8/8/8/4P3/k3K3 w - - 0 1", WDLWin, 1},
+		{"king in front of pawn", "4k3/8/8/8/8/8/4P3/4K3 b - - 0 1", WDLDraw, 0},
+		{"opposition wins", "4k3/8/4K3/4P3/8/8/8/8 b - - 0 1", WDLLoss, -4},
+		{"black pawn runs", "4k2K/3p4/8/8/8/8/8/8 b - - 0 1", WDLWin, 1},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		if wdl, ok := tablebase.ProbeWDL(thePosition); !ok || wdl != c.expectedWDL {
+			t.Errorf("expected WDL %v, got %v (%v)", c.expectedWDL, wdl, ok)
+		}
+		if dtz, ok := tablebase.ProbeDTZ(thePosition); !ok || dtz != c.expectedDTZ {
+			t.Errorf("expected DTZ %v, got %v (%v)", c.expectedDTZ, dtz, ok)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+
+	// every seventh key samples positions on every square, both sides to
+	// move, and flipping every other one gives black the extra piece
+	for name, solution := range solveSyzygyTestTables(t) {
+		t.Run(name, func(t *testing.T) {
+			sampled := 0
+			for key := 0; key < solution.size(); key += 7 {
+				legal, expectedWDL, expectedDTZ := solution.result(key)
+				if !legal {
+					continue
+				}
+				thePosition, _ := solution.position(key)
+				if sampled%2 == 1 {
+					thePosition.Flip()
+				}
+				sampled++
+
+				wdl, ok := tablebase.ProbeWDL(thePosition)
+				dtz, dtzOK := tablebase.ProbeDTZ(thePosition)
+				if !ok || !dtzOK || wdl != expectedWDL || dtz != expectedDTZ {
+					t.Fatalf("%s: expected %v and DTZ %d, got %v (%v) and DTZ %d (%v)",
+						thePosition.GetFEN().String(), expectedWDL, expectedDTZ, wdl, ok, dtz, dtzOK)
+				}
+			}
+		})
+	}
+}
+
+// TestSyzygyPublished probes the published 3 piece tables against the results
+// worked out by retrograde analysis, so that the prober is checked against the
+// files it is meant to read. It only runs when SYZYGY_PUBLISHED names a
+// directory holding them. The published DTZ tables store some distances in
+// moves rather than plies, which can make them a ply longer.
+func TestSyzygyPublished(t *testing.T) {
+	dir := os.Getenv("SYZYGY_PUBLISHED")
+	if dir == "" {
+		t.Skip("SYZYGY_PUBLISHED is not set")
+	}
+	tablebase, err := OpenTablebase([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tablebase.Close()
+
+	for name, solution := range solveSyzygyTestTables(t) {
+		t.Run(name, func(t *testing.T) {
+			if !slices.Contains(tablebase.tablebaseNames(), name+tbSuffixWDL) ||
+				!slices.Contains(tablebase.tablebaseNames(), name+tbSuffixDTZ) {
+				t.Fatalf("%s is missing from %s", name, dir)
+			}
+
+			sampled := 0
+			for key := 0; key < solution.size(); key += 7 {
+				legal, expectedWDL, expectedDTZ := solution.result(key)
+				if !legal {
+					continue
+				}
+				thePosition, _ := solution.position(key)
+				if sampled%2 == 1 {
+					thePosition.Flip()
+				}
+				sampled++
+
+				wdl, ok := tablebase.ProbeWDL(thePosition)
+				dtz, dtzOK := tablebase.ProbeDTZ(thePosition)
+				longer := max(dtz, -dtz) - max(expectedDTZ, -expectedDTZ)
+				if !ok || !dtzOK || wdl != expectedWDL || dtz*expectedDTZ < 0 || (dtz == 0) != (expectedDTZ == 0) ||
+					longer < 0 || longer > 1 {
+					t.Fatalf("%s: expected %v and DTZ %d, got %v (%v) and DTZ %d (%v)",
+						thePosition.GetFEN().String(), expectedWDL, expectedDTZ, wdl, ok, dtz, dtzOK)
+				}
+			}
+		})
+	}
+}
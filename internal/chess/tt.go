@@ -0,0 +1,113 @@
+package chess
+
+import "math/bits"
+
+// bound says how a score stored in the transposition table relates to the
+// true score of the position.
+type bound uint8
+
+const (
+	boundNone bound = iota
+	// boundUpper scores are at least the true score: every move failed low.
+	boundUpper
+	// boundLower scores are at most the true score: a move failed high.
+	boundLower
+	boundExact
+)
+
+// ttEntrySize is the size of a ttEntry in bytes.
+const ttEntrySize = 16
+
+type ttEntry struct {
+	key   uint64
+	move  move
+	score int16
+	depth int8
+	bound bound
+}
+
+// TranspositionTable remembers the results of searching positions, keyed by
+// their Zobrist hash, so that positions reached again by another order of
+// moves or in a later iteration need not be searched afresh. It may be shared
+// by searches, one at a time.
+type TranspositionTable struct {
+	entries []ttEntry
+}
+
+// NewTranspositionTable allocates a table of about the given size.
+func NewTranspositionTable(megabytes int) *TranspositionTable {
+	size := max(megabytes, 1) << 20 / ttEntrySize
+	return &TranspositionTable{entries: make([]ttEntry, size)}
+}
+
+// Clear forgets everything in the table, as when starting a new game.
+func (t *TranspositionTable) Clear() {
+	clear(t.entries)
+}
+
+// index maps a hash to a slot by the high bits of their product, which spreads
+// hashes evenly over any number of slots.
+func (t *TranspositionTable) index(hash uint64) int {
+	high, _ := bits.Mul64(hash, uint64(len(t.entries)))
+	return int(high)
+}
+
+// probe returns the entry stored for a position, with its score as seen from
+// ply, and whether there was one.
+func (t *TranspositionTable) probe(hash uint64, ply int) (ttEntry, bool) {
+	entry := t.entries[t.index(hash)]
+	if entry.key != hash || entry.bound == boundNone {
+		return ttEntry{}, false
+	}
+	entry.score = int16(scoreFromTT(int(entry.score), ply))
+	return entry, true
+}
+
+// store records the result of searching a position at ply. A shallower search
+// of another position only replaces a deeper one if it is exact, and a store
+// without a move keeps the move already known for the position.
+func (t *TranspositionTable) store(hash uint64, theMove move, score int, depth int, theBound bound, ply int) {
+	entry := &t.entries[t.index(hash)]
+	if entry.key == hash {
+		if theMove == noMove {
+			theMove = entry.move
+		}
+	} else if entry.bound != boundNone && theBound != boundExact && depth < int(entry.depth)-2 {
+		return
+	}
+
+	*entry = ttEntry{
+		key:   hash,
+		move:  theMove,
+		score: int16(scoreToTT(score, ply)),
+		depth: int8(depth),
+		bound: theBound,
+	}
+}
+
+// minKnownWin is the lowest score that means a mate or tablebase win, both of
+// which depend on the distance from the root.
+const minKnownWin = tbWinScore - maxPly
+
+// scoreToTT makes mate and tablebase scores relative to the position being
+// stored rather than to the root, as the position may be reached at another
+// ply.
+func scoreToTT(score int, ply int) int {
+	switch {
+	case score >= minKnownWin:
+		return score + ply
+	case score <= -minKnownWin:
+		return score - ply
+	}
+	return score
+}
+
+func scoreFromTT(score int, ply int) int {
+	switch {
+	case score >= minKnownWin:
+		return score - ply
+	case score <= -minKnownWin:
+		return score + ply
+	}
+	return score
+}
@@ -0,0 +1,68 @@
+package chess
+
+import "testing"
+
+func TestTranspositionTable(t *testing.T) {
+	type testCase struct {
+		name          string
+		score         int
+		storePly      int
+		probePly      int
+		expectedScore int
+	}
+
+	testCases := []testCase{
+		{"plain score", 35, 4, 7, 35},
+		{"mate found deeper", mateScore - 10, 4, 2, mateScore - 8},
+		{"mated found shallower", -mateScore + 10, 4, 6, -mateScore + 12},
+		{"tablebase win", tbWinScore - 5, 5, 3, tbWinScore - 3},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		table := NewTranspositionTable(1)
+		theMove := moveFromParts(e2, e4, empty, empty, 0, nullCoordinate)
+		table.store(0x1234, theMove, c.score, 5, boundExact, c.storePly)
+
+		entry, ok := table.probe(0x1234, c.probePly)
+		if !ok {
+			t.Fatal("expected a hit")
+		}
+		if int(entry.score) != c.expectedScore {
+			t.Errorf("expected score %v, got %v", c.expectedScore, entry.score)
+		}
+		if entry.move != theMove || entry.depth != 5 || entry.bound != boundExact {
+			t.Errorf("expected the stored move, depth and bound, got %+v", entry)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestTranspositionTableReplacement(t *testing.T) {
+	table := NewTranspositionTable(1)
+	theMove := moveFromParts(e2, e4, empty, empty, 0, nullCoordinate)
+	// a hash that lands in the same slot as another
+	const hash, other = 0x10, 0x20
+	if table.index(hash) != table.index(other) {
+		t.Fatal("expected the hashes to share a slot")
+	}
+
+	table.store(hash, theMove, 10, 8, boundLower, 0)
+	table.store(other, noMove, 20, 2, boundUpper, 0)
+	if _, ok := table.probe(other, 0); ok {
+		t.Error("expected a shallow search not to replace a deep one")
+	}
+
+	table.store(hash, noMove, 30, 3, boundUpper, 0)
+	entry, ok := table.probe(hash, 0)
+	if !ok || entry.move != theMove || entry.score != 30 {
+		t.Errorf("expected the new result with the old move, got %+v", entry)
+	}
+
+	table.Clear()
+	if _, ok := table.probe(hash, 0); ok {
+		t.Error("expected nothing after clearing")
+	}
+}
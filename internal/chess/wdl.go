@@ -0,0 +1,103 @@
+package chess
+
+import "math"
+
+// WinDrawLoss is the expected outcome of a game in per mille for the side to
+// move, which sums to 1000.
+type WinDrawLoss struct {
+	Win  int
+	Draw int
+	Loss int
+}
+
+// WDLModel turns scores into the chances of winning, drawing and losing. The
+// chance of winning is a logistic curve in the score, centred on the score
+// at which a win is as likely as not and as steep as its spread, and the
+// chance of losing is the same curve mirrored. Both the centre and the spread
+// are linear in the WDLFeatures of the position, as a pawn is worth more as
+// the board empties and the game goes on.
+type WDLModel struct {
+	Centre [WDLFeatureCount]float64
+	Spread [WDLFeatureCount]float64
+}
+
+const (
+	// WDLFeatureCount is the number of WDLFeatures.
+	WDLFeatureCount = 3
+
+	// wdlMaterial is the material at the start of a game, counting pawns as
+	// one, minor pieces as three, rooks as five and queens as nine.
+	wdlMaterial = 78
+	// wdlPlies is the number of plies that counts as a long game, beyond
+	// which the ply feature stops growing.
+	wdlPlies = 200
+)
+
+// DefaultWDLModel was fitted to the 31530 positions kept from the engine's
+// games against itself by
+//
+//	karei datagen --nodes 5000 --random-plies 4 --games 300 --seed 1 wdl.bin
+//	karei fitwdl wdl.bin
+//
+// and refitting from it changes nothing. A third of those games were drawn,
+// so it expects fewer draws than stronger play would give.
+var DefaultWDLModel = WDLModel{
+	Centre: [WDLFeatureCount]float64{-83.97, 165.51, 493.19},
+	Spread: [WDLFeatureCount]float64{12.20, 138.04, 120.64},
+}
+
+// WDLFeatures returns the terms a WDLModel weighs: a constant, the share of
+// the starting material left on the board and the share of a long game that
+// has been played.
+func WDLFeatures(p *Position) [WDLFeatureCount]float64 {
+	material := 0
+	for theState := whiteQueen; theState < empty; theState++ {
+		material += wdlPieceValues[theState.getPieceType()] * p.pieceColourTypeCounter[theState]
+	}
+
+	ply := 2 * (p.fullMoveNumber - 1)
+	if p.activeColour == black {
+		ply++
+	}
+
+	return [WDLFeatureCount]float64{1, float64(material) / wdlMaterial, float64(min(max(ply, 0), wdlPlies)) / wdlPlies}
+}
+
+var wdlPieceValues = [6]int{
+	king:   0,
+	queen:  9,
+	rook:   5,
+	bishop: 3,
+	knight: 3,
+	pawn:   1,
+}
+
+// Curve returns the centre and spread of the curve for a position with the
+// features. Both are kept positive, so that a draw is never less likely than
+// nothing.
+func (m WDLModel) Curve(features [WDLFeatureCount]float64) (centre, spread float64) {
+	for idx, feature := range features {
+		centre += m.Centre[idx] * feature
+		spread += m.Spread[idx] * feature
+	}
+	return max(centre, 1), max(spread, 1)
+}
+
+// WDLProbabilities returns the chances of winning, drawing and losing with a
+// score on a curve.
+func WDLProbabilities(score, centre, spread float64) (win, draw, loss float64) {
+	win = 1 / (1 + math.Exp((centre-score)/spread))
+	loss = 1 / (1 + math.Exp((centre+score)/spread))
+	return win, 1 - win - loss, loss
+}
+
+// Predict returns the expected outcome for the side to move in the position
+// with a score in centipawns, which should not be a mate score.
+func (m WDLModel) Predict(p *Position, score int) WinDrawLoss {
+	centre, spread := m.Curve(WDLFeatures(p))
+	win, _, loss := WDLProbabilities(float64(score), centre, spread)
+
+	result := WinDrawLoss{Win: int(math.Round(1000 * win)), Loss: int(math.Round(1000 * loss))}
+	result.Draw = 1000 - result.Win - result.Loss
+	return result
+}
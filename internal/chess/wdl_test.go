@@ -0,0 +1,52 @@
+package chess
+
+import "testing"
+
+func TestWDLFeatures(t *testing.T) {
+	type testCase struct {
+		name     string
+		fen      string
+		expected [WDLFeatureCount]float64
+	}
+
+	testCases := []testCase{
+		{"starting position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", [WDLFeatureCount]float64{1, 1, 0}},
+		{"black to move", "4k3/8/8/8/8/8/PPPP4/R3K3 b - - 0 51", [WDLFeatureCount]float64{1, 9.0 / 78, 101.0 / 200}},
+		{"long game", "4k3/8/8/8/8/8/8/4K3 w - - 0 300", [WDLFeatureCount]float64{1, 0, 1}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := loadPosition(t, c.fen)
+		if features := WDLFeatures(&thePosition); features != c.expected {
+			t.Errorf("expected %v, got %v", c.expected, features)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestWDLPredict(t *testing.T) {
+	thePosition := loadPosition(t, "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+
+	even := DefaultWDLModel.Predict(&thePosition, 0)
+	if even.Win != even.Loss || even.Draw <= 0 {
+		t.Errorf("expected an even score to be balanced, got %+v", even)
+	}
+
+	previous := DefaultWDLModel.Predict(&thePosition, -1000)
+	for score := -900; score <= 1000; score += 100 {
+		wdl := DefaultWDLModel.Predict(&thePosition, score)
+		if wdl.Win+wdl.Draw+wdl.Loss != 1000 {
+			t.Errorf("%d: expected chances adding up to 1000, got %+v", score, wdl)
+		}
+		if wdl.Win < previous.Win || wdl.Loss > previous.Loss {
+			t.Errorf("%d: expected better chances than %+v, got %+v", score, previous, wdl)
+		}
+		if mirrored := DefaultWDLModel.Predict(&thePosition, -score); mirrored.Win != wdl.Loss || mirrored.Loss != wdl.Win {
+			t.Errorf("%d: expected %+v mirrored, got %+v", score, wdl, mirrored)
+		}
+		previous = wdl
+	}
+}
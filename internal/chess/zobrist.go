@@ -0,0 +1,39 @@
+package chess
+
+var zobristPieceSquare [12][64]uint64
+var zobristCastling [16]uint64
+var zobristEnPassantFile [8]uint64
+var zobristBlackToMove uint64
+
+func initZobristKeys() {
+	generator := xorShiftGenerator(0x9e3779b97f4a7c15)
+
+	for theState := whiteKing; theState < empty; theState++ {
+		for theCoord := a1; theCoord <= h8; theCoord++ {
+			zobristPieceSquare[theState][theCoord] = generator.next()
+		}
+	}
+	for idx := range zobristCastling {
+		zobristCastling[idx] = generator.next()
+	}
+	for idx := range zobristEnPassantFile {
+		zobristEnPassantFile[idx] = generator.next()
+	}
+	zobristBlackToMove = generator.next()
+}
+
+func zobristEnPassant(theCoord coordinate) uint64 {
+	if theCoord == nullCoordinate {
+		return 0
+	}
+	return zobristEnPassantFile[theCoord.getFileIndex()]
+}
+
+type xorShiftGenerator uint64
+
+func (g *xorShiftGenerator) next() uint64 {
+	*g ^= *g >> 12
+	*g ^= *g << 25
+	*g ^= *g >> 27
+	return uint64(*g) * 0x2545f4914f6cdd1d
+}
@@ -0,0 +1,142 @@
+package console
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// maxLineLength leaves room for position commands with very long move lists.
+const maxLineLength = 1 << 20
+
+// Reader forwards the non-empty lines of an input stream to a channel. The
+// channel is closed at the end of the input or on the first read error, so
+// that whoever is consuming it can shut down.
+type Reader struct {
+	Lines <-chan string
+
+	mutex sync.Mutex
+	err   error
+}
+
+func NewReader(r io.Reader) *Reader {
+	lines := make(chan string)
+	result := &Reader{Lines: lines}
+
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxLineLength)
+		for scanner.Scan() {
+			if text := strings.TrimSpace(scanner.Text()); text != "" {
+				lines <- text
+			}
+		}
+
+		result.mutex.Lock()
+		result.err = scanner.Err()
+		result.mutex.Unlock()
+	}()
+
+	return result
+}
+
+// Err returns the error that ended the input, if any. It is nil while the
+// input is still being read, and after a clean end of input.
+func (r *Reader) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// Writer writes lines sent to its channel to an output stream. Output is
+// buffered, but flushed whenever the channel runs dry, so interactive clients
+// see every line promptly.
+type Writer struct {
+	Lines chan<- string
+
+	done chan struct{}
+	err  error
+}
+
+func NewWriter(w io.Writer) *Writer {
+	lines := make(chan string, 64)
+	result := &Writer{Lines: lines, done: make(chan struct{})}
+
+	go func() {
+		defer close(result.done)
+
+		buffered := bufio.NewWriter(w)
+		for line := range lines {
+			if result.err != nil {
+				continue
+			}
+			if _, result.err = fmt.Fprintln(buffered, line); result.err != nil {
+				continue
+			}
+			if len(lines) == 0 {
+				result.err = buffered.Flush()
+			}
+		}
+		if result.err == nil {
+			result.err = buffered.Flush()
+		}
+	}()
+
+	return result
+}
+
+// Close writes out any remaining lines and returns the first write error. No
+// lines may be sent after Close is called.
+func (w *Writer) Close() error {
+	close(w.Lines)
+	<-w.done
+	return w.err
+}
+
+// SignalError is the cause of a context cancelled by a termination signal.
+type SignalError struct {
+	Signal os.Signal
+}
+
+func (e SignalError) Error() string {
+	return "received signal " + e.Signal.String()
+}
+
+// ExitCode follows the shell convention of 128 plus the signal number.
+func (e SignalError) ExitCode() int {
+	if number, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return 1
+}
+
+// NotifyContext returns a context that is cancelled with a SignalError when
+// the process receives an interrupt or termination signal. Calling stop
+// restores the default signal behaviour.
+func NotifyContext(parent context.Context) (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case theSignal := <-signals:
+			cancel(SignalError{theSignal})
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		cancel(nil)
+	}
+}
@@ -0,0 +1,100 @@
+package console
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	type testCase struct {
+		name          string
+		input         io.Reader
+		expectedLines []string
+		expectedError error
+	}
+
+	readError := errors.New("read failed")
+
+	testCases := []testCase{
+		{"end of input", strings.NewReader("uci\n\n  isready  \nquit"), []string{"uci", "isready", "quit"}, nil},
+		{"empty input", strings.NewReader(""), nil, nil},
+		{"read error", io.MultiReader(strings.NewReader("uci\n"), &failingReader{readError}), []string{"uci"}, readError},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		reader := NewReader(c.input)
+
+		var lines []string
+		for line := range reader.Lines {
+			lines = append(lines, line)
+		}
+
+		if !reflect.DeepEqual(lines, c.expectedLines) {
+			t.Errorf("expected lines %q, got %q", c.expectedLines, lines)
+		}
+		if err := reader.Err(); !errors.Is(err, c.expectedError) {
+			t.Errorf("expected error %v, got %v", c.expectedError, err)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var output strings.Builder
+	writer := NewWriter(&output)
+
+	for _, line := range []string{"id name Karei", "uciok", "bestmove e2e4"} {
+		writer.Lines <- line
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "id name Karei\nuciok\nbestmove e2e4\n"; output.String() != expected {
+		t.Errorf("expected output %q, got %q", expected, output.String())
+	}
+}
+
+func TestWriterError(t *testing.T) {
+	writeError := errors.New("write failed")
+	writer := NewWriter(&failingWriter{writeError})
+
+	writer.Lines <- "uciok"
+	writer.Lines <- "readyok"
+
+	if err := writer.Close(); !errors.Is(err, writeError) {
+		t.Errorf("expected error %v, got %v", writeError, err)
+	}
+}
+
+func TestSignalErrorExitCode(t *testing.T) {
+	if result := (SignalError{syscall.SIGINT}).ExitCode(); result != 130 {
+		t.Errorf("expected exit code 130 for SIGINT, got %v", result)
+	}
+	if result := (SignalError{syscall.SIGTERM}).ExitCode(); result != 143 {
+		t.Errorf("expected exit code 143 for SIGTERM, got %v", result)
+	}
+}
+
+type failingReader struct {
+	err error
+}
+
+func (r *failingReader) Read([]byte) (int, error) {
+	return 0, r.err
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w *failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
@@ -0,0 +1,326 @@
+// Package datagen plays self-play games and records quiet positions from
+// them, with the search score and the final result, as training data for
+// evaluation networks and parameter tuning.
+package datagen
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"sync"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+var logger = logging.For("datagen")
+
+// Config describes a run of self-play games.
+type Config struct {
+	// Path is the file the records are appended to. Progress is kept next to
+	// it in Path + ".progress", so that an interrupted run carries on where
+	// it stopped when started again with the same configuration.
+	Path   string
+	Format Format
+
+	// Games is the total number of games to play, counting those played by
+	// earlier runs.
+	Games int
+	// Concurrency is the number of games played at once.
+	Concurrency int
+	// Depth and Nodes limit the search for each move. At least one must be
+	// set.
+	Depth int
+	Nodes uint64
+	// RandomPlies is the number of random moves each game starts with.
+	RandomPlies int
+	// MaxPlies, if not zero, is the number of moves after which a game is
+	// drawn.
+	MaxPlies int
+	// Seed chooses the random openings. Game n is played from the opening
+	// chosen by Seed + n, so a given configuration always plays the same
+	// games.
+	Seed int64
+	// Evaluator, if set, replaces the handcrafted evaluation.
+	Evaluator chess.Evaluator
+}
+
+// Progress is how far a run has got.
+type Progress struct {
+	Games     int
+	Positions int
+}
+
+type gameResult struct {
+	number  int
+	records []Record
+	result  string
+	err     error
+}
+
+// Run plays the games that are still to be played, passing a report to output
+// after each one, and returns the progress made in total. If ctx is done, the
+// games in progress are abandoned and the run can be resumed later.
+func Run(ctx context.Context, config Config, output func(string)) (Progress, error) {
+	var progress Progress
+
+	if config.Games < 1 {
+		return progress, fmt.Errorf("bad value for games: %d", config.Games)
+	}
+	if config.Concurrency < 1 {
+		return progress, fmt.Errorf("bad value for concurrency: %d", config.Concurrency)
+	}
+	if config.Depth < 1 && config.Nodes == 0 {
+		return progress, errors.New("either depth or nodes must be set")
+	}
+
+	f, saved, err := openOutput(config.Path)
+	if err != nil {
+		return progress, err
+	}
+	defer f.Close()
+	progress.Games = saved.Games
+	progress.Positions = saved.Positions
+	size := saved.size
+	if progress.Games > 0 {
+		output(fmt.Sprintf("Resuming after game %d with %d positions", progress.Games, progress.Positions))
+	}
+
+	runCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	numbers := make(chan int)
+	go func() {
+		defer close(numbers)
+		for number := progress.Games + 1; number <= config.Games; number++ {
+			select {
+			case numbers <- number:
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+
+	results := make(chan gameResult)
+	var wg sync.WaitGroup
+	for idx := 0; idx < config.Concurrency; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for number := range numbers {
+				records, result, err := playGame(runCtx, config, number)
+				select {
+				case results <- gameResult{number, records, result, err}:
+				case <-runCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	// games finish out of order but are written in order, so that the
+	// progress file only has to count them
+	pending := map[int]gameResult{}
+	var firstErr error
+	for result := range results {
+		if result.err != nil {
+			if firstErr == nil && runCtx.Err() == nil {
+				firstErr = result.err
+				cancel(result.err)
+			}
+			continue
+		}
+		if runCtx.Err() != nil {
+			continue
+		}
+
+		pending[result.number] = result
+		for {
+			next, ok := pending[progress.Games+1]
+			if !ok {
+				break
+			}
+			delete(pending, next.number)
+
+			var buf []byte
+			for _, record := range next.records {
+				if buf, err = AppendRecord(buf, config.Format, record); err != nil {
+					break
+				}
+			}
+			if err == nil {
+				_, err = f.Write(buf)
+			}
+			if err == nil {
+				size += int64(len(buf))
+				progress.Games++
+				progress.Positions += len(next.records)
+				err = saveProgress(config.Path, progress, size)
+			}
+			if err != nil {
+				firstErr = fmt.Errorf("writing %s: %w", config.Path, err)
+				cancel(firstErr)
+				break
+			}
+
+			output(fmt.Sprintf("Finished game %d of %d: %s, %d positions (%d in total)", next.number, config.Games, next.result, len(next.records), progress.Positions))
+		}
+	}
+
+	if firstErr != nil {
+		return progress, firstErr
+	}
+	if err := context.Cause(ctx); err != nil {
+		return progress, err
+	}
+	return progress, nil
+}
+
+// playGame plays one game and returns the quiet positions from it, along with
+// the result.
+func playGame(ctx context.Context, config Config, number int) ([]Record, string, error) {
+	random := rand.New(rand.NewSource(config.Seed + int64(number)))
+	position := randomOpening(random, config.RandomPlies)
+	params := chess.SearchParameters{Depth: config.Depth, Nodes: config.Nodes, Evaluator: config.Evaluator}
+
+	var searcher chess.Searcher
+	var records []Record
+	var result string
+	for ply := 0; ; ply++ {
+		if result, _ = position.Outcome(); result != "" {
+			break
+		}
+		if config.MaxPlies > 0 && ply >= config.MaxPlies {
+			result = "1/2-1/2"
+			break
+		}
+
+		var info chess.SearchInfo
+		bestMove, _ := searcher.Search(ctx, position, params, func(searchInfo chess.SearchInfo) {
+			if searchInfo.Bound != chess.ExactScore {
+				return
+			}
+			info = searchInfo
+		})
+		if err := ctx.Err(); err != nil {
+			return nil, "", err
+		}
+
+		// once the search sees mate the rest of the game teaches nothing
+		if info.MateIn != 0 {
+			result = "0-1"
+			if (info.MateIn > 0) == position.WhiteToMove() {
+				result = "1-0"
+			}
+			break
+		}
+
+		if !position.InCheck() && !position.IsCapture(bestMove) {
+			score := info.Score
+			if !position.WhiteToMove() {
+				score = -score
+			}
+			records = append(records, Record{FEN: position.GetFEN(), Score: score})
+		}
+
+		if err := position.MakeMove(bestMove); err != nil {
+			return nil, "", err
+		}
+	}
+
+	whiteScore := map[string]float64{"1-0": 1, "1/2-1/2": 0.5, "0-1": 0}[result]
+	for idx := range records {
+		records[idx].Result = whiteScore
+	}
+
+	logger.Debug("game finished", "game", number, "result", result, "positions", len(records))
+	return records, result, nil
+}
+
+// randomOpening plays random moves from the starting position, trying again
+// if the game ends before they have all been played.
+func randomOpening(random *rand.Rand, plies int) chess.Position {
+	for {
+		position := chess.Position{}
+		position.LoadFEN(chess.GetStartingFEN())
+		for ply := 0; ply < plies; ply++ {
+			moves := position.LegalMoves()
+			if len(moves) == 0 {
+				break
+			}
+			position.MakeMove(moves[random.Intn(len(moves))])
+		}
+
+		if result, _ := position.Outcome(); result == "" {
+			return position
+		}
+	}
+}
+
+// savedProgress is what the progress file records: the games and positions
+// written so far and the size of the output once they were.
+type savedProgress struct {
+	Progress
+	size int64
+}
+
+func progressPath(path string) string {
+	return path + ".progress"
+}
+
+// openOutput opens the output for appending after the games recorded in the
+// progress file, dropping anything written after them by a run that was cut
+// short.
+func openOutput(path string) (*os.File, savedProgress, error) {
+	var saved savedProgress
+
+	data, err := os.ReadFile(progressPath(path))
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		if info, err := os.Stat(path); err == nil && info.Size() > 0 {
+			return nil, saved, fmt.Errorf("%s already exists but has no progress file", path)
+		}
+	case err != nil:
+		return nil, saved, err
+	default:
+		if _, err := fmt.Sscanf(string(data), "games %d positions %d bytes %d", &saved.Games, &saved.Positions, &saved.size); err != nil {
+			return nil, saved, fmt.Errorf("bad progress file %s", progressPath(path))
+		}
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, saved, err
+	}
+	if info, err := f.Stat(); err != nil || info.Size() < saved.size {
+		f.Close()
+		return nil, saved, fmt.Errorf("%s is shorter than its progress file says", path)
+	}
+	if err := f.Truncate(saved.size); err != nil {
+		f.Close()
+		return nil, saved, err
+	}
+	if _, err := f.Seek(saved.size, 0); err != nil {
+		f.Close()
+		return nil, saved, err
+	}
+	return f, saved, nil
+}
+
+// saveProgress replaces the progress file in one step, so that it is never
+// seen half written.
+func saveProgress(path string, progress Progress, size int64) error {
+	temporary := progressPath(path) + ".tmp"
+	data := fmt.Sprintf("games %d positions %d bytes %d\n", progress.Games, progress.Positions, size)
+	if err := os.WriteFile(temporary, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(temporary, progressPath(path))
+}
@@ -0,0 +1,194 @@
+package datagen
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func TestRecordFormats(t *testing.T) {
+	type testCase struct {
+		name   string
+		fen    string
+		score  int
+		result float64
+	}
+
+	testCases := []testCase{
+		{"starting position", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 35, 0.5},
+		{"en passant", "rnbqkbnr/ppp1p1pp/8/3pPp2/8/8/PPPP1PPP/RNBQKBNR w KQkq f6 0 3", -12, 1},
+		{"some castling", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R b Kq - 17 140", 250, 0},
+		{"endgame", "8/8/8/8/3k4/8/3P4/4K3 b - - 99 1000", -32000, 1},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		fen, err := chess.ParseFEN(c.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := Record{fen, c.score, c.result}
+
+		for _, format := range []Format{Binary, Text} {
+			buf, err := AppendRecord(nil, format, record)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == Binary && len(buf) != recordSize {
+				t.Errorf("expected %d bytes, got %d", recordSize, len(buf))
+			}
+
+			read, err := ReadRecords(bytes.NewReader(buf), format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(read) != 1 || read[0] != record {
+				t.Errorf("%s: expected %v, got %v", format, record, read)
+			}
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestParseTextRecord(t *testing.T) {
+	type testCase struct {
+		name           string
+		line           string
+		expectedResult float64
+		expectError    bool
+	}
+
+	testCases := []testCase{
+		{"decimal result", "8/8/8/8/3k4/8/3P4/4K3 b - - 0 1 | 10 | 0.5", 0.5, false},
+		{"PGN result", "8/8/8/8/3k4/8/3P4/4K3 b - - 0 1|10|1-0", 1, false},
+		{"missing field", "8/8/8/8/3k4/8/3P4/4K3 b - - 0 1 | 10", 0, true},
+		{"bad score", "8/8/8/8/3k4/8/3P4/4K3 b - - 0 1 | ten | 0.5", 0, true},
+		{"bad result", "8/8/8/8/3k4/8/3P4/4K3 b - - 0 1 | 10 | 0.7", 0, true},
+		{"bad FEN", "8/8/8 b - | 10 | 0.5", 0, true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		record, err := ParseTextRecord(c.line)
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if record.Result != c.expectedResult {
+			t.Errorf("expected result %v, got %v", c.expectedResult, record.Result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func testConfig(path string, games int) Config {
+	return Config{
+		Path:        path,
+		Games:       games,
+		Concurrency: 2,
+		Nodes:       500,
+		RandomPlies: 8,
+		MaxPlies:    16,
+		Seed:        7,
+	}
+}
+
+func TestRun(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.bin")
+	progress, err := Run(context.Background(), testConfig(path, 3), func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if progress.Games != 3 || progress.Positions == 0 {
+		t.Fatalf("expected 3 games with some positions, got %+v", progress)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	records, err := ReadRecords(f, Binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != progress.Positions {
+		t.Errorf("expected %d records, got %d", progress.Positions, len(records))
+	}
+
+	for _, record := range records {
+		position := chess.Position{}
+		if err := position.LoadFEN(record.FEN); err != nil {
+			t.Fatal(err)
+		}
+		if position.InCheck() {
+			t.Errorf("expected only quiet positions, got %s", record.FEN)
+		}
+	}
+}
+
+func TestRunResumes(t *testing.T) {
+	dir := t.TempDir()
+	resumed := filepath.Join(dir, "resumed.txt")
+	uninterrupted := filepath.Join(dir, "uninterrupted.txt")
+
+	config := testConfig(resumed, 1)
+	config.Format = Text
+	if _, err := Run(context.Background(), config, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a run cut short may leave part of a game behind
+	f, err := os.OpenFile(resumed, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1 | 0")
+	f.Close()
+
+	config.Games = 3
+	if _, err := Run(context.Background(), config, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	config.Path = uninterrupted
+	if _, err := Run(context.Background(), config, func(string) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	resumedData, _ := os.ReadFile(resumed)
+	uninterruptedData, _ := os.ReadFile(uninterrupted)
+	if !bytes.Equal(resumedData, uninterruptedData) {
+		t.Error("expected the resumed run to write the same records as an uninterrupted one")
+	}
+
+	if err := os.Remove(progressPath(uninterrupted)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Run(context.Background(), config, func(string) {}); err == nil {
+		t.Error("expected an error for output without a progress file")
+	}
+}
+
+func TestRunStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	config := testConfig(filepath.Join(t.TempDir(), "data.bin"), 2)
+	if _, err := Run(ctx, config, func(string) {}); err != context.Canceled {
+		t.Errorf("expected %v, got %v", context.Canceled, err)
+	}
+}
@@ -0,0 +1,304 @@
+package datagen
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// Record is one training position: the position, the score the search gave
+// it in centipawns from white's point of view, and the final result of the
+// game as white's share of the point.
+type Record struct {
+	FEN    chess.FEN
+	Score  int
+	Result float64
+}
+
+// Format is a way of writing records to a file.
+type Format int
+
+const (
+	// Binary packs each record into recordSize bytes.
+	Binary Format = iota
+	// Text writes each record on its own line as FEN | score | result.
+	Text
+)
+
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "binary":
+		return Binary, nil
+	case "text":
+		return Text, nil
+	}
+	return 0, fmt.Errorf("unrecognised format %s", s)
+}
+
+func (f Format) String() string {
+	if f == Text {
+		return "text"
+	}
+	return "binary"
+}
+
+// A binary record holds, in order and little-endian:
+//
+//	occupancy    uint64, bit 0 for a1 up to bit 63 for h8
+//	pieces       16 bytes, a nibble per occupied square from a1 up, low
+//	             nibble first, indexing pieceLetters
+//	flags        byte, bit 0 set if black is to move and bits 1 to 4 for
+//	             the castling rights K, Q, k and q
+//	en passant   byte, the square or noEnPassant
+//	half moves   byte
+//	full moves   uint16
+//	score        int16
+//	result       byte, 0 if black won, 1 for a draw and 2 if white won
+const recordSize = 32
+
+const (
+	pieceLetters = "KkQqRrBbNnPp"
+	noEnPassant  = 0xff
+	maxPieces    = 32
+)
+
+var castlingLetters = [4]string{"K", "Q", "k", "q"}
+
+// AppendRecord encodes a record in the format and appends it to buf.
+func AppendRecord(buf []byte, f Format, r Record) ([]byte, error) {
+	if f == Text {
+		return fmt.Appendf(buf, "%s | %d | %.1f\n", r.FEN.String(), r.Score, r.Result), nil
+	}
+
+	packed, err := packRecord(r)
+	if err != nil {
+		return buf, err
+	}
+	return append(buf, packed[:]...), nil
+}
+
+func packRecord(r Record) ([recordSize]byte, error) {
+	var packed [recordSize]byte
+
+	var occupancy uint64
+	var pieces []byte
+	rank, file := 7, 0
+	for _, letter := range r.FEN.BoardState {
+		switch {
+		case letter == '/':
+			rank, file = rank-1, 0
+		case letter >= '1' && letter <= '8':
+			file += int(letter - '0')
+		default:
+			code := strings.IndexRune(pieceLetters, letter)
+			if code < 0 || rank < 0 || file > 7 {
+				return packed, fmt.Errorf("bad FEN: %s", r.FEN.String())
+			}
+			occupancy |= 1 << (8*rank + file)
+			pieces = append(pieces, byte(code))
+			file++
+		}
+	}
+	if len(pieces) > maxPieces {
+		return packed, fmt.Errorf("too many pieces to pack: %s", r.FEN.String())
+	}
+
+	// the board is read from a8, but packed from a1
+	ordered := make([]byte, 0, len(pieces))
+	for rank := 0; rank < 8; rank++ {
+		start := bits.OnesCount64(occupancy >> (8 * (rank + 1)))
+		end := bits.OnesCount64(occupancy >> (8 * rank))
+		ordered = append(ordered, pieces[start:end]...)
+	}
+
+	binary.LittleEndian.PutUint64(packed[0:], occupancy)
+	for idx, code := range ordered {
+		packed[8+idx/2] |= code << (4 * (idx % 2))
+	}
+
+	if r.FEN.ActiveColour == "b" {
+		packed[24] |= 1
+	}
+	for idx, letter := range castlingLetters {
+		if strings.Contains(r.FEN.CastlingRights, letter) {
+			packed[24] |= 2 << idx
+		}
+	}
+
+	packed[25] = noEnPassant
+	if r.FEN.EnPassantSquare != "-" {
+		if len(r.FEN.EnPassantSquare) != 2 {
+			return packed, fmt.Errorf("bad FEN: %s", r.FEN.String())
+		}
+		packed[25] = (r.FEN.EnPassantSquare[1]-'1')*8 + r.FEN.EnPassantSquare[0] - 'a'
+	}
+
+	halfMoves, err := strconv.ParseUint(r.FEN.HalfMoveClock, 10, 8)
+	if err != nil {
+		return packed, fmt.Errorf("bad FEN: %s", r.FEN.String())
+	}
+	fullMoves, err := strconv.ParseUint(r.FEN.FullMoveNumber, 10, 16)
+	if err != nil {
+		return packed, fmt.Errorf("bad FEN: %s", r.FEN.String())
+	}
+	packed[26] = byte(halfMoves)
+	binary.LittleEndian.PutUint16(packed[27:], uint16(fullMoves))
+
+	score := min(max(r.Score, -32767), 32767)
+	binary.LittleEndian.PutUint16(packed[29:], uint16(int16(score)))
+	packed[31] = byte(2 * r.Result)
+
+	return packed, nil
+}
+
+func unpackRecord(packed []byte) (Record, error) {
+	var r Record
+
+	occupancy := binary.LittleEndian.Uint64(packed[0:])
+	if bits.OnesCount64(occupancy) > maxPieces || packed[31] > 2 {
+		return r, errors.New("bad record")
+	}
+
+	var board [64]byte
+	for idx := 0; occupancy != 0; idx++ {
+		square := bits.TrailingZeros64(occupancy)
+		occupancy &= occupancy - 1
+		code := packed[8+idx/2] >> (4 * (idx % 2)) & 0xf
+		if int(code) >= len(pieceLetters) {
+			return r, errors.New("bad record")
+		}
+		board[square] = pieceLetters[code]
+	}
+
+	var boardState strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		emptySquares := 0
+		for file := 0; file < 8; file++ {
+			if board[8*rank+file] == 0 {
+				emptySquares++
+				continue
+			}
+			if emptySquares > 0 {
+				boardState.WriteByte(byte('0' + emptySquares))
+				emptySquares = 0
+			}
+			boardState.WriteByte(board[8*rank+file])
+		}
+		if emptySquares > 0 {
+			boardState.WriteByte(byte('0' + emptySquares))
+		}
+		if rank > 0 {
+			boardState.WriteByte('/')
+		}
+	}
+	r.FEN.BoardState = boardState.String()
+
+	r.FEN.ActiveColour = "w"
+	if packed[24]&1 != 0 {
+		r.FEN.ActiveColour = "b"
+	}
+	for idx, letter := range castlingLetters {
+		if packed[24]&(2<<idx) != 0 {
+			r.FEN.CastlingRights += letter
+		}
+	}
+	if r.FEN.CastlingRights == "" {
+		r.FEN.CastlingRights = "-"
+	}
+
+	r.FEN.EnPassantSquare = "-"
+	if square := packed[25]; square != noEnPassant {
+		if square >= 64 {
+			return r, errors.New("bad record")
+		}
+		r.FEN.EnPassantSquare = string([]byte{'a' + square%8, '1' + square/8})
+	}
+
+	r.FEN.HalfMoveClock = strconv.Itoa(int(packed[26]))
+	r.FEN.FullMoveNumber = strconv.Itoa(int(binary.LittleEndian.Uint16(packed[27:])))
+	r.Score = int(int16(binary.LittleEndian.Uint16(packed[29:])))
+	r.Result = float64(packed[31]) / 2
+
+	return r, nil
+}
+
+// ReadRecords reads every record in the format from r.
+func ReadRecords(r io.Reader, f Format) ([]Record, error) {
+	var records []Record
+
+	if f == Binary {
+		buffered := bufio.NewReader(r)
+		packed := make([]byte, recordSize)
+		for {
+			_, err := io.ReadFull(buffered, packed)
+			if err == io.EOF {
+				return records, nil
+			}
+			if err != nil {
+				return records, fmt.Errorf("record %d: %w", len(records)+1, err)
+			}
+			record, err := unpackRecord(packed)
+			if err != nil {
+				return records, fmt.Errorf("record %d: %w", len(records)+1, err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		record, err := ParseTextRecord(line)
+		if err != nil {
+			return records, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// ParseTextRecord reads a record written as FEN | score | result. The result
+// may also be given as 1-0, 0-1 or 1/2-1/2.
+func ParseTextRecord(line string) (Record, error) {
+	var r Record
+
+	fields := strings.Split(line, "|")
+	if len(fields) != 3 {
+		return r, fmt.Errorf("bad record: %s", line)
+	}
+
+	fen, err := chess.ParseFEN(strings.TrimSpace(fields[0]))
+	if err != nil {
+		return r, err
+	}
+	r.FEN = fen
+
+	if r.Score, err = strconv.Atoi(strings.TrimSpace(fields[1])); err != nil {
+		return r, fmt.Errorf("bad score in record: %s", line)
+	}
+
+	switch result := strings.TrimSpace(fields[2]); result {
+	case "1-0":
+		r.Result = 1
+	case "0-1":
+		r.Result = 0
+	case "1/2-1/2":
+		r.Result = 0.5
+	default:
+		if r.Result, err = strconv.ParseFloat(result, 64); err != nil || (r.Result != 0 && r.Result != 0.5 && r.Result != 1) {
+			return r, fmt.Errorf("bad result in record: %s", line)
+		}
+	}
+
+	return r, nil
+}
@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+const (
+	// DefaultBenchDepth is deep enough for every pruning, reduction and
+	// extension in the search, including singular extensions, to take effect,
+	// so that changes to any of them alter the signature.
+	DefaultBenchDepth = 8
+	benchHashSize     = 16
+)
+
+var benchPositions = []string{
+	"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+	"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 10",
+	"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 11",
+	"4rrk1/pp1n3p/3q2pQ/2p1pb2/2PP4/2P3N1/P2B2PP/4RRK1 b - - 7 19",
+	"r3r1k1/2p2ppp/p1p1bn2/8/1q2P3/2NPQN2/PPP3PP/R4RK1 b - - 2 15",
+	"r1bbk1nr/pp3p1p/2n5/1N4p1/2Np1B2/8/PPP2PPP/2KR1B1R w kq - 0 13",
+	"r1bq1rk1/ppp1nppp/4n3/3p3Q/3P4/1BP1B3/PP1N2PP/R4RK1 w - - 1 16",
+	"4r1k1/r1q2ppp/ppp2n2/4P3/5Rb1/1N1BQ3/PPP3PP/R5K1 w - - 1 17",
+	"2rqkb1r/ppp2p2/2npb1p1/1N1Nn2p/2P1PP2/8/PP2B1PP/R1BQK2R b KQ - 0 11",
+	"6k1/6p1/6Pp/ppp5/3pn2P/1P3K2/1PP2P2/3N4 b - - 0 1",
+	"3b4/5kp1/1p1p1p1p/pP1PpP1P/P1P1P3/3KN3/8/8 w - - 0 1",
+	"8/8/8/8/5kp1/P7/8/1K1N4 w - - 0 1",
+}
+
+// Bench searches a fixed set of positions to the given depth with a fresh
+// single-threaded engine and a fixed hash size, reporting progress to output.
+// The total node count serves as a signature of the engine's search behaviour.
+func Bench(depth int, output func(string)) (nodes uint64, elapsed time.Duration) {
+	e := New()
+	e.hashSize = benchHashSize
+	e.threads = 1
+
+	startTime := time.Now()
+	for idx, fenString := range benchPositions {
+		fen, err := chess.ParseFEN(fenString)
+		if err != nil {
+			panic(err)
+		}
+
+		position := chess.Position{}
+		if err := position.LoadFEN(fen); err != nil {
+			panic(err)
+		}
+
+		output(fmt.Sprintf("Position %d/%d: %s", idx+1, len(benchPositions), fenString))
+
+		// each position starts afresh, so that its node count does not depend
+		// on the ones before
+		e.NewGame()
+		params := chess.SearchParameters{Depth: depth, TranspositionTable: e.transpositionTable(0, position.WhiteToMove())}
+
+		var lastInfo chess.SearchInfo
+		e.searcher.Search(context.Background(), position, params, func(searchInfo chess.SearchInfo) {
+			lastInfo = searchInfo
+		})
+		output(fmt.Sprintf("Depth %d, score %d, nodes %d, pv %s", lastInfo.Depth, lastInfo.Score, lastInfo.Nodes, strings.Join(lastInfo.PV, " ")))
+
+		nodes += lastInfo.Nodes
+	}
+	elapsed = time.Since(startTime)
+
+	nps := uint64(0)
+	if milliseconds := elapsed.Milliseconds(); milliseconds > 0 {
+		nps = nodes * 1000 / uint64(milliseconds)
+	}
+
+	output("===========================")
+	output(fmt.Sprintf("Total time (ms) : %d", elapsed.Milliseconds()))
+	output(fmt.Sprintf("Nodes searched  : %d", nodes))
+	output(fmt.Sprintf("Nodes/second    : %d", nps))
+
+	return nodes, elapsed
+}
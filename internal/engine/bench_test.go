@@ -0,0 +1,25 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	var lines []string
+	nodes, _ := Bench(1, func(line string) {
+		lines = append(lines, line)
+	})
+
+	if nodes == 0 {
+		t.Fatal("expected bench to search some nodes")
+	}
+	if !strings.HasPrefix(lines[0], "Position 1/") {
+		t.Errorf("expected first line to announce a position, got %s", lines[0])
+	}
+
+	repeatNodes, _ := Bench(1, func(string) {})
+	if repeatNodes != nodes {
+		t.Errorf("expected bench to be deterministic, got %d then %d nodes", nodes, repeatNodes)
+	}
+}
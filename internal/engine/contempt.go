@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Contempt makes the engine avoid draws against weaker opponents and seek
+// them against stronger ones. The Contempt option sets how much a draw is
+// worth to the engine's opponent against an equal one, and UCI_Opponent moves
+// it by the difference between their ratings.
+const (
+	minContempt = -100
+	maxContempt = 100
+
+	// contemptElo is the rating difference worth a centipawn of contempt.
+	contemptElo = 10
+	// maxOpponentContempt is the furthest an opponent's rating moves the
+	// contempt from the Contempt option.
+	maxOpponentContempt = 50
+
+	// fullStrengthElo is a rough guess at the engine's rating at its best,
+	// which is above the strongest level it can be weakened to.
+	fullStrengthElo = 2600
+)
+
+// titleElos are the ratings required for each title, which stand in for the
+// rating of a titled human opponent whose rating is not given.
+var titleElos = map[string]int{
+	"GM":  2500,
+	"IM":  2400,
+	"FM":  2300,
+	"CM":  2200,
+	"WGM": 2300,
+	"WIM": 2200,
+	"WFM": 2100,
+	"WCM": 2000,
+}
+
+// opponent is who the engine is playing, as given by UCI_Opponent.
+type opponent struct {
+	// title is empty for an untitled opponent.
+	title string
+	// elo is zero if the rating is unknown.
+	elo      int
+	computer bool
+	name     string
+}
+
+// parseOpponent reads the value of UCI_Opponent, which is the opponent's
+// title, rating, whether they are a computer or a human and their name, with
+// none for an unknown title or rating, as in "GM 2800 human Gary Kasparov".
+func parseOpponent(value string) (opponent, error) {
+	fields := strings.Fields(value)
+	if len(fields) < 3 {
+		return opponent{}, fmt.Errorf("expected <title> <rating> <computer|human> <name>, got %s", value)
+	}
+
+	var theOpponent opponent
+	if title := strings.ToUpper(fields[0]); title != "NONE" {
+		if _, ok := titleElos[title]; !ok {
+			return opponent{}, fmt.Errorf("unrecognised title %s", fields[0])
+		}
+		theOpponent.title = title
+	}
+
+	if fields[1] != "none" {
+		elo, err := strconv.Atoi(fields[1])
+		if err != nil || elo <= 0 {
+			return opponent{}, fmt.Errorf("bad rating %s", fields[1])
+		}
+		theOpponent.elo = elo
+	}
+
+	switch fields[2] {
+	case "computer":
+		theOpponent.computer = true
+	case "human":
+	default:
+		return opponent{}, fmt.Errorf("expected computer or human, got %s", fields[2])
+	}
+
+	theOpponent.name = strings.Join(fields[3:], " ")
+	return theOpponent, nil
+}
+
+// rating returns the opponent's rating, if it is known or can be told from a
+// human's title. A computer's title says nothing about its strength.
+func (o opponent) rating() (int, bool) {
+	if o.elo > 0 {
+		return o.elo, true
+	}
+	if !o.computer && o.title != "" {
+		return titleElos[o.title], true
+	}
+	return 0, false
+}
+
+// contemptFor moves the contempt by how much stronger the engine is than the
+// opponent, as far as their ratings are known.
+func contemptFor(contempt int, ownElo int, theOpponent *opponent) int {
+	if theOpponent == nil {
+		return contempt
+	}
+	opponentElo, ok := theOpponent.rating()
+	if !ok {
+		return contempt
+	}
+	adjustment := (ownElo - opponentElo) / contemptElo
+	return contempt + min(max(adjustment, -maxOpponentContempt), maxOpponentContempt)
+}
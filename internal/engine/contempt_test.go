@@ -0,0 +1,71 @@
+package engine
+
+import "testing"
+
+func TestParseOpponent(t *testing.T) {
+	type testCase struct {
+		name        string
+		value       string
+		expectError bool
+		expected    opponent
+	}
+
+	testCases := []testCase{
+		{"titled human", "GM 2800 human Gary Kasparov", false, opponent{"GM", 2800, false, "Gary Kasparov"}},
+		{"unknown computer", "none none computer Shredder", false, opponent{"", 0, true, "Shredder"}},
+		{"lower case title", "im none human Someone", false, opponent{"IM", 0, false, "Someone"}},
+		{"no name", "none 1500 human", false, opponent{"", 1500, false, ""}},
+		{"too short", "GM 2800", true, opponent{}},
+		{"unknown title", "XM 2800 human Someone", true, opponent{}},
+		{"bad rating", "none strong human Someone", true, opponent{}},
+		{"neither computer nor human", "none 2000 alien Someone", true, opponent{}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		theOpponent, err := parseOpponent(c.value)
+		if c.expectError {
+			if err == nil {
+				t.Errorf("expected an error, got %+v", theOpponent)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if theOpponent != c.expected {
+			t.Errorf("expected %+v, got %+v", c.expected, theOpponent)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestContemptFor(t *testing.T) {
+	type testCase struct {
+		name     string
+		opponent *opponent
+		expected int
+	}
+
+	testCases := []testCase{
+		{"no opponent", nil, 10},
+		{"weaker opponent", &opponent{elo: 1800}, 30},
+		{"stronger opponent", &opponent{elo: 2400}, -30},
+		{"much weaker opponent", &opponent{elo: 800}, 10 + maxOpponentContempt},
+		{"titled human", &opponent{title: "FM"}, -20},
+		{"titled computer", &opponent{title: "FM", computer: true}, 10},
+		{"unrated computer", &opponent{computer: true}, 10},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		if contempt := contemptFor(10, 2000, c.opponent); contempt != c.expected {
+			t.Errorf("expected %d, got %d", c.expected, contempt)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -1,18 +1,576 @@
 package engine
 
-var In, Out chan string
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
-func Start() {
-	In = make(chan string)
-	Out = make(chan string)
+	"github.com/yutanagano/karei/internal/book"
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+// Option describes a configurable engine parameter in the terms used by the
+// UCI option command.
+type Option struct {
+	Name    string
+	Type    string
+	Default string
+	Min     int
+	Max     int
+	Vars    []string
+}
+
+// Limits constrain a search, mirroring the parameters of the UCI go command.
+// Zero values mean no limit.
+type Limits struct {
+	SearchMoves []string
+	Ponder      bool
+	WTime       time.Duration
+	BTime       time.Duration
+	WInc        time.Duration
+	BInc        time.Duration
+	MovesToGo   int
+	Depth       int
+	Nodes       uint64
+	Mate        int
+	MoveTime    time.Duration
+	Infinite    bool
+}
+
+// Engine searches positions in the background. An Engine runs at most one
+// search at a time, but any number of engines may coexist in one process.
+type Engine struct {
+	hashSize int
+	// tt is allocated by the first search after Hash is set
+	tt *chess.TranspositionTable
+	// threads is accepted from GUIs that always set Threads, but the search
+	// runs on a single thread whatever its value
+	threads int
+	ponder  bool
+
+	ownBook      bool
+	book         *book.Book
+	bookDepth    int
+	bookBestMove bool
+	random       *rand.Rand
+
+	tablebase        *chess.Tablebase
+	syzygyProbeDepth int
+	syzygy50MoveRule bool
+
+	evaluator     chess.Evaluator
+	limitStrength bool
+	elo           int
+	skillLevel    int
+
+	contempt int
+	// opponent is nil unless UCI_Opponent has been set
+	opponent    *opponent
+	analyseMode bool
+	showWDL     bool
+	// drawScore is what the last search scored a draw as, from white's point
+	// of view. The transposition table holds scores that depend on it.
+	drawScore int
+
+	// disabledFeatures are parts of the selective search turned off by the
+	// hidden options used for testing them
+	disabledFeatures chess.SearchFeature
+
+	searcher chess.Searcher
+	mutex    sync.Mutex
+	current  *searchState
+	logger   *slog.Logger
+}
+
+func New() *Engine {
+	return &Engine{
+		hashSize:         32,
+		threads:          1,
+		bookDepth:        20,
+		random:           rand.New(rand.NewSource(time.Now().UnixNano())),
+		syzygyProbeDepth: 1,
+		syzygy50MoveRule: true,
+		elo:              defaultElo,
+		skillLevel:       len(skillLevels) - 1,
+		logger:           logging.For("engine"),
+	}
+}
+
+// SetLogger replaces the logger used for diagnostics, taking effect from the
+// next search.
+func (e *Engine) SetLogger(logger *slog.Logger) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.logger = logger
+}
+
+func (e *Engine) Options() []Option {
+	return []Option{
+		{Name: "Hash", Type: "spin", Default: "32", Min: 1, Max: 1024},
+		{Name: "Threads", Type: "spin", Default: "1", Min: 1, Max: 16},
+		{Name: "Ponder", Type: "check", Default: "false"},
+		{Name: "OwnBook", Type: "check", Default: "false"},
+		{Name: "BookFile", Type: "string", Default: "<empty>"},
+		{Name: "BookDepth", Type: "spin", Default: "20", Min: 1, Max: 255},
+		{Name: "BookBestMove", Type: "check", Default: "false"},
+		{Name: "SyzygyPath", Type: "string", Default: "<empty>"},
+		{Name: "SyzygyProbeDepth", Type: "spin", Default: "1", Min: 1, Max: 100},
+		{Name: "Syzygy50MoveRule", Type: "check", Default: "true"},
+		{Name: "EvalFile", Type: "string", Default: "<empty>"},
+		{Name: "UCI_LimitStrength", Type: "check", Default: "false"},
+		{Name: "UCI_Elo", Type: "spin", Default: strconv.Itoa(defaultElo), Min: minElo, Max: maxElo},
+		{Name: "Skill Level", Type: "combo", Default: fullStrength, Vars: skillLevelNames()},
+		{Name: "Contempt", Type: "spin", Default: "0", Min: minContempt, Max: maxContempt},
+		{Name: "UCI_Opponent", Type: "string", Default: "<empty>"},
+		{Name: "UCI_AnalyseMode", Type: "check", Default: "false"},
+		{Name: "UCI_ShowWDL", Type: "check", Default: "false"},
+	}
+}
+
+func (e *Engine) SetOption(name, value string) error {
+	switch strings.ToLower(name) {
+	case "hash":
+		size, err := parseSpin(value, 1, 1024)
+		if err != nil {
+			return fmt.Errorf("bad value for Hash: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.hashSize = size
+		e.tt = nil
+		e.mutex.Unlock()
+	case "threads":
+		threads, err := parseSpin(value, 1, 16)
+		if err != nil {
+			return fmt.Errorf("bad value for Threads: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.threads = threads
+		e.mutex.Unlock()
+	case "ponder":
+		ponder, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for Ponder: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.ponder = ponder
+		e.mutex.Unlock()
+	case "ownbook":
+		ownBook, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for OwnBook: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.ownBook = ownBook
+		e.mutex.Unlock()
+	case "bookfile":
+		var theBook *book.Book
+		if value != "" && value != "<empty>" {
+			var err error
+			if theBook, err = book.Open(value); err != nil {
+				return fmt.Errorf("bad value for BookFile: %s", err.Error())
+			}
+		}
+		e.mutex.Lock()
+		e.book = theBook
+		e.mutex.Unlock()
+	case "bookdepth":
+		depth, err := parseSpin(value, 1, 255)
+		if err != nil {
+			return fmt.Errorf("bad value for BookDepth: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.bookDepth = depth
+		e.mutex.Unlock()
+	case "bookbestmove":
+		bestMove, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for BookBestMove: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.bookBestMove = bestMove
+		e.mutex.Unlock()
+	case "syzygypath":
+		// directories are separated as in PATH
+		var tablebase *chess.Tablebase
+		if value != "" && value != "<empty>" {
+			var err error
+			if tablebase, err = chess.OpenTablebase(filepath.SplitList(value)); err != nil {
+				return fmt.Errorf("bad value for SyzygyPath: %s", err.Error())
+			}
+		}
+		e.mutex.Lock()
+		previous := e.tablebase
+		e.tablebase = tablebase
+		e.mutex.Unlock()
+		// options are not set during a search, and if one were probing the
+		// old tables it would only lose them
+		if previous != nil {
+			previous.Close()
+		}
+	case "syzygyprobedepth":
+		depth, err := parseSpin(value, 1, 100)
+		if err != nil {
+			return fmt.Errorf("bad value for SyzygyProbeDepth: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.syzygyProbeDepth = depth
+		e.mutex.Unlock()
+	case "syzygy50moverule":
+		rule50, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for Syzygy50MoveRule: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.syzygy50MoveRule = rule50
+		e.mutex.Unlock()
+	case "evalfile":
+		// <empty> keeps the handcrafted evaluation and <default> selects the
+		// built-in network
+		var evaluator chess.Evaluator
+		switch value {
+		case "", "<empty>":
+		case "<default>":
+			evaluator = chess.DefaultNetwork()
+		default:
+			network, err := chess.LoadNetwork(value)
+			if err != nil {
+				return fmt.Errorf("bad value for EvalFile: %s", err.Error())
+			}
+			evaluator = network
+		}
+		e.mutex.Lock()
+		e.evaluator = evaluator
+		e.mutex.Unlock()
+	case "uci_limitstrength":
+		limitStrength, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for UCI_LimitStrength: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.limitStrength = limitStrength
+		e.mutex.Unlock()
+	case "uci_elo":
+		elo, err := parseSpin(value, minElo, maxElo)
+		if err != nil {
+			return fmt.Errorf("bad value for UCI_Elo: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.elo = elo
+		e.mutex.Unlock()
+	case "skill level":
+		level := slices.IndexFunc(skillLevels, func(level skill) bool {
+			return strings.EqualFold(level.name, value)
+		})
+		if level < 0 {
+			return fmt.Errorf("bad value for Skill Level: %s", value)
+		}
+		e.mutex.Lock()
+		e.skillLevel = level
+		e.mutex.Unlock()
+	case "contempt":
+		contempt, err := parseSpin(value, minContempt, maxContempt)
+		if err != nil {
+			return fmt.Errorf("bad value for Contempt: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.contempt = contempt
+		e.mutex.Unlock()
+	case "uci_opponent":
+		var theOpponent *opponent
+		if value != "" && value != "<empty>" {
+			parsed, err := parseOpponent(value)
+			if err != nil {
+				return fmt.Errorf("bad value for UCI_Opponent: %s", err.Error())
+			}
+			theOpponent = &parsed
+		}
+		e.mutex.Lock()
+		e.opponent = theOpponent
+		e.mutex.Unlock()
+	case "uci_analysemode":
+		analyseMode, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for UCI_AnalyseMode: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.analyseMode = analyseMode
+		e.mutex.Unlock()
+	case "uci_showwdl":
+		showWDL, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for UCI_ShowWDL: %s", err.Error())
+		}
+		e.mutex.Lock()
+		e.showWDL = showWDL
+		e.mutex.Unlock()
+	default:
+		if err := e.setSearchFeature(name, value); err != nil {
+			return err
+		}
+	}
+
+	e.mutex.Lock()
+	e.logger.Info("option set", "name", name, "value", value)
+	e.mutex.Unlock()
+	return nil
+}
+
+// setSearchFeature handles the options that turn each feature of the
+// selective search on and off. They are left out of Options, as they are only
+// meant for measuring the features one at a time.
+func (e *Engine) setSearchFeature(name, value string) error {
+	for _, feature := range chess.SearchFeatures {
+		if !strings.EqualFold(name, feature.String()) {
+			continue
+		}
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("bad value for %s: %s", feature, err.Error())
+		}
+		e.mutex.Lock()
+		if enabled {
+			e.disabledFeatures &^= feature
+		} else {
+			e.disabledFeatures |= feature
+		}
+		e.mutex.Unlock()
+		return nil
+	}
+	return fmt.Errorf("unrecognised option %s", name)
+}
+
+// NewGame forgets what earlier searches learnt, as it does not carry over to
+// another game.
+func (e *Engine) NewGame() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.tt != nil {
+		e.tt.Clear()
+	}
+}
+
+// playingElo returns the rating the engine is weakened to, and whether it is
+// weakened at all. UCI_LimitStrength takes precedence over Skill Level. The
+// caller must hold the mutex.
+func (e *Engine) playingElo() (int, bool) {
+	if e.limitStrength {
+		return e.elo, true
+	}
+	if level := skillLevels[e.skillLevel]; level.name != fullStrength {
+		return level.elo, true
+	}
+	return fullStrengthElo, false
+}
+
+// playingStrength returns how to weaken the engine, unless it is to play at
+// its best. The caller must hold the mutex.
+func (e *Engine) playingStrength() (strength, bool) {
+	if elo, weakened := e.playingElo(); weakened {
+		return strengthForElo(elo), true
+	}
+	return strength{}, false
+}
+
+// searchContempt returns the contempt to search with. Analysis scores draws
+// as even, so that the scores do not depend on the side to move. The caller
+// must hold the mutex.
+func (e *Engine) searchContempt() int {
+	if e.analyseMode {
+		return 0
+	}
+	elo, _ := e.playingElo()
+	return contemptFor(e.contempt, elo, e.opponent)
+}
+
+// transpositionTable returns the engine's table, allocating it at the size
+// set by Hash if need be. The table is cleared if draws are to be scored
+// differently from the last search, which happens when the contempt changes
+// or the engine plays the other side. The caller must hold the mutex.
+func (e *Engine) transpositionTable(contempt int, whiteToMove bool) *chess.TranspositionTable {
+	drawScore := -contempt
+	if !whiteToMove {
+		drawScore = contempt
+	}
+	if e.tt == nil {
+		e.tt = chess.NewTranspositionTable(e.hashSize)
+	} else if drawScore != e.drawScore {
+		e.tt.Clear()
+	}
+	e.drawScore = drawScore
+	return e.tt
+}
+
+// Go starts searching the position in the background and returns immediately.
+// Progress is passed to info after each iteration of the search, and done is
+// called exactly once with the result when the search finishes. bestMove is
+// empty if the position has no legal moves.
+func (e *Engine) Go(position chess.Position, limits Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string)) {
+	if bookMove, ok := e.findBookMove(position, limits); ok {
+		go done(bookMove, "")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	state := &searchState{cancel: cancel}
+	state.optimum, state.maximum = limits.allocateTime(position.WhiteToMove())
+	if !limits.Ponder {
+		state.startClock()
+	}
+
+	e.mutex.Lock()
+	e.current = state
+	logger := e.logger
+	contempt := e.searchContempt()
+	params := chess.SearchParameters{
+		Depth:              limits.Depth,
+		Nodes:              limits.Nodes,
+		SearchMoves:        limits.SearchMoves,
+		Tablebase:          e.tablebase,
+		TBProbeDepth:       e.syzygyProbeDepth,
+		TBIgnore50MoveRule: !e.syzygy50MoveRule,
+		Evaluator:          e.evaluator,
+		TranspositionTable: e.transpositionTable(contempt, position.WhiteToMove()),
+		DisabledFeatures:   e.disabledFeatures,
+		Contempt:           contempt,
+	}
+	showWDL := e.showWDL
+	playingStrength, weakened := e.playingStrength()
+	if weakened {
+		playingStrength.limit(&params)
+	}
+	e.mutex.Unlock()
+
+	logger.Debug("search started", "fen", position.GetFEN().String(), "depth", limits.Depth, "nodes", limits.Nodes,
+		"ponder", limits.Ponder, "infinite", limits.Infinite, "optimum", state.optimum, "maximum", state.maximum)
+
+	// lines holds the latest of each of the best lines, for a weakened
+	// engine to choose from
+	var lines []chess.SearchInfo
+	if weakened {
+		lines = make([]chess.SearchInfo, params.MultiPV)
+	}
+	report := func(searchInfo chess.SearchInfo) {
+		if showWDL {
+			wdl := wdlOf(&position, searchInfo)
+			searchInfo.WDL = &wdl
+		}
+		info(searchInfo)
+		// a bound says little about the iteration it belongs to
+		if searchInfo.Bound != chess.ExactScore {
+			return
+		}
+		if idx := searchInfo.MultiPV - 1; idx >= 0 && idx < len(lines) {
+			lines[idx] = searchInfo
+		}
+
+		if limits.Mate > 0 && searchInfo.MateIn > 0 && searchInfo.MateIn <= limits.Mate {
+			logger.Debug("stopping search after finding mate", "mate", searchInfo.MateIn)
+			cancel()
+		}
+		if state.isPastOptimum() {
+			logger.Debug("stopping search past optimum time", "depth", searchInfo.Depth)
+			cancel()
+		}
+	}
 
 	go func() {
-		for command := range In {
-			switch command {
-			case "stop":
-			case "quit":
+		bestMove, ponderMove := e.searcher.Search(ctx, position, params, report)
+		state.stopClock()
+		cancel()
+		// lines the search did not reach, or that had no moves, are left out
+		lines = slices.DeleteFunc(lines, func(line chess.SearchInfo) bool { return len(line.PV) == 0 })
+		if weakened && len(lines) > 1 {
+			e.mutex.Lock()
+			line := playingStrength.pickLine(lines, e.random)
+			e.mutex.Unlock()
+			bestMove, ponderMove = line.PV[0], ""
+			if len(line.PV) > 1 {
+				ponderMove = line.PV[1]
 			}
+			logger.Debug("weakened move chosen", "move", bestMove, "score", line.Score, "best", lines[0].Score)
 		}
+		logger.Debug("search finished", "bestmove", bestMove, "ponder", ponderMove)
+		done(bestMove, ponderMove)
 	}()
-	return
+}
+
+// findBookMove picks a move from the opening book, if the engine is using one
+// and the position is in it. Ponder and infinite searches never use the book,
+// since they must not finish before being told to.
+func (e *Engine) findBookMove(position chess.Position, limits Limits) (string, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if !e.ownBook || e.book == nil || limits.Ponder || limits.Infinite {
+		return "", false
+	}
+	if moveNumber, err := strconv.Atoi(position.GetFEN().FullMoveNumber); err != nil || moveNumber > e.bookDepth {
+		return "", false
+	}
+
+	var bookMove string
+	var ok bool
+	if e.bookBestMove {
+		bookMove, ok = e.book.BestMove(position)
+	} else {
+		bookMove, ok = e.book.RandomMove(position, e.random)
+	}
+	if !ok || (len(limits.SearchMoves) > 0 && !slices.Contains(limits.SearchMoves, bookMove)) {
+		return "", false
+	}
+
+	e.logger.Debug("playing book move", "fen", position.GetFEN().String(), "move", bookMove)
+	return bookMove, true
+}
+
+// Stop ends the current search as soon as possible. The result is still
+// delivered through the done callback passed to Go.
+func (e *Engine) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.logger.Debug("stop requested")
+	if e.current != nil {
+		e.current.cancel()
+	}
+}
+
+// PonderHit switches a ponder search to a normal search, starting its clock.
+func (e *Engine) PonderHit() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.logger.Debug("ponder hit")
+	if e.current != nil {
+		e.current.startClock()
+	}
+}
+
+func parseSpin(value string, min, max int) (int, error) {
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if result < min || result > max {
+		return 0, fmt.Errorf("%d not in range %d to %d", result, min, max)
+	}
+	return result, nil
+}
+
+// wdlOf returns the expected outcome of a line. A mate is certain, and its
+// score is not a number of centipawns the model could make sense of.
+func wdlOf(position *chess.Position, searchInfo chess.SearchInfo) chess.WinDrawLoss {
+	switch {
+	case searchInfo.MateIn > 0:
+		return chess.WinDrawLoss{Win: 1000}
+	case searchInfo.MateIn < 0:
+		return chess.WinDrawLoss{Loss: 1000}
+	}
+	return chess.DefaultWDLModel.Predict(position, searchInfo.Score)
 }
@@ -0,0 +1,555 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutanagano/karei/internal/book"
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func TestGo(t *testing.T) {
+	type testCase struct {
+		name             string
+		limits           Limits
+		stopAfter        time.Duration
+		expectedBestMove string
+	}
+
+	testCases := []testCase{
+		{"depth", Limits{Depth: 2, SearchMoves: []string{"d2d4"}}, 0, "d2d4"},
+		{"nodes", Limits{Nodes: 1000, SearchMoves: []string{"g1f3"}}, 0, "g1f3"},
+		{"move time", Limits{MoveTime: 20 * time.Millisecond, SearchMoves: []string{"e2e4"}}, 0, "e2e4"},
+		{"stopped", Limits{Infinite: true, SearchMoves: []string{"c2c4"}}, 20 * time.Millisecond, "c2c4"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := chess.Position{}
+		thePosition.LoadFEN(chess.GetStartingFEN())
+
+		results := make(chan string, 2)
+		e := New()
+		e.Go(thePosition, c.limits, func(chess.SearchInfo) {}, func(bestMove, ponderMove string) {
+			results <- bestMove
+		})
+
+		if c.stopAfter > 0 {
+			time.Sleep(c.stopAfter)
+			e.Stop()
+		}
+
+		select {
+		case bestMove := <-results:
+			if bestMove != c.expectedBestMove {
+				t.Errorf("expected best move %s, got %s", c.expectedBestMove, bestMove)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("search did not finish")
+		}
+
+		select {
+		case <-results:
+			t.Error("done called more than once")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestBookMove(t *testing.T) {
+	games, err := chess.ReadPGN(strings.NewReader("1. b3 e5 1-0\n1. b3 e5 1-0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	builder := book.NewBuilder(book.BuildConfig{MaxPly: 1, MinGames: 1})
+	for _, game := range games {
+		builder.Add(game)
+	}
+
+	path := filepath.Join(t.TempDir(), "book.bin")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := builder.Book().Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	e := New()
+	for _, option := range [][2]string{{"OwnBook", "true"}, {"BookFile", path}, {"BookBestMove", "true"}} {
+		if err := e.SetOption(option[0], option[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.SetOption("BookFile", filepath.Join(t.TempDir(), "missing.bin")); err == nil {
+		t.Error("expected an error for a missing book")
+	}
+	e.SetOption("BookFile", path)
+
+	type testCase struct {
+		name             string
+		moves            []string
+		limits           Limits
+		expectedBestMove string
+	}
+
+	testCases := []testCase{
+		{"in book", nil, Limits{Depth: 1}, "b2b3"},
+		{"infinite", nil, Limits{Infinite: true, SearchMoves: []string{"a2a3"}}, "a2a3"},
+		{"excluded by search moves", nil, Limits{Depth: 1, SearchMoves: []string{"g1f3"}}, "g1f3"},
+		{"out of book", []string{"e2e4"}, Limits{Depth: 1, SearchMoves: []string{"e7e5"}}, "e7e5"},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		thePosition := chess.Position{}
+		thePosition.LoadFEN(chess.GetStartingFEN())
+		for _, moveString := range c.moves {
+			thePosition.MakeMove(moveString)
+		}
+
+		results := make(chan string, 1)
+		e.Go(thePosition, c.limits, func(chess.SearchInfo) {}, func(bestMove, ponderMove string) {
+			results <- bestMove
+		})
+		if c.limits.Infinite {
+			time.Sleep(20 * time.Millisecond)
+			e.Stop()
+		}
+
+		select {
+		case bestMove := <-results:
+			if bestMove != c.expectedBestMove {
+				t.Errorf("expected best move %s, got %s", c.expectedBestMove, bestMove)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("search did not finish")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSyzygyOptions(t *testing.T) {
+	type testCase struct {
+		name        string
+		option      string
+		value       string
+		expectError bool
+	}
+
+	dir := t.TempDir()
+	testCases := []testCase{
+		{"path", "SyzygyPath", dir, false},
+		{"several paths", "SyzygyPath", dir + string(filepath.ListSeparator) + dir, false},
+		{"empty path", "SyzygyPath", "<empty>", false},
+		{"missing path", "SyzygyPath", filepath.Join(dir, "missing"), true},
+		{"probe depth", "SyzygyProbeDepth", "5", false},
+		{"probe depth out of range", "SyzygyProbeDepth", "0", true},
+		{"fifty move rule", "Syzygy50MoveRule", "false", false},
+		{"bad fifty move rule", "Syzygy50MoveRule", "sometimes", true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		err := New().SetOption(c.option, c.value)
+		if c.expectError && err == nil {
+			t.Error("expected an error")
+		}
+		if !c.expectError && err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestEvalFileOption(t *testing.T) {
+	type testCase struct {
+		name        string
+		value       string
+		expectError bool
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "default.nnue")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chess.DefaultNetwork().Write(f); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	badPath := filepath.Join(dir, "bad.nnue")
+	if err := os.WriteFile(badPath, []byte("not a network"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []testCase{
+		{"handcrafted", "<empty>", false},
+		{"built in", "<default>", false},
+		{"file", path, false},
+		{"missing file", filepath.Join(dir, "missing.nnue"), true},
+		{"bad file", badPath, true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		e := New()
+		err := e.SetOption("EvalFile", c.value)
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		thePosition := chess.Position{}
+		thePosition.LoadFEN(chess.GetStartingFEN())
+		results := make(chan string, 1)
+		e.Go(thePosition, Limits{Depth: 2}, func(chess.SearchInfo) {}, func(bestMove, ponderMove string) {
+			results <- bestMove
+		})
+		select {
+		case bestMove := <-results:
+			if bestMove == "" {
+				t.Error("expected a best move")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("search did not finish")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSearchFeatureOptions(t *testing.T) {
+	type testCase struct {
+		name             string
+		option           string
+		value            string
+		expectError      bool
+		expectedDisabled chess.SearchFeature
+	}
+
+	testCases := []testCase{
+		{"disable", "NullMovePruning", "false", false, chess.NullMovePruning},
+		{"enable", "NullMovePruning", "true", false, 0},
+		{"case insensitive", "singularextensions", "false", false, chess.SingularExtensions},
+		{"bad value", "Razoring", "sometimes", true, 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		e := New()
+		err := e.SetOption(c.option, c.value)
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if e.disabledFeatures != c.expectedDisabled {
+			t.Errorf("expected disabled features %b, got %b", c.expectedDisabled, e.disabledFeatures)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+
+	// the options are hidden from the GUI
+	for _, option := range New().Options() {
+		for _, feature := range chess.SearchFeatures {
+			if option.Name == feature.String() {
+				t.Errorf("expected %s not to be listed", option.Name)
+			}
+		}
+	}
+}
+
+func TestStrengthOptions(t *testing.T) {
+	type setting struct{ name, value string }
+	type testCase struct {
+		name             string
+		settings         []setting
+		expectError      bool
+		expectWeakened   bool
+		expectedStrength strength
+	}
+
+	testCases := []testCase{
+		{"full strength by default", nil, false, false, strength{}},
+		{"limit strength", []setting{{"UCI_LimitStrength", "true"}, {"UCI_Elo", "1000"}}, false, true, strengthForElo(1000)},
+		{"default rating", []setting{{"UCI_LimitStrength", "true"}}, false, true, strengthForElo(defaultElo)},
+		{"skill level", []setting{{"Skill Level", "club"}}, false, true, strengthForElo(1600)},
+		{"limit strength comes first", []setting{{"Skill Level", "Beginner"}, {"UCI_LimitStrength", "true"}, {"UCI_Elo", "2000"}}, false, true, strengthForElo(2000)},
+		{"maximum skill level", []setting{{"Skill Level", "Novice"}, {"Skill Level", "Maximum"}}, false, false, strength{}},
+		{"rating out of range", []setting{{"UCI_Elo", "500"}}, true, false, strength{}},
+		{"unknown skill level", []setting{{"Skill Level", "Grandmaster"}}, true, false, strength{}},
+		{"bad limit strength", []setting{{"UCI_LimitStrength", "maybe"}}, true, false, strength{}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		e := New()
+		var err error
+		for _, s := range c.settings {
+			if err = e.SetOption(s.name, s.value); err != nil {
+				break
+			}
+		}
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		playingStrength, weakened := e.playingStrength()
+		if weakened != c.expectWeakened || playingStrength != c.expectedStrength {
+			t.Errorf("expected %v %+v, got %v %+v", c.expectWeakened, c.expectedStrength, weakened, playingStrength)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestContemptOptions(t *testing.T) {
+	type setting struct{ name, value string }
+	type testCase struct {
+		name             string
+		settings         []setting
+		expectError      bool
+		expectedContempt int
+	}
+
+	testCases := []testCase{
+		{"no contempt by default", nil, false, 0},
+		{"contempt", []setting{{"Contempt", "25"}}, false, 25},
+		{"weaker opponent", []setting{{"UCI_Opponent", "none 2400 computer Weaker"}}, false, 20},
+		{"weaker than the weakened engine", []setting{{"Skill Level", "Club"}, {"UCI_Opponent", "none 1200 human Someone"}}, false, 40},
+		{"opponent forgotten", []setting{{"UCI_Opponent", "none 2400 computer Weaker"}, {"UCI_Opponent", "<empty>"}}, false, 0},
+		{"analysis", []setting{{"Contempt", "25"}, {"UCI_AnalyseMode", "true"}}, false, 0},
+		{"contempt out of range", []setting{{"Contempt", "200"}}, true, 0},
+		{"bad opponent", []setting{{"UCI_Opponent", "GM"}}, true, 0},
+		{"bad analysis mode", []setting{{"UCI_AnalyseMode", "maybe"}}, true, 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		e := New()
+		var err error
+		for _, s := range c.settings {
+			if err = e.SetOption(s.name, s.value); err != nil {
+				break
+			}
+		}
+		if c.expectError {
+			if err == nil {
+				t.Error("expected an error")
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		if contempt := e.searchContempt(); contempt != c.expectedContempt {
+			t.Errorf("expected %d, got %d", c.expectedContempt, contempt)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestDrawScoreChangeClearsTable(t *testing.T) {
+	type setting struct{ name, value string }
+	type testCase struct {
+		name          string
+		settings      []setting
+		moves         []string
+		expectCleared bool
+	}
+
+	testCases := []testCase{
+		{"same search", nil, nil, false},
+		{"unrelated option", []setting{{"UCI_ShowWDL", "true"}}, nil, false},
+		{"contempt", []setting{{"Contempt", "30"}}, nil, true},
+		{"opponent", []setting{{"UCI_Opponent", "none 2400 computer Weaker"}}, nil, true},
+		{"analysis", []setting{{"UCI_AnalyseMode", "true"}}, nil, true},
+		{"other side", nil, []string{"e2e4"}, true},
+	}
+
+	// searchNodes returns the number of nodes a depth 4 search of the
+	// position after the moves takes
+	searchNodes := func(t *testing.T, e *Engine, moves []string) uint64 {
+		thePosition := chess.Position{}
+		thePosition.LoadFEN(chess.GetStartingFEN())
+		for _, moveString := range moves {
+			thePosition.MakeMove(moveString)
+		}
+
+		var nodes uint64
+		finished := make(chan struct{})
+		e.Go(thePosition, Limits{Depth: 4}, func(info chess.SearchInfo) {
+			nodes = info.Nodes
+		}, func(bestMove, ponderMove string) {
+			close(finished)
+		})
+		select {
+		case <-finished:
+		case <-time.After(30 * time.Second):
+			t.Fatal("search did not finish")
+		}
+		return nodes
+	}
+
+	newEngine := func(t *testing.T, settings []setting) *Engine {
+		e := New()
+		for _, s := range append([]setting{{"Contempt", "25"}}, settings...) {
+			if err := e.SetOption(s.name, s.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return e
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		expected := searchNodes(t, newEngine(t, c.settings), c.moves)
+
+		e := newEngine(t, nil)
+		searchNodes(t, e, nil)
+		for _, s := range c.settings {
+			if err := e.SetOption(s.name, s.value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		nodes := searchNodes(t, e, c.moves)
+
+		if cleared := nodes == expected; cleared != c.expectCleared {
+			t.Errorf("expected the table to be cleared: %v, got %d nodes against %d from an empty table", c.expectCleared, nodes, expected)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestWeakenedSearch(t *testing.T) {
+	thePosition := chess.Position{}
+	thePosition.LoadFEN(chess.GetStartingFEN())
+
+	e := New()
+	if err := e.SetOption("Skill Level", "Beginner"); err != nil {
+		t.Fatal(err)
+	}
+
+	candidates := map[string]bool{}
+	results := make(chan string, 1)
+	e.Go(thePosition, Limits{}, func(info chess.SearchInfo) {
+		if info.Depth > strengthForElo(minElo).depth {
+			t.Errorf("expected the search to stop at depth %d, got %d", strengthForElo(minElo).depth, info.Depth)
+		}
+		candidates[info.PV[0]] = true
+	}, func(bestMove, ponderMove string) {
+		results <- bestMove
+	})
+
+	select {
+	case bestMove := <-results:
+		if len(candidates) != strengthCandidates {
+			t.Errorf("expected %d candidates, got %v", strengthCandidates, candidates)
+		}
+		if !candidates[bestMove] {
+			t.Errorf("expected one of the candidates, got %s", bestMove)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("search did not finish")
+	}
+}
+
+func TestShowWDL(t *testing.T) {
+	thePosition := chess.Position{}
+	thePosition.LoadFEN(chess.GetStartingFEN())
+
+	for _, showWDL := range []bool{false, true} {
+		e := New()
+		if err := e.SetOption("UCI_ShowWDL", strconv.FormatBool(showWDL)); err != nil {
+			t.Fatal(err)
+		}
+
+		results := make(chan struct{})
+		e.Go(thePosition, Limits{Depth: 3}, func(info chess.SearchInfo) {
+			if (info.WDL != nil) != showWDL {
+				t.Errorf("UCI_ShowWDL %v: got %+v", showWDL, info.WDL)
+			}
+			if info.WDL != nil && *info.WDL != chess.DefaultWDLModel.Predict(&thePosition, info.Score) {
+				t.Errorf("expected %+v, got %+v", chess.DefaultWDLModel.Predict(&thePosition, info.Score), *info.WDL)
+			}
+		}, func(bestMove, ponderMove string) {
+			close(results)
+		})
+
+		select {
+		case <-results:
+		case <-time.After(5 * time.Second):
+			t.Fatal("search did not finish")
+		}
+	}
+
+	if err := New().SetOption("UCI_ShowWDL", "maybe"); err == nil {
+		t.Error("expected an error for a bad value")
+	}
+}
+
+func TestWDLOf(t *testing.T) {
+	type testCase struct {
+		name       string
+		searchInfo chess.SearchInfo
+		expected   chess.WinDrawLoss
+	}
+
+	thePosition := chess.Position{}
+	thePosition.LoadFEN(chess.GetStartingFEN())
+
+	testCases := []testCase{
+		{"centipawns", chess.SearchInfo{Score: 50}, chess.DefaultWDLModel.Predict(&thePosition, 50)},
+		{"mating", chess.SearchInfo{Score: 31999, MateIn: 1}, chess.WinDrawLoss{Win: 1000}},
+		{"getting mated", chess.SearchInfo{Score: -31998, MateIn: -1}, chess.WinDrawLoss{Loss: 1000}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		if result := wdlOf(&thePosition, c.searchInfo); result != c.expected {
+			t.Errorf("expected %+v, got %+v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
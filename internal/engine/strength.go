@@ -0,0 +1,108 @@
+package engine
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// The strength options weaken the engine for human opponents. UCI_Elo and the
+// Skill Level names both come down to a rating, which sets how far the engine
+// looks ahead and how carelessly it chooses among its best moves. The ratings
+// are rough: the calibrate command measures the gaps between them, but nothing
+// ties them to human rating lists.
+const (
+	minElo     = 800
+	maxElo     = 2200
+	defaultElo = 1500
+
+	// fullStrength is the Skill Level that leaves the engine at its best.
+	fullStrength = "Maximum"
+	// strengthCandidates is the number of best moves a weakened engine
+	// chooses from.
+	strengthCandidates = 4
+)
+
+// skill is a value of Skill Level and the rating it stands for.
+type skill struct {
+	name string
+	elo  int
+}
+
+// skillLevels are the values of Skill Level, weakest first.
+var skillLevels = []skill{
+	{"Beginner", 800},
+	{"Novice", 1000},
+	{"Casual", 1200},
+	{"Intermediate", 1400},
+	{"Club", 1600},
+	{"Advanced", 1800},
+	{"Expert", 2000},
+	{"Master", 2200},
+	{fullStrength, 0},
+}
+
+func skillLevelNames() []string {
+	names := make([]string, len(skillLevels))
+	for idx, level := range skillLevels {
+		names[idx] = level.name
+	}
+	return names
+}
+
+// strength describes how a weakened engine plays.
+type strength struct {
+	depth int
+	nodes uint64
+	// candidates is the number of best moves searched to choose from.
+	candidates int
+	// noise is the largest random bonus, in centipawns, given to the score
+	// of each candidate before choosing the best.
+	noise int
+	// blunderChance is the probability of playing a candidate picked at
+	// random instead.
+	blunderChance float64
+}
+
+// strengthForElo interpolates between the weakest and strongest settings. The
+// limits on depth and nodes grow geometrically, as each doubling of the
+// search is worth roughly the same.
+func strengthForElo(elo int) strength {
+	level := float64(min(max(elo, minElo), maxElo)-minElo) / (maxElo - minElo)
+	weakness := 1 - level
+	return strength{
+		depth:         1 + int(math.Round(9*level)),
+		nodes:         uint64(math.Round(200 * math.Pow(2, 11*level))),
+		candidates:    strengthCandidates,
+		noise:         int(math.Round(20 + 280*weakness)),
+		blunderChance: 0.2 * weakness * weakness,
+	}
+}
+
+// limit tightens the parameters of a search to the strength.
+func (s strength) limit(params *chess.SearchParameters) {
+	if params.Depth == 0 || params.Depth > s.depth {
+		params.Depth = s.depth
+	}
+	if params.Nodes == 0 || params.Nodes > s.nodes {
+		params.Nodes = s.nodes
+	}
+	params.MultiPV = s.candidates
+}
+
+// pickLine chooses which of the lines found by the search to play, which must
+// not be empty.
+func (s strength) pickLine(lines []chess.SearchInfo, random *rand.Rand) chess.SearchInfo {
+	if random.Float64() < s.blunderChance {
+		return lines[random.Intn(len(lines))]
+	}
+
+	best, bestValue := 0, math.MinInt
+	for idx, line := range lines {
+		if value := line.Score + random.Intn(s.noise+1); value > bestValue {
+			best, bestValue = idx, value
+		}
+	}
+	return lines[best]
+}
@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func TestStrengthForElo(t *testing.T) {
+	previous := strengthForElo(minElo - 100)
+	if previous != strengthForElo(minElo) {
+		t.Errorf("expected ratings below %d to play as %d", minElo, minElo)
+	}
+
+	for elo := minElo + 100; elo <= maxElo; elo += 100 {
+		current := strengthForElo(elo)
+		if current.depth < previous.depth || current.nodes < previous.nodes {
+			t.Errorf("%d: expected the search to grow, got %+v after %+v", elo, current, previous)
+		}
+		if current.noise > previous.noise || current.blunderChance > previous.blunderChance {
+			t.Errorf("%d: expected fewer mistakes, got %+v after %+v", elo, current, previous)
+		}
+		previous = current
+	}
+}
+
+func TestStrengthLimit(t *testing.T) {
+	type testCase struct {
+		name     string
+		params   chess.SearchParameters
+		expected chess.SearchParameters
+	}
+
+	s := strength{depth: 4, nodes: 1000, candidates: 3}
+	testCases := []testCase{
+		{"no limits", chess.SearchParameters{}, chess.SearchParameters{Depth: 4, Nodes: 1000, MultiPV: 3}},
+		{"looser limits", chess.SearchParameters{Depth: 10, Nodes: 5000}, chess.SearchParameters{Depth: 4, Nodes: 1000, MultiPV: 3}},
+		{"tighter limits", chess.SearchParameters{Depth: 2, Nodes: 500}, chess.SearchParameters{Depth: 2, Nodes: 500, MultiPV: 3}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		params := c.params
+		s.limit(&params)
+		if params.Depth != c.expected.Depth || params.Nodes != c.expected.Nodes || params.MultiPV != c.expected.MultiPV {
+			t.Errorf("expected %+v, got %+v", c.expected, params)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestPickLine(t *testing.T) {
+	type testCase struct {
+		name          string
+		strength      strength
+		expectBest    bool
+		expectOthers  bool
+		expectBlunder bool
+	}
+
+	lines := []chess.SearchInfo{
+		{MultiPV: 1, Score: 50, PV: []string{"e2e4"}},
+		{MultiPV: 2, Score: 30, PV: []string{"d2d4"}},
+		{MultiPV: 3, Score: -400, PV: []string{"g2g4"}},
+	}
+
+	testCases := []testCase{
+		{"without noise", strength{}, true, false, false},
+		{"with noise", strength{noise: 100}, true, true, false},
+		{"always blundering", strength{blunderChance: 1}, true, true, true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		random := rand.New(rand.NewSource(1))
+		picked := map[string]bool{}
+		for idx := 0; idx < 200; idx++ {
+			picked[c.strength.pickLine(lines, random).PV[0]] = true
+		}
+
+		if picked["e2e4"] != c.expectBest {
+			t.Errorf("expected the best move to be picked: %v", c.expectBest)
+		}
+		if picked["d2d4"] != c.expectOthers {
+			t.Errorf("expected a close second to be picked: %v", c.expectOthers)
+		}
+		if picked["g2g4"] != c.expectBlunder {
+			t.Errorf("expected a blunder to be picked: %v", c.expectBlunder)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -0,0 +1,86 @@
+package engine
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	moveOverhead       = 50 * time.Millisecond
+	defaultMovesToGo   = 30
+	maximumTimeScaling = 3
+)
+
+// allocateTime returns the time after which no new iteration should be
+// started, and the time after which the search must be stopped. Zero values
+// mean the search is not timed.
+func (l Limits) allocateTime(whiteToMove bool) (optimum, maximum time.Duration) {
+	if l.Infinite {
+		return 0, 0
+	}
+	if l.MoveTime > 0 {
+		// the search only notices the clock every few thousand nodes, and the
+		// best move still has to reach the GUI
+		moveTime := max(l.MoveTime-moveOverhead, l.MoveTime/4)
+		return moveTime, moveTime
+	}
+
+	timeLeft, increment := l.WTime, l.WInc
+	if !whiteToMove {
+		timeLeft, increment = l.BTime, l.BInc
+	}
+	if timeLeft <= 0 {
+		return 0, 0
+	}
+
+	movesToGo := defaultMovesToGo
+	if l.MovesToGo > 0 && l.MovesToGo < defaultMovesToGo {
+		movesToGo = l.MovesToGo
+	}
+
+	available := max(timeLeft-moveOverhead, timeLeft/4)
+	optimum = timeLeft/time.Duration(movesToGo) + increment*3/4
+	maximum = min(optimum*maximumTimeScaling, available)
+	optimum = min(optimum, maximum)
+
+	return optimum, maximum
+}
+
+type searchState struct {
+	cancel           context.CancelFunc
+	optimum, maximum time.Duration
+
+	mutex      sync.Mutex
+	clockStart time.Time
+	running    bool
+	timer      *time.Timer
+}
+
+func (s *searchState) startClock() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.running {
+		return
+	}
+
+	s.running = true
+	s.clockStart = time.Now()
+	if s.maximum > 0 {
+		s.timer = time.AfterFunc(s.maximum, s.cancel)
+	}
+}
+
+func (s *searchState) stopClock() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+}
+
+func (s *searchState) isPastOptimum() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.running && s.optimum > 0 && time.Since(s.clockStart) > s.optimum
+}
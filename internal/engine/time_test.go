@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAllocateTime(t *testing.T) {
+	type testCase struct {
+		name            string
+		limits          Limits
+		whiteToMove     bool
+		expectedOptimum time.Duration
+		expectedMaximum time.Duration
+	}
+
+	testCases := []testCase{
+		{
+			"infinite",
+			Limits{Infinite: true, WTime: time.Minute},
+			true,
+			0,
+			0,
+		},
+		{
+			"move time",
+			Limits{MoveTime: 500 * time.Millisecond},
+			true,
+			450 * time.Millisecond,
+			450 * time.Millisecond,
+		},
+		{
+			"short move time",
+			Limits{MoveTime: 40 * time.Millisecond},
+			true,
+			10 * time.Millisecond,
+			10 * time.Millisecond,
+		},
+		{
+			"sudden death uses side to move's clock",
+			Limits{WTime: time.Second, BTime: 60 * time.Second},
+			false,
+			2 * time.Second,
+			6 * time.Second,
+		},
+		{
+			"increment",
+			Limits{WTime: 30 * time.Second, WInc: time.Second},
+			true,
+			1750 * time.Millisecond,
+			5250 * time.Millisecond,
+		},
+		{
+			"last move before time control",
+			Limits{WTime: time.Second, MovesToGo: 1},
+			true,
+			950 * time.Millisecond,
+			950 * time.Millisecond,
+		},
+		{
+			"no clock",
+			Limits{Depth: 5},
+			true,
+			0,
+			0,
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		optimum, maximum := c.limits.allocateTime(c.whiteToMove)
+		if optimum != c.expectedOptimum {
+			t.Errorf("expected optimum time %v, got %v", c.expectedOptimum, optimum)
+		}
+		if maximum != c.expectedMaximum {
+			t.Errorf("expected maximum time %v, got %v", c.expectedMaximum, maximum)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
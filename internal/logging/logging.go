@@ -0,0 +1,173 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+)
+
+// SubsystemKey is the attribute naming the part of the program a record
+// comes from.
+const SubsystemKey = "subsystem"
+
+var (
+	mutex  sync.RWMutex
+	output slog.Handler = slog.NewTextHandler(io.Discard, nil)
+	levels              = Levels{Default: slog.LevelInfo}
+)
+
+// Levels gives the minimum level of records logged by each subsystem.
+type Levels struct {
+	Default    slog.Level
+	Subsystems map[string]slog.Level
+}
+
+// ParseLevels reads a level specification made of a default level optionally
+// followed by overrides for single subsystems, such as "warn,uci=debug".
+func ParseLevels(spec string) (Levels, error) {
+	result := Levels{Default: slog.LevelInfo, Subsystems: map[string]slog.Level{}}
+
+	for _, part := range strings.Split(spec, ",") {
+		subsystem, levelName, isOverride := strings.Cut(strings.TrimSpace(part), "=")
+		if !isOverride {
+			levelName = subsystem
+		}
+
+		var level slog.Level
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return Levels{}, fmt.Errorf("bad log level %s", levelName)
+		}
+
+		if isOverride {
+			result.Subsystems[subsystem] = level
+		} else {
+			result.Default = level
+		}
+	}
+
+	return result, nil
+}
+
+func (l Levels) levelFor(subsystem string) slog.Level {
+	if level, ok := l.Subsystems[subsystem]; ok {
+		return level
+	}
+	return l.Default
+}
+
+// Configure writes records that pass the given levels to w as text, one per
+// line. It also makes the standard library's default logger log as subsystem
+// main.
+func Configure(w io.Writer, l Levels) {
+	mutex.Lock()
+	output = slog.NewTextHandler(w, &slog.HandlerOptions{Level: slog.LevelDebug})
+	levels = l
+	mutex.Unlock()
+
+	slog.SetDefault(For("main"))
+}
+
+// For returns the logger of a subsystem. Loggers may be created before
+// Configure is called, and follow any later configuration.
+func For(subsystem string) *slog.Logger {
+	return slog.New(&subsystemHandler{subsystem: subsystem})
+}
+
+// subsystemHandler passes records to the configured output, looking it up
+// afresh for each record so that package level loggers see the configuration
+// made in main.
+type subsystemHandler struct {
+	subsystem  string
+	operations []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	return level >= levels.levelFor(h.subsystem)
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, r slog.Record) error {
+	mutex.RLock()
+	handler := output
+	mutex.RUnlock()
+
+	handler = handler.WithAttrs([]slog.Attr{slog.String(SubsystemKey, h.subsystem)})
+	for _, operation := range h.operations {
+		handler = operation(handler)
+	}
+	return handler.Handle(ctx, r)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+func (h *subsystemHandler) with(operation func(slog.Handler) slog.Handler) *subsystemHandler {
+	operations := append(h.operations[:len(h.operations):len(h.operations)], operation)
+	return &subsystemHandler{subsystem: h.subsystem, operations: operations}
+}
+
+// Mirror returns a logger that logs to base as usual, and also passes every
+// record at or above level to mirror as a single line of text, whatever the
+// configured levels.
+func Mirror(base *slog.Logger, level slog.Level, mirror func(string)) *slog.Logger {
+	return slog.New(&mirrorHandler{base: base.Handler(), level: level, mirror: mirror})
+}
+
+type mirrorHandler struct {
+	base   slog.Handler
+	level  slog.Level
+	attrs  []slog.Attr
+	mirror func(string)
+}
+
+func (h *mirrorHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level || h.base.Enabled(ctx, level)
+}
+
+func (h *mirrorHandler) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	if h.base.Enabled(ctx, r.Level) {
+		err = h.base.Handle(ctx, r.Clone())
+	}
+
+	if r.Level >= h.level {
+		var b strings.Builder
+		b.WriteString(r.Message)
+		for _, attr := range h.attrs {
+			fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+		}
+		r.Attrs(func(attr slog.Attr) bool {
+			fmt.Fprintf(&b, " %s=%v", attr.Key, attr.Value)
+			return true
+		})
+		h.mirror(b.String())
+	}
+
+	return err
+}
+
+func (h *mirrorHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &mirrorHandler{
+		base:   h.base.WithAttrs(attrs),
+		level:  h.level,
+		attrs:  append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...),
+		mirror: h.mirror,
+	}
+}
+
+func (h *mirrorHandler) WithGroup(name string) slog.Handler {
+	return &mirrorHandler{base: h.base.WithGroup(name), level: h.level, attrs: h.attrs, mirror: h.mirror}
+}
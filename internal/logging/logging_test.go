@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"bytes"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	type testCase struct {
+		name          string
+		spec          string
+		expected      Levels
+		expectedError bool
+	}
+
+	testCases := []testCase{
+		{"default only", "debug", Levels{Default: slog.LevelDebug, Subsystems: map[string]slog.Level{}}, false},
+		{
+			"overrides",
+			"warn,uci=debug, engine=error",
+			Levels{Default: slog.LevelWarn, Subsystems: map[string]slog.Level{"uci": slog.LevelDebug, "engine": slog.LevelError}},
+			false,
+		},
+		{"override only", "chess=debug", Levels{Default: slog.LevelInfo, Subsystems: map[string]slog.Level{"chess": slog.LevelDebug}}, false},
+		{"bad level", "loud", Levels{}, true},
+		{"bad override", "info,uci=loud", Levels{}, true},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		result, err := ParseLevels(c.spec)
+		if c.expectedError {
+			if err == nil {
+				t.Errorf("expected error, got %v", result)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(result, c.expected) {
+			t.Errorf("expected %v, got %v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestSubsystemLevels(t *testing.T) {
+	// loggers made before Configure must follow it
+	uciLogger, engineLogger := For("uci"), For("engine").With("search", 1)
+
+	var output bytes.Buffer
+	Configure(&output, Levels{Default: slog.LevelWarn, Subsystems: map[string]slog.Level{"uci": slog.LevelDebug}})
+	defer Configure(&bytes.Buffer{}, Levels{Default: slog.LevelInfo})
+
+	uciLogger.Debug("uci debug")
+	engineLogger.Info("engine info")
+	engineLogger.Warn("engine warning")
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %q", lines)
+	}
+	if !strings.Contains(lines[0], "msg=\"uci debug\" subsystem=uci") {
+		t.Errorf("expected uci debug record, got %s", lines[0])
+	}
+	if !strings.Contains(lines[1], "msg=\"engine warning\" subsystem=engine search=1") {
+		t.Errorf("expected engine warning record, got %s", lines[1])
+	}
+}
+
+func TestMirror(t *testing.T) {
+	var output bytes.Buffer
+	Configure(&output, Levels{Default: slog.LevelInfo})
+	defer Configure(&bytes.Buffer{}, Levels{Default: slog.LevelInfo})
+
+	var mirrored []string
+	logger := Mirror(For("engine"), slog.LevelDebug, func(line string) {
+		mirrored = append(mirrored, line)
+	}).With("depth", 3)
+
+	logger.Debug("search started", "nodes", 0)
+	logger.Info("option set", "name", "Hash")
+
+	if expected := []string{"search started depth=3 nodes=0", "option set depth=3 name=Hash"}; !reflect.DeepEqual(mirrored, expected) {
+		t.Errorf("expected mirrored lines %q, got %q", expected, mirrored)
+	}
+	if strings.Contains(output.String(), "search started") {
+		t.Error("expected debug record to be mirrored but not logged")
+	}
+	if !strings.Contains(output.String(), "option set") {
+		t.Error("expected info record to be logged as well as mirrored")
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "karei.log")
+
+	f, err := OpenRotatingFile(path, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := os.ReadFile(path)
+	backup, _ := os.ReadFile(path + ".1")
+	if string(current) != "third\n" {
+		t.Errorf("expected current log to hold the last line, got %q", current)
+	}
+	if string(backup) != "second\n" {
+		t.Errorf("expected backup to hold the line before, got %q", backup)
+	}
+}
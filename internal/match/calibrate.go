@@ -0,0 +1,105 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"math"
+)
+
+// Level is one setting of an engine's strength options.
+type Level struct {
+	Name    string
+	Options map[string]string
+}
+
+// CalibrationConfig describes a ladder of matches between the strength levels
+// of one engine, each level playing the one below it.
+type CalibrationConfig struct {
+	// Match is the template for each match, whose engines are replaced by
+	// Engine set to the two levels.
+	Match  Config
+	Engine EngineConfig
+	// Levels are played weakest first.
+	Levels []Level
+	// Anchor is the rating given to the weakest level.
+	Anchor float64
+}
+
+// Rating is the strength measured for a level.
+type Rating struct {
+	Level string
+	Elo   float64
+	// Margin is the 95% confidence interval of Elo relative to the weakest
+	// level, which widens with every step up the ladder.
+	Margin float64
+	// Score is the level's score against the level below, and is empty for
+	// the weakest level.
+	Score Score
+}
+
+// Calibrate plays every level against the one below it and chains the rating
+// differences into a rating for each level, passing progress to output. A
+// level that wins or loses every game is rated as if it had dropped half a
+// point, which understates the gap.
+func Calibrate(ctx context.Context, config CalibrationConfig, output func(string)) ([]Rating, error) {
+	if len(config.Levels) < 2 {
+		return nil, fmt.Errorf("bad value for levels: %d, need at least two", len(config.Levels))
+	}
+
+	ratings := []Rating{{Level: config.Levels[0].Name, Elo: config.Anchor}}
+	variance := 0.0
+	for idx := 1; idx < len(config.Levels); idx++ {
+		weaker, stronger := config.Levels[idx-1], config.Levels[idx]
+		matchConfig := config.Match
+		matchConfig.Engines = [2]EngineConfig{levelEngine(config.Engine, stronger), levelEngine(config.Engine, weaker)}
+
+		output(fmt.Sprintf("Playing %s against %s", stronger.Name, weaker.Name))
+		score, err := Run(ctx, matchConfig, output)
+		if err != nil {
+			return ratings, err
+		}
+
+		difference, margin := boundedElo(score)
+		variance += margin * margin
+		ratings = append(ratings, Rating{
+			Level:  stronger.Name,
+			Elo:    ratings[idx-1].Elo + difference,
+			Margin: math.Sqrt(variance),
+			Score:  score,
+		})
+		output(fmt.Sprintf("%s is %s +/- %s stronger than %s", stronger.Name, formatElo(difference), formatElo(margin), weaker.Name))
+	}
+
+	output("===========================")
+	for _, rating := range ratings {
+		output(fmt.Sprintf("%-20s %6.0f +/- %s", rating.Level, rating.Elo, formatElo(rating.Margin)))
+	}
+	return ratings, nil
+}
+
+func levelEngine(base EngineConfig, level Level) EngineConfig {
+	engine := base
+	engine.Name = level.Name
+	engine.Options = maps.Clone(base.Options)
+	if engine.Options == nil {
+		engine.Options = map[string]string{}
+	}
+	maps.Copy(engine.Options, level.Options)
+	return engine
+}
+
+// boundedElo is Score.Elo, except that a perfect or zero score loses or gains
+// half a point so that the difference stays finite.
+func boundedElo(score Score) (elo, margin float64) {
+	games := score.Games()
+	switch {
+	case games == 0:
+		return 0, 0
+	case score.Losses == 0 && score.Draws == 0:
+		score = Score{Wins: games - 1, Draws: 1}
+	case score.Wins == 0 && score.Draws == 0:
+		score = Score{Losses: games - 1, Draws: 1}
+	}
+	return score.Elo()
+}
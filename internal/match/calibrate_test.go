@@ -0,0 +1,82 @@
+package match
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCalibrate(t *testing.T) {
+	var output []string
+	config := CalibrationConfig{
+		Match: Config{
+			TimeControl:  TimeControl{Base: 5 * time.Second, Increment: 100 * time.Millisecond},
+			Adjudication: Adjudication{MaxMoves: 3},
+			Rounds:       1,
+			Concurrency:  2,
+			startPlayer:  startInProcess,
+		},
+		Levels: []Level{
+			{"Weak", map[string]string{"UCI_LimitStrength": "true", "UCI_Elo": "800"}},
+			{"Strong", map[string]string{"UCI_LimitStrength": "true", "UCI_Elo": "1600"}},
+		},
+		Anchor: 800,
+	}
+
+	ratings, err := Calibrate(context.Background(), config, func(line string) {
+		output = append(output, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ratings) != 2 || ratings[0].Level != "Weak" || ratings[1].Level != "Strong" {
+		t.Fatalf("expected a rating for each level, got %+v", ratings)
+	}
+	if ratings[0].Elo != 800 {
+		t.Errorf("expected the weakest level at the anchor, got %v", ratings[0].Elo)
+	}
+	// every game is drawn once it reaches the move limit
+	if ratings[1].Score != (Score{Draws: 2}) || ratings[1].Elo != 800 {
+		t.Errorf("expected two draws and no difference, got %s and %v", ratings[1].Score, ratings[1].Elo)
+	}
+	if !strings.Contains(strings.Join(output, "\n"), "Playing Strong against Weak") {
+		t.Errorf("expected the match to be announced, got %v", output)
+	}
+
+	config.Levels = config.Levels[:1]
+	if _, err := Calibrate(context.Background(), config, func(string) {}); err == nil {
+		t.Error("expected an error with a single level")
+	}
+}
+
+func TestBoundedElo(t *testing.T) {
+	type testCase struct {
+		name     string
+		score    Score
+		expected Score
+	}
+
+	testCases := []testCase{
+		{"mixed", Score{Wins: 3, Draws: 2, Losses: 1}, Score{Wins: 3, Draws: 2, Losses: 1}},
+		{"all won", Score{Wins: 10}, Score{Wins: 9, Draws: 1}},
+		{"all lost", Score{Losses: 10}, Score{Losses: 9, Draws: 1}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		elo, margin := boundedElo(c.score)
+		expectedElo, expectedMargin := c.expected.Elo()
+		if elo != expectedElo || margin != expectedMargin && !math.IsInf(margin, 1) {
+			t.Errorf("expected %v +/- %v, got %v +/- %v", expectedElo, expectedMargin, elo, margin)
+		}
+		if math.IsInf(elo, 0) {
+			t.Errorf("expected a finite difference, got %v", elo)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -0,0 +1,293 @@
+package match
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// TimeControl gives each side Base time for every Moves moves, or for the whole
+// game if Moves is zero, plus Increment after each move. A side loses on time
+// once it overruns its clock by more than Margin.
+type TimeControl struct {
+	Moves     int
+	Base      time.Duration
+	Increment time.Duration
+	Margin    time.Duration
+}
+
+// ParseTimeControl reads a time control in the form [moves/]seconds[+increment],
+// such as 10+0.1 or 40/60.
+func ParseTimeControl(s string) (TimeControl, error) {
+	var result TimeControl
+	rest := s
+
+	if movesString, baseString, ok := strings.Cut(rest, "/"); ok {
+		moves, err := strconv.Atoi(movesString)
+		if err != nil || moves < 1 {
+			return result, fmt.Errorf("bad time control %s", s)
+		}
+		result.Moves, rest = moves, baseString
+	}
+
+	baseString, incrementString, hasIncrement := strings.Cut(rest, "+")
+	base, err := parseSeconds(baseString)
+	if err != nil || base <= 0 {
+		return result, fmt.Errorf("bad time control %s", s)
+	}
+	result.Base = base
+
+	if hasIncrement {
+		increment, err := parseSeconds(incrementString)
+		if err != nil || increment < 0 {
+			return result, fmt.Errorf("bad time control %s", s)
+		}
+		result.Increment = increment
+	}
+
+	return result, nil
+}
+
+func parseSeconds(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// String writes the time control as for the PGN TimeControl tag.
+func (tc TimeControl) String() string {
+	result := strconv.FormatFloat(tc.Base.Seconds(), 'f', -1, 64)
+	if tc.Moves > 0 {
+		result = strconv.Itoa(tc.Moves) + "/" + result
+	}
+	if tc.Increment > 0 {
+		result += "+" + strconv.FormatFloat(tc.Increment.Seconds(), 'f', -1, 64)
+	}
+	return result
+}
+
+// Adjudication ends games early on the engines' own scores, in centipawns
+// from the side to move's point of view, or on the tablebase. A count of zero
+// disables a rule.
+type Adjudication struct {
+	// A side resigns once its score has been at or below -ResignScore for
+	// ResignMoves of its moves in a row.
+	ResignScore int
+	ResignMoves int
+	// The game is drawn once both sides' scores have been within DrawScore
+	// of zero for DrawMoves moves each in a row, once the game is
+	// DrawMoveNumber moves long.
+	DrawScore      int
+	DrawMoves      int
+	DrawMoveNumber int
+	// The game is drawn once it reaches MaxMoves moves.
+	MaxMoves int
+	// Tablebase, if set, decides the game once it reaches a position in the
+	// tables.
+	Tablebase *chess.Tablebase
+}
+
+// Results in PGN notation.
+const (
+	whiteWins = "1-0"
+	blackWins = "0-1"
+	drawn     = "1/2-1/2"
+)
+
+// PGN values of the Termination tag.
+const (
+	terminationNormal      = "normal"
+	terminationAdjudicated = "adjudication"
+	terminationTimeForfeit = "time forfeit"
+	terminationInfraction  = "rules infraction"
+	terminationAbandoned   = "abandoned"
+)
+
+var sideNames = [2]string{"White", "Black"}
+
+// playGame plays a game from the opening between two players, each already
+// told that a new game is starting. It only returns an error if ctx is done
+// before the game ends.
+func playGame(ctx context.Context, players [2]*player, opening Opening, tc TimeControl, adjudication Adjudication) (gameRecord, error) {
+	record := gameRecord{
+		date:        time.Now(),
+		white:       players[0].name,
+		black:       players[1].name,
+		startFEN:    opening.FEN,
+		timeControl: tc,
+	}
+
+	position := chess.Position{}
+	if err := position.LoadFEN(opening.FEN); err != nil {
+		return record, err
+	}
+
+	positionCommand := "position fen " + opening.FEN.String() + " moves"
+	if opening.FEN == chess.GetStartingFEN() {
+		positionCommand = "position startpos moves"
+	}
+
+	playMove := func(moveString, comment string) error {
+		san, err := position.SAN(moveString)
+		if err != nil {
+			return err
+		}
+		position.MakeMove(moveString)
+		positionCommand += " " + moveString
+		record.moves = append(record.moves, playedMove{san: san, comment: comment})
+		return nil
+	}
+
+	for _, moveString := range opening.Moves {
+		if err := playMove(moveString, ""); err != nil {
+			return record, fmt.Errorf("bad opening: %w", err)
+		}
+	}
+
+	clocks := [2]time.Duration{tc.Base, tc.Base}
+	movesMade := [2]int{}
+	resignCounts := [2]int{}
+	drawCount := 0
+
+	end := func(result, termination, reason string) (gameRecord, error) {
+		record.result, record.termination, record.reason = result, termination, reason
+		return record, nil
+	}
+	loss := func(side int, termination, reason string) (gameRecord, error) {
+		if side == 0 {
+			return end(blackWins, termination, reason)
+		}
+		return end(whiteWins, termination, reason)
+	}
+
+	for {
+		if result, reason := position.Outcome(); result != "" {
+			return end(result, terminationNormal, reason)
+		}
+		if adjudication.MaxMoves > 0 && len(record.moves) >= 2*adjudication.MaxMoves {
+			return end(drawn, terminationAdjudicated, "Draw by move limit")
+		}
+		if result, reason := adjudication.tablebaseResult(position); result != "" {
+			return end(result, terminationAdjudicated, reason)
+		}
+
+		side := 0
+		if !position.WhiteToMove() {
+			side = 1
+		}
+
+		goCommand := fmt.Sprintf("go wtime %d btime %d winc %d binc %d",
+			clocks[0].Milliseconds(), clocks[1].Milliseconds(), tc.Increment.Milliseconds(), tc.Increment.Milliseconds())
+		if tc.Moves > 0 {
+			goCommand += fmt.Sprintf(" movestogo %d", tc.Moves-movesMade[side]%tc.Moves)
+		}
+
+		searched, err := players[side].search(ctx, strings.TrimSuffix(positionCommand, " moves"), goCommand, clocks[side]+tc.Margin)
+		if ctx.Err() != nil {
+			return record, context.Cause(ctx)
+		}
+		if err != nil {
+			return loss(side, terminationAbandoned, fmt.Sprintf("%s disconnects: %s", sideNames[side], err.Error()))
+		}
+
+		clocks[side] -= searched.elapsed
+		if clocks[side] < -tc.Margin {
+			return loss(side, terminationTimeForfeit, sideNames[side]+" loses on time")
+		}
+		clocks[side] += tc.Increment
+		movesMade[side]++
+		if tc.Moves > 0 && movesMade[side]%tc.Moves == 0 {
+			clocks[side] += tc.Base
+		}
+
+		if err := playMove(searched.bestMove, formatComment(searched)); err != nil {
+			return loss(side, terminationInfraction, fmt.Sprintf("%s makes an illegal move: %s", sideNames[side], searched.bestMove))
+		}
+
+		if adjudication.ResignMoves > 0 {
+			if searched.hasScore && searched.score <= -adjudication.ResignScore {
+				resignCounts[side]++
+			} else {
+				resignCounts[side] = 0
+			}
+			if resignCounts[side] >= adjudication.ResignMoves {
+				return loss(side, terminationAdjudicated, sideNames[1-side]+" wins by adjudication")
+			}
+		}
+
+		if adjudication.DrawMoves > 0 {
+			fullMoves := (len(record.moves) + 1) / 2
+			if fullMoves >= adjudication.DrawMoveNumber && searched.hasScore && abs(searched.score) <= adjudication.DrawScore {
+				drawCount++
+			} else {
+				drawCount = 0
+			}
+			if drawCount >= 2*adjudication.DrawMoves {
+				return end(drawn, terminationAdjudicated, "Draw by adjudication")
+			}
+		}
+	}
+}
+
+// tablebaseResult is the result the tablebase gives for the position, or empty
+// if it has none. Positions are only probed straight after a capture or pawn
+// move, where the fifty move rule cannot yet have changed the result, and cursed
+// wins and blessed losses count as draws.
+func (a Adjudication) tablebaseResult(position chess.Position) (result, reason string) {
+	if a.Tablebase == nil || position.GetFEN().HalfMoveClock != "0" {
+		return "", ""
+	}
+	wdl, ok := a.Tablebase.ProbeWDL(position)
+	if !ok {
+		return "", ""
+	}
+
+	side := 0
+	if !position.WhiteToMove() {
+		side = 1
+	}
+	switch wdl {
+	case chess.WDLWin:
+	case chess.WDLLoss:
+		side = 1 - side
+	default:
+		return drawn, "Draw by tablebase"
+	}
+	if side == 0 {
+		return whiteWins, "White wins by tablebase"
+	}
+	return blackWins, "Black wins by tablebase"
+}
+
+// formatComment describes a search in the style of common GUIs, as the score
+// in pawns from the mover's point of view, the depth and the time taken.
+func formatComment(searched searchResult) string {
+	seconds := fmt.Sprintf("%.3fs", searched.elapsed.Seconds())
+	if !searched.hasScore {
+		return seconds
+	}
+
+	var score string
+	switch {
+	case searched.score > mateScore-1000:
+		score = fmt.Sprintf("+M%d", mateScore-searched.score)
+	case searched.score < -mateScore+1000:
+		score = fmt.Sprintf("-M%d", mateScore+searched.score)
+	default:
+		score = fmt.Sprintf("%+.2f", float64(searched.score)/100)
+	}
+	return fmt.Sprintf("%s/%d %s", score, searched.depth, seconds)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
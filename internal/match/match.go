@@ -0,0 +1,246 @@
+// Package match plays games between two UCI engines and reports the result
+// as a rating difference, optionally stopping once a sequential probability
+// ratio test reaches a verdict.
+package match
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+var logger = logging.For("match")
+
+// errConcluded stops the games in progress once the SPRT reaches a verdict.
+var errConcluded = errors.New("SPRT concluded")
+
+// Config describes a match.
+type Config struct {
+	Event        string
+	Engines      [2]EngineConfig
+	TimeControl  TimeControl
+	Adjudication Adjudication
+	// Openings are played in order, each twice with colours swapped, and
+	// reused from the start if there are fewer than Rounds. If there are no
+	// openings, every game starts from the starting position.
+	Openings []Opening
+	// Rounds is the number of pairs of games to play.
+	Rounds int
+	// Concurrency is the number of games played at once, each between its
+	// own pair of engine processes.
+	Concurrency int
+	// SPRT, if set, ends the match early once the test reaches a verdict.
+	SPRT *SPRT
+	// PGN, if set, receives every finished game.
+	PGN io.Writer
+
+	// startPlayer starts an engine, and may be replaced in tests.
+	startPlayer func(context.Context, EngineConfig) (*player, error)
+}
+
+type job struct {
+	number       int
+	round        int
+	opening      Opening
+	firstIsWhite bool
+}
+
+type gameResult struct {
+	job    job
+	names  [2]string
+	record gameRecord
+	err    error
+}
+
+// Run plays the match, passing a progress report to output after each game,
+// and returns the final score of the first engine. If ctx is done, the games
+// in progress are abandoned and the score so far is returned with the cause.
+func Run(ctx context.Context, config Config, output func(string)) (Score, error) {
+	var score Score
+
+	if config.Rounds < 1 {
+		return score, fmt.Errorf("bad value for rounds: %d", config.Rounds)
+	}
+	if config.Concurrency < 1 {
+		return score, fmt.Errorf("bad value for concurrency: %d", config.Concurrency)
+	}
+	if len(config.Openings) == 0 {
+		config.Openings = []Opening{{FEN: chess.GetStartingFEN()}}
+	}
+	if config.startPlayer == nil {
+		config.startPlayer = startProcess
+	}
+
+	matchCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	jobs := make(chan job)
+	go func() {
+		defer close(jobs)
+		for round := 0; round < config.Rounds; round++ {
+			opening := config.Openings[round%len(config.Openings)]
+			for idx, firstIsWhite := range []bool{true, false} {
+				select {
+				case jobs <- job{2*round + idx + 1, round + 1, opening, firstIsWhite}:
+				case <-matchCtx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	results := make(chan gameResult)
+	var wg sync.WaitGroup
+	for idx := 0; idx < config.Concurrency; idx++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runWorker(matchCtx, config, jobs, results)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	for result := range results {
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+				cancel(result.err)
+			}
+			continue
+		}
+		if matchCtx.Err() != nil {
+			continue
+		}
+
+		record := result.record
+		switch {
+		case record.result == drawn:
+			score.Draws++
+		case (record.result == whiteWins) == result.job.firstIsWhite:
+			score.Wins++
+		default:
+			score.Losses++
+		}
+
+		if config.PGN != nil {
+			if err := record.writePGN(config.PGN); err != nil {
+				firstErr = fmt.Errorf("writing PGN: %w", err)
+				cancel(firstErr)
+				continue
+			}
+		}
+
+		output(fmt.Sprintf("Finished game %d (%s vs %s): %s {%s}", result.job.number, record.white, record.black, record.result, record.reason))
+		output(fmt.Sprintf("Score of %s vs %s: %s", result.names[0], result.names[1], score))
+		elo, margin := score.Elo()
+		output(fmt.Sprintf("Elo difference: %s +/- %s, LOS: %.1f %%", formatElo(elo), formatElo(margin), 100*score.LOS()))
+
+		if config.SPRT != nil {
+			lower, upper := config.SPRT.Bounds()
+			verdict := config.SPRT.Verdict(score)
+			output(fmt.Sprintf("SPRT: llr %.3f, lbound %.3f, ubound %.3f - %s", config.SPRT.LLR(score), lower, upper, verdict))
+			if verdict != Continue {
+				cancel(errConcluded)
+			}
+		}
+	}
+
+	if firstErr != nil {
+		return score, firstErr
+	}
+	if err := context.Cause(ctx); err != nil {
+		return score, err
+	}
+	return score, nil
+}
+
+// runWorker plays games from jobs with its own pair of engines, restarting an
+// engine if it stops responding between games.
+func runWorker(ctx context.Context, config Config, jobs <-chan job, results chan<- gameResult) {
+	var players [2]*player
+	defer func() {
+		for _, p := range players {
+			if p != nil {
+				p.close()
+			}
+		}
+	}()
+
+	fail := func(theJob job, err error) {
+		select {
+		case results <- gameResult{job: theJob, err: err}:
+		case <-ctx.Done():
+		}
+	}
+
+	for theJob := range jobs {
+		for idx := range players {
+			if players[idx] != nil {
+				if err := players[idx].newGame(ctx); err == nil {
+					continue
+				}
+				if ctx.Err() != nil {
+					return
+				}
+				logger.Warn("restarting engine", "engine", players[idx].name)
+				players[idx].close()
+				players[idx] = nil
+			}
+
+			p, err := config.startPlayer(ctx, config.Engines[idx])
+			if err != nil {
+				fail(theJob, err)
+				return
+			}
+			players[idx] = p
+		}
+
+		names := [2]string{players[0].name, players[1].name}
+		if names[0] == names[1] {
+			names[1] += " (2)"
+		}
+
+		gamePlayers := players
+		white, black := names[0], names[1]
+		if !theJob.firstIsWhite {
+			gamePlayers[0], gamePlayers[1] = players[1], players[0]
+			white, black = black, white
+		}
+
+		record, err := playGame(ctx, gamePlayers, theJob.opening, config.TimeControl, config.Adjudication)
+		if err != nil {
+			if ctx.Err() == nil {
+				fail(theJob, err)
+			}
+			return
+		}
+		record.event, record.round = config.Event, theJob.round
+		record.white, record.black = white, black
+
+		select {
+		case results <- gameResult{job: theJob, names: names, record: record}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func formatElo(elo float64) string {
+	switch {
+	case math.IsInf(elo, 1):
+		return "inf"
+	case math.IsInf(elo, -1):
+		return "-inf"
+	}
+	return fmt.Sprintf("%.1f", elo)
+}
@@ -0,0 +1,239 @@
+package match
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+	"github.com/yutanagano/karei/internal/uci"
+)
+
+// startInProcess runs a UCI session with the engine in this process instead
+// of starting an executable.
+func startInProcess(ctx context.Context, config EngineConfig) (*player, error) {
+	toEngine := make(chan string)
+	fromEngine := make(chan string, 100)
+	session := uci.New(toEngine, fromEngine, engine.New())
+
+	finished := make(chan struct{})
+	go func() {
+		session.Start(context.Background())
+		close(fromEngine)
+		close(finished)
+	}()
+
+	p := &player{toEngine: toEngine, fromEngine: fromEngine}
+	p.close = func() error {
+		go func() {
+			for range fromEngine {
+			}
+		}()
+		toEngine <- "quit"
+		<-finished
+		return nil
+	}
+
+	if err := p.handshake(ctx, config); err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func TestParseTimeControl(t *testing.T) {
+	type testCase struct {
+		name     string
+		s        string
+		expected TimeControl
+		isValid  bool
+	}
+
+	testCases := []testCase{
+		{"sudden death", "60", TimeControl{Base: time.Minute}, true},
+		{"increment", "10+0.1", TimeControl{Base: 10 * time.Second, Increment: 100 * time.Millisecond}, true},
+		{"repeating", "40/120+1", TimeControl{Moves: 40, Base: 2 * time.Minute, Increment: time.Second}, true},
+		{"no base", "+1", TimeControl{}, false},
+		{"bad moves", "0/60", TimeControl{}, false},
+		{"negative increment", "60+-1", TimeControl{}, false},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		result, err := ParseTimeControl(c.s)
+		if c.isValid != (err == nil) {
+			t.Fatalf("expected valid %v, got error %v", c.isValid, err)
+		}
+		if c.isValid && result != c.expected {
+			t.Errorf("expected %+v, got %+v", c.expected, result)
+		}
+		if c.isValid && result.String() != c.s {
+			t.Errorf("expected %s to format as itself, got %s", c.s, result.String())
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestReadInfo(t *testing.T) {
+	type testCase struct {
+		name     string
+		line     string
+		expected searchResult
+	}
+
+	testCases := []testCase{
+		{"centipawns", "depth 7 seldepth 9 score cp -35 nodes 1000 pv e2e4", searchResult{score: -35, hasScore: true, depth: 7}},
+		{"mate", "depth 5 score mate 3 pv a1a8", searchResult{score: mateScore - 3, hasScore: true, depth: 5}},
+		{"mated", "depth 5 score mate -2", searchResult{score: -mateScore + 2, hasScore: true, depth: 5}},
+		{"bound", "depth 6 score cp 20 lowerbound", searchResult{score: 20, hasScore: true, depth: 6}},
+		{"wdl", "depth 8 score cp -15 wdl 50 800 150 nodes 900", searchResult{score: -15, hasScore: true, depth: 8}},
+		{"bad score", "depth 4 score cp many", searchResult{depth: 4}},
+		{"string", "string depth 3 score cp 10", searchResult{}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		var result searchResult
+		result.readInfo(strings.Fields(c.line))
+		if result != c.expected {
+			t.Errorf("expected %+v, got %+v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestRun(t *testing.T) {
+	var pgn strings.Builder
+	var output []string
+
+	config := Config{
+		Event:        "Test",
+		Engines:      [2]EngineConfig{{Name: "First"}, {Name: "Second"}},
+		TimeControl:  TimeControl{Base: 5 * time.Second, Increment: 100 * time.Millisecond},
+		Adjudication: Adjudication{MaxMoves: 3},
+		Rounds:       1,
+		Concurrency:  2,
+		SPRT:         &SPRT{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05},
+		PGN:          &pgn,
+		startPlayer:  startInProcess,
+	}
+
+	score, err := Run(context.Background(), config, func(line string) {
+		output = append(output, line)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if score != (Score{Draws: 2}) {
+		t.Errorf("expected two drawn games, got %s", score)
+	}
+	if games := strings.Count(pgn.String(), "[Event "); games != 2 {
+		t.Errorf("expected 2 games in the PGN, got %d", games)
+	}
+	for _, players := range []string{"[White \"First\"]\n[Black \"Second\"]", "[White \"Second\"]\n[Black \"First\"]"} {
+		if !strings.Contains(pgn.String(), players) {
+			t.Errorf("expected a game with %s", players)
+		}
+	}
+	if last := output[len(output)-1]; !strings.HasPrefix(last, "SPRT: ") {
+		t.Errorf("expected the report to end with the SPRT, got %s", last)
+	}
+}
+
+func TestRunStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	config := Config{
+		TimeControl: TimeControl{Base: time.Minute},
+		Rounds:      10,
+		Concurrency: 1,
+		startPlayer: startInProcess,
+	}
+
+	finished := make(chan error)
+	go func() {
+		_, err := Run(ctx, config, func(string) {})
+		finished <- err
+	}()
+
+	select {
+	case err := <-finished:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected deadline exceeded, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("match did not stop")
+	}
+}
+
+// writeKQvK writes single valued KQvK tables in which the side with the queen
+// always wins, which holds wherever the queen cannot be taken.
+func writeKQvK(t *testing.T) string {
+	t.Helper()
+	var b bytes.Buffer
+	b.Write([]byte{0x71, 0xe8, 0x23, 0x5d, 1, 0, 0x55, 0x66, 0xee, 0})
+	b.Write([]byte{0x80, 4, 0x80, 0})
+	for b.Len()%64 != 16 {
+		b.WriteByte(0)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "KQvK.rtbw"), b.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestTablebaseAdjudication(t *testing.T) {
+	tablebase, err := chess.OpenTablebase([]string{writeKQvK(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tablebase.Close()
+
+	type testCase struct {
+		name           string
+		fen            string
+		expectedResult string
+	}
+
+	testCases := []testCase{
+		{"white wins", "8/8/8/8/8/2k5/8/KQ6 w - - 0 1", whiteWins},
+		{"black loses", "8/8/8/8/8/2k5/8/KQ6 b - - 0 1", whiteWins},
+		{"black wins", "8/8/8/8/8/2K5/8/kq6 w - - 0 1", blackWins},
+		{"queen is lost", "8/8/8/8/8/8/6Qk/K7 b - - 0 1", drawn},
+		{"fifty move counter running", "8/8/8/8/8/2k5/8/KQ6 w - - 3 10", ""},
+		{"not in the tables", "8/8/8/8/8/2k5/8/KR6 w - - 0 1", ""},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		fen, err := chess.ParseFEN(c.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		position := chess.Position{}
+		if err := position.LoadFEN(fen); err != nil {
+			t.Fatal(err)
+		}
+
+		result, _ := Adjudication{Tablebase: tablebase}.tablebaseResult(position)
+		if result != c.expectedResult {
+			t.Errorf("expected %q, got %q", c.expectedResult, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
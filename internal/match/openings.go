@@ -0,0 +1,100 @@
+package match
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// Opening is a starting position for a pair of games, given as a FEN and the
+// moves played from it in long algebraic notation.
+type Opening struct {
+	FEN   chess.FEN
+	Moves []string
+}
+
+// LoadOpenings reads an opening book. Files ending in .pgn are read as PGN
+// games, whose moves (and FEN tags, if any) make up the openings. Anything
+// else is read as EPD, one position per line.
+func LoadOpenings(path string) ([]Opening, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var openings []Opening
+	if strings.EqualFold(filepath.Ext(path), ".pgn") {
+		openings, err = readPGNOpenings(f)
+	} else {
+		openings, err = readEPDOpenings(f)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if len(openings) == 0 {
+		return nil, fmt.Errorf("%s: no openings found", path)
+	}
+	return openings, nil
+}
+
+func readEPDOpenings(r io.Reader) ([]Opening, error) {
+	var openings []Opening
+
+	scanner := bufio.NewScanner(r)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		record, err := chess.ParseEPD(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		position := chess.Position{}
+		if err := position.LoadFEN(record.FEN); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		openings = append(openings, Opening{FEN: record.FEN})
+	}
+
+	return openings, scanner.Err()
+}
+
+func readPGNOpenings(r io.Reader) ([]Opening, error) {
+	games, err := chess.ReadPGN(r)
+	if err != nil {
+		return nil, err
+	}
+
+	openings := make([]Opening, 0, len(games))
+	for idx, game := range games {
+		fen, err := game.StartingFEN()
+		if err != nil {
+			return nil, fmt.Errorf("game %d: %w", idx+1, err)
+		}
+		opening := Opening{FEN: fen}
+
+		position := chess.Position{}
+		if err := position.LoadFEN(opening.FEN); err != nil {
+			return nil, fmt.Errorf("game %d: %w", idx+1, err)
+		}
+		for _, san := range game.Moves {
+			moveString, err := position.ParseSAN(san)
+			if err != nil {
+				return nil, fmt.Errorf("game %d: %w", idx+1, err)
+			}
+			position.MakeMove(moveString)
+			opening.Moves = append(opening.Moves, moveString)
+		}
+
+		openings = append(openings, opening)
+	}
+	return openings, nil
+}
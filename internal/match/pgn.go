@@ -0,0 +1,105 @@
+package match
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// pgnLineLength is the longest movetext line written, as recommended by the
+// PGN standard.
+const pgnLineLength = 79
+
+// playedMove is a move of a game in SAN, with a comment to follow it.
+type playedMove struct {
+	san     string
+	comment string
+}
+
+// gameRecord is everything written to PGN about a finished game.
+type gameRecord struct {
+	event       string
+	round       int
+	date        time.Time
+	white       string
+	black       string
+	startFEN    chess.FEN
+	timeControl TimeControl
+	moves       []playedMove
+	result      string
+	termination string
+	reason      string
+}
+
+func (g gameRecord) writePGN(w io.Writer) error {
+	var b strings.Builder
+
+	writeTag := func(name, value string) {
+		fmt.Fprintf(&b, "[%s %s]\n", name, strconv.Quote(value))
+	}
+	writeTag("Event", g.event)
+	writeTag("Site", "?")
+	writeTag("Date", g.date.Format("2006.01.02"))
+	writeTag("Round", strconv.Itoa(g.round))
+	writeTag("White", g.white)
+	writeTag("Black", g.black)
+	writeTag("Result", g.result)
+	if g.startFEN != chess.GetStartingFEN() {
+		writeTag("SetUp", "1")
+		writeTag("FEN", g.startFEN.String())
+	}
+	writeTag("TimeControl", g.timeControl.String())
+	writeTag("PlyCount", strconv.Itoa(len(g.moves)))
+	writeTag("Termination", g.termination)
+	b.WriteString("\n")
+
+	var tokens []string
+	whiteToMove := g.startFEN.ActiveColour == "w"
+	moveNumber, err := strconv.Atoi(g.startFEN.FullMoveNumber)
+	if err != nil || moveNumber < 1 {
+		moveNumber = 1
+	}
+	for idx, theMove := range g.moves {
+		switch {
+		case whiteToMove:
+			tokens = append(tokens, strconv.Itoa(moveNumber)+".")
+		case idx == 0:
+			tokens = append(tokens, strconv.Itoa(moveNumber)+"...")
+		}
+		tokens = append(tokens, theMove.san)
+		if theMove.comment != "" {
+			tokens = append(tokens, "{"+theMove.comment+"}")
+		}
+
+		if !whiteToMove {
+			moveNumber++
+		}
+		whiteToMove = !whiteToMove
+	}
+	if g.reason != "" {
+		tokens = append(tokens, "{"+g.reason+"}")
+	}
+	tokens = append(tokens, g.result)
+
+	lineLength := 0
+	for _, token := range tokens {
+		if lineLength > 0 && lineLength+1+len(token) > pgnLineLength {
+			b.WriteString("\n")
+			lineLength = 0
+		}
+		if lineLength > 0 {
+			b.WriteString(" ")
+			lineLength++
+		}
+		b.WriteString(token)
+		lineLength += len(token)
+	}
+	b.WriteString("\n\n")
+
+	_, err = io.WriteString(w, b.String())
+	return err
+}
@@ -0,0 +1,79 @@
+package match
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func TestReadPGNOpenings(t *testing.T) {
+	openings, err := readPGNOpenings(strings.NewReader(`[FEN "4k3/8/8/8/8/8/8/4K2R b K - 0 30"]
+
+30... Kd7 31. O-O *
+
+1. d4 d5 2. c4 *
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fen, _ := chess.ParseFEN("4k3/8/8/8/8/8/8/4K2R b K - 0 30")
+	expected := []Opening{
+		{fen, []string{"e8d7", "e1g1"}},
+		{chess.GetStartingFEN(), []string{"d2d4", "d7d5", "c2c4"}},
+	}
+	if !reflect.DeepEqual(openings, expected) {
+		t.Errorf("expected %v, got %v", expected, openings)
+	}
+}
+
+func TestWritePGN(t *testing.T) {
+	fen, _ := chess.ParseFEN("4k3/8/8/8/8/8/8/4K2R b K - 0 30")
+	record := gameRecord{
+		event:       "Test",
+		round:       2,
+		date:        time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		white:       "White engine",
+		black:       "Black engine",
+		startFEN:    fen,
+		timeControl: TimeControl{Moves: 40, Base: time.Minute},
+		moves: []playedMove{
+			{"Kd7", ""},
+			{"O-O", "+1.50/12 0.250s"},
+			{"Ke6", "-1.50/11 0.300s"},
+			{"Rf6+", "+M9/12 0.280s"},
+		},
+		result:      whiteWins,
+		termination: terminationAdjudicated,
+		reason:      "White wins by adjudication",
+	}
+
+	var b strings.Builder
+	if err := record.writePGN(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[Event "Test"]
+[Site "?"]
+[Date "2024.03.01"]
+[Round "2"]
+[White "White engine"]
+[Black "Black engine"]
+[Result "1-0"]
+[SetUp "1"]
+[FEN "4k3/8/8/8/8/8/8/4K2R b K - 0 30"]
+[TimeControl "40/60"]
+[PlyCount "4"]
+[Termination "adjudication"]
+
+30... Kd7 31. O-O {+1.50/12 0.250s} Ke6 {-1.50/11 0.300s} 32. Rf6+
+{+M9/12 0.280s} {White wins by adjudication} 1-0
+
+`
+	if b.String() != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, b.String())
+	}
+}
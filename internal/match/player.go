@@ -0,0 +1,220 @@
+package match
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei/internal/console"
+	"github.com/yutanagano/karei/internal/uci"
+)
+
+const (
+	// handshakeTimeOut bounds the wait for uciok and readyok.
+	handshakeTimeOut = 10 * time.Second
+	// quitTimeOut is how long an engine may take to exit after quit before it
+	// is killed.
+	quitTimeOut = time.Second
+	// mateScore is the centipawn score given to a mate in 0, reduced by one
+	// for every move until mate.
+	mateScore = 30000
+)
+
+// EngineConfig tells a match how to start one of its engines.
+type EngineConfig struct {
+	// Name overrides the name the engine gives itself.
+	Name    string
+	Path    string
+	Args    []string
+	Options map[string]string
+}
+
+var errEngineExited = errors.New("engine exited")
+
+// player talks UCI to an engine over a pair of channels.
+type player struct {
+	name       string
+	toEngine   chan<- string
+	fromEngine <-chan string
+	close      func() error
+}
+
+// searchResult is the answer of an engine to a go command, along with the last
+// score it reported.
+type searchResult struct {
+	bestMove string
+	score    int
+	hasScore bool
+	depth    int
+	elapsed  time.Duration
+}
+
+// startProcess runs an engine executable and completes the UCI handshake.
+func startProcess(ctx context.Context, config EngineConfig) (*player, error) {
+	cmd := exec.Command(config.Path, config.Args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	reader := console.NewReader(stdout)
+	writer := console.NewWriter(stdin)
+	exited := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		close(exited)
+	}()
+
+	p := &player{toEngine: writer.Lines, fromEngine: reader.Lines}
+	p.close = func() error {
+		writer.Lines <- "quit"
+		writer.Close()
+		stdin.Close()
+
+		select {
+		case <-exited:
+		case <-time.After(quitTimeOut):
+			cmd.Process.Kill()
+			<-exited
+		}
+		return nil
+	}
+
+	if err := p.handshake(ctx, config); err != nil {
+		p.close()
+		return nil, fmt.Errorf("starting %s: %w", config.Path, err)
+	}
+	return p, nil
+}
+
+func (p *player) send(line string) {
+	p.toEngine <- line
+}
+
+// waitFor reads lines from the engine until one is accepted by handle, the
+// time out passes or ctx is done.
+func (p *player) waitFor(ctx context.Context, timeOut time.Duration, handle func(line string) bool) error {
+	timer := time.NewTimer(timeOut)
+	defer timer.Stop()
+
+	for {
+		select {
+		case line, ok := <-p.fromEngine:
+			if !ok {
+				return errEngineExited
+			}
+			if handle(line) {
+				return nil
+			}
+		case <-timer.C:
+			return fmt.Errorf("no answer from engine within %v", timeOut)
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
+
+func (p *player) handshake(ctx context.Context, config EngineConfig) error {
+	p.send("uci")
+	err := p.waitFor(ctx, handshakeTimeOut, func(line string) bool {
+		if name, ok := strings.CutPrefix(line, "id name "); ok {
+			p.name = name
+		}
+		return line == "uciok"
+	})
+	if err != nil {
+		return err
+	}
+
+	if config.Name != "" {
+		p.name = config.Name
+	}
+	if p.name == "" {
+		p.name = config.Path
+	}
+
+	for name, value := range config.Options {
+		p.send("setoption name " + name + " value " + value)
+	}
+	return p.isReady(ctx)
+}
+
+func (p *player) isReady(ctx context.Context) error {
+	p.send("isready")
+	return p.waitFor(ctx, handshakeTimeOut, func(line string) bool {
+		return line == "readyok"
+	})
+}
+
+func (p *player) newGame(ctx context.Context) error {
+	p.send("ucinewgame")
+	return p.isReady(ctx)
+}
+
+// search sends the position and go commands and waits up to timeOut for the
+// best move. If the time runs out, the engine is told to stop and given a
+// little longer to answer.
+func (p *player) search(ctx context.Context, positionCommand, goCommand string, timeOut time.Duration) (searchResult, error) {
+	var result searchResult
+
+	handle := func(line string) bool {
+		tokens := strings.Fields(line)
+		switch {
+		case len(tokens) >= 2 && tokens[0] == "bestmove":
+			result.bestMove = tokens[1]
+			return true
+		case len(tokens) > 0 && tokens[0] == "info":
+			result.readInfo(tokens[1:])
+		}
+		return false
+	}
+
+	p.send(positionCommand)
+	p.send(goCommand)
+	startTime := time.Now()
+
+	err := p.waitFor(ctx, timeOut, handle)
+	if err != nil && !errors.Is(err, errEngineExited) && ctx.Err() == nil {
+		p.send("stop")
+		err = p.waitFor(ctx, quitTimeOut, handle)
+	}
+	result.elapsed = time.Since(startTime)
+	return result, err
+}
+
+func (r *searchResult) readInfo(tokens []string) {
+	for idx := 0; idx+1 < len(tokens); idx++ {
+		switch tokens[idx] {
+		case "string":
+			return
+		case "depth":
+			if depth, err := strconv.Atoi(tokens[idx+1]); err == nil {
+				r.depth = depth
+			}
+		case "score":
+			score, used, err := uci.ParseScore(tokens[idx+1:])
+			if err != nil {
+				continue
+			}
+			idx += used
+			r.score, r.hasScore = score.Value, true
+			if score.Mate {
+				r.score = mateScore - score.Value
+				if score.Value <= 0 {
+					r.score = -mateScore - score.Value
+				}
+			}
+		}
+	}
+}
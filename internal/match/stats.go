@@ -0,0 +1,139 @@
+package match
+
+import (
+	"fmt"
+	"math"
+)
+
+// Score counts the results of a match from the point of view of the first
+// engine.
+type Score struct {
+	Wins   int
+	Draws  int
+	Losses int
+}
+
+func (s Score) Games() int {
+	return s.Wins + s.Draws + s.Losses
+}
+
+// Ratio is the fraction of points won, counting draws as half a point.
+func (s Score) Ratio() float64 {
+	if s.Games() == 0 {
+		return 0.5
+	}
+	return (float64(s.Wins) + float64(s.Draws)/2) / float64(s.Games())
+}
+
+// variance is the variance of the points won in a single game.
+func (s Score) variance() float64 {
+	n := float64(s.Games())
+	ratio := s.Ratio()
+	return (float64(s.Wins)*math.Pow(1-ratio, 2) +
+		float64(s.Draws)*math.Pow(0.5-ratio, 2) +
+		float64(s.Losses)*math.Pow(ratio, 2)) / n
+}
+
+// Elo estimates the rating difference between the engines, with the margin of
+// its 95% confidence interval.
+func (s Score) Elo() (elo, margin float64) {
+	ratio := s.Ratio()
+	if s.Games() == 0 || ratio == 0 || ratio == 1 {
+		return eloFromRatio(ratio), math.Inf(1)
+	}
+
+	standardError := math.Sqrt(s.variance() / float64(s.Games()))
+	const z95 = 1.959964
+	lower := eloFromRatio(ratio - z95*standardError)
+	upper := eloFromRatio(ratio + z95*standardError)
+	return eloFromRatio(ratio), (upper - lower) / 2
+}
+
+// LOS is the likelihood of superiority, the probability that the first engine
+// is the stronger one given its wins and losses.
+func (s Score) LOS() float64 {
+	if s.Wins+s.Losses == 0 {
+		return 0.5
+	}
+	return 0.5 * (1 + math.Erf(float64(s.Wins-s.Losses)/math.Sqrt(2*float64(s.Wins+s.Losses))))
+}
+
+func (s Score) String() string {
+	return fmt.Sprintf("%d - %d - %d  [%.3f] %d", s.Wins, s.Losses, s.Draws, s.Ratio(), s.Games())
+}
+
+func eloFromRatio(ratio float64) float64 {
+	switch {
+	case ratio <= 0:
+		return math.Inf(-1)
+	case ratio >= 1:
+		return math.Inf(1)
+	}
+	return 400 * math.Log10(ratio/(1-ratio))
+}
+
+func ratioFromElo(elo float64) float64 {
+	return 1 / (1 + math.Pow(10, -elo/400))
+}
+
+// SPRT is a sequential probability ratio test of the hypothesis that the first
+// engine is Elo1 stronger than the second (H1) against the hypothesis that it
+// is Elo0 stronger (H0), with false positive rate Alpha and false negative rate
+// Beta.
+type SPRT struct {
+	Elo0  float64
+	Elo1  float64
+	Alpha float64
+	Beta  float64
+}
+
+// Verdict is the conclusion of an SPRT so far.
+type Verdict uint8
+
+const (
+	Continue Verdict = iota
+	AcceptH0
+	AcceptH1
+)
+
+func (v Verdict) String() string {
+	switch v {
+	case AcceptH0:
+		return "H0 was accepted"
+	case AcceptH1:
+		return "H1 was accepted"
+	}
+	return "no conclusion yet"
+}
+
+// Bounds are the log-likelihood ratios at which the test accepts H0 and H1.
+func (t SPRT) Bounds() (lower, upper float64) {
+	return math.Log(t.Beta / (1 - t.Alpha)), math.Log((1 - t.Beta) / t.Alpha)
+}
+
+// LLR approximates the log-likelihood ratio of H1 to H0 given the score, by
+// treating the mean score as normally distributed.
+func (t SPRT) LLR(s Score) float64 {
+	if s.Games() == 0 {
+		return 0
+	}
+	variance := s.variance()
+	if variance == 0 {
+		return 0
+	}
+
+	ratio0, ratio1 := ratioFromElo(t.Elo0), ratioFromElo(t.Elo1)
+	return float64(s.Games()) * (ratio1 - ratio0) * (2*s.Ratio() - ratio0 - ratio1) / (2 * variance)
+}
+
+func (t SPRT) Verdict(s Score) Verdict {
+	llr := t.LLR(s)
+	lower, upper := t.Bounds()
+	switch {
+	case llr >= upper:
+		return AcceptH1
+	case llr <= lower:
+		return AcceptH0
+	}
+	return Continue
+}
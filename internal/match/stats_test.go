@@ -0,0 +1,83 @@
+package match
+
+import (
+	"math"
+	"testing"
+)
+
+func TestElo(t *testing.T) {
+	type testCase struct {
+		name   string
+		score  Score
+		elo    float64
+		margin float64
+	}
+
+	testCases := []testCase{
+		{"even", Score{Wins: 30, Draws: 40, Losses: 30}, 0, 53.2},
+		{"ahead", Score{Wins: 60, Draws: 20, Losses: 20}, 147.2, 66.0},
+		{"behind", Score{Wins: 20, Draws: 20, Losses: 60}, -147.2, 66.0},
+		{"all draws", Score{Draws: 10}, 0, 0},
+		{"all wins", Score{Wins: 3}, math.Inf(1), math.Inf(1)},
+		{"no games", Score{}, 0, math.Inf(1)},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		elo, margin := c.score.Elo()
+		if !closeTo(elo, c.elo, 0.1) || !closeTo(margin, c.margin, 0.1) {
+			t.Errorf("expected %.1f +/- %.1f, got %.1f +/- %.1f", c.elo, c.margin, elo, margin)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestLOS(t *testing.T) {
+	if los := (Score{Wins: 10, Losses: 10}).LOS(); los != 0.5 {
+		t.Errorf("expected LOS 0.5 for an even score, got %f", los)
+	}
+	if los := (Score{Wins: 60, Losses: 40}).LOS(); !closeTo(los, 0.977, 0.001) {
+		t.Errorf("expected LOS 0.977, got %f", los)
+	}
+}
+
+func TestSPRT(t *testing.T) {
+	type testCase struct {
+		name    string
+		score   Score
+		verdict Verdict
+	}
+
+	sprt := SPRT{Elo0: 0, Elo1: 10, Alpha: 0.05, Beta: 0.05}
+
+	testCases := []testCase{
+		{"too few games", Score{Wins: 12, Draws: 10, Losses: 8}, Continue},
+		{"clearly stronger", Score{Wins: 600, Draws: 800, Losses: 450}, AcceptH1},
+		{"clearly not stronger", Score{Wins: 450, Draws: 800, Losses: 600}, AcceptH0},
+		{"in between", Score{Wins: 510, Draws: 800, Losses: 500}, Continue},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		if verdict := sprt.Verdict(c.score); verdict != c.verdict {
+			t.Errorf("expected %s, got %s (llr %.3f)", c.verdict, verdict, sprt.LLR(c.score))
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+
+	lower, upper := sprt.Bounds()
+	if !closeTo(lower, -2.944, 0.001) || !closeTo(upper, 2.944, 0.001) {
+		t.Errorf("expected bounds -2.944 and 2.944, got %.3f and %.3f", lower, upper)
+	}
+}
+
+func closeTo(x, y, tolerance float64) bool {
+	if math.IsInf(x, 0) || math.IsInf(y, 0) {
+		return x == y
+	}
+	return math.Abs(x-y) <= tolerance
+}
@@ -0,0 +1,555 @@
+// Package server serves analysis and move generation over HTTP with JSON
+// bodies.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei"
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+var logger = logging.For("server")
+
+// errSearchTimeLimit ends searches that run past Config.MaxSearchTime.
+var errSearchTimeLimit = errors.New("search time limit reached")
+
+// Config sets the resources a Server may use.
+type Config struct {
+	// Engines is the number of searches that may run at once.
+	Engines int
+	// QueueSize is the number of analysis requests that may wait for an
+	// engine. Further requests are turned away with 503 Service Unavailable.
+	QueueSize int
+	// MaxSearchTime caps the length of every search, whatever its limits.
+	MaxSearchTime time.Duration
+	// MaxPerftDepth is the deepest perft a client may ask for.
+	MaxPerftDepth int
+	// EngineOptions are used to create each engine.
+	EngineOptions karei.Options
+}
+
+// DefaultConfig returns the configuration used when serve is given no flags.
+func DefaultConfig() Config {
+	return Config{
+		Engines:       1,
+		QueueSize:     16,
+		MaxSearchTime: 30 * time.Second,
+		MaxPerftDepth: 5,
+	}
+}
+
+// Server is an http.Handler answering the following requests:
+//
+//	POST /analyse          search a position and return the best move
+//	GET|POST /analyse/stream
+//	                       search a position, sending progress as server-sent events
+//	POST /legal-moves      list the legal moves of a position
+//	POST /perft            count the leaf nodes of the move tree of a position
+//	POST /validate-fen     check and normalise a FEN
+//
+// Positions are given as a FEN, empty for the starting position, and a list
+// of moves in long algebraic notation played from it.
+type Server struct {
+	config  Config
+	engines chan *karei.Engine
+	queue   chan struct{}
+	mux     *http.ServeMux
+}
+
+func New(config Config) (*Server, error) {
+	if config.Engines < 1 {
+		return nil, fmt.Errorf("bad value for engines: %d", config.Engines)
+	}
+	if config.QueueSize < 0 {
+		return nil, fmt.Errorf("bad value for queue size: %d", config.QueueSize)
+	}
+	if config.MaxSearchTime <= 0 {
+		return nil, fmt.Errorf("bad value for maximum search time: %v", config.MaxSearchTime)
+	}
+	if config.MaxPerftDepth < 1 {
+		return nil, fmt.Errorf("bad value for maximum perft depth: %d", config.MaxPerftDepth)
+	}
+
+	s := &Server{
+		config:  config,
+		engines: make(chan *karei.Engine, config.Engines),
+		queue:   make(chan struct{}, config.Engines+config.QueueSize),
+		mux:     http.NewServeMux(),
+	}
+
+	for idx := 0; idx < config.Engines; idx++ {
+		e, err := karei.NewEngine(config.EngineOptions)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.engines <- e
+	}
+
+	s.mux.HandleFunc("/analyse", s.handleAnalyse)
+	s.mux.HandleFunc("/analyse/stream", s.handleAnalyseStream)
+	s.mux.HandleFunc("/legal-moves", s.handleLegalMoves)
+	s.mux.HandleFunc("/perft", s.handlePerft)
+	s.mux.HandleFunc("/validate-fen", s.handleValidateFEN)
+	return s, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	s.mux.ServeHTTP(w, r)
+	logger.Info("request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "time", time.Since(startTime))
+}
+
+// Close stops any running searches and releases the engines. It must only be
+// called once no more requests are being served.
+func (s *Server) Close() error {
+	for {
+		select {
+		case e := <-s.engines:
+			e.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type positionRequest struct {
+	FEN   string   `json:"fen"`
+	Moves []string `json:"moves"`
+}
+
+type analyseRequest struct {
+	positionRequest
+	Depth       int      `json:"depth"`
+	Nodes       uint64   `json:"nodes"`
+	MoveTime    int      `json:"movetime"`
+	SearchMoves []string `json:"searchmoves"`
+}
+
+type perftRequest struct {
+	positionRequest
+	Depth int `json:"depth"`
+}
+
+type score struct {
+	Type  string `json:"type"`
+	Value int    `json:"value"`
+}
+
+type infoResponse struct {
+	Depth    int      `json:"depth"`
+	SelDepth int      `json:"seldepth"`
+	Score    score    `json:"score"`
+	Nodes    uint64   `json:"nodes"`
+	Time     int64    `json:"time"`
+	PV       []string `json:"pv"`
+}
+
+type analyseResponse struct {
+	BestMove   string `json:"bestmove"`
+	PonderMove string `json:"ponder,omitempty"`
+	infoResponse
+}
+
+type legalMove struct {
+	UCI string `json:"uci"`
+	SAN string `json:"san"`
+}
+
+type legalMovesResponse struct {
+	Moves  []legalMove `json:"moves"`
+	Result string      `json:"result,omitempty"`
+	Reason string      `json:"reason,omitempty"`
+}
+
+type perftResponse struct {
+	Nodes   uint64            `json:"nodes"`
+	Divided map[string]uint64 `json:"divided"`
+}
+
+type validateFENResponse struct {
+	Valid bool   `json:"valid"`
+	FEN   string `json:"fen,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+func (s *Server) handleAnalyse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request analyseRequest
+	if err := decodeBody(r, &request); err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	result, status, err := s.analyse(r.Context(), request, nil)
+	if err != nil {
+		sendError(w, status, err)
+		return
+	}
+	sendJSON(w, http.StatusOK, newAnalyseResponse(result))
+}
+
+// handleAnalyseStream sends an info event after each iteration of the search,
+// and a bestmove event with the result, or an error event, at the end. GET
+// requests take their parameters from the query string for the benefit of
+// browsers' EventSource, with moves and searchmoves separated by spaces or
+// commas.
+func (s *Server) handleAnalyseStream(w http.ResponseWriter, r *http.Request) {
+	var request analyseRequest
+	switch r.Method {
+	case http.MethodGet:
+		if err := decodeQuery(r, &request); err != nil {
+			sendError(w, http.StatusBadRequest, err)
+			return
+		}
+	case http.MethodPost:
+		if err := decodeBody(r, &request); err != nil {
+			sendError(w, http.StatusBadRequest, err)
+			return
+		}
+	default:
+		sendMethodNotAllowed(w, http.MethodGet, http.MethodPost)
+		return
+	}
+
+	controller := http.NewResponseController(w)
+	started := false
+	sendEvent := func(event string, data any) {
+		if !started {
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			logger.Error("encoding event", "event", event, "error", err.Error())
+			return
+		}
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, encoded)
+		controller.Flush()
+	}
+
+	result, status, err := s.analyse(r.Context(), request, func(info karei.Info) {
+		sendEvent("info", newInfoResponse(info))
+	})
+	switch {
+	case err != nil && !started:
+		sendError(w, status, err)
+	case err != nil:
+		sendEvent("error", errorResponse{err.Error()})
+	default:
+		sendEvent("bestmove", newAnalyseResponse(result))
+	}
+}
+
+// analyse waits for a free engine and runs the search, returning a status
+// code to go with any error.
+func (s *Server) analyse(ctx context.Context, request analyseRequest, info func(karei.Info)) (karei.Result, int, error) {
+	if _, err := loadPosition(request.positionRequest); err != nil {
+		return karei.Result{}, http.StatusBadRequest, err
+	}
+
+	limits, err := request.limits()
+	if err != nil {
+		return karei.Result{}, http.StatusBadRequest, err
+	}
+	limits.Info = info
+
+	e, release, err := s.acquire(ctx)
+	if err != nil {
+		return karei.Result{}, http.StatusServiceUnavailable, err
+	}
+	defer release()
+
+	if err := e.SetPosition(request.FEN, request.Moves); err != nil {
+		return karei.Result{}, http.StatusBadRequest, err
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, s.config.MaxSearchTime, errSearchTimeLimit)
+	defer cancel()
+
+	result, err := e.Search(ctx, limits)
+	if err != nil && !errors.Is(err, errSearchTimeLimit) {
+		return result, http.StatusServiceUnavailable, err
+	}
+	if result.BestMove == "" {
+		return result, http.StatusBadRequest, errors.New("no legal moves")
+	}
+	return result, http.StatusOK, nil
+}
+
+// acquire takes a place in the queue and waits for a free engine. The
+// release function must be called to hand the engine back, fresh for the next
+// request, and give up the place.
+func (s *Server) acquire(ctx context.Context) (*karei.Engine, func(), error) {
+	select {
+	case s.queue <- struct{}{}:
+	default:
+		return nil, nil, errors.New("too many requests queued")
+	}
+
+	select {
+	case e := <-s.engines:
+		return e, func() {
+			e.NewGame()
+			s.engines <- e
+			<-s.queue
+		}, nil
+	case <-ctx.Done():
+		<-s.queue
+		return nil, nil, context.Cause(ctx)
+	}
+}
+
+func (r analyseRequest) limits() (karei.Limits, error) {
+	switch {
+	case r.Depth < 0:
+		return karei.Limits{}, fmt.Errorf("bad value for depth: %d", r.Depth)
+	case r.MoveTime < 0:
+		return karei.Limits{}, fmt.Errorf("bad value for movetime: %d", r.MoveTime)
+	case r.Depth == 0 && r.Nodes == 0 && r.MoveTime == 0:
+		return karei.Limits{}, errors.New("analysis needs a positive depth, nodes or movetime")
+	}
+
+	return karei.Limits{
+		SearchMoves: r.SearchMoves,
+		Depth:       r.Depth,
+		Nodes:       r.Nodes,
+		MoveTime:    time.Duration(r.MoveTime) * time.Millisecond,
+	}, nil
+}
+
+func (s *Server) handleLegalMoves(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request positionRequest
+	if err := decodeBody(r, &request); err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	position, err := loadPosition(request)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	response := legalMovesResponse{Moves: []legalMove{}}
+	moves := position.LegalMoves()
+	slices.Sort(moves)
+	for _, moveString := range moves {
+		san, err := position.SAN(moveString)
+		if err != nil {
+			sendError(w, http.StatusInternalServerError, err)
+			return
+		}
+		response.Moves = append(response.Moves, legalMove{UCI: moveString, SAN: san})
+	}
+	response.Result, response.Reason = position.Outcome()
+
+	sendJSON(w, http.StatusOK, response)
+}
+
+func (s *Server) handlePerft(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request perftRequest
+	if err := decodeBody(r, &request); err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.Depth < 1 || request.Depth > s.config.MaxPerftDepth {
+		sendError(w, http.StatusBadRequest, fmt.Errorf("bad value for depth: %d not in range 1 to %d", request.Depth, s.config.MaxPerftDepth))
+		return
+	}
+	position, err := loadPosition(request.positionRequest)
+	if err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// Perft holds an engine while it runs so that it shares the limit on
+	// concurrent work with analysis.
+	_, release, err := s.acquire(r.Context())
+	if err != nil {
+		sendError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer release()
+
+	divided, err := position.DivideContext(r.Context(), request.Depth)
+	if err != nil {
+		sendError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	response := perftResponse{Divided: divided}
+	for _, count := range response.Divided {
+		response.Nodes += count
+	}
+	sendJSON(w, http.StatusOK, response)
+}
+
+// handleValidateFEN answers with the FEN as the engine would write it if it
+// is valid, and the reason if not. Invalid FENs are not an error.
+func (s *Server) handleValidateFEN(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		sendMethodNotAllowed(w, http.MethodPost)
+		return
+	}
+
+	var request struct {
+		FEN string `json:"fen"`
+	}
+	if err := decodeBody(r, &request); err != nil {
+		sendError(w, http.StatusBadRequest, err)
+		return
+	}
+	if request.FEN == "" {
+		sendError(w, http.StatusBadRequest, errors.New("missing fen"))
+		return
+	}
+
+	position, err := loadPosition(positionRequest{FEN: request.FEN})
+	if err != nil {
+		sendJSON(w, http.StatusOK, validateFENResponse{Error: err.Error()})
+		return
+	}
+	sendJSON(w, http.StatusOK, validateFENResponse{Valid: true, FEN: position.GetFEN().String()})
+}
+
+func loadPosition(request positionRequest) (chess.Position, error) {
+	position := chess.Position{}
+
+	fen := chess.GetStartingFEN()
+	if request.FEN != "" && request.FEN != "startpos" {
+		var err error
+		if fen, err = chess.ParseFEN(request.FEN); err != nil {
+			return position, err
+		}
+	}
+	if err := position.LoadFEN(fen); err != nil {
+		return position, err
+	}
+
+	for idx, moveString := range request.Moves {
+		if err := position.MakeMove(moveString); err != nil {
+			return position, fmt.Errorf("illegal move at index %d (%s): %w", idx, moveString, err)
+		}
+	}
+	return position, nil
+}
+
+func newInfoResponse(info karei.Info) infoResponse {
+	response := infoResponse{
+		Depth:    info.Depth,
+		SelDepth: info.SelDepth,
+		Score:    score{"cp", info.Score},
+		Nodes:    info.Nodes,
+		Time:     info.Time.Milliseconds(),
+		PV:       info.PV,
+	}
+	if info.MateIn != 0 {
+		response.Score = score{"mate", info.MateIn}
+	}
+	if response.PV == nil {
+		response.PV = []string{}
+	}
+	return response
+}
+
+func newAnalyseResponse(result karei.Result) analyseResponse {
+	return analyseResponse{
+		BestMove:     result.BestMove,
+		PonderMove:   result.PonderMove,
+		infoResponse: newInfoResponse(result.Info),
+	}
+}
+
+const maxBodySize = 1 << 16
+
+func decodeBody(r *http.Request, v any) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("bad request body: %s", err.Error())
+	}
+	return nil
+}
+
+func decodeQuery(r *http.Request, request *analyseRequest) error {
+	query := r.URL.Query()
+	splitMoves := func(s string) []string {
+		return strings.FieldsFunc(s, func(c rune) bool { return c == ' ' || c == ',' })
+	}
+
+	request.FEN = query.Get("fen")
+	request.Moves = splitMoves(query.Get("moves"))
+	request.SearchMoves = splitMoves(query.Get("searchmoves"))
+
+	for _, field := range []struct {
+		name  string
+		value *int
+	}{{"depth", &request.Depth}, {"movetime", &request.MoveTime}} {
+		if s := query.Get(field.name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("bad value for %s: %s", field.name, err.Error())
+			}
+			*field.value = n
+		}
+	}
+	if s := query.Get("nodes"); s != "" {
+		n, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad value for nodes: %s", err.Error())
+		}
+		request.Nodes = n
+	}
+	return nil
+}
+
+func sendJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Warn("writing response", "error", err.Error())
+	}
+}
+
+func sendError(w http.ResponseWriter, status int, err error) {
+	logger.Debug("request failed", "status", status, "error", err.Error())
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "1")
+	}
+	sendJSON(w, status, errorResponse{err.Error()})
+}
+
+func sendMethodNotAllowed(w http.ResponseWriter, methods ...string) {
+	w.Header().Set("Allow", strings.Join(methods, ", "))
+	sendJSON(w, http.StatusMethodNotAllowed, errorResponse{"method not allowed"})
+}
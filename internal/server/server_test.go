@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T, config Config) *httptest.Server {
+	handler, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testServer := httptest.NewServer(handler)
+	t.Cleanup(func() {
+		testServer.Close()
+		handler.Close()
+	})
+	return testServer
+}
+
+func TestRequests(t *testing.T) {
+	type testCase struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		status   int
+		expected map[string]any
+	}
+
+	testCases := []testCase{
+		{
+			"analyse mate in one",
+			http.MethodPost,
+			"/analyse",
+			`{"fen": "6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", "depth": 2}`,
+			http.StatusOK,
+			map[string]any{"bestmove": "a1a8", "score": map[string]any{"type": "mate", "value": 1.0}},
+		},
+		{
+			"analyse with searchmoves",
+			http.MethodPost,
+			"/analyse",
+			`{"moves": ["e2e4"], "depth": 1, "searchmoves": ["a7a6"]}`,
+			http.StatusOK,
+			map[string]any{"bestmove": "a7a6"},
+		},
+		{
+			"analyse without limits",
+			http.MethodPost,
+			"/analyse",
+			`{"fen": ""}`,
+			http.StatusBadRequest,
+			map[string]any{"error": "analysis needs a positive depth, nodes or movetime"},
+		},
+		{
+			"analyse checkmate",
+			http.MethodPost,
+			"/analyse",
+			`{"moves": ["f2f3", "e7e5", "g2g4", "d8h4"], "depth": 1}`,
+			http.StatusBadRequest,
+			map[string]any{"error": "no legal moves"},
+		},
+		{
+			"analyse with illegal move",
+			http.MethodPost,
+			"/analyse",
+			`{"moves": ["e2e5"], "depth": 1}`,
+			http.StatusBadRequest,
+			map[string]any{"error": "illegal move at index 0 (e2e5): illegal move in current position: e2e5"},
+		},
+		{
+			"analyse with unknown field",
+			http.MethodPost,
+			"/analyse",
+			`{"dept": 1}`,
+			http.StatusBadRequest,
+			map[string]any{"error": `bad request body: json: unknown field "dept"`},
+		},
+		{
+			"analyse with get",
+			http.MethodGet,
+			"/analyse",
+			"",
+			http.StatusMethodNotAllowed,
+			map[string]any{"error": "method not allowed"},
+		},
+		{
+			"legal moves",
+			http.MethodPost,
+			"/legal-moves",
+			`{"fen": "4k3/8/8/8/8/8/8/4K2R w K - 0 1"}`,
+			http.StatusOK,
+			map[string]any{"moves": []any{
+				map[string]any{"uci": "e1d1", "san": "Kd1"},
+				map[string]any{"uci": "e1d2", "san": "Kd2"},
+				map[string]any{"uci": "e1e2", "san": "Ke2"},
+				map[string]any{"uci": "e1f1", "san": "Kf1"},
+				map[string]any{"uci": "e1f2", "san": "Kf2"},
+				map[string]any{"uci": "e1g1", "san": "O-O"},
+				map[string]any{"uci": "h1f1", "san": "Rf1"},
+				map[string]any{"uci": "h1g1", "san": "Rg1"},
+				map[string]any{"uci": "h1h2", "san": "Rh2"},
+				map[string]any{"uci": "h1h3", "san": "Rh3"},
+				map[string]any{"uci": "h1h4", "san": "Rh4"},
+				map[string]any{"uci": "h1h5", "san": "Rh5"},
+				map[string]any{"uci": "h1h6", "san": "Rh6"},
+				map[string]any{"uci": "h1h7", "san": "Rh7"},
+				map[string]any{"uci": "h1h8", "san": "Rh8+"},
+			}},
+		},
+		{
+			"legal moves after checkmate",
+			http.MethodPost,
+			"/legal-moves",
+			`{"moves": ["f2f3", "e7e5", "g2g4", "d8h4"]}`,
+			http.StatusOK,
+			map[string]any{"moves": []any{}, "result": "0-1", "reason": "Black mates"},
+		},
+		{
+			"perft",
+			http.MethodPost,
+			"/perft",
+			`{"moves": ["e2e4"], "depth": 1}`,
+			http.StatusOK,
+			map[string]any{"nodes": 20.0},
+		},
+		{
+			"perft too deep",
+			http.MethodPost,
+			"/perft",
+			`{"depth": 6}`,
+			http.StatusBadRequest,
+			map[string]any{"error": "bad value for depth: 6 not in range 1 to 5"},
+		},
+		{
+			"valid fen",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "4k3/8/8/8/8/8/8/4K3 w - -"}`,
+			http.StatusOK,
+			map[string]any{"valid": true, "fen": "4k3/8/8/8/8/8/8/4K3 w - - 0 1"},
+		},
+		{
+			"invalid fen",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "8/8/8/8/8/8/8/8 w - - 0 1"}`,
+			http.StatusOK,
+			map[string]any{"valid": false, "error": "bad FEN: expected exactly one white king, got 0"},
+		},
+		{
+			"fen with castling rights and no rooks",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "4k3/8/8/8/8/8/8/4K3 w KQ - 0 1"}`,
+			http.StatusOK,
+			map[string]any{"valid": false, "error": "bad FEN: castling rights KQ without the king and rook on e1 and h1"},
+		},
+		{
+			"fen with a misplaced en passant square",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e3 0 1"}`,
+			http.StatusOK,
+			map[string]any{"valid": false, "error": "bad FEN: no pawn can just have passed over en passant square e3"},
+		},
+		{
+			"fen with nine ranks",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR/8 w KQkq - 0 1"}`,
+			http.StatusOK,
+			map[string]any{"valid": false, "error": "bad FEN: expected 8 ranks, got 9"},
+		},
+		{
+			"fen with a pawn on the back rank",
+			http.MethodPost,
+			"/validate-fen",
+			`{"fen": "P3k3/8/8/8/8/8/8/4K3 w - - 0 1"}`,
+			http.StatusOK,
+			map[string]any{"valid": false, "error": "bad FEN: pawn on the back rank at a8"},
+		},
+	}
+
+	testServer := newTestServer(t, DefaultConfig())
+
+	checkCase := func(t *testing.T, c testCase) {
+		request, err := http.NewRequest(c.method, testServer.URL+c.path, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+
+		if response.StatusCode != c.status {
+			t.Errorf("expected status %d, got %d", c.status, response.StatusCode)
+		}
+
+		var decoded map[string]any
+		if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+			t.Fatal(err)
+		}
+		for key, expected := range c.expected {
+			if !reflect.DeepEqual(decoded[key], expected) {
+				t.Errorf("expected %s to be %v, got %v", key, expected, decoded[key])
+			}
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+// readEvents returns the names of the server-sent events in a stream, along
+// with the data of the last one.
+func readEvents(t *testing.T, response *http.Response) (names []string, lastData string) {
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			names = append(names, name)
+		}
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			lastData = data
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return names, lastData
+}
+
+func TestAnalyseStream(t *testing.T) {
+	testServer := newTestServer(t, DefaultConfig())
+
+	response, err := http.Get(testServer.URL + "/analyse/stream?fen=6k1/5ppp/8/8/8/8/8/R5K1+w+-+-+0+1&depth=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	if contentType := response.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("expected an event stream, got %s", contentType)
+	}
+
+	names, lastData := readEvents(t, response)
+	if expected := []string{"info", "info", "bestmove"}; !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected events %v, got %v", expected, names)
+	}
+
+	var result analyseResponse
+	if err := json.Unmarshal([]byte(lastData), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.BestMove != "a1a8" || result.Depth != 2 {
+		t.Errorf("expected a1a8 at depth 2, got %s at depth %d", result.BestMove, result.Depth)
+	}
+}
+
+func TestFullQueue(t *testing.T) {
+	config := DefaultConfig()
+	config.QueueSize = 0
+	config.MaxSearchTime = 500 * time.Millisecond
+	testServer := newTestServer(t, config)
+
+	busy, err := http.Post(testServer.URL+"/analyse/stream", "application/json", strings.NewReader(`{"depth": 100}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Body.Close()
+	if busy.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", busy.StatusCode)
+	}
+
+	for _, path := range []string{"/analyse", "/perft"} {
+		response, err := http.Post(testServer.URL+path, "application/json", strings.NewReader(`{"depth": 1}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		response.Body.Close()
+		if response.StatusCode != http.StatusServiceUnavailable {
+			t.Errorf("expected status 503 from %s, got %d", path, response.StatusCode)
+		}
+		if response.Header.Get("Retry-After") == "" {
+			t.Errorf("expected Retry-After from %s", path)
+		}
+	}
+
+	names, _ := readEvents(t, busy)
+	if len(names) == 0 || names[len(names)-1] != "bestmove" {
+		t.Errorf("expected the capped search to end with bestmove, got %v", names)
+	}
+}
+
+func TestEnginesStartFresh(t *testing.T) {
+	config := DefaultConfig()
+	config.Engines = 1
+	testServer := newTestServer(t, config)
+
+	analyse := func() analyseResponse {
+		response, err := http.Post(testServer.URL+"/analyse", "application/json", strings.NewReader(`{"depth": 6}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer response.Body.Close()
+		var result analyseResponse
+		if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	first, second := analyse(), analyse()
+	if first.Nodes != second.Nodes || first.BestMove != second.BestMove {
+		t.Errorf("expected the same search from a fresh engine, got %s in %d nodes then %s in %d nodes",
+			first.BestMove, first.Nodes, second.BestMove, second.Nodes)
+	}
+}
@@ -0,0 +1,349 @@
+// Package tune fits the weights of the handcrafted evaluation to the results
+// of games, by minimising the error between the results and a sigmoid of the
+// evaluation of positions from them.
+package tune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sync"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/datagen"
+	"github.com/yutanagano/karei/internal/logging"
+)
+
+var logger = logging.For("tune")
+
+// Method is a way of searching for better parameters.
+type Method int
+
+const (
+	// Local tries moving each parameter up or down by one in turn, keeping
+	// the change if it lowers the error, until no change does.
+	Local Method = iota
+	// Gradient follows the gradient of the error with Adam.
+	Gradient
+)
+
+func ParseMethod(s string) (Method, error) {
+	switch s {
+	case "local":
+		return Local, nil
+	case "gradient":
+		return Gradient, nil
+	}
+	return 0, fmt.Errorf("unrecognised method %s", s)
+}
+
+func (m Method) String() string {
+	if m == Gradient {
+		return "gradient"
+	}
+	return "local"
+}
+
+// Config describes a tuning run.
+type Config struct {
+	Method Method
+	// K scales evaluations before the sigmoid. If zero, it is fitted to the
+	// dataset with the starting parameters.
+	K float64
+	// Iterations is the most passes over the parameters for local search, or
+	// steps for gradient descent.
+	Iterations int
+	// LearningRate is the step size for gradient descent, in centipawns.
+	LearningRate float64
+	// Concurrency is the number of goroutines the dataset is split between.
+	Concurrency int
+	// Include, if set, limits tuning to the parameters with matching names.
+	Include *regexp.Regexp
+}
+
+// entry is a position from the dataset reduced to the linear model of its
+// evaluation.
+type entry struct {
+	offset       float64
+	coefficients []chess.EvalCoefficient
+	result       float64
+}
+
+// Dataset is a set of positions prepared for tuning.
+type Dataset struct {
+	entries []entry
+}
+
+// Len returns the number of positions in the dataset.
+func (d *Dataset) Len() int {
+	return len(d.entries)
+}
+
+// NewDataset prepares records for tuning. The scores in the records are not
+// used, only the positions and results.
+func NewDataset(records []datagen.Record, concurrency int) (*Dataset, error) {
+	if concurrency < 1 {
+		return nil, fmt.Errorf("bad value for concurrency: %d", concurrency)
+	}
+
+	d := &Dataset{entries: make([]entry, len(records))}
+	errs := make([]error, concurrency)
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for idx := worker; idx < len(records); idx += concurrency {
+				position := chess.Position{}
+				if err := position.LoadFEN(records[idx].FEN); err != nil {
+					errs[worker] = fmt.Errorf("record %d: %w", idx+1, err)
+					return
+				}
+				offset, coefficients := position.EvalCoefficients()
+				d.entries[idx] = entry{offset, coefficients, records[idx].Result}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// sigmoid maps an evaluation in centipawns to an expected share of the point.
+func sigmoid(k, score float64) float64 {
+	return 1 / (1 + math.Pow(10, -k*score/400))
+}
+
+// tuner holds the state shared by the optimisers.
+type tuner struct {
+	dataset     *Dataset
+	k           float64
+	concurrency int
+	values      []float64
+}
+
+func (t *tuner) score(e *entry) float64 {
+	score := e.offset
+	for _, coefficient := range e.coefficients {
+		score += coefficient.Value * t.values[coefficient.Index]
+	}
+	return score
+}
+
+// forEachChunk calls f concurrently on parts of the dataset, passing the index
+// of the part, and waits for it to return.
+func (t *tuner) forEachChunk(f func(chunk int, entries []entry)) {
+	entries := t.dataset.entries
+	size := (len(entries) + t.concurrency - 1) / t.concurrency
+
+	var wg sync.WaitGroup
+	for chunk := 0; chunk < t.concurrency; chunk++ {
+		start := min(chunk*size, len(entries))
+		end := min(start+size, len(entries))
+		wg.Add(1)
+		go func(chunk int) {
+			defer wg.Done()
+			f(chunk, entries[start:end])
+		}(chunk)
+	}
+	wg.Wait()
+}
+
+// error returns the mean squared error of the predicted results.
+func (t *tuner) error() float64 {
+	sums := make([]float64, t.concurrency)
+	t.forEachChunk(func(chunk int, entries []entry) {
+		for idx := range entries {
+			difference := entries[idx].result - sigmoid(t.k, t.score(&entries[idx]))
+			sums[chunk] += difference * difference
+		}
+	})
+
+	total := 0.0
+	for _, sum := range sums {
+		total += sum
+	}
+	return total / float64(len(t.dataset.entries))
+}
+
+// gradient returns the gradient of the error with respect to the values.
+func (t *tuner) gradient() []float64 {
+	gradients := make([][]float64, t.concurrency)
+	t.forEachChunk(func(chunk int, entries []entry) {
+		gradient := make([]float64, len(t.values))
+		for idx := range entries {
+			predicted := sigmoid(t.k, t.score(&entries[idx]))
+			slope := (predicted - entries[idx].result) * predicted * (1 - predicted)
+			for _, coefficient := range entries[idx].coefficients {
+				gradient[coefficient.Index] += slope * coefficient.Value
+			}
+		}
+		gradients[chunk] = gradient
+	})
+
+	result := make([]float64, len(t.values))
+	scale := 2 * t.k * math.Ln10 / 400 / float64(len(t.dataset.entries))
+	for _, gradient := range gradients {
+		for idx, value := range gradient {
+			result[idx] += value * scale
+		}
+	}
+	return result
+}
+
+// FitK returns the scaling constant that minimises the error of the dataset
+// with the given parameters.
+func FitK(dataset *Dataset, parameters []chess.EvalParameter, concurrency int) float64 {
+	t := newTuner(dataset, 0, parameters, concurrency)
+	errorAt := func(k float64) float64 {
+		t.k = k
+		return t.error()
+	}
+
+	// golden section search, which is enough as the error has a single
+	// minimum in k
+	ratio := (math.Sqrt(5) - 1) / 2
+	low, high := 0.0, 10.0
+	lowProbe, highProbe := high-ratio*(high-low), low+ratio*(high-low)
+	lowError, highError := errorAt(lowProbe), errorAt(highProbe)
+	for high-low > 1e-4 {
+		if lowError < highError {
+			high, highProbe, highError = highProbe, lowProbe, lowError
+			lowProbe = high - ratio*(high-low)
+			lowError = errorAt(lowProbe)
+		} else {
+			low, lowProbe, lowError = lowProbe, highProbe, highError
+			highProbe = low + ratio*(high-low)
+			highError = errorAt(highProbe)
+		}
+	}
+	return (low + high) / 2
+}
+
+func newTuner(dataset *Dataset, k float64, parameters []chess.EvalParameter, concurrency int) *tuner {
+	t := &tuner{dataset: dataset, k: k, concurrency: max(concurrency, 1)}
+	for _, parameter := range parameters {
+		t.values = append(t.values, float64(parameter.Value))
+	}
+	return t
+}
+
+// Tune returns the parameters that fit the dataset best, starting from the
+// given ones, which must be those returned by chess.EvalParameters. The
+// progress of each iteration is passed to output. If ctx is done, the best
+// parameters so far are returned along with its error.
+func Tune(ctx context.Context, dataset *Dataset, parameters []chess.EvalParameter, config Config, output func(string)) ([]chess.EvalParameter, error) {
+	if dataset.Len() == 0 {
+		return nil, errors.New("the dataset is empty")
+	}
+	if config.Iterations < 1 {
+		return nil, fmt.Errorf("bad value for iterations: %d", config.Iterations)
+	}
+	if config.Concurrency < 1 {
+		return nil, fmt.Errorf("bad value for concurrency: %d", config.Concurrency)
+	}
+	if config.Method == Gradient && config.LearningRate <= 0 {
+		return nil, fmt.Errorf("bad value for learning rate: %v", config.LearningRate)
+	}
+
+	var tuned []int
+	for idx, parameter := range parameters {
+		if config.Include == nil || config.Include.MatchString(parameter.Name) {
+			tuned = append(tuned, idx)
+		}
+	}
+	if len(tuned) == 0 {
+		return nil, errors.New("no parameters to tune")
+	}
+
+	k := config.K
+	if k == 0 {
+		k = FitK(dataset, parameters, config.Concurrency)
+		output(fmt.Sprintf("Fitted K = %.4f", k))
+	}
+
+	t := newTuner(dataset, k, parameters, config.Concurrency)
+	output(fmt.Sprintf("Tuning %d parameters on %d positions, starting error %.6f", len(tuned), dataset.Len(), t.error()))
+	logger.Info("tuning", "method", config.Method.String(), "parameters", len(tuned), "positions", dataset.Len(), "k", k)
+
+	var err error
+	if config.Method == Gradient {
+		err = t.descend(ctx, tuned, config, output)
+	} else {
+		err = t.localSearch(ctx, tuned, config, output)
+	}
+
+	result := make([]chess.EvalParameter, len(parameters))
+	for idx, parameter := range parameters {
+		result[idx] = chess.EvalParameter{Name: parameter.Name, Value: int(math.Round(t.values[idx]))}
+	}
+	return result, err
+}
+
+// localSearch moves each parameter by one in whichever direction lowers the
+// error, until a pass over them all changes nothing.
+func (t *tuner) localSearch(ctx context.Context, tuned []int, config Config, output func(string)) error {
+	bestError := t.error()
+	for iteration := 1; iteration <= config.Iterations; iteration++ {
+		improved := false
+		for _, idx := range tuned {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			original := t.values[idx]
+			for _, step := range []float64{1, -1} {
+				t.values[idx] = original + step
+				if newError := t.error(); newError < bestError {
+					bestError = newError
+					improved = true
+					break
+				}
+				t.values[idx] = original
+			}
+		}
+
+		output(fmt.Sprintf("Iteration %d: error %.6f", iteration, bestError))
+		if !improved {
+			break
+		}
+	}
+	return nil
+}
+
+// descend follows the gradient of the error with Adam, only moving the tuned
+// parameters.
+func (t *tuner) descend(ctx context.Context, tuned []int, config Config, output func(string)) error {
+	const (
+		beta1   = 0.9
+		beta2   = 0.999
+		epsilon = 1e-8
+	)
+
+	firstMoments := make([]float64, len(t.values))
+	secondMoments := make([]float64, len(t.values))
+	for iteration := 1; iteration <= config.Iterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		gradient := t.gradient()
+		for _, idx := range tuned {
+			firstMoments[idx] = beta1*firstMoments[idx] + (1-beta1)*gradient[idx]
+			secondMoments[idx] = beta2*secondMoments[idx] + (1-beta2)*gradient[idx]*gradient[idx]
+			first := firstMoments[idx] / (1 - math.Pow(beta1, float64(iteration)))
+			second := secondMoments[idx] / (1 - math.Pow(beta2, float64(iteration)))
+			t.values[idx] -= config.LearningRate * first / (math.Sqrt(second) + epsilon)
+		}
+
+		if iteration%10 == 0 || iteration == config.Iterations {
+			output(fmt.Sprintf("Iteration %d: error %.6f", iteration, t.error()))
+		}
+	}
+	return nil
+}
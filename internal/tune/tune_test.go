@@ -0,0 +1,139 @@
+package tune
+
+import (
+	"context"
+	"math"
+	"regexp"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/datagen"
+)
+
+// testRecords are positions where the side with more material went on to
+// win, with some draws where it was level.
+var testRecords = []struct {
+	fen    string
+	result float64
+}{
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0.5},
+	{"rnb1kbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 1},
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNB1KBNR b KQkq - 0 1", 0},
+	{"r1bqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 1},
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/R1BQKBNR w KQkq - 0 1", 0},
+	{"rnbqkbnr/ppp1pppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 0.5},
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPP1PPPP/RNBQKBNR w KQkq - 0 1", 0.5},
+	{"1nbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQk - 0 1", 1},
+	{"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/1NBQKBNR w Kkq - 0 1", 0},
+	{"4k3/8/8/8/8/8/PPP5/4K3 w - - 0 1", 1},
+	{"4k3/ppp5/8/8/8/8/8/4K3 w - - 0 1", 0},
+	{"4k3/8/8/8/8/8/8/R3K3 w - - 0 1", 1},
+}
+
+func testDataset(t *testing.T) *Dataset {
+	var records []datagen.Record
+	for _, record := range testRecords {
+		fen, err := chess.ParseFEN(record.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, datagen.Record{FEN: fen, Result: record.result})
+	}
+
+	dataset, err := NewDataset(records, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataset
+}
+
+func TestFitK(t *testing.T) {
+	dataset := testDataset(t)
+	parameters := chess.EvalParameters()
+
+	k := FitK(dataset, parameters, 2)
+	errorAt := func(k float64) float64 {
+		return newTuner(dataset, k, parameters, 2).error()
+	}
+	if best := errorAt(k); errorAt(k-0.05) < best || errorAt(k+0.05) < best {
+		t.Errorf("expected the error to be lowest at K = %v", k)
+	}
+}
+
+func TestTune(t *testing.T) {
+	type testCase struct {
+		name   string
+		config Config
+	}
+
+	include := regexp.MustCompile(`^PieceValues\.`)
+	testCases := []testCase{
+		{"local search", Config{Method: Local, K: 0.5, Iterations: 3, Concurrency: 2, Include: include}},
+		{"gradient descent", Config{Method: Gradient, K: 0.5, Iterations: 50, LearningRate: 5, Concurrency: 2, Include: include}},
+	}
+
+	dataset := testDataset(t)
+	parameters := chess.EvalParameters()
+
+	checkCase := func(t *testing.T, c testCase) {
+		tuned, err := Tune(context.Background(), dataset, parameters, c.config, func(string) {})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		before := newTuner(dataset, c.config.K, parameters, 1).error()
+		after := newTuner(dataset, c.config.K, tuned, 1).error()
+		if after >= before {
+			t.Errorf("expected the error to fall from %v, got %v", before, after)
+		}
+
+		for idx, parameter := range tuned {
+			if parameter.Name != parameters[idx].Name {
+				t.Fatalf("expected parameter %s, got %s", parameters[idx].Name, parameter.Name)
+			}
+			if !include.MatchString(parameter.Name) && parameter.Value != parameters[idx].Value {
+				t.Errorf("expected %s to be left alone, got %v", parameter.Name, parameter.Value)
+			}
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestGradient(t *testing.T) {
+	dataset := testDataset(t)
+	tuner := newTuner(dataset, 0.8, chess.EvalParameters(), 2)
+	gradient := tuner.gradient()
+
+	// compare with the error either side of each parameter
+	for _, idx := range []int{0, 7, 10} {
+		original := tuner.values[idx]
+		tuner.values[idx] = original + 0.5
+		above := tuner.error()
+		tuner.values[idx] = original - 0.5
+		below := tuner.error()
+		tuner.values[idx] = original
+
+		if expected := above - below; math.Abs(gradient[idx]-expected) > 1e-6+1e-3*math.Abs(expected) {
+			t.Errorf("parameter %d: expected gradient %v, got %v", idx, expected, gradient[idx])
+		}
+	}
+}
+
+func TestTuneErrors(t *testing.T) {
+	dataset := testDataset(t)
+	parameters := chess.EvalParameters()
+
+	for _, config := range []Config{
+		{Iterations: 0, Concurrency: 1},
+		{Iterations: 1, Concurrency: 0},
+		{Method: Gradient, Iterations: 1, Concurrency: 1},
+		{Iterations: 1, Concurrency: 1, Include: regexp.MustCompile("^Nothing$")},
+	} {
+		if _, err := Tune(context.Background(), dataset, parameters, config, func(string) {}); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
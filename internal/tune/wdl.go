@@ -0,0 +1,164 @@
+package tune
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/datagen"
+)
+
+// WDLConfig describes a fit of a chess.WDLModel.
+type WDLConfig struct {
+	// Iterations is the number of steps of gradient descent.
+	Iterations int
+	// LearningRate is the step size, in centipawns.
+	LearningRate float64
+}
+
+// wdlEntry is a position from the dataset reduced to what the model sees of
+// it, with the score and result from the side to move's point of view.
+type wdlEntry struct {
+	features [chess.WDLFeatureCount]float64
+	score    float64
+	result   float64
+}
+
+// minProbability keeps the log likelihood finite when the model is sure of
+// an outcome that did not happen.
+const minProbability = 1e-9
+
+// FitWDL returns the model under which the results of the records are most
+// likely given their scores, starting from the given model. The progress of
+// every hundredth step is passed to output. If ctx is done, the model so far
+// is returned along with its error.
+func FitWDL(ctx context.Context, records []datagen.Record, model chess.WDLModel, config WDLConfig, output func(string)) (chess.WDLModel, error) {
+	if len(records) == 0 {
+		return model, errors.New("the dataset is empty")
+	}
+	if config.Iterations < 1 {
+		return model, fmt.Errorf("bad value for iterations: %d", config.Iterations)
+	}
+	if config.LearningRate <= 0 {
+		return model, fmt.Errorf("bad value for learning rate: %v", config.LearningRate)
+	}
+
+	entries, err := newWDLEntries(records)
+	if err != nil {
+		return model, err
+	}
+
+	output(fmt.Sprintf("Fitting the WDL model on %d positions, starting loss %.6f", len(entries), wdlLoss(entries, model)))
+
+	const (
+		beta1   = 0.9
+		beta2   = 0.999
+		epsilon = 1e-8
+	)
+
+	// the centre coefficients come first, then the spread ones
+	var firstMoments, secondMoments [2 * chess.WDLFeatureCount]float64
+	for iteration := 1; iteration <= config.Iterations; iteration++ {
+		if err := ctx.Err(); err != nil {
+			return model, err
+		}
+
+		gradient := wdlGradient(entries, model)
+		for idx := range gradient {
+			firstMoments[idx] = beta1*firstMoments[idx] + (1-beta1)*gradient[idx]
+			secondMoments[idx] = beta2*secondMoments[idx] + (1-beta2)*gradient[idx]*gradient[idx]
+			first := firstMoments[idx] / (1 - math.Pow(beta1, float64(iteration)))
+			second := secondMoments[idx] / (1 - math.Pow(beta2, float64(iteration)))
+			step := config.LearningRate * first / (math.Sqrt(second) + epsilon)
+			if idx < chess.WDLFeatureCount {
+				model.Centre[idx] -= step
+			} else {
+				model.Spread[idx-chess.WDLFeatureCount] -= step
+			}
+		}
+
+		if iteration%100 == 0 || iteration == config.Iterations {
+			output(fmt.Sprintf("Iteration %d: loss %.6f", iteration, wdlLoss(entries, model)))
+		}
+	}
+	return model, nil
+}
+
+// newWDLEntries turns the scores and results of records around for black to
+// move.
+func newWDLEntries(records []datagen.Record) ([]wdlEntry, error) {
+	entries := make([]wdlEntry, len(records))
+	for idx, record := range records {
+		position := chess.Position{}
+		if err := position.LoadFEN(record.FEN); err != nil {
+			return nil, fmt.Errorf("record %d: %w", idx+1, err)
+		}
+		entries[idx] = wdlEntry{chess.WDLFeatures(&position), float64(record.Score), record.Result}
+		if !position.WhiteToMove() {
+			entries[idx].score, entries[idx].result = -entries[idx].score, 1-entries[idx].result
+		}
+	}
+	return entries, nil
+}
+
+// outcomeProbability returns the probability the model gives to the result of
+// an entry.
+func outcomeProbability(e *wdlEntry, centre, spread float64) float64 {
+	win, draw, loss := chess.WDLProbabilities(e.score, centre, spread)
+	switch e.result {
+	case 1:
+		return win
+	case 0:
+		return loss
+	}
+	return draw
+}
+
+// wdlLoss returns the mean negative log likelihood of the results.
+func wdlLoss(entries []wdlEntry, model chess.WDLModel) float64 {
+	total := 0.0
+	for idx := range entries {
+		centre, spread := model.Curve(entries[idx].features)
+		total -= math.Log(max(outcomeProbability(&entries[idx], centre, spread), minProbability))
+	}
+	return total / float64(len(entries))
+}
+
+// wdlGradient returns the gradient of the loss with respect to the centre and
+// then the spread coefficients.
+func wdlGradient(entries []wdlEntry, model chess.WDLModel) [2 * chess.WDLFeatureCount]float64 {
+	var gradient [2 * chess.WDLFeatureCount]float64
+	for idx := range entries {
+		e := &entries[idx]
+		centre, spread := model.Curve(e.features)
+		win, _, loss := chess.WDLProbabilities(e.score, centre, spread)
+
+		// the slopes of the chances of winning and losing in the centre and
+		// spread, from the derivative of the logistic curve
+		winSlope, lossSlope := win*(1-win)/spread, loss*(1-loss)/spread
+		winByCentre, winBySpread := -winSlope, -winSlope*(e.score-centre)/spread
+		lossByCentre, lossBySpread := -lossSlope, -lossSlope*(-e.score-centre)/spread
+
+		var byCentre, bySpread float64
+		switch e.result {
+		case 1:
+			byCentre, bySpread = winByCentre, winBySpread
+		case 0:
+			byCentre, bySpread = lossByCentre, lossBySpread
+		default:
+			byCentre, bySpread = -winByCentre-lossByCentre, -winBySpread-lossBySpread
+		}
+		probability := max(outcomeProbability(e, centre, spread), minProbability)
+		for feature, value := range e.features {
+			gradient[feature] -= byCentre / probability * value
+			gradient[chess.WDLFeatureCount+feature] -= bySpread / probability * value
+		}
+	}
+
+	for idx := range gradient {
+		gradient[idx] /= float64(len(entries))
+	}
+	return gradient
+}
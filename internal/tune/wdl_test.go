@@ -0,0 +1,107 @@
+package tune
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/datagen"
+)
+
+// wdlTestRecords are the test records with a score from white's point of view
+// that agrees with the result more often than not.
+func wdlTestRecords(t *testing.T) []datagen.Record {
+	scores := map[float64][]int{1: {250, 400, -50}, 0.5: {10, -30, 120}, 0: {-250, -400, 60}}
+
+	var records []datagen.Record
+	for idx, record := range testRecords {
+		fen, err := chess.ParseFEN(record.fen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, score := range scores[record.result] {
+			records = append(records, datagen.Record{FEN: fen, Score: score + idx, Result: record.result})
+		}
+	}
+	return records
+}
+
+func TestFitWDL(t *testing.T) {
+	records := wdlTestRecords(t)
+	start := chess.WDLModel{Centre: [chess.WDLFeatureCount]float64{100}, Spread: [chess.WDLFeatureCount]float64{100}}
+
+	model, err := FitWDL(context.Background(), records, start, WDLConfig{Iterations: 300, LearningRate: 2}, func(string) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := newWDLEntries(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before, after := wdlLoss(entries, start), wdlLoss(entries, model); after >= before {
+		t.Errorf("expected the loss to fall, got %v from %v", after, before)
+	}
+
+	position := chess.Position{}
+	position.LoadFEN(chess.GetStartingFEN())
+	if wdl := model.Predict(&position, 300); wdl.Win <= wdl.Loss {
+		t.Errorf("expected a good score to win more often than it loses, got %+v", wdl)
+	}
+}
+
+func TestWDLGradient(t *testing.T) {
+	records := wdlTestRecords(t)
+	entries, err := newWDLEntries(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model := chess.WDLModel{Centre: [chess.WDLFeatureCount]float64{150, 20, 10}, Spread: [chess.WDLFeatureCount]float64{90, 10, 5}}
+	gradient := wdlGradient(entries, model)
+
+	// compare with the loss either side of each coefficient
+	coefficients := append(model.Centre[:], model.Spread[:]...)
+	for idx := range coefficients {
+		at := func(delta float64) float64 {
+			shifted := model
+			if idx < chess.WDLFeatureCount {
+				shifted.Centre[idx] += delta
+			} else {
+				shifted.Spread[idx-chess.WDLFeatureCount] += delta
+			}
+			return wdlLoss(entries, shifted)
+		}
+
+		if expected := at(0.5) - at(-0.5); math.Abs(gradient[idx]-expected) > 1e-6+1e-3*math.Abs(expected) {
+			t.Errorf("coefficient %d: expected gradient %v, got %v", idx, expected, gradient[idx])
+		}
+	}
+}
+
+func TestFitWDLErrors(t *testing.T) {
+	records := wdlTestRecords(t)
+
+	type testCase struct {
+		name    string
+		records []datagen.Record
+		config  WDLConfig
+	}
+
+	testCases := []testCase{
+		{"no records", nil, WDLConfig{Iterations: 1, LearningRate: 1}},
+		{"no iterations", records, WDLConfig{Iterations: 0, LearningRate: 1}},
+		{"no learning rate", records, WDLConfig{Iterations: 1, LearningRate: 0}},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		if _, err := FitWDL(context.Background(), c.records, chess.DefaultWDLModel, c.config, func(string) {}); err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
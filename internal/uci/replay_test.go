@@ -0,0 +1,469 @@
+package uci
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+)
+
+const (
+	// replayTimeOut bounds the wait for each expected response.
+	replayTimeOut = time.Second
+	// settleTime is how long the session must stay quiet before a command
+	// that the client sent after responses.
+	settleTime = 50 * time.Millisecond
+	// maxReadyLatency is the longest a client should wait for readyok.
+	maxReadyLatency = 100 * time.Millisecond
+)
+
+// transcriptEpoch stands for the start of a recording, so that recorded times
+// are never the zero time.
+var transcriptEpoch = time.Unix(0, 0)
+
+type transcriptEvent struct {
+	direction string
+	line      string
+	time      time.Time
+}
+
+func (e transcriptEvent) String() string {
+	return e.direction + " " + e.line
+}
+
+// TestReplayTranscripts checks the sessions recorded with karei uci --record in
+// testdata/transcripts against the rules of the protocol, including how long
+// readyok took, then replays their commands against a session with a fake
+// engine and checks that session too, once paced as the client sent them and
+// once with every command sent straight after the one before. The fake engine
+// answers differently from the real one, so replays are not compared with the
+// recording line by line. The transcripts there now were all scripted, each
+// imitating a kind of client as its header says, rather than recorded behind a
+// GUI. New recordings, from scripts or from real GUIs, can be dropped in as
+// they are.
+func TestReplayTranscripts(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "transcripts", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) == 0 {
+		t.Fatal("no transcripts found")
+	}
+
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".txt"), func(t *testing.T) {
+			t.Parallel()
+
+			golden := readTranscript(t, path)
+			t.Run("recording", func(t *testing.T) { checkConformance(t, golden) })
+			t.Run("replay", func(t *testing.T) { checkConformance(t, replay(t, golden, true)) })
+			t.Run("replay without waiting", func(t *testing.T) { checkConformance(t, replay(t, golden, false)) })
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	fromUCI := make(chan string, 100)
+	toUCI := make(chan string)
+	session := New(toUCI, fromUCI, &fakeEngine{})
+
+	var transcript bytes.Buffer
+	recorder := NewRecorder(&transcript)
+	ticks := 0
+	recorder.now = func() time.Time {
+		ticks++
+		return recorder.start.Add(time.Duration(ticks) * 1500 * time.Microsecond)
+	}
+	session.Record(recorder)
+
+	finished := make(chan bool)
+	go func() {
+		session.Start(context.Background())
+		finished <- true
+	}()
+	toUCI <- "isready"
+	toUCI <- "position startpos"
+	toUCI <- "go depth 1"
+	for line := range fromUCI {
+		if strings.HasPrefix(line, "bestmove") {
+			break
+		}
+	}
+	toUCI <- "quit"
+	<-finished
+
+	expected := `1.500 < info string hello from karei
+3.000 > isready
+4.500 < readyok
+6.000 > position startpos
+7.500 > go depth 1
+9.000 < info depth 1 seldepth 1 score cp 0 nodes 20 nps 0 time 0 pv a2a3 a7a5
+10.500 < bestmove a2a3 ponder a7a5
+12.000 > quit
+`
+	if transcript.String() != expected {
+		t.Fatalf("expected transcript %q, got %q", expected, transcript.String())
+	}
+}
+
+// readTranscript returns the commands and responses of a transcript, with
+// their times if they were recorded. Lines may also be written by hand without
+// a time.
+func readTranscript(t *testing.T, path string) (events []transcriptEvent) {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		var eventTime time.Time
+		direction, line, _ := strings.Cut(text, " ")
+		if milliseconds, err := strconv.ParseFloat(direction, 64); err == nil {
+			eventTime = transcriptEpoch.Add(time.Duration(milliseconds * float64(time.Millisecond)))
+			direction, line, _ = strings.Cut(line, " ")
+		}
+		if direction != FromClient && direction != ToClient {
+			t.Fatalf("bad transcript line %q", text)
+		}
+		events = append(events, transcriptEvent{direction, line, eventTime})
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return events
+}
+
+// isAnswer reports whether a response answers a command of the client, as
+// opposed to reporting on a search or passing on information, which differ
+// from engine to engine.
+func isAnswer(line string) bool {
+	command, _, _ := strings.Cut(line, " ")
+	switch command {
+	case "uciok", "readyok", "bestmove":
+		return true
+	}
+	return false
+}
+
+// replay sends the commands of a transcript to a new session in order. When
+// paced, a command that came after responses in the transcript waits until the
+// session has given as many answers as the transcript has so far, and then
+// for it to go quiet. A paced command with a recorded time also waits as long
+// after that as the client did after the event before it.
+func replay(t *testing.T, golden []transcriptEvent, paced bool) []transcriptEvent {
+	fromUCI := make(chan string, 100)
+	toUCI := make(chan string)
+	session := New(toUCI, fromUCI, &fakeEngine{})
+
+	finished := make(chan bool)
+	go func() {
+		session.Start(context.Background())
+		close(fromUCI)
+		finished <- true
+	}()
+
+	var mutex sync.Mutex
+	var events []transcriptEvent
+	responses, answers := 0, 0
+	collected := make(chan bool)
+	go func() {
+		for line := range fromUCI {
+			mutex.Lock()
+			events = append(events, transcriptEvent{ToClient, line, time.Now()})
+			responses++
+			if isAnswer(line) {
+				answers++
+			}
+			mutex.Unlock()
+		}
+		collected <- true
+	}()
+
+	getResponses := func() (int, int) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return responses, answers
+	}
+
+	waitForAnswers := func(expected int) {
+		deadline := time.Now().Add(replayTimeOut)
+		for _, current := getResponses(); current < expected && time.Now().Before(deadline); _, current = getResponses() {
+			time.Sleep(time.Millisecond)
+		}
+		for last, lastChange := -1, time.Now(); time.Since(lastChange) < settleTime; {
+			time.Sleep(time.Millisecond)
+			if current, _ := getResponses(); current != last {
+				last, lastChange = current, time.Now()
+			}
+		}
+	}
+
+	expectedAnswers := 0
+	afterResponse := false
+	sentQuit := false
+	var previous time.Time
+	for _, event := range golden {
+		if event.direction == ToClient {
+			if isAnswer(event.line) {
+				expectedAnswers++
+			}
+			afterResponse = true
+			previous = event.time
+			continue
+		}
+
+		if paced && afterResponse {
+			waitForAnswers(expectedAnswers)
+			afterResponse = false
+		}
+		if paced && !event.time.IsZero() && !previous.IsZero() {
+			time.Sleep(min(event.time.Sub(previous), replayTimeOut))
+		}
+		previous = event.time
+
+		mutex.Lock()
+		events = append(events, transcriptEvent{FromClient, event.line, time.Now()})
+		mutex.Unlock()
+		toUCI <- event.line
+
+		if event.line == "quit" {
+			sentQuit = true
+			break
+		}
+	}
+	if !sentQuit {
+		if paced {
+			waitForAnswers(expectedAnswers)
+		}
+		close(toUCI)
+	}
+
+	select {
+	case <-finished:
+	case <-time.After(replayTimeOut):
+		t.Fatal("session did not finish at the end of the transcript")
+	}
+	<-collected
+
+	return events
+}
+
+// search is a go command that has not been answered with bestmove yet.
+type search struct {
+	infinite bool
+	// holdBestMove is set while the client has not allowed bestmove.
+	holdBestMove bool
+}
+
+// checkConformance asserts the rules of the UCI protocol that hold whatever
+// the engine: uci is answered with ids and uciok, every isready gets a prompt
+// readyok, and every go gets exactly one bestmove of its own, which for go
+// ponder and go infinite only comes once the client allows it. The latency of
+// readyok is only checked for events with times.
+func checkConformance(t *testing.T, events []transcriptEvent) {
+	pendingUci := 0
+	var pendingReady []time.Time
+	var searches []*search
+
+	for idx, event := range events {
+		fail := func(format string, args ...any) {
+			t.Errorf("line %d (%s): %s", idx+1, event, fmt.Sprintf(format, args...))
+		}
+		tokens := strings.Fields(event.line)
+		command := ""
+		if len(tokens) > 0 {
+			command = tokens[0]
+		}
+
+		if event.direction == FromClient {
+			switch command {
+			case "uci":
+				pendingUci++
+			case "isready":
+				pendingReady = append(pendingReady, event.time)
+			case "go":
+				infinite := slices.Contains(tokens, "infinite")
+				searches = append(searches, &search{infinite, infinite || slices.Contains(tokens, "ponder")})
+			case "stop", "quit":
+				for _, theSearch := range searches {
+					theSearch.holdBestMove = false
+				}
+			case "ponderhit":
+				if len(searches) > 0 {
+					theSearch := searches[len(searches)-1]
+					theSearch.holdBestMove = theSearch.infinite
+				}
+			}
+			continue
+		}
+
+		switch command {
+		case "uciok":
+			if pendingUci == 0 {
+				fail("uciok without uci")
+				continue
+			}
+			pendingUci--
+		case "id", "option":
+			if pendingUci == 0 {
+				fail("%s outside the reply to uci", command)
+			}
+		case "readyok":
+			if len(pendingReady) == 0 {
+				fail("readyok without isready")
+				continue
+			}
+			if latency := event.time.Sub(pendingReady[0]); !event.time.IsZero() && latency > maxReadyLatency {
+				fail("readyok took %v", latency)
+			}
+			pendingReady = pendingReady[1:]
+		case "bestmove":
+			if len(searches) == 0 {
+				fail("bestmove without a search")
+				continue
+			}
+			if searches[0].holdBestMove {
+				fail("bestmove before stop or ponderhit")
+			}
+			searches = searches[1:]
+		case "info":
+			if len(tokens) > 1 && tokens[1] != "string" && len(searches) == 0 {
+				fail("search info outside a search")
+			}
+		}
+	}
+
+	if pendingUci > 0 {
+		t.Errorf("%d uci commands without uciok", pendingUci)
+	}
+	if len(pendingReady) > 0 {
+		t.Errorf("%d isready commands without readyok", len(pendingReady))
+	}
+	if len(searches) > 0 {
+		t.Errorf("%d go commands without bestmove", len(searches))
+	}
+}
+
+// fakeEngine stands in for the engine in replays. It plays the alphabetically
+// first legal move and expects the alphabetically first reply. Searches return
+// at once unless they are ponder or infinite searches, which wait for stop, or
+// for ponderhit if they are not infinite.
+type fakeEngine struct {
+	mutex     sync.Mutex
+	stop      chan struct{}
+	ponderHit chan struct{}
+}
+
+func (f *fakeEngine) SetLogger(logger *slog.Logger) {}
+
+func (f *fakeEngine) Options() []engine.Option {
+	return []engine.Option{
+		{Name: "Hash", Type: "spin", Default: "32", Min: 1, Max: 1024},
+		{Name: "Threads", Type: "spin", Default: "1", Min: 1, Max: 16},
+		{Name: "Ponder", Type: "check", Default: "false"},
+	}
+}
+
+func (f *fakeEngine) SetOption(name, value string) error {
+	switch strings.ToLower(name) {
+	case "hash", "threads", "ponder":
+		return nil
+	}
+	return fmt.Errorf("unrecognised option %s", name)
+}
+
+func (f *fakeEngine) NewGame() {}
+
+func (f *fakeEngine) Go(position chess.Position, limits engine.Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string)) {
+	stop := make(chan struct{})
+	ponderHit := make(chan struct{})
+
+	f.mutex.Lock()
+	f.stop, f.ponderHit = stop, ponderHit
+	f.mutex.Unlock()
+
+	moves := position.LegalMoves()
+	if len(limits.SearchMoves) > 0 {
+		moves = slices.DeleteFunc(moves, func(moveString string) bool {
+			return !slices.Contains(limits.SearchMoves, moveString)
+		})
+	}
+	slices.Sort(moves)
+
+	var pv []string
+	if len(moves) > 0 {
+		pv = append(pv, moves[0])
+		position.MakeMove(moves[0])
+		if replies := position.LegalMoves(); len(replies) > 0 {
+			slices.Sort(replies)
+			pv = append(pv, replies[0])
+		}
+	}
+
+	go func() {
+		if len(pv) > 0 {
+			info(chess.SearchInfo{Depth: 1, SelDepth: 1, Nodes: uint64(len(moves)), PV: pv})
+		}
+
+		switch {
+		case limits.Infinite:
+			<-stop
+		case limits.Ponder:
+			select {
+			case <-stop:
+			case <-ponderHit:
+			}
+		}
+
+		bestMove, ponderMove := "", ""
+		if len(pv) > 0 {
+			bestMove = pv[0]
+		}
+		if len(pv) > 1 {
+			ponderMove = pv[1]
+		}
+		done(bestMove, ponderMove)
+	}()
+}
+
+func (f *fakeEngine) Stop() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.stop != nil {
+		close(f.stop)
+		f.stop = nil
+	}
+}
+
+func (f *fakeEngine) PonderHit() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.ponderHit != nil {
+		close(f.ponderHit)
+		f.ponderHit = nil
+	}
+}
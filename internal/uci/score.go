@@ -0,0 +1,103 @@
+package uci
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+// Score is the score of an info line, from the point of view of the side to
+// move.
+type Score struct {
+	// Mate is set if Value is the number of moves to mate, negative if the
+	// side to move is getting mated, rather than centipawns.
+	Mate  bool
+	Value int
+	Bound chess.ScoreBound
+	// WDL is the expected outcome, if the engine gives it.
+	WDL *chess.WinDrawLoss
+}
+
+// ScoreOf returns the score of search progress.
+func ScoreOf(searchInfo chess.SearchInfo) Score {
+	score := Score{Value: searchInfo.Score, Bound: searchInfo.Bound, WDL: searchInfo.WDL}
+	if searchInfo.MateIn != 0 {
+		score.Mate, score.Value = true, searchInfo.MateIn
+	}
+	return score
+}
+
+// String spells the score as it follows score in an info line.
+func (s Score) String() string {
+	var b strings.Builder
+
+	if s.Mate {
+		fmt.Fprintf(&b, "mate %d", s.Value)
+	} else {
+		fmt.Fprintf(&b, "cp %d", s.Value)
+	}
+	switch s.Bound {
+	case chess.LowerBound:
+		b.WriteString(" lowerbound")
+	case chess.UpperBound:
+		b.WriteString(" upperbound")
+	}
+	if s.WDL != nil {
+		fmt.Fprintf(&b, " wdl %d %d %d", s.WDL.Win, s.WDL.Draw, s.WDL.Loss)
+	}
+
+	return b.String()
+}
+
+// ParseScore reads a score from the tokens following score in an info line,
+// returning it with the number of tokens it took up.
+func ParseScore(tokens []string) (Score, int, error) {
+	// (cp <x> | mate <y>) (lowerbound | upperbound)? (wdl <w> <d> <l>)?
+	var s Score
+	if len(tokens) < 2 {
+		return s, 0, fmt.Errorf("expected score cp <x> or score mate <y>, got %s", strings.Join(tokens, " "))
+	}
+
+	switch tokens[0] {
+	case "cp":
+	case "mate":
+		s.Mate = true
+	default:
+		return s, 0, fmt.Errorf("expected cp or mate, got %s", tokens[0])
+	}
+	value, err := strconv.Atoi(tokens[1])
+	if err != nil {
+		return s, 0, fmt.Errorf("bad value for score %s: %s", tokens[0], err.Error())
+	}
+	s.Value = value
+	used := 2
+
+	if used < len(tokens) {
+		switch tokens[used] {
+		case "lowerbound":
+			s.Bound = chess.LowerBound
+			used++
+		case "upperbound":
+			s.Bound = chess.UpperBound
+			used++
+		}
+	}
+
+	if used < len(tokens) && tokens[used] == "wdl" {
+		if used+3 >= len(tokens) {
+			return s, 0, fmt.Errorf("expected wdl <w> <d> <l>, got %s", strings.Join(tokens[used:], " "))
+		}
+		var chances [3]int
+		for idx := range chances {
+			if chances[idx], err = strconv.Atoi(tokens[used+1+idx]); err != nil {
+				return s, 0, fmt.Errorf("bad value for score wdl: %s", err.Error())
+			}
+		}
+		s.WDL = &chess.WinDrawLoss{Win: chances[0], Draw: chances[1], Loss: chances[2]}
+		used += 4
+	}
+
+	return s, used, nil
+}
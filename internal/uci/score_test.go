@@ -0,0 +1,60 @@
+package uci
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yutanagano/karei/internal/chess"
+)
+
+func TestParseScore(t *testing.T) {
+	type testCase struct {
+		name         string
+		tokens       string
+		expectError  bool
+		expected     Score
+		expectedUsed int
+	}
+
+	testCases := []testCase{
+		{"centipawns", "cp -35 nodes 1000", false, Score{Value: -35}, 2},
+		{"mate", "mate 3 pv a1a8", false, Score{Mate: true, Value: 3}, 2},
+		{"mated", "mate -2", false, Score{Mate: true, Value: -2}, 2},
+		{"lower bound", "cp 20 lowerbound nodes 5", false, Score{Value: 20, Bound: chess.LowerBound}, 3},
+		{"upper bound", "cp -20 upperbound", false, Score{Value: -20, Bound: chess.UpperBound}, 3},
+		{"wdl", "cp 40 wdl 300 600 100 nodes 5", false, Score{Value: 40, WDL: &chess.WinDrawLoss{Win: 300, Draw: 600, Loss: 100}}, 6},
+		{"bound and wdl", "cp 40 lowerbound wdl 300 600 100", false,
+			Score{Value: 40, Bound: chess.LowerBound, WDL: &chess.WinDrawLoss{Win: 300, Draw: 600, Loss: 100}}, 7},
+		{"unknown unit", "pawns 1", true, Score{}, 0},
+		{"missing value", "cp", true, Score{}, 0},
+		{"bad value", "cp many", true, Score{}, 0},
+		{"short wdl", "cp 40 wdl 300 600", true, Score{}, 0},
+		{"bad wdl", "cp 40 wdl 300 most 100", true, Score{}, 0},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		score, used, err := ParseScore(strings.Fields(c.tokens))
+		if c.expectError {
+			if err == nil {
+				t.Errorf("expected an error, got %+v", score)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(score, c.expected) || used != c.expectedUsed {
+			t.Errorf("expected %+v using %d tokens, got %+v using %d", c.expected, c.expectedUsed, score, used)
+		}
+
+		// the score reads back as it was written
+		if spelt := strings.Join(strings.Fields(c.tokens)[:used], " "); score.String() != spelt {
+			t.Errorf("expected %s, got %s", spelt, score.String())
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
@@ -0,0 +1,50 @@
+package uci
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Directions prefixed to each line of a transcript.
+const (
+	FromClient = ">"
+	ToClient   = "<"
+)
+
+// Recorder writes a transcript of a session, one line per command or
+// response, each prefixed with the milliseconds since the recording started
+// and its direction. Transcripts of real GUI sessions can be replayed in
+// tests, paced as the GUI sent its commands.
+type Recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	err   error
+	start time.Time
+	now   func() time.Time
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	return &Recorder{w: w, start: time.Now(), now: time.Now}
+}
+
+func (r *Recorder) record(direction, line string) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.err == nil {
+		elapsed := float64(r.now().Sub(r.start).Microseconds()) / 1000
+		_, r.err = fmt.Fprintf(r.w, "%.3f %s %s\n", elapsed, direction, line)
+	}
+}
+
+// Err returns the first error met while writing the transcript.
+func (r *Recorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
@@ -1,102 +1,219 @@
 package uci
 
 import (
-	"errors"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+	"github.com/yutanagano/karei/internal/logging"
 	"github.com/yutanagano/karei/internal/util"
 )
 
-var fromClient, toClient, fromEngine, toEngine chan string
-var clientConnected, engineConnected = false, false
+var logger = logging.For("uci")
 
-var debug, isInfinite = false, false
-var currentPosition chess.Position
-var bestMove string
+// Engine is the searching side of a UCI session.
+type Engine interface {
+	SetLogger(logger *slog.Logger)
+	Options() []engine.Option
+	SetOption(name, value string) error
+	NewGame()
+	Go(position chess.Position, limits engine.Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string))
+	Stop()
+	PonderHit()
+}
+
+type state uint8
+
+const (
+	idle state = iota
+	searching
+	pondering
+)
 
-func ConnectClient(toUCI, fromUCI chan string) {
-	fromClient = toUCI
-	toClient = fromUCI
-	clientConnected = true
+type searchResult struct {
+	bestMove, ponderMove string
 }
 
-func ConnectEngine(toUCI, fromUCI chan string) {
-	fromEngine = toUCI
-	toEngine = fromUCI
-	engineConnected = true
+// Session speaks UCI to a single client on behalf of a single engine.
+type Session struct {
+	fromClient <-chan string
+	toClient   chan<- string
+	engine     Engine
+
+	state            state
+	diagnostics      *slog.Logger
+	infinite         bool
+	stopRequested    bool
+	heldResult       *searchResult
+	searchResults    chan searchResult
+	deferredCommands util.Queue[string]
+	currentPosition  chess.Position
+	recorder         *Recorder
+	sendMutex        sync.Mutex
 }
 
-func Start() {
-	if !(clientConnected && engineConnected) {
-		err := errors.New("cannot start UCI without client/engine connection")
-		fmt.Println(err.Error())
-		log.Fatalln(err)
+func New(fromClient <-chan string, toClient chan<- string, e Engine) *Session {
+	s := &Session{
+		fromClient:    fromClient,
+		toClient:      toClient,
+		engine:        e,
+		searchResults: make(chan searchResult, 1),
+		diagnostics:   logger,
 	}
+	s.currentPosition.LoadFEN(chess.GetStartingFEN())
+	return s
+}
 
-	toClient <- "info string hello from karei"
+// Record makes the session write a transcript to r. It must be called before
+// Start.
+func (s *Session) Record(r *Recorder) {
+	s.recorder = r
+}
+
+// Start runs the session until the client sends quit or closes its channel,
+// or until ctx is done. If a search is running at that point, it is stopped
+// and its best move is sent before Start returns. The error is the cause of
+// ctx being done, if that is why the session ended.
+func (s *Session) Start(ctx context.Context) error {
+	s.send("info string hello from karei")
 
-Repl:
 	for {
 		select {
-		case input := <-fromClient:
-			tokens := util.Queue[string](strings.Split(input, " "))
-			command := tokens.Pop()
-
-			switch command {
-			case "uci":
-				handleUci()
-			case "setoption":
-				handleSetOption(tokens)
-			case "isready":
-				handleIsReady()
-			case "ucinewgame":
-				handleNewGame()
-			case "position":
-				handlePosition(tokens)
-			case "debug":
-				handleDebug(tokens)
-			case "register":
-				handleRegister(tokens)
-			case "go":
-				handleGo(tokens)
-			case "ponderhit":
-				handlePonderHit()
-			case "stop":
-				handleStop()
-			case "quit":
-				break Repl
+		case <-ctx.Done():
+			s.quit()
+			return context.Cause(ctx)
+		case input, ok := <-s.fromClient:
+			if !ok {
+				logger.Info("input closed")
+				s.quit()
+				return nil
+			}
+
+			logger.Info("transcript", "direction", "in", "line", input)
+			s.recorder.record(FromClient, input)
+			if input == "quit" {
+				s.quit()
+				return nil
 			}
-		case bestMove := <-fromEngine:
-			handleBestMove(bestMove)
+			s.handleInput(input)
+		case result := <-s.searchResults:
+			s.handleSearchResult(result)
 		}
 	}
 }
 
-func handleUci() {
-	toClient <- "id name Karei"
-	toClient <- "id author Yuta Nagano"
-	toClient <- "option name Hash type spin default 32 min 1 max 1024"
-	toClient <- "option name Threads type spin default 1 min 1 max 16"
-	toClient <- "uciok"
+func (s *Session) handleInput(input string) {
+	tokens := util.Queue[string](strings.Fields(input))
+	command := tokens.Pop()
+
+	if s.state != idle {
+		switch command {
+		case "position", "ucinewgame", "setoption", "flip", "d", "eval", "go":
+			s.diagnostics.Debug("deferring command until search ends", "command", input)
+			s.deferredCommands = append(s.deferredCommands, input)
+			return
+		case "bench":
+			s.send("info string already searching, ignoring " + command)
+			return
+		}
+	}
+
+	switch command {
+	case "uci":
+		s.handleUci()
+	case "setoption":
+		s.handleSetOption(tokens)
+	case "isready":
+		s.handleIsReady()
+	case "ucinewgame":
+		s.handleNewGame()
+	case "position":
+		s.handlePosition(tokens)
+	case "debug":
+		s.handleDebug(tokens)
+	case "register":
+		s.handleRegister(tokens)
+	case "go":
+		s.handleGo(tokens)
+	case "ponderhit":
+		s.handlePonderHit()
+	case "stop":
+		s.handleStop()
+	case "d":
+		s.handleDisplay()
+	case "eval":
+		s.handleEval()
+	case "bench":
+		s.handleBench(tokens)
+	case "flip":
+		s.currentPosition.Flip()
+	case "":
+	default:
+		s.send("info string unrecognised command " + command)
+	}
 }
 
-func handleSetOption(tokens util.Queue[string]) {
-	toClient <- "info string set option " + strings.Join(tokens, " ")
-	toClient <- "info string not implemented yet"
+func (s *Session) handleUci() {
+	s.send("id name Karei")
+	s.send("id author Yuta Nagano")
+	for _, option := range s.engine.Options() {
+		s.send(formatOption(option))
+	}
+	s.send("uciok")
 }
 
-func handleIsReady() {
-	toClient <- "readyok"
+func formatOption(option engine.Option) string {
+	result := "option name " + option.Name + " type " + option.Type
+	switch option.Type {
+	case "spin":
+		result += fmt.Sprintf(" default %s min %d max %d", option.Default, option.Min, option.Max)
+	case "combo":
+		result += " default " + option.Default
+		for _, v := range option.Vars {
+			result += " var " + v
+		}
+	case "check", "string":
+		result += " default " + option.Default
+	}
+	return result
 }
 
-func handleNewGame() {
-	currentPosition.LoadFEN(chess.GetStartingFEN())
+func (s *Session) handleSetOption(tokens util.Queue[string]) {
+	// setoption name <id> (value <x>)?
+	if tokens.Pop() != "name" {
+		s.send("info string expected setoption name <id> [value <x>]")
+		return
+	}
+
+	var name, value []string
+	for len(tokens) > 0 && tokens[0] != "value" {
+		name = append(name, tokens.Pop())
+	}
+	tokens.Pop()
+	value = tokens
+
+	err := s.engine.SetOption(strings.Join(name, " "), strings.Join(value, " "))
+	if err != nil {
+		s.send("info string " + err.Error())
+	}
+}
+
+func (s *Session) handleIsReady() {
+	s.send("readyok")
 }
 
-func handlePosition(tokens util.Queue[string]) {
+func (s *Session) handleNewGame() {
+	s.currentPosition.LoadFEN(chess.GetStartingFEN())
+	s.engine.NewGame()
+}
+
+func (s *Session) handlePosition(tokens util.Queue[string]) {
 	// [fen <fenstring> | startpos] (moves <move1> ... <movei>)?
 	var positionFen chess.FEN
 
@@ -117,98 +234,285 @@ func handlePosition(tokens util.Queue[string]) {
 		positionFen = chess.GetStartingFEN()
 	default:
 		err := fmt.Errorf("expected position specifier to be 'fen' or 'startpos', got %s", position_specifier)
-		toClient <- "info string " + err.Error()
+		s.send("info string " + err.Error())
 		return
 	}
 
-	err := currentPosition.LoadFEN(positionFen)
+	// the current position is only replaced once the whole command has been
+	// applied, so that a bad FEN or move leaves it as it was
+	var position chess.Position
+	err := position.LoadFEN(positionFen)
 	if err != nil {
-		fmt.Printf("error loading FEN: %s", err.Error())
+		s.send("info string error loading FEN: " + err.Error())
+		return
 	}
 
 	nextToken := tokens.Pop()
 	if nextToken == "moves" {
-		toClient <- "info string parsing moves: " + strings.Join(tokens, " ")
-		// TODO parse moves
+		for idx, moveString := range tokens {
+			err := position.MakeMove(moveString)
+			if err != nil {
+				s.send(fmt.Sprintf("info string illegal move at index %d (%s): %s", idx, moveString, err.Error()))
+				return
+			}
+		}
 	}
+	s.currentPosition = position
 }
 
-func handleDebug(tokens util.Queue[string]) {
+func (s *Session) handleDebug(tokens util.Queue[string]) {
 	// [ on | off ]
 	mode := tokens.Pop()
 	switch mode {
 	case "on":
-		debug = true
-		toClient <- "info string debug mode on"
+		mirror := func(message string) {
+			s.send("info string " + message)
+		}
+		s.diagnostics = logging.Mirror(logger, slog.LevelDebug, mirror)
+		s.engine.SetLogger(logging.Mirror(logging.For("engine"), slog.LevelDebug, mirror))
+		s.send("info string debug mode on")
 	case "off":
-		debug = false
-		toClient <- "info string debug mode off"
+		s.diagnostics = logger
+		s.engine.SetLogger(logging.For("engine"))
+		s.send("info string debug mode off")
 	default:
-		toClient <- "info string unrecognised debug mode " + mode
+		s.send("info string unrecognised debug mode " + mode)
 	}
 }
 
-func handleRegister(tokens util.Queue[string]) {
+func (s *Session) handleRegister(tokens util.Queue[string]) {
 	// register [ later | name <x> code <y> ]
-	toClient <- "info string register not implemented"
+	s.send("info string register not implemented")
+}
+
+func (s *Session) handleGo(tokens util.Queue[string]) {
+	limits, err := parseLimits(tokens)
+	if err != nil {
+		// the client still waits for a best move
+		s.send("info string " + err.Error())
+		s.sendBestMove(searchResult{})
+		return
+	}
+
+	s.state = searching
+	if limits.Ponder {
+		s.state = pondering
+	}
+	s.infinite = limits.Infinite
+	s.stopRequested = false
+	s.heldResult = nil
+
+	s.engine.Go(s.currentPosition, limits, func(searchInfo chess.SearchInfo) {
+		s.send(FormatInfo(searchInfo))
+	}, func(bestMove, ponderMove string) {
+		s.searchResults <- searchResult{bestMove, ponderMove}
+	})
+}
+
+// FormatInfo spells search progress as a UCI info line.
+func FormatInfo(searchInfo chess.SearchInfo) string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "info depth %d seldepth %d", searchInfo.Depth, searchInfo.SelDepth)
+	if searchInfo.MultiPV > 0 {
+		fmt.Fprintf(&b, " multipv %d", searchInfo.MultiPV)
+	}
+	b.WriteString(" score " + ScoreOf(searchInfo).String())
+
+	milliseconds := searchInfo.Time.Milliseconds()
+	nps := uint64(0)
+	if milliseconds > 0 {
+		nps = searchInfo.Nodes * 1000 / uint64(milliseconds)
+	}
+	fmt.Fprintf(&b, " nodes %d nps %d time %d", searchInfo.Nodes, nps, milliseconds)
+	if searchInfo.TBHits > 0 {
+		fmt.Fprintf(&b, " tbhits %d", searchInfo.TBHits)
+	}
+
+	if len(searchInfo.PV) > 0 {
+		b.WriteString(" pv " + strings.Join(searchInfo.PV, " "))
+	}
+
+	return b.String()
 }
 
-func handleGo(tokens util.Queue[string]) {
+func parseLimits(tokens util.Queue[string]) (engine.Limits, error) {
 	// go (searchmoves <move1> ... <movei>)? ponder? (wtime <x>)? (btime <x>)? (winc <x>)? (binc <x>)? (movestogo <x>)? (depth <x>)? (nodes <x>)? (mate <x>)? (movetime <x>)? infinite?
-	if len(tokens) > 1 {
-		switch (tokens)[1] {
+	var limits engine.Limits
+
+	parseInt := func(name string) (int, error) {
+		value, err := strconv.Atoi(tokens.Pop())
+		if err != nil {
+			return 0, fmt.Errorf("bad value for go %s: %s", name, err.Error())
+		}
+		return value, nil
+	}
+	parseMilliseconds := func(name string) (time.Duration, error) {
+		value, err := parseInt(name)
+		return time.Duration(value) * time.Millisecond, err
+	}
+
+	var err error
+	for len(tokens) > 0 && err == nil {
+		switch parameter := tokens.Pop(); parameter {
 		case "searchmoves":
-			toClient <- "info string go searchmoves not implemented"
+			for len(tokens) > 0 && !isGoParameter(tokens[0]) {
+				limits.SearchMoves = append(limits.SearchMoves, tokens.Pop())
+			}
 		case "ponder":
-			toClient <- "info string go ponder not implemented"
+			limits.Ponder = true
 		case "wtime":
-			toClient <- "info string go wtime not implemented"
+			limits.WTime, err = parseMilliseconds(parameter)
 		case "btime":
-			toClient <- "info string go btime not implemented"
+			limits.BTime, err = parseMilliseconds(parameter)
 		case "winc":
-			toClient <- "info string go winc not implemented"
+			limits.WInc, err = parseMilliseconds(parameter)
 		case "binc":
-			toClient <- "info string go binc not implemented"
+			limits.BInc, err = parseMilliseconds(parameter)
 		case "movestogo":
-			toClient <- "info string go movestogo not implemented"
+			limits.MovesToGo, err = parseInt(parameter)
 		case "depth":
-			toClient <- "info string go depth not implemented"
+			limits.Depth, err = parseInt(parameter)
 		case "nodes":
-			toClient <- "info string go nodes not implemented"
+			var nodes int
+			nodes, err = parseInt(parameter)
+			limits.Nodes = uint64(max(nodes, 0))
 		case "mate":
-			toClient <- "info string go mate not implemented"
+			limits.Mate, err = parseInt(parameter)
 		case "movetime":
-			toClient <- "info string go movetime not implemented"
+			limits.MoveTime, err = parseMilliseconds(parameter)
 		case "infinite":
-			toClient <- "info string go infinite not implemented"
+			limits.Infinite = true
 		default:
-			toClient <- "info string go " + tokens[1] + " not implemented"
+			err = fmt.Errorf("unrecognised go parameter %s", parameter)
 		}
-	} else {
-		toClient <- "info string go not implemented"
 	}
+
+	return limits, err
 }
 
-func handlePonderHit() {
-	toClient <- "info string ponderhit not implemented"
+func isGoParameter(token string) bool {
+	switch token {
+	case "searchmoves", "ponder", "wtime", "btime", "winc", "binc", "movestogo", "depth", "nodes", "mate", "movetime", "infinite":
+		return true
+	}
+	return false
 }
 
-func handleStop() {
-	if isInfinite {
-		if bestMove != "" {
-			toClient <- bestMove
-			bestMove = ""
-		}
+func (s *Session) handlePonderHit() {
+	if s.state != pondering {
+		return
+	}
 
-		toEngine <- "stop"
-		isInfinite = false
+	s.state = searching
+	s.engine.PonderHit()
+	if s.heldResult != nil && !s.infinite {
+		s.sendBestMove(*s.heldResult)
 	}
 }
 
-func handleBestMove(move string) {
-	if isInfinite {
-		bestMove = move
+func (s *Session) handleStop() {
+	if s.state == idle {
 		return
 	}
-	toClient <- move
+
+	// wait for the search to report, so that a go straight after stop finds
+	// the session idle and gets a best move of its own
+	s.stopRequested = true
+	if s.heldResult == nil {
+		s.engine.Stop()
+		result := <-s.searchResults
+		s.heldResult = &result
+	}
+	s.sendBestMove(*s.heldResult)
+}
+
+func (s *Session) handleDisplay() {
+	for _, line := range strings.Split(s.currentPosition.String(), "\n") {
+		s.send(line)
+	}
+	s.send("Legal moves: " + strings.Join(s.currentPosition.LegalMoves(), " "))
+}
+
+func (s *Session) handleEval() {
+	separator := "---------------+----------+----------+----------"
+
+	s.send("          Term |    White |    Black |    Total")
+	s.send(separator)
+	for _, term := range s.currentPosition.EvaluationBreakdown() {
+		s.send(fmt.Sprintf("%14s | %8s | %8s | %8s", term.Name, formatPawns(term.White), formatPawns(term.Black), formatPawns(term.White-term.Black)))
+	}
+	s.send(separator)
+	s.send("Final evaluation: " + formatPawns(s.currentPosition.Evaluate()) + " (white side)")
+}
+
+func (s *Session) handleBench(tokens util.Queue[string]) {
+	// bench [depth]
+	depth := engine.DefaultBenchDepth
+	if len(tokens) > 0 {
+		var err error
+		depth, err = strconv.Atoi(tokens.Pop())
+		if err != nil || depth < 1 {
+			s.send("info string bad value for bench depth")
+			return
+		}
+	}
+
+	engine.Bench(depth, func(line string) {
+		s.send(line)
+	})
+}
+
+func formatPawns(centipawns int) string {
+	return fmt.Sprintf("%+.2f", float64(centipawns)/100)
+}
+
+func (s *Session) handleSearchResult(result searchResult) {
+	// with go ponder or go infinite, bestmove may only be sent after ponderhit or stop
+	if !s.stopRequested && (s.state == pondering || s.infinite) {
+		s.diagnostics.Debug("holding best move until stop or ponderhit", "bestmove", result.bestMove)
+		s.heldResult = &result
+		return
+	}
+	s.sendBestMove(result)
+}
+
+func (s *Session) sendBestMove(result searchResult) {
+	bestMove := result.bestMove
+	if bestMove == "" {
+		bestMove = "0000"
+	}
+
+	if result.ponderMove != "" {
+		s.send("bestmove " + bestMove + " ponder " + result.ponderMove)
+	} else {
+		s.send("bestmove " + bestMove)
+	}
+
+	// a deferred go starts a new search, which the commands after it wait for
+	s.state = idle
+	s.heldResult = nil
+	for len(s.deferredCommands) > 0 && s.state == idle {
+		s.handleInput(s.deferredCommands.Pop())
+	}
+}
+
+// quit stops the search, and any searches deferred behind it, so that every
+// go is answered before the session ends.
+func (s *Session) quit() {
+	for s.state != idle {
+		s.handleStop()
+	}
+}
+
+// send passes a line to the client. Lines may be sent from the engine's
+// goroutine as well as the session's, so the transcript and log are kept in
+// the same order as the output.
+func (s *Session) send(line string) {
+	s.sendMutex.Lock()
+	defer s.sendMutex.Unlock()
+
+	logger.Info("transcript", "direction", "out", "line", line)
+	s.recorder.record(ToClient, line)
+	s.toClient <- line
 }
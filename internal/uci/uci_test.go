@@ -1,64 +1,333 @@
 package uci
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
 	"github.com/yutanagano/karei/internal/util"
 )
 
 func TestUci(t *testing.T) {
-	type testCase struct {
-		name            string
+	type step struct {
 		input           string
 		expectedOutputs []string
 	}
 
+	type testCase struct {
+		name  string
+		steps []step
+	}
+
 	testCases := []testCase{
 		{
 			"uci",
-			"uci",
-			[]string{
-				"id name Karei",
-				"id author Yuta Nagano",
-				"option name Hash type spin default 32 min 1 max 1024",
-				"option name Threads type spin default 1 min 1 max 16",
-				"uciok",
+			[]step{
+				{"uci", []string{
+					"id name Karei",
+					"id author Yuta Nagano",
+					"option name Hash type spin default 32 min 1 max 1024",
+					"option name Threads type spin default 1 min 1 max 16",
+					"option name Ponder type check default false",
+					"uciok",
+				}},
 			},
 		},
 		{
 			"debug on",
-			"debug on",
-			[]string{"info string debug mode on"},
+			[]step{{"debug on", []string{"info string debug mode on"}}},
 		},
 		{
 			"debug off",
-			"debug off",
-			[]string{"info string debug mode off"},
+			[]step{{"debug off", []string{"info string debug mode off"}}},
+		},
+		{
+			"debug diagnostics",
+			[]step{
+				{"debug on", []string{"info string debug mode on"}},
+				{"go infinite", nil},
+				{"position startpos", []string{"info string deferring command until search ends command=position startpos"}},
+				{"debug off", []string{"info string debug mode off"}},
+				{"ucinewgame", nil},
+				{"stop", []string{"bestmove e2e4 ponder e7e5"}},
+			},
+		},
+		{
+			"unrecognised command",
+			[]step{{"foo bar", []string{"info string unrecognised command foo"}}},
+		},
+		{
+			"display",
+			[]step{
+				{"d", []string{
+					" +---+---+---+---+---+---+---+---+",
+					" | r | n | b | q | k | b | n | r | 8",
+					" +---+---+---+---+---+---+---+---+",
+					" | p | p | p | p | p | p | p | p | 7",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 6",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 5",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 4",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 3",
+					" +---+---+---+---+---+---+---+---+",
+					" | P | P | P | P | P | P | P | P | 2",
+					" +---+---+---+---+---+---+---+---+",
+					" | R | N | B | Q | K | B | N | R | 1",
+					" +---+---+---+---+---+---+---+---+",
+					"   a   b   c   d   e   f   g   h",
+					"",
+					"Fen: rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+					"Key: 3B134A8AA051AEF2",
+					"Checkers:",
+					"Legal moves: b1a3 b1c3 g1f3 g1h3 a2a3 b2b3 c2c3 d2d3 e2e3 f2f3 g2g3 h2h3 a2a4 b2b4 c2c4 d2d4 e2e4 f2f4 g2g4 h2h4",
+				}},
+			},
+		},
+		{
+			"eval",
+			[]step{
+				{"position fen 4k3/8/8/8/8/8/4P3/4K3 w - - 0 1", nil},
+				{"eval", []string{
+					"          Term |    White |    Black |    Total",
+					"---------------+----------+----------+----------",
+					"      Material |    +0.94 |    +0.00 |    +0.94",
+					" Piece squares |    -0.50 |    -0.30 |    -0.20",
+					"      Mobility |    +0.18 |    +0.15 |    +0.03",
+					"         Pawns |    -0.05 |    +0.00 |    -0.05",
+					"       Endgame |  +100.32 |    +0.00 |  +100.32",
+					"---------------+----------+----------+----------",
+					"Final evaluation: +101.04 (white side)",
+				}},
+			},
+		},
+		{
+			"eval of drawn endgame",
+			[]step{
+				{"position fen 8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", nil},
+				{"eval", []string{
+					"          Term |    White |    Black |    Total",
+					"---------------+----------+----------+----------",
+					"      Material |    +0.94 |    +0.00 |    +0.94",
+					" Piece squares |    -0.50 |    +0.30 |    -0.80",
+					"      Mobility |    +0.18 |    +0.24 |    -0.06",
+					"         Pawns |    -0.05 |    +0.00 |    -0.05",
+					"       Endgame |    +0.00 |    +0.03 |    -0.03",
+					"---------------+----------+----------+----------",
+					"Final evaluation: +0.00 (white side)",
+				}},
+			},
+		},
+		{
+			"flip",
+			[]step{
+				{"position startpos moves e2e4", nil},
+				{"flip", nil},
+				{"d", []string{
+					" +---+---+---+---+---+---+---+---+",
+					" | r | n | b | q | k | b | n | r | 8",
+					" +---+---+---+---+---+---+---+---+",
+					" | p | p | p | p |   | p | p | p | 7",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 6",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   | p |   |   |   | 5",
+				}},
+			},
+		},
+		{
+			"display during search",
+			[]step{
+				{"go infinite", nil},
+				{"position fen 8/8/8/8/8/8/8/KQ5k w - - 0 1", nil},
+				{"d", nil},
+				{"stop", []string{
+					"bestmove e2e4 ponder e7e5",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 8",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 7",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 6",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 5",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 4",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 3",
+					" +---+---+---+---+---+---+---+---+",
+					" |   |   |   |   |   |   |   |   | 2",
+					" +---+---+---+---+---+---+---+---+",
+					" | K | Q |   |   |   |   |   | k | 1",
+					" +---+---+---+---+---+---+---+---+",
+					"   a   b   c   d   e   f   g   h",
+					"",
+					"Fen: 8/8/8/8/8/8/8/KQ5k w - - 0 1",
+				}},
+			},
+		},
+		{
+			"eval during search",
+			[]step{
+				{"go infinite", nil},
+				{"position fen 8/8/8/8/8/4k3/4P3/4K3 w - - 0 1", nil},
+				{"eval", nil},
+				{"stop", []string{
+					"bestmove e2e4 ponder e7e5",
+					"          Term |    White |    Black |    Total",
+					"---------------+----------+----------+----------",
+					"      Material |    +0.94 |    +0.00 |    +0.94",
+				}},
+			},
+		},
+		{
+			"go",
+			[]step{{"go depth 1", []string{"info depth 1 seldepth 1 score cp 0 nodes 0 nps 0 time 0 pv e2e4 e7e5", "bestmove e2e4 ponder e7e5"}}},
+		},
+		{
+			"bad go parameter",
+			[]step{
+				{"go depth x", []string{"info string bad value for go depth: strconv.Atoi: parsing \"x\": invalid syntax", "bestmove 0000"}},
+				{"isready", []string{"readyok"}},
+			},
+		},
+		{
+			"isready during search",
+			[]step{
+				{"go infinite", nil},
+				{"isready", []string{"readyok"}},
+				{"stop", []string{"bestmove e2e4 ponder e7e5"}},
+			},
+		},
+		{
+			"go during search",
+			[]step{
+				{"go infinite", nil},
+				{"go depth 1", nil},
+				{"stop", []string{
+					"bestmove e2e4 ponder e7e5",
+					"info depth 1 seldepth 1 score cp 0 nodes 0 nps 0 time 0 pv e2e4 e7e5",
+					"bestmove e2e4 ponder e7e5",
+				}},
+			},
+		},
+		{
+			"bench during search",
+			[]step{
+				{"go infinite", nil},
+				{"bench 1", []string{"info string already searching, ignoring bench"}},
+				{"stop", []string{"bestmove e2e4 ponder e7e5"}},
+			},
+		},
+		{
+			"bad bench depth",
+			[]step{
+				{"bench x", []string{"info string bad value for bench depth"}},
+				{"isready", []string{"readyok"}},
+			},
+		},
+		{
+			"stop after search finished",
+			[]step{
+				{"go depth 1", []string{"info depth 1 seldepth 1 score cp 0 nodes 0 nps 0 time 0 pv e2e4 e7e5", "bestmove e2e4 ponder e7e5"}},
+				{"stop", nil},
+				{"isready", []string{"readyok"}},
+			},
+		},
+		{
+			"infinite search finishing early",
+			[]step{
+				{"go infinite mate 1", nil},
+				{"isready", []string{"readyok"}},
+				{"stop", []string{"bestmove e2e4 ponder e7e5"}},
+				{"isready", []string{"readyok"}},
+			},
+		},
+		{
+			"ponderhit",
+			[]step{
+				{"go ponder wtime 1000 btime 1000", nil},
+				{"isready", []string{"readyok"}},
+				{"ponderhit", []string{"bestmove e2e4 ponder e7e5"}},
+			},
+		},
+		{
+			"go straight after stop",
+			[]step{
+				{"go infinite", nil},
+				{"stop", nil},
+				{"position startpos moves e2e4", nil},
+				{"go depth 1", []string{
+					"bestmove e2e4 ponder e7e5",
+					"info depth 1 seldepth 1 score cp 0 nodes 0 nps 0 time 0 pv e2e4 e7e5",
+					"bestmove e2e4 ponder e7e5",
+				}},
+			},
+		},
+		{
+			"stop while pondering",
+			[]step{
+				{"go ponder", nil},
+				{"stop", []string{"bestmove e2e4 ponder e7e5"}},
+				{"isready", []string{"readyok"}},
+			},
+		},
+		{
+			"quit during search",
+			[]step{
+				{"go infinite", nil},
+				{"quit", []string{"bestmove e2e4 ponder e7e5"}},
+			},
+		},
+		{
+			"quit with a deferred go",
+			[]step{
+				{"go infinite", nil},
+				{"go infinite", nil},
+				{"quit", []string{"bestmove e2e4 ponder e7e5", "bestmove e2e4 ponder e7e5"}},
+			},
 		},
 	}
 
-	fromUCI, toUCI := startUCIWithDummyEngine()
+	checkCase := func(t *testing.T, c testCase) {
+		t.Parallel()
 
-	err := waitUntilReady(fromUCI, toUCI, 10)
-	if err != nil {
-		t.Error(err.Error())
-	}
+		fromUCI, toUCI, finished := startUCIWithDummyEngine()
 
-	checkCase := func(t *testing.T, c testCase) {
-		toUCI <- c.input
-		for _, expectedOutput := range c.expectedOutputs {
-			timeOut := getMillisecondTimeOutChannel(10)
-			select {
-			case result := <-fromUCI:
-				if result != expectedOutput {
-					t.Errorf("expected output %v, got %v", expectedOutput, result)
+		err := waitUntilReady(fromUCI, toUCI, 100)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		for _, s := range c.steps {
+			toUCI <- s.input
+			for _, expectedOutput := range s.expectedOutputs {
+				timeOut := getMillisecondTimeOutChannel(100)
+				select {
+				case result := <-fromUCI:
+					if result != expectedOutput {
+						t.Errorf("expected output %v, got %v", expectedOutput, result)
+					}
+				case <-timeOut:
+					t.Errorf("timeout waiting for output %v", expectedOutput)
 				}
-			case <-timeOut:
-				t.Errorf("timeout waiting for output %v", expectedOutput)
+			}
+		}
+
+		if c.steps[len(c.steps)-1].input == "quit" {
+			select {
+			case <-finished:
+			case <-getMillisecondTimeOutChannel(100):
+				t.Error("session did not finish after quit")
 			}
 		}
 	}
@@ -68,11 +337,69 @@ func TestUci(t *testing.T) {
 	}
 }
 
+func TestPositionDeferredDuringSearch(t *testing.T) {
+	fromUCI := make(chan string, 100)
+	toUCI := make(chan string)
+	session := New(toUCI, fromUCI, &dummyEngine{})
+	finished := make(chan bool)
+	go func() {
+		session.Start(context.Background())
+		finished <- true
+	}()
+
+	toUCI <- "go infinite"
+	toUCI <- "position startpos moves e2e4"
+	toUCI <- "quit"
+	<-finished
+
+	expected := chess.Position{}
+	expected.LoadFEN(chess.GetStartingFEN())
+	expected.MakeMove("e2e4")
+
+	if !reflect.DeepEqual(session.currentPosition, expected) {
+		t.Errorf("expected position %v, got %v", expected, session.currentPosition)
+	}
+}
+
+func TestStartEndsWhenContextDone(t *testing.T) {
+	fromUCI := make(chan string, 100)
+	toUCI := make(chan string)
+	session := New(toUCI, fromUCI, &dummyEngine{})
+
+	shutdown := errors.New("shutdown")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- session.Start(ctx)
+	}()
+
+	toUCI <- "go infinite"
+	cancel(shutdown)
+
+	select {
+	case err := <-finished:
+		if !errors.Is(err, shutdown) {
+			t.Errorf("expected error %v, got %v", shutdown, err)
+		}
+	case <-getMillisecondTimeOutChannel(100):
+		t.Fatal("session did not finish after context was cancelled")
+	}
+
+	var outputs []string
+	for len(fromUCI) > 0 {
+		outputs = append(outputs, <-fromUCI)
+	}
+	if expected := []string{"info string hello from karei", "bestmove e2e4 ponder e7e5"}; !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("expected outputs %v, got %v", expected, outputs)
+	}
+}
+
 func TestHandlePosition(t *testing.T) {
 	type testCase struct {
 		name      string
 		arguments util.Queue[string]
 		fen       chess.FEN
+		moves     []string
 	}
 
 	testCases := []testCase{
@@ -95,16 +422,54 @@ func TestHandlePosition(t *testing.T) {
 				HalfMoveClock:   "0",
 				FullMoveNumber:  "3",
 			},
+			nil,
+		},
+		{
+			"startpos with moves",
+			util.Queue[string]{"startpos", "moves", "e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "e1g1"},
+			chess.GetStartingFEN(),
+			[]string{"e2e4", "e7e5", "g1f3", "b8c6", "f1c4", "g8f6", "e1g1"},
+		},
+		{
+			"promotion",
+			util.Queue[string]{"fen", "4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1", "moves", "a7a8q", "e8d7"},
+			chess.FEN{
+				BoardState:      "4k3/P7/8/8/8/8/8/4K3",
+				ActiveColour:    "w",
+				CastlingRights:  "-",
+				EnPassantSquare: "-",
+				HalfMoveClock:   "0",
+				FullMoveNumber:  "1",
+			},
+			[]string{"a7a8q", "e8d7"},
+		},
+		{
+			"keeps position on illegal move",
+			util.Queue[string]{"fen", "4k3/P7/8/8/8/8/8/4K3", "w", "-", "-", "0", "1", "moves", "a7a8q", "e8d8"},
+			chess.GetStartingFEN(),
+			nil,
+		},
+		{
+			"keeps position on bad fen",
+			util.Queue[string]{"fen", "4k3/P7/8/8/8/8/8/4K3", "x", "-", "-", "0", "1"},
+			chess.GetStartingFEN(),
+			nil,
 		},
 	}
 
 	checkCase := func(t *testing.T, c testCase) {
-		handlePosition(c.arguments)
+		session := New(nil, make(chan string, 100), &dummyEngine{})
+		session.handlePosition(c.arguments)
 		expected := chess.Position{}
 		expected.LoadFEN(c.fen)
+		for _, moveString := range c.moves {
+			if err := expected.MakeMove(moveString); err != nil {
+				t.Fatal(err)
+			}
+		}
 
-		if !reflect.DeepEqual(currentPosition, expected) {
-			t.Errorf("expected position %v, got %v", expected, currentPosition)
+		if !reflect.DeepEqual(session.currentPosition, expected) {
+			t.Errorf("expected position %v, got %v", expected, session.currentPosition)
 		}
 	}
 
@@ -113,16 +478,138 @@ func TestHandlePosition(t *testing.T) {
 	}
 }
 
-func startUCIWithDummyEngine() (fromUCI, toUCI chan string) {
+func TestFormatInfo(t *testing.T) {
+	type testCase struct {
+		name       string
+		searchInfo chess.SearchInfo
+		expected   string
+	}
+
+	testCases := []testCase{
+		{
+			"centipawns",
+			chess.SearchInfo{Depth: 3, SelDepth: 5, Score: -25, Nodes: 2000, Time: time.Second, PV: []string{"e2e4", "e7e5"}},
+			"info depth 3 seldepth 5 score cp -25 nodes 2000 nps 2000 time 1000 pv e2e4 e7e5",
+		},
+		{
+			"mate",
+			chess.SearchInfo{Depth: 4, SelDepth: 4, Score: 31997, MateIn: 2, Nodes: 50, PV: []string{"c6b6", "a8b8", "b1h1"}},
+			"info depth 4 seldepth 4 score mate 2 nodes 50 nps 0 time 0 pv c6b6 a8b8 b1h1",
+		},
+		{
+			"tablebase hits",
+			chess.SearchInfo{Depth: 2, SelDepth: 3, Score: 31871, Nodes: 40, TBHits: 12, PV: []string{"a1b2"}},
+			"info depth 2 seldepth 3 score cp 31871 nodes 40 nps 0 time 0 tbhits 12 pv a1b2",
+		},
+		{
+			"multipv",
+			chess.SearchInfo{Depth: 5, SelDepth: 7, MultiPV: 2, Score: 12, Nodes: 300, PV: []string{"g1f3"}},
+			"info depth 5 seldepth 7 multipv 2 score cp 12 nodes 300 nps 0 time 0 pv g1f3",
+		},
+		{
+			"lower bound",
+			chess.SearchInfo{Depth: 6, SelDepth: 9, Score: 60, Bound: chess.LowerBound, Nodes: 800, PV: []string{"d2d4"}},
+			"info depth 6 seldepth 9 score cp 60 lowerbound nodes 800 nps 0 time 0 pv d2d4",
+		},
+		{
+			"upper bound",
+			chess.SearchInfo{Depth: 6, SelDepth: 9, Score: -40, Bound: chess.UpperBound, Nodes: 900, PV: []string{"e2e4"}},
+			"info depth 6 seldepth 9 score cp -40 upperbound nodes 900 nps 0 time 0 pv e2e4",
+		},
+		{
+			"wdl",
+			chess.SearchInfo{Depth: 7, SelDepth: 9, Score: 35, WDL: &chess.WinDrawLoss{Win: 120, Draw: 850, Loss: 30}, Nodes: 700, PV: []string{"c2c4"}},
+			"info depth 7 seldepth 9 score cp 35 wdl 120 850 30 nodes 700 nps 0 time 0 pv c2c4",
+		},
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) {
+			if result := FormatInfo(c.searchInfo); result != c.expected {
+				t.Errorf("expected %s, got %s", c.expected, result)
+			}
+		})
+	}
+}
+
+// dummyEngine answers every search with e2e4. Finite searches finish
+// immediately; infinite and ponder searches wait to be stopped, and ponder
+// searches also finish on ponderhit.
+type dummyEngine struct {
+	mutex     sync.Mutex
+	stop      chan struct{}
+	ponderHit chan struct{}
+}
+
+func (d *dummyEngine) Options() []engine.Option {
+	return []engine.Option{
+		{Name: "Hash", Type: "spin", Default: "32", Min: 1, Max: 1024},
+		{Name: "Threads", Type: "spin", Default: "1", Min: 1, Max: 16},
+		{Name: "Ponder", Type: "check", Default: "false"},
+	}
+}
+
+func (d *dummyEngine) SetLogger(logger *slog.Logger) {}
+
+func (d *dummyEngine) SetOption(name, value string) error {
+	return nil
+}
+
+func (d *dummyEngine) NewGame() {}
+
+func (d *dummyEngine) Go(position chess.Position, limits engine.Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string)) {
+	stop := make(chan struct{})
+	ponderHit := make(chan struct{})
+
+	d.mutex.Lock()
+	d.stop, d.ponderHit = stop, ponderHit
+	d.mutex.Unlock()
+
+	go func() {
+		switch {
+		case limits.Infinite && limits.Mate > 0:
+		case limits.Infinite:
+			<-stop
+		case limits.Ponder:
+			select {
+			case <-stop:
+			case <-ponderHit:
+			}
+		default:
+			info(chess.SearchInfo{Depth: 1, SelDepth: 1, PV: []string{"e2e4", "e7e5"}})
+		}
+		done("e2e4", "e7e5")
+	}()
+}
+
+func (d *dummyEngine) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+}
+
+func (d *dummyEngine) PonderHit() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.ponderHit != nil {
+		close(d.ponderHit)
+		d.ponderHit = nil
+	}
+}
+
+func startUCIWithDummyEngine() (fromUCI, toUCI chan string, finished chan bool) {
 	fromUCI = make(chan string, 100)
 	toUCI = make(chan string)
-	ConnectClient(toUCI, fromUCI)
-
-	fromDummyEngine := make(chan string)
-	toDummyEngine := make(chan string)
-	ConnectEngine(fromDummyEngine, toDummyEngine)
+	finished = make(chan bool, 1)
 
-	go Start()
+	session := New(toUCI, fromUCI, &dummyEngine{})
+	go func() {
+		session.Start(context.Background())
+		finished <- true
+	}()
 	return
 }
 
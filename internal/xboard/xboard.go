@@ -0,0 +1,476 @@
+package xboard
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+	"github.com/yutanagano/karei/internal/logging"
+	"github.com/yutanagano/karei/internal/util"
+)
+
+var logger = logging.For("xboard")
+
+// Engine is the searching side of an xboard session.
+type Engine interface {
+	NewGame()
+	Go(position chess.Position, limits engine.Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string))
+	Stop()
+}
+
+type state uint8
+
+const (
+	idle state = iota
+	thinking
+	analysing
+)
+
+const (
+	// mateScore is the base used for reporting mate scores in thinking
+	// output, so that mate in n is reported as mateScore + n.
+	mateScore = 100000
+
+	// defaultMoveTime is used when the client has not set a time control.
+	defaultMoveTime = 5 * time.Second
+)
+
+// Session speaks the Chess Engine Communication Protocol (CECP) to a single
+// client on behalf of a single engine.
+type Session struct {
+	fromClient <-chan string
+	toClient   chan<- string
+	engine     Engine
+
+	state         state
+	searchResults chan string
+	forceMode     bool
+	analyseMode   bool
+	engineIsWhite bool
+	post          bool
+
+	startingFEN     chess.FEN
+	moves           []string
+	currentPosition chess.Position
+
+	movesPerSession  int
+	baseTime         time.Duration
+	increment        time.Duration
+	timeLeft         time.Duration
+	opponentTimeLeft time.Duration
+	moveTime         time.Duration
+	depth            int
+}
+
+func New(fromClient <-chan string, toClient chan<- string, e Engine) *Session {
+	s := &Session{
+		fromClient:    fromClient,
+		toClient:      toClient,
+		engine:        e,
+		searchResults: make(chan string, 1),
+	}
+	s.loadFEN(chess.GetStartingFEN())
+	return s
+}
+
+// Start runs the session until the client sends quit or closes its channel,
+// or until ctx is done. Any running search is stopped before Start returns.
+// The error is the cause of ctx being done, if that is why the session ended.
+func (s *Session) Start(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			s.stopSearch()
+			return context.Cause(ctx)
+		case input, ok := <-s.fromClient:
+			if !ok {
+				logger.Info("input closed")
+				s.stopSearch()
+				return nil
+			}
+
+			logger.Info("transcript", "direction", "in", "line", input)
+			if input == "quit" {
+				s.stopSearch()
+				return nil
+			}
+			s.handleInput(input)
+		case bestMove := <-s.searchResults:
+			s.handleSearchResult(bestMove)
+		}
+	}
+}
+
+func (s *Session) handleInput(input string) {
+	tokens := util.Queue[string](strings.Fields(input))
+	command := tokens.Pop()
+
+	switch command {
+	case "xboard":
+	case "protover":
+		s.handleProtover()
+	case "new":
+		s.handleNew()
+	case "force":
+		s.stopSearch()
+		s.forceMode = true
+	case "go":
+		s.handleGo()
+	case "usermove":
+		s.handleUserMove(tokens.Pop())
+	case "setboard":
+		s.handleSetBoard(tokens)
+	case "level":
+		s.handleLevel(input, tokens)
+	case "st":
+		s.handleSt(input, tokens)
+	case "sd":
+		s.handleSd(input, tokens)
+	case "time":
+		s.timeLeft = s.parseCentiseconds(input, tokens)
+	case "otim":
+		s.opponentTimeLeft = s.parseCentiseconds(input, tokens)
+	case "analyze":
+		s.handleAnalyse()
+	case "exit":
+		if s.analyseMode {
+			s.stopSearch()
+			s.analyseMode = false
+		}
+	case "undo":
+		s.handleUndo(input, 1)
+	case "remove":
+		s.handleUndo(input, 2)
+	case "result":
+		s.stopSearch()
+		s.forceMode = true
+	case "post":
+		s.post = true
+	case "nopost":
+		s.post = false
+	case "ping":
+		s.send("pong " + tokens.Pop())
+	case "?":
+		if s.state == thinking {
+			s.playEngineMove(s.stopSearch())
+		}
+	case "accepted", "rejected", "random", "hard", "easy", "computer", "name", "rating", "ics", ".", "":
+	default:
+		if isMove(command) {
+			s.handleUserMove(command)
+			return
+		}
+		s.send("Error (unknown command): " + command)
+	}
+}
+
+func (s *Session) handleProtover() {
+	s.send(`feature myname="Karei" ping=1 setboard=1 usermove=1 analyze=1 colors=0 sigint=0 sigterm=0 reuse=1 done=1`)
+}
+
+func (s *Session) handleNew() {
+	s.stopSearch()
+	s.loadFEN(chess.GetStartingFEN())
+	s.engine.NewGame()
+
+	s.forceMode = false
+	s.engineIsWhite = false
+	s.depth = 0
+	s.timeLeft, s.opponentTimeLeft = s.baseTime, s.baseTime
+
+	if s.analyseMode {
+		s.analyse()
+	}
+}
+
+func (s *Session) handleGo() {
+	s.stopSearch()
+	s.forceMode = false
+	s.engineIsWhite = s.currentPosition.WhiteToMove()
+	s.think()
+}
+
+func (s *Session) handleUserMove(moveString string) {
+	s.stopSearch()
+
+	if err := s.currentPosition.MakeMove(moveString); err != nil {
+		s.send("Illegal move: " + moveString)
+		return
+	}
+	s.moves = append(s.moves, moveString)
+
+	if s.analyseMode {
+		s.analyse()
+		return
+	}
+	if s.reportOutcome() {
+		return
+	}
+	if !s.forceMode && s.currentPosition.WhiteToMove() == s.engineIsWhite {
+		s.think()
+	}
+}
+
+func (s *Session) handleSetBoard(tokens util.Queue[string]) {
+	s.stopSearch()
+
+	fen, err := chess.ParseFEN(strings.Join(tokens, " "))
+	if err == nil {
+		err = s.loadFEN(fen)
+	}
+	if err != nil {
+		s.send("tellusererror Illegal position: " + err.Error())
+		return
+	}
+
+	if s.analyseMode {
+		s.analyse()
+	}
+}
+
+func (s *Session) handleLevel(input string, tokens util.Queue[string]) {
+	// level <moves per session> <base minutes[:seconds]> <increment seconds>
+	movesPerSession, err := strconv.Atoi(tokens.Pop())
+	if err != nil || movesPerSession < 0 {
+		s.send("Error (bad moves per session): " + input)
+		return
+	}
+
+	base, err := parseBaseTime(tokens.Pop())
+	if err != nil {
+		s.send("Error (bad base time): " + input)
+		return
+	}
+
+	increment, err := strconv.ParseFloat(tokens.Pop(), 64)
+	if err != nil || increment < 0 {
+		s.send("Error (bad increment): " + input)
+		return
+	}
+
+	s.movesPerSession = movesPerSession
+	s.baseTime = base
+	s.increment = time.Duration(increment * float64(time.Second))
+	s.timeLeft, s.opponentTimeLeft = base, base
+	s.moveTime = 0
+}
+
+// parseBaseTime reads the base time of a level command, which is given either
+// in minutes or in minutes and seconds separated by a colon.
+func parseBaseTime(token string) (time.Duration, error) {
+	minutesString, secondsString, hasSeconds := strings.Cut(token, ":")
+
+	minutes, err := strconv.Atoi(minutesString)
+	if err != nil || minutes < 0 {
+		return 0, fmt.Errorf("bad minutes %s", minutesString)
+	}
+
+	seconds := 0
+	if hasSeconds {
+		seconds, err = strconv.Atoi(secondsString)
+		if err != nil || seconds < 0 || seconds >= 60 {
+			return 0, fmt.Errorf("bad seconds %s", secondsString)
+		}
+	}
+
+	return time.Duration(minutes)*time.Minute + time.Duration(seconds)*time.Second, nil
+}
+
+func (s *Session) handleSt(input string, tokens util.Queue[string]) {
+	// st <seconds per move>
+	seconds, err := strconv.ParseFloat(tokens.Pop(), 64)
+	if err != nil || seconds <= 0 {
+		s.send("Error (bad time per move): " + input)
+		return
+	}
+
+	s.moveTime = time.Duration(seconds * float64(time.Second))
+	s.movesPerSession = 0
+	s.baseTime, s.increment = 0, 0
+}
+
+func (s *Session) handleSd(input string, tokens util.Queue[string]) {
+	// sd <depth>
+	depth, err := strconv.Atoi(tokens.Pop())
+	if err != nil || depth < 1 {
+		s.send("Error (bad depth): " + input)
+		return
+	}
+
+	s.depth = depth
+}
+
+func (s *Session) parseCentiseconds(input string, tokens util.Queue[string]) time.Duration {
+	centiseconds, err := strconv.Atoi(tokens.Pop())
+	if err != nil {
+		s.send("Error (bad time): " + input)
+		return 0
+	}
+	return time.Duration(centiseconds) * 10 * time.Millisecond
+}
+
+func (s *Session) handleAnalyse() {
+	s.stopSearch()
+	s.analyseMode = true
+	s.analyse()
+}
+
+func (s *Session) handleUndo(input string, count int) {
+	s.stopSearch()
+
+	if len(s.moves) < count {
+		s.send("Error (no moves to undo): " + input)
+		return
+	}
+
+	moves := s.moves[:len(s.moves)-count]
+	s.loadFEN(s.startingFEN)
+	for _, moveString := range moves {
+		s.currentPosition.MakeMove(moveString)
+	}
+	s.moves = moves
+
+	if s.analyseMode {
+		s.analyse()
+	}
+}
+
+// loadFEN sets up a new game from the given position.
+func (s *Session) loadFEN(fen chess.FEN) error {
+	var thePosition chess.Position
+	if err := thePosition.LoadFEN(fen); err != nil {
+		return err
+	}
+
+	s.startingFEN = fen
+	s.currentPosition = thePosition
+	s.moves = nil
+	return nil
+}
+
+func (s *Session) think() {
+	if s.reportOutcome() {
+		return
+	}
+
+	s.state = thinking
+	s.search(s.getLimits(), s.post)
+}
+
+func (s *Session) analyse() {
+	s.state = analysing
+	s.search(engine.Limits{Infinite: true}, true)
+}
+
+func (s *Session) search(limits engine.Limits, post bool) {
+	s.engine.Go(s.currentPosition, limits, func(searchInfo chess.SearchInfo) {
+		// thinking output has no way to mark a score as a bound
+		if post && searchInfo.Bound == chess.ExactScore {
+			s.send(formatThinking(searchInfo))
+		}
+	}, func(bestMove, ponderMove string) {
+		s.searchResults <- bestMove
+	})
+}
+
+func (s *Session) getLimits() engine.Limits {
+	limits := engine.Limits{Depth: s.depth}
+
+	switch {
+	case s.moveTime > 0:
+		limits.MoveTime = s.moveTime
+	case s.timeLeft > 0:
+		limits.WTime, limits.BTime = s.timeLeft, s.opponentTimeLeft
+		if !s.engineIsWhite {
+			limits.WTime, limits.BTime = s.opponentTimeLeft, s.timeLeft
+		}
+		limits.WInc, limits.BInc = s.increment, s.increment
+		if s.movesPerSession > 0 {
+			// Both sides have made one move fewer than the move number, however
+			// the game was set up.
+			movesMade := s.currentPosition.FullMoveNumber() - 1
+			limits.MovesToGo = s.movesPerSession - movesMade%s.movesPerSession
+		}
+	case s.depth == 0:
+		limits.MoveTime = defaultMoveTime
+	}
+
+	return limits
+}
+
+func formatThinking(searchInfo chess.SearchInfo) string {
+	score := searchInfo.Score
+	switch {
+	case searchInfo.MateIn > 0:
+		score = mateScore + searchInfo.MateIn
+	case searchInfo.MateIn < 0:
+		score = -mateScore + searchInfo.MateIn
+	}
+
+	centiseconds := searchInfo.Time.Milliseconds() / 10
+	return fmt.Sprintf("%d %d %d %d %s", searchInfo.Depth, score, centiseconds, searchInfo.Nodes, strings.Join(searchInfo.PV, " "))
+}
+
+func (s *Session) send(line string) {
+	logger.Info("transcript", "direction", "out", "line", line)
+	s.toClient <- line
+}
+
+// stopSearch stops any running search and waits for it to finish, returning
+// its best move.
+func (s *Session) stopSearch() string {
+	if s.state == idle {
+		return ""
+	}
+
+	s.engine.Stop()
+	bestMove := <-s.searchResults
+	s.state = idle
+	return bestMove
+}
+
+func (s *Session) handleSearchResult(bestMove string) {
+	previousState := s.state
+	s.state = idle
+
+	// analysis only ends by itself when there is nothing left to analyse
+	if previousState == thinking {
+		s.playEngineMove(bestMove)
+	}
+}
+
+func (s *Session) playEngineMove(bestMove string) {
+	if bestMove == "" {
+		s.reportOutcome()
+		return
+	}
+
+	s.currentPosition.MakeMove(bestMove)
+	s.moves = append(s.moves, bestMove)
+	s.send("move " + bestMove)
+	s.reportOutcome()
+}
+
+// reportOutcome sends the result of the game if it has ended, and reports
+// whether it has.
+func (s *Session) reportOutcome() bool {
+	result, reason := s.currentPosition.Outcome()
+	if result == "" {
+		return false
+	}
+
+	s.send(result + " {" + reason + "}")
+	return true
+}
+
+func isMove(token string) bool {
+	if len(token) != 4 && len(token) != 5 {
+		return false
+	}
+	return token[0] >= 'a' && token[0] <= 'h' && token[1] >= '1' && token[1] <= '8' &&
+		token[2] >= 'a' && token[2] <= 'h' && token[3] >= '1' && token[3] <= '8'
+}
@@ -0,0 +1,330 @@
+package xboard
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+)
+
+func TestXboard(t *testing.T) {
+	type step struct {
+		input           string
+		expectedOutputs []string
+	}
+
+	type testCase struct {
+		name  string
+		steps []step
+	}
+
+	testCases := []testCase{
+		{
+			"protover",
+			[]step{
+				{"xboard", nil},
+				{"protover 2", []string{`feature myname="Karei" ping=1 setboard=1 usermove=1 analyze=1 colors=0 sigint=0 sigterm=0 reuse=1 done=1`}},
+				{"ping 1", []string{"pong 1"}},
+			},
+		},
+		{
+			"unknown command",
+			[]step{
+				{"foo", []string{"Error (unknown command): foo"}},
+			},
+		},
+		{
+			"engine replies as black",
+			[]step{
+				{"new", nil},
+				{"usermove e2e4", []string{"move a7a5"}},
+				{"usermove d2d4", []string{"move a5a4"}},
+			},
+		},
+		{
+			"bare moves",
+			[]step{
+				{"e2e4", []string{"move a7a5"}},
+			},
+		},
+		{
+			"illegal move",
+			[]step{
+				{"usermove e2e5", []string{"Illegal move: e2e5"}},
+				{"ping 1", []string{"pong 1"}},
+			},
+		},
+		{
+			"force and go",
+			[]step{
+				{"force", nil},
+				{"usermove e2e4", nil},
+				{"usermove e7e5", nil},
+				{"go", []string{"move a2a3"}},
+				{"usermove a7a6", []string{"move a1a2"}},
+			},
+		},
+		{
+			"post",
+			[]step{
+				{"post", nil},
+				{"sd 1", nil},
+				{"usermove e2e4", []string{"1 -15 0 20 a7a5", "move a7a5"}},
+				{"nopost", nil},
+				{"usermove d2d4", []string{"move a5a4"}},
+			},
+		},
+		{
+			"setboard",
+			[]step{
+				{"force", nil},
+				{"setboard 7k/8/8/8/8/8/8/KR6 w - - 0 1", nil},
+				{"go", []string{"move a1a2"}},
+			},
+		},
+		{
+			"illegal setboard",
+			[]step{
+				{"setboard 8/8/8/8/8/8/8/8 w - - 0 1", []string{"tellusererror Illegal position: bad FEN: expected exactly one white king, got 0"}},
+			},
+		},
+		{
+			"setboard with castling rights and no rooks",
+			[]step{
+				{"setboard 4k3/8/8/8/8/8/8/4K3 w KQ - 0 1", []string{"tellusererror Illegal position: bad FEN: castling rights KQ without the king and rook on e1 and h1"}},
+			},
+		},
+		{
+			"setboard with a misplaced en passant square",
+			[]step{
+				{"setboard rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq e3 0 1", []string{"tellusererror Illegal position: bad FEN: no pawn can just have passed over en passant square e3"}},
+			},
+		},
+		{
+			"setboard with nine ranks",
+			[]step{
+				{"setboard rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR/8 w KQkq - 0 1", []string{"tellusererror Illegal position: bad FEN: expected 8 ranks, got 9"}},
+			},
+		},
+		{
+			"illegal setboard keeps game",
+			[]step{
+				{"force", nil},
+				{"usermove e2e4", nil},
+				{"usermove e7e5", nil},
+				{"setboard 8/8/8/8/8/8/8/8 w - - 0 1", []string{"tellusererror Illegal position: bad FEN: expected exactly one white king, got 0"}},
+				{"undo", nil},
+				{"go", []string{"move a7a5"}},
+			},
+		},
+		{
+			"undo and remove",
+			[]step{
+				{"force", nil},
+				{"usermove e2e4", nil},
+				{"usermove e7e5", nil},
+				{"remove", nil},
+				{"undo", []string{"Error (no moves to undo): undo"}},
+				{"go", []string{"move a2a3"}},
+			},
+		},
+		{
+			"engine mates",
+			[]step{
+				{"force", nil},
+				{"setboard 6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", nil},
+				{"usermove a1a8", []string{"1-0 {White mates}"}},
+			},
+		},
+		{
+			"move now",
+			[]step{
+				{"st 3600", nil},
+				{"usermove e2e4", nil},
+				{"?", []string{"move a7a5"}},
+			},
+		},
+		{
+			"analyse",
+			[]step{
+				{"analyze", []string{"1 -15 0 20 a2a3"}},
+				{"usermove e2e4", []string{"1 -15 0 20 a7a5"}},
+				{"undo", []string{"1 -15 0 20 a2a3"}},
+				{"exit", nil},
+				{"ping 2", []string{"pong 2"}},
+			},
+		},
+		{
+			"bad level",
+			[]step{
+				{"level 40 5:xx 0", []string{"Error (bad base time): level 40 5:xx 0"}},
+			},
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		t.Parallel()
+
+		fromXboard := make(chan string, 100)
+		toXboard := make(chan string)
+		finished := make(chan bool, 1)
+
+		session := New(toXboard, fromXboard, &dummyEngine{})
+		go func() {
+			session.Start(context.Background())
+			finished <- true
+		}()
+
+		for _, s := range c.steps {
+			toXboard <- s.input
+			for _, expectedOutput := range s.expectedOutputs {
+				select {
+				case result := <-fromXboard:
+					if result != expectedOutput {
+						t.Errorf("expected output %v, got %v", expectedOutput, result)
+					}
+				case <-time.After(100 * time.Millisecond):
+					t.Errorf("timeout waiting for output %v", expectedOutput)
+				}
+			}
+		}
+
+		toXboard <- "quit"
+		select {
+		case <-finished:
+		case <-time.After(100 * time.Millisecond):
+			t.Error("session did not finish after quit")
+		}
+
+		select {
+		case result := <-fromXboard:
+			t.Errorf("unexpected output %v", result)
+		default:
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestStartEndsWhenContextDone(t *testing.T) {
+	fromXboard := make(chan string, 100)
+	toXboard := make(chan string)
+	session := New(toXboard, fromXboard, &dummyEngine{})
+
+	shutdown := errors.New("shutdown")
+	ctx, cancel := context.WithCancelCause(context.Background())
+	finished := make(chan error, 1)
+	go func() {
+		finished <- session.Start(ctx)
+	}()
+
+	toXboard <- "analyze"
+	cancel(shutdown)
+
+	select {
+	case err := <-finished:
+		if !errors.Is(err, shutdown) {
+			t.Errorf("expected error %v, got %v", shutdown, err)
+		}
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("session did not finish after context was cancelled")
+	}
+}
+
+func TestGetLimits(t *testing.T) {
+	type testCase struct {
+		name     string
+		inputs   []string
+		expected engine.Limits
+	}
+
+	testCases := []testCase{
+		{"default", nil, engine.Limits{MoveTime: defaultMoveTime}},
+		{"depth", []string{"sd 4"}, engine.Limits{Depth: 4}},
+		{"time per move", []string{"st 2.5"}, engine.Limits{MoveTime: 2500 * time.Millisecond}},
+		{
+			"level",
+			[]string{"level 40 0:30 0", "time 1000", "otim 2000"},
+			engine.Limits{WTime: 20 * time.Second, BTime: 10 * time.Second, MovesToGo: 40},
+		},
+		{
+			"level after setboard",
+			[]string{"force", "setboard 7k/8/8/8/8/8/8/KR6 w - - 0 30", "level 40 0:30 0", "time 1000", "otim 2000"},
+			engine.Limits{WTime: 20 * time.Second, BTime: 10 * time.Second, MovesToGo: 11},
+		},
+		{
+			"level after setboard with black to move",
+			[]string{"force", "setboard 7k/8/8/8/8/8/8/KR6 b - - 0 45", "usermove h8g8", "level 40 0:30 0", "time 1000", "otim 2000"},
+			engine.Limits{WTime: 20 * time.Second, BTime: 10 * time.Second, MovesToGo: 35},
+		},
+		{
+			"increment",
+			[]string{"level 0 2 1.5"},
+			engine.Limits{WTime: 2 * time.Minute, BTime: 2 * time.Minute, WInc: 1500 * time.Millisecond, BInc: 1500 * time.Millisecond},
+		},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		session := New(nil, make(chan string, 10), &dummyEngine{})
+		for _, input := range c.inputs {
+			session.handleInput(input)
+		}
+
+		if result := session.getLimits(); result.WTime != c.expected.WTime || result.BTime != c.expected.BTime ||
+			result.WInc != c.expected.WInc || result.BInc != c.expected.BInc || result.MovesToGo != c.expected.MovesToGo ||
+			result.Depth != c.expected.Depth || result.MoveTime != c.expected.MoveTime {
+			t.Errorf("expected limits %+v, got %+v", c.expected, result)
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+// dummyEngine always plays the alphabetically first legal move. Timed searches wait to be
+// stopped if they are longer than a minute, as do infinite searches.
+type dummyEngine struct {
+	mutex sync.Mutex
+	stop  chan struct{}
+}
+
+func (d *dummyEngine) NewGame() {}
+
+func (d *dummyEngine) Go(position chess.Position, limits engine.Limits, info func(chess.SearchInfo), done func(bestMove, ponderMove string)) {
+	stop := make(chan struct{})
+
+	d.mutex.Lock()
+	d.stop = stop
+	d.mutex.Unlock()
+
+	go func() {
+		bestMove := ""
+		if legalMoves := position.LegalMoves(); len(legalMoves) > 0 {
+			slices.Sort(legalMoves)
+			bestMove = legalMoves[0]
+			info(chess.SearchInfo{Depth: 1, Score: -15, Nodes: 20, PV: []string{bestMove}})
+		}
+
+		if limits.Infinite || limits.MoveTime > time.Minute {
+			<-stop
+		}
+		done(bestMove, "")
+	}()
+}
+
+func (d *dummyEngine) Stop() {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.stop != nil {
+		close(d.stop)
+		d.stop = nil
+	}
+}
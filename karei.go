@@ -0,0 +1,285 @@
+// Package karei embeds the Karei chess engine in Go programs.
+//
+// An Engine holds a position and searches it on request:
+//
+//	e, err := karei.NewEngine(karei.Options{Hash: 64})
+//	...
+//	defer e.Close()
+//	e.SetPosition("", []string{"e2e4", "e7e5"})
+//	result, err := e.Search(ctx, karei.Limits{Depth: 4})
+//
+// Any number of engines may be used at once, each from its own goroutine or
+// shared between goroutines.
+package karei
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yutanagano/karei/internal/chess"
+	"github.com/yutanagano/karei/internal/engine"
+)
+
+var (
+	// ErrClosed is returned by methods called after Close.
+	ErrClosed = errors.New("engine closed")
+	// ErrSearching is returned by Search while another search is running.
+	ErrSearching = errors.New("engine already searching")
+)
+
+// Options configure a new engine. Zero values select the defaults.
+type Options struct {
+	// Hash is the hash table size in megabytes, from 1 to 1024.
+	Hash int
+	// Threads is the number of search threads, from 1 to 16. It is accepted
+	// for compatibility, but the search is single-threaded.
+	Threads int
+	// Logger receives the engine's diagnostics. By default they are
+	// discarded.
+	Logger *slog.Logger
+}
+
+// Limits constrain a search. Zero values mean no limit, and a search with no
+// limits runs until it is stopped.
+type Limits struct {
+	// SearchMoves restricts the search to these moves, in long algebraic
+	// notation such as e2e4 or e7e8q.
+	SearchMoves []string
+	WTime       time.Duration
+	BTime       time.Duration
+	WInc        time.Duration
+	BInc        time.Duration
+	MovesToGo   int
+	Depth       int
+	Nodes       uint64
+	// Mate stops the search once it finds a mate in this many moves or
+	// fewer.
+	Mate     int
+	MoveTime time.Duration
+
+	// Info, if set, is called from the search goroutine after each iteration
+	// of the search.
+	Info func(Info)
+}
+
+// Info reports the progress of a search.
+type Info struct {
+	Depth    int
+	SelDepth int
+	// Score is in centipawns from the point of view of the side to move. It
+	// is only meaningful if MateIn is zero.
+	Score int
+	// MateIn is the number of moves to mate, negative if the side to move is
+	// getting mated, or zero if no mate was found.
+	MateIn int
+	Nodes  uint64
+	Time   time.Duration
+	PV     []string
+}
+
+// Result is the outcome of a search.
+type Result struct {
+	// BestMove is empty if the position has no legal moves.
+	BestMove string
+	// PonderMove is the expected reply, or empty if there is none.
+	PonderMove string
+	// Info is the last progress report of the search.
+	Info Info
+}
+
+// Engine searches chess positions. Its methods may be called from several
+// goroutines, but an engine runs only one search at a time.
+type Engine struct {
+	engine *engine.Engine
+
+	mutex     sync.Mutex
+	position  chess.Position
+	searching bool
+	stop      context.CancelFunc
+	closed    bool
+	searches  sync.WaitGroup
+}
+
+// NewEngine returns an engine set up at the starting position.
+func NewEngine(opts Options) (*Engine, error) {
+	e := &Engine{engine: engine.New()}
+
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.New(discardHandler{})
+	}
+	e.engine.SetLogger(logger)
+
+	if opts.Hash != 0 {
+		if err := e.engine.SetOption("Hash", strconv.Itoa(opts.Hash)); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Threads != 0 {
+		if err := e.engine.SetOption("Threads", strconv.Itoa(opts.Threads)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := e.position.LoadFEN(chess.GetStartingFEN()); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// SetPosition sets the position to search to the one given in FEN, or the
+// starting position if fen is empty or "startpos", followed by moves in long
+// algebraic notation. On error the position is left unchanged. A search
+// already running is not affected.
+func (e *Engine) SetPosition(fen string, moves []string) error {
+	fenRecord := chess.GetStartingFEN()
+	if fen != "" && fen != "startpos" {
+		var err error
+		if fenRecord, err = chess.ParseFEN(fen); err != nil {
+			return err
+		}
+	}
+
+	var position chess.Position
+	if err := position.LoadFEN(fenRecord); err != nil {
+		return err
+	}
+	for idx, moveString := range moves {
+		if err := position.MakeMove(moveString); err != nil {
+			return fmt.Errorf("illegal move at index %d (%s): %w", idx, moveString, err)
+		}
+	}
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.closed {
+		return ErrClosed
+	}
+	e.position = position
+	return nil
+}
+
+// NewGame tells the engine that the next search is from a different game.
+func (e *Engine) NewGame() {
+	e.engine.NewGame()
+}
+
+// Search searches the current position until a limit is hit, Stop is called
+// or ctx is done, and returns the best move found. If ctx ends the search, the
+// result found so far is returned along with the cause of the cancellation.
+func (e *Engine) Search(ctx context.Context, limits Limits) (Result, error) {
+	e.mutex.Lock()
+	switch {
+	case e.closed:
+		e.mutex.Unlock()
+		return Result{}, ErrClosed
+	case e.searching:
+		e.mutex.Unlock()
+		return Result{}, ErrSearching
+	}
+	searchCtx, stop := context.WithCancel(ctx)
+	e.searching, e.stop = true, stop
+	e.searches.Add(1)
+	position := e.position
+	e.mutex.Unlock()
+
+	defer func() {
+		e.mutex.Lock()
+		e.searching, e.stop = false, nil
+		e.mutex.Unlock()
+		stop()
+		e.searches.Done()
+	}()
+
+	if err := context.Cause(ctx); err != nil {
+		return Result{}, err
+	}
+
+	var lastInfo Info
+	done := make(chan Result, 1)
+	e.engine.Go(position, limits.internal(), func(searchInfo chess.SearchInfo) {
+		if searchInfo.Bound != chess.ExactScore {
+			return
+		}
+		lastInfo = newInfo(searchInfo)
+		if limits.Info != nil {
+			limits.Info(lastInfo)
+		}
+	}, func(bestMove, ponderMove string) {
+		done <- Result{BestMove: bestMove, PonderMove: ponderMove}
+	})
+
+	var result Result
+	select {
+	case result = <-done:
+	case <-searchCtx.Done():
+		e.engine.Stop()
+		result = <-done
+	}
+
+	result.Info = lastInfo
+	return result, context.Cause(ctx)
+}
+
+// Stop ends the running search, if any, as soon as possible. The search still
+// returns the best move it has found.
+func (e *Engine) Stop() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.stop != nil {
+		e.stop()
+	}
+}
+
+// Close stops any running search and waits for it to return. Later calls to
+// SetPosition and Search fail with ErrClosed.
+func (e *Engine) Close() error {
+	e.mutex.Lock()
+	e.closed = true
+	if e.stop != nil {
+		e.stop()
+	}
+	e.mutex.Unlock()
+
+	e.searches.Wait()
+	return nil
+}
+
+func newInfo(searchInfo chess.SearchInfo) Info {
+	return Info{
+		Depth:    searchInfo.Depth,
+		SelDepth: searchInfo.SelDepth,
+		Score:    searchInfo.Score,
+		MateIn:   searchInfo.MateIn,
+		Nodes:    searchInfo.Nodes,
+		Time:     searchInfo.Time,
+		PV:       searchInfo.PV,
+	}
+}
+
+func (l Limits) internal() engine.Limits {
+	return engine.Limits{
+		SearchMoves: l.SearchMoves,
+		WTime:       l.WTime,
+		BTime:       l.BTime,
+		WInc:        l.WInc,
+		BInc:        l.BInc,
+		MovesToGo:   l.MovesToGo,
+		Depth:       l.Depth,
+		Nodes:       l.Nodes,
+		Mate:        l.Mate,
+		MoveTime:    l.MoveTime,
+	}
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
@@ -0,0 +1,214 @@
+package karei
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSetPosition(t *testing.T) {
+	type testCase struct {
+		name    string
+		fen     string
+		moves   []string
+		isValid bool
+	}
+
+	testCases := []testCase{
+		{"empty", "", nil, true},
+		{"startpos with moves", "startpos", []string{"e2e4", "e7e5", "g1f3"}, true},
+		{"fen", "r1bqkbnr/pppp1ppp/2n5/4p3/3PP3/5N2/PPP2PPP/RNBQKB1R b KQkq - 0 3", nil, true},
+		{"short fen", "4k3/P7/8/8/8/8/8/4K3 w - -", []string{"a7a8q"}, true},
+		{"illegal move", "", []string{"e2e4", "e2e4"}, false},
+		{"bad fen", "not a fen", nil, false},
+		{"no kings", "8/8/8/8/8/8/8/8 w - - 0 1", nil, false},
+	}
+
+	checkCase := func(t *testing.T, c testCase) {
+		e, err := NewEngine(Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer e.Close()
+
+		err = e.SetPosition(c.fen, c.moves)
+		if c.isValid && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !c.isValid && err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	for _, c := range testCases {
+		t.Run(c.name, func(t *testing.T) { checkCase(t, c) })
+	}
+}
+
+func TestNewEngineRejectsBadOptions(t *testing.T) {
+	if _, err := NewEngine(Options{Hash: -1}); err == nil {
+		t.Error("expected an error for a negative hash size")
+	}
+	if _, err := NewEngine(Options{Threads: 100}); err == nil {
+		t.Error("expected an error for too many threads")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	e, err := NewEngine(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	if err := e.SetPosition("6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var depths []int
+	result, err := e.Search(context.Background(), Limits{Depth: 2, Info: func(info Info) {
+		depths = append(depths, info.Depth)
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.BestMove != "a1a8" {
+		t.Errorf("expected best move a1a8, got %s", result.BestMove)
+	}
+	if result.Info.MateIn != 1 {
+		t.Errorf("expected mate in 1, got %d", result.Info.MateIn)
+	}
+	if len(depths) != 2 || depths[1] != 2 {
+		t.Errorf("expected reports at depths 1 and 2, got %v", depths)
+	}
+}
+
+func TestSearchEndsWhenContextDone(t *testing.T) {
+	e, err := NewEngine(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	result, err := e.Search(ctx, Limits{})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+	if result.BestMove == "" {
+		t.Error("expected the best move found so far")
+	}
+}
+
+func TestStop(t *testing.T) {
+	e, err := NewEngine(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer e.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		e.Stop()
+	}()
+
+	result, err := e.Search(context.Background(), Limits{})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if result.BestMove == "" {
+		t.Error("expected the best move found so far")
+	}
+}
+
+func TestSearchWhileSearching(t *testing.T) {
+	e, err := NewEngine(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+	finished := make(chan bool)
+	go func() {
+		e.Search(context.Background(), Limits{Info: func(Info) {
+			select {
+			case started <- true:
+			default:
+			}
+		}})
+		finished <- true
+	}()
+	<-started
+
+	if _, err := e.Search(context.Background(), Limits{Depth: 1}); !errors.Is(err, ErrSearching) {
+		t.Errorf("expected ErrSearching, got %v", err)
+	}
+
+	e.Close()
+	<-finished
+
+	if _, err := e.Search(context.Background(), Limits{Depth: 1}); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+	if err := e.SetPosition("", nil); !errors.Is(err, ErrClosed) {
+		t.Errorf("expected ErrClosed, got %v", err)
+	}
+}
+
+func TestEnginesSearchIndependently(t *testing.T) {
+	const numEngines = 4
+
+	results := make([]Result, numEngines)
+	var wg sync.WaitGroup
+	for idx := range results {
+		wg.Add(1)
+		go func(idx int) {
+			defer wg.Done()
+
+			e, err := NewEngine(Options{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer e.Close()
+
+			e.SetPosition("", []string{"e2e4", "e7e5"})
+			if results[idx], err = e.Search(context.Background(), Limits{Depth: 2}); err != nil {
+				t.Error(err)
+			}
+		}(idx)
+	}
+	wg.Wait()
+
+	for idx, result := range results {
+		if result.BestMove != results[0].BestMove || result.Info.Nodes != results[0].Info.Nodes {
+			t.Errorf("engine %d found %s in %d nodes, engine 0 found %s in %d nodes",
+				idx, result.BestMove, result.Info.Nodes, results[0].BestMove, results[0].Info.Nodes)
+		}
+	}
+}
+
+func ExampleEngine_Search() {
+	e, err := NewEngine(Options{Hash: 16})
+	if err != nil {
+		panic(err)
+	}
+	defer e.Close()
+
+	if err := e.SetPosition("6k1/5ppp/8/8/8/8/8/R5K1 w - - 0 1", nil); err != nil {
+		panic(err)
+	}
+
+	result, err := e.Search(context.Background(), Limits{Depth: 3})
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(result.BestMove, result.Info.MateIn)
+	// Output: a1a8 1
+}